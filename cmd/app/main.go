@@ -4,16 +4,27 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/andy-ahmedov/crud_service/internal/config"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
 	"github.com/andy-ahmedov/crud_service/internal/repository/psql"
 	"github.com/andy-ahmedov/crud_service/internal/service"
-	grpc_client "github.com/andy-ahmedov/crud_service/internal/transport/grpc"
+	"github.com/andy-ahmedov/crud_service/internal/transport/graphql"
+	grpc_transport "github.com/andy-ahmedov/crud_service/internal/transport/grpc"
 	"github.com/andy-ahmedov/crud_service/internal/transport/rest"
+	"github.com/andy-ahmedov/crud_service/pkg/cache"
 	"github.com/andy-ahmedov/crud_service/pkg/hash"
+	"github.com/andy-ahmedov/crud_service/pkg/idempotency"
+	"github.com/andy-ahmedov/crud_service/pkg/mail"
 	"github.com/andy-ahmedov/crud_service/pkg/postgres"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/andy-ahmedov/crud_service/pkg/webhook"
 )
 
 // @title CRUD API Service
@@ -34,6 +45,11 @@ import (
 const (
 	CONFIG_DIR  = "configs"
 	CONFIG_FILE = "main"
+
+	// idempotencyPurgeInterval is how often the expired idempotency keys are deleted from postgres
+	idempotencyPurgeInterval = time.Hour
+	// rateLimitPurgeInterval is how often the refilled rate limit buckets are deleted from postgres
+	rateLimitPurgeInterval = 10 * time.Minute
 )
 
 func init() {
@@ -50,33 +66,128 @@ func main() {
 		log.Fatal(err)
 	}
 
-	db, err := postgres.ConnectToDB(cfg.DB)
-	if err != nil {
-		log.Fatal(err)
+	var (
+		db    *pgxpool.Pool
+		repos repositories
+	)
+
+	switch cfg.Storage {
+	case "postgres", "":
+		if db, err = postgres.NewPool(cfg.DB); err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		repos = postgresRepositories(cfg, db)
+	case "memory":
+		repos = memoryRepositories(memory.NewDB())
+	default:
+		log.Fatalf("unknown storage %q", cfg.Storage)
 	}
-	defer db.Close(context.Background())
 
 	hasher := hash.NewSHA1Hasher(cfg.Salt)
 
-	booksRepo := psql.NewBookRepository(db)
-	sessionRepo := psql.NewTokens(db)
+	booksRepo := repos.books
+	if cfg.Cache.Storage != "" {
+		cachedBooks := service.NewCachedBooks(booksRepo, newCacheStore(cfg), cfg.Cache.TTL, repos.cacheInvalidations)
+		go cachedBooks.RunInvalidations(context.Background())
+		booksRepo = cachedBooks
+	}
+	sessionRepo := repos.sessions
 	// добавить репозиторий токена. Включить его в параметры NewUsers
-	booksService := service.NewBooksStorage(booksRepo)
+	booksService := service.NewBooksStorage(booksRepo, repos.revisions, repos.transactor, bookRules(cfg))
+
+	if cfg.Books.TrashRetention > 0 {
+		interval := cfg.Books.TrashPurgeInterval
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		go booksService.RunTrashRetention(context.Background(), cfg.Books.TrashRetention, interval)
+	}
 
-	userRepo := psql.NewUserRepository(db)
+	// the changes made through every replica reach the watchers through LISTEN/NOTIFY,
+	// without it they are handed over within this process
+	if repos.bookEvents != nil {
+		go booksService.RunEventFeed(context.Background(), repos.bookEvents)
+	}
+
+	userRepo := repos.users
+
+	auditClient := newAuditClient(cfg.Audit.Client)
+
+	var mailer service.Mailer = mail.NewConsoleSender()
+	if cfg.Mail.Host != "" {
+		mailer = mail.NewSMTPSender(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	}
+
+	userService := service.NewUsers(userRepo, hasher, sessionRepo, auditClient, mailer, []byte(cfg.Secret), cfg.TokenTTL, cfg.ImpersonationTTL)
 
-	auditClient, err := grpc_client.NewClient(9000)
+	webhookService := service.NewWebhooks(repos.webhooks, repos.deliveries, userService,
+		webhook.NewClient(cfg.Webhooks.Timeout, cfg.Webhooks.AllowPrivateNetworks), cfg.Webhooks.MaxAttempts)
+	booksService.PublishTo(webhookService)
+	userService.Webhooks = webhookService
+
+	pollInterval := cfg.Webhooks.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+	go webhookService.Run(context.Background(), pollInterval)
+
+	rateLimits := rest.RateLimits{
+		Auth:  ratelimit.Limit(cfg.RateLimit.Auth),
+		Books: ratelimit.Limit(cfg.RateLimit.Books),
+		Users: ratelimit.Limit(cfg.RateLimit.Users),
+	}
+	rateLimits.Store = newRateLimitStore(cfg.RateLimit.Storage, db, rateLimits.Auth, rateLimits.Books, rateLimits.Users)
+
+	idempotencyKeys := rest.Idempotency{
+		Store: newIdempotencyStore(cfg.Idempotency.Storage, db),
+		TTL:   cfg.Idempotency.TTL,
+	}
+
+	privacyService := service.NewPrivacy(userRepo, sessionRepo, repos.identities, repos.webhooks, repos.importJobs, booksService, repos.transactor, auditClient, cfg.Erasure.BooksPolicy, cfg.Erasure.ReassignTo)
+
+	providers := make([]service.OIDCProvider, 0, len(cfg.OIDC.Providers))
+	for _, p := range cfg.OIDC.Providers {
+		providers = append(providers, service.OIDCProvider(p))
+	}
+	oidcService := service.NewOIDC(userService, repos.identities, providers)
+
+	oauthService := service.NewOAuthServer(userService, repos.oauthClients, repos.oauthCodes)
+
+	importService := service.NewBookImporter(booksService, repos.importJobs)
+
+	graphQL, err := graphql.NewHandler(booksService, userService, cfg.GraphQL.MaxComplexity)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	userService := service.NewUsers(userRepo, hasher, sessionRepo, auditClient, []byte(cfg.Secret), cfg.TokenTTL)
+	handler := rest.NewHandler(booksService, userService, privacyService, oidcService, oauthService, importService, webhookService, rateLimits, idempotencyKeys, graphQL)
 
-	handler := rest.NewHandler(booksService, userService)
+	if cfg.GRPC.Port != 0 {
+		grpcServer := grpc_transport.NewServer(booksService, userService, grpc_transport.RateLimit{
+			Store: rateLimits.Store,
+			Limit: rateLimits.Auth,
+		})
+		go func() {
+			if err := grpcServer.ListenAndServe(cfg.GRPC.Port); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		// the /v1 API is generated from the protos and served by the gateway
+		gateway, err := grpc_transport.NewGateway(context.Background(), cfg.GRPC.Port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.ServeGateway(gateway)
+	}
+
+	router := handler.InitGinRouter()
 
 	srv := &http.Server{
-		Addr:    cfg.Server.Port,
-		Handler: handler.InitGinRouter(),
+		Addr:    ":" + cfg.Server.Port,
+		Handler: router,
 	}
 
 	log.Info("SERVER STARTED")
@@ -85,3 +196,154 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func bookRules(cfg *config.Config) service.BookRules {
+	rules := service.DefaultBookRules
+
+	if cfg.Books.MaxRating != 0 {
+		rules.MinRating, rules.MaxRating = cfg.Books.MinRating, cfg.Books.MaxRating
+	}
+
+	if cfg.Books.EarliestPublishYear != 0 {
+		rules.EarliestPublishDate = time.Date(cfg.Books.EarliestPublishYear, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return rules
+}
+
+// repositories are the tables of the service. The feeds of the other replicas only exist
+// in Postgres, they are nil in memory.
+type repositories struct {
+	books        service.BooksInterface
+	revisions    service.RevisionRepository
+	users        service.UserStorage
+	sessions     service.SessionRepository
+	identities   service.IdentityRepository
+	oauthClients service.OAuthClientRepository
+	oauthCodes   service.AuthorizationCodeRepository
+	importJobs   service.ImportJobRepository
+	webhooks     service.WebhookRepository
+	deliveries   service.WebhookDeliveryRepository
+	transactor   service.Transactor
+
+	bookEvents         service.BookEventSource
+	cacheInvalidations service.BookCacheInvalidations
+}
+
+func postgresRepositories(cfg *config.Config, db *pgxpool.Pool) repositories {
+	// a connection that listens for notifications can't be shared
+	listenConn := func(ctx context.Context) (*pgx.Conn, error) {
+		return postgres.ConnectToDB(cfg.DB)
+	}
+
+	return repositories{
+		books:        psql.NewBookRepository(db),
+		revisions:    psql.NewBookRevisions(db),
+		users:        psql.NewUserRepository(db),
+		sessions:     psql.NewTokens(db),
+		identities:   psql.NewIdentities(db),
+		oauthClients: psql.NewOAuthClients(db),
+		oauthCodes:   psql.NewAuthorizationCodes(db),
+		importJobs:   psql.NewImportJobs(db),
+		webhooks:     psql.NewWebhooks(db),
+		deliveries:   psql.NewWebhookDeliveries(db),
+		transactor:   psql.NewTransactor(db),
+
+		bookEvents:         psql.NewBookEventListener(listenConn),
+		cacheInvalidations: psql.NewBookCacheInvalidations(db, listenConn),
+	}
+}
+
+func memoryRepositories(db *memory.DB) repositories {
+	return repositories{
+		books:        memory.NewBookRepository(db),
+		revisions:    memory.NewBookRevisions(db),
+		users:        memory.NewUserRepository(db),
+		sessions:     memory.NewTokens(db),
+		identities:   memory.NewIdentities(db),
+		oauthClients: memory.NewOAuthClients(db),
+		oauthCodes:   memory.NewAuthorizationCodes(db),
+		importJobs:   memory.NewImportJobs(db),
+		webhooks:     memory.NewWebhooks(db),
+		deliveries:   memory.NewWebhookDeliveries(db),
+		transactor:   memory.NewTransactor(db),
+	}
+}
+
+func newAuditClient(client string) service.AuditClient {
+	switch client {
+	case "grpc", "":
+		auditClient, err := grpc_transport.NewClient(9000)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return auditClient
+	case "memory":
+		return memory.NewAuditLog()
+	default:
+		log.Fatalf("unknown audit client %q", client)
+		return nil
+	}
+}
+
+// newRateLimitStore and newIdempotencyStore get a nil db when the tables are kept in memory.
+func newRateLimitStore(storage string, db *pgxpool.Pool, limits ...ratelimit.Limit) ratelimit.Store {
+	switch storage {
+	case "postgres":
+		if db == nil {
+			log.Fatal("the postgres rate limit storage needs the postgres storage")
+		}
+
+		var refill time.Duration
+		for _, limit := range limits {
+			refill = max(refill, limit.RefillTime())
+		}
+
+		buckets := psql.NewRateLimits(db)
+		go buckets.RunPurge(context.Background(), rateLimitPurgeInterval, refill)
+		return buckets
+	case "memory", "":
+		return ratelimit.NewMemoryStore()
+	default:
+		log.Fatalf("unknown rate limit storage %q", storage)
+		return nil
+	}
+}
+
+func newIdempotencyStore(storage string, db *pgxpool.Pool) idempotency.Store {
+	switch storage {
+	case "postgres":
+		if db == nil {
+			log.Fatal("the postgres idempotency storage needs the postgres storage")
+		}
+		keys := psql.NewIdempotencyKeys(db)
+		go keys.RunPurge(context.Background(), idempotencyPurgeInterval)
+		return keys
+	case "memory", "":
+		return idempotency.NewMemoryStore()
+	default:
+		log.Fatalf("unknown idempotency storage %q", storage)
+		return nil
+	}
+}
+
+func newCacheStore(cfg *config.Config) cache.Store {
+	switch cfg.Cache.Storage {
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Cache.Redis.Addr,
+			Password: cfg.Cache.Redis.Password,
+			DB:       cfg.Cache.Redis.DB,
+		})
+		return cache.NewRedisStore(client, "crud_service:")
+	case "memory":
+		size := cfg.Cache.Size
+		if size <= 0 {
+			size = 10000
+		}
+		return cache.NewMemoryStore(size)
+	default:
+		log.Fatalf("unknown cache storage %q", cfg.Cache.Storage)
+		return nil
+	}
+}
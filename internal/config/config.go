@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/andy-ahmedov/crud_service/internal/domain"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/spf13/viper"
 )
@@ -10,13 +13,100 @@ import (
 type Config struct {
 	DB Postgres
 
+	Storage string `mapstructure:"storage"`
+
+	Audit struct {
+		Client string `mapstructure:"client"`
+	} `mapstructure:"audit"`
+
 	Server struct {
 		Port string `mapstructure:"port"`
 	} `mapstructure:"server"`
 
+	GRPC struct {
+		Port int `mapstructure:"port"`
+	} `mapstructure:"grpc"`
+
+	GraphQL struct {
+		MaxComplexity int `mapstructure:"max_complexity"`
+	} `mapstructure:"graphql"`
+
 	Salt     string        `mapstructure:"salt"`
 	Secret   string        `mapstructure:"secret"`
 	TokenTTL time.Duration `mapstructure:"token_ttl"`
+
+	ImpersonationTTL time.Duration `mapstructure:"impersonation_ttl"`
+
+	RateLimit struct {
+		Storage string    `mapstructure:"storage"`
+		Auth    RateLimit `mapstructure:"auth"`
+		Books   RateLimit `mapstructure:"books"`
+		Users   RateLimit `mapstructure:"users"`
+	} `mapstructure:"rate_limit"`
+
+	Idempotency struct {
+		Storage string        `mapstructure:"storage"`
+		TTL     time.Duration `mapstructure:"ttl"`
+	} `mapstructure:"idempotency"`
+
+	Cache struct {
+		Storage string        `mapstructure:"storage"`
+		Size    int           `mapstructure:"size"`
+		TTL     time.Duration `mapstructure:"ttl"`
+		Redis   struct {
+			Addr     string `mapstructure:"addr"`
+			Password string `mapstructure:"password"`
+			DB       int    `mapstructure:"db"`
+		} `mapstructure:"redis"`
+	} `mapstructure:"cache"`
+
+	Books struct {
+		MinRating           int `mapstructure:"min_rating"`
+		MaxRating           int `mapstructure:"max_rating"`
+		EarliestPublishYear int `mapstructure:"earliest_publish_year"`
+
+		TrashRetention     time.Duration `mapstructure:"trash_retention"`
+		TrashPurgeInterval time.Duration `mapstructure:"trash_purge_interval"`
+	} `mapstructure:"books"`
+
+	Erasure struct {
+		BooksPolicy string `mapstructure:"books_policy"`
+		ReassignTo  int64  `mapstructure:"reassign_to"`
+	} `mapstructure:"erasure"`
+
+	OIDC struct {
+		Providers []OIDCProvider `mapstructure:"providers"`
+	} `mapstructure:"oidc"`
+
+	Webhooks struct {
+		Timeout              time.Duration `mapstructure:"timeout"`
+		MaxAttempts          int           `mapstructure:"max_attempts"`
+		PollInterval         time.Duration `mapstructure:"poll_interval"`
+		AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+	} `mapstructure:"webhooks"`
+
+	Mail struct {
+		Host     string `mapstructure:"host"`
+		Port     int    `mapstructure:"port"`
+		Username string `mapstructure:"username"`
+		Password string `mapstructure:"password"`
+		From     string `mapstructure:"from"`
+	} `mapstructure:"mail"`
+}
+
+type RateLimit struct {
+	Requests int           `mapstructure:"requests"`
+	Period   time.Duration `mapstructure:"period"`
+	Burst    int           `mapstructure:"burst"`
+}
+
+type OIDCProvider struct {
+	Name         string   `mapstructure:"name"`
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
 }
 
 type Postgres struct {
@@ -45,5 +135,26 @@ func New(folder, filename string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
+
+// validate rejects the settings that would only fail, or silently do the wrong thing, once
+// they are used.
+func (cfg *Config) validate() error {
+	switch cfg.Erasure.BooksPolicy {
+	case domain.ErasureDeleteBooks, domain.ErasureOrphanBooks:
+	case domain.ErasureReassignBooks:
+		// the books would be orphaned instead
+		if cfg.Erasure.ReassignTo <= 0 {
+			return errors.New("erasure: reassign_to has to be the ID of a user when books_policy is reassign")
+		}
+	default:
+		return fmt.Errorf("erasure: unknown books_policy %q", cfg.Erasure.BooksPolicy)
+	}
+
+	return nil
+}
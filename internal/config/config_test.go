@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+func TestConfig_validate(t *testing.T) {
+	testTable := []struct {
+		name       string
+		policy     string
+		reassignTo int64
+		wantErr    bool
+	}{
+		{
+			name:   "Orphan books",
+			policy: domain.ErasureOrphanBooks,
+		},
+		{
+			name:       "Reassign books",
+			policy:     domain.ErasureReassignBooks,
+			reassignTo: 2,
+		},
+		{
+			name:    "Reassign books to nobody",
+			policy:  domain.ErasureReassignBooks,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown policy",
+			policy:  "keep",
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := new(Config)
+			cfg.Erasure.BooksPolicy, cfg.Erasure.ReassignTo = testCase.policy, testCase.reassignTo
+
+			if err := cfg.validate(); (err != nil) != testCase.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package domain
+
+import "fmt"
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+const (
+	// BatchAtomic writes all operations or none of them.
+	BatchAtomic = "atomic"
+	// BatchBestEffort writes every operation that succeeds on its own.
+	BatchBestEffort = "best_effort"
+)
+
+type BookBatch struct {
+	Mode       string          `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Operations []BookOperation `json:"operations" binding:"required,min=1,max=1000,dive"`
+}
+
+// BookOperation creates Book, replaces the book with ID by Book or moves it to the trash.
+// Version works like If-Match, 0 accepts any version.
+type BookOperation struct {
+	Op      string `json:"op" binding:"required,oneof=create update delete"`
+	ID      int64  `json:"id,omitempty" binding:"required_unless=Op create"`
+	Version int64  `json:"version,omitempty"`
+	Book    *Book  `json:"book,omitempty" binding:"required_unless=Op delete"`
+}
+
+type BookOperationResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Book  *Book  `json:"book,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// BatchError tells which operation made an atomic batch fail.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
@@ -1,21 +1,69 @@
 package domain
 
 import (
+	"encoding/xml"
 	_ "errors"
 	"time"
 )
 
+// The validate tags are checked by the book service, rating and publish_date are rules
+// of its own, see service.BookRules.
 type Book struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	Author      string    `json:"author"`
-	PublishDate time.Time `json:"publish_date"`
-	Rating      int       `json:"rating"`
+	XMLName     xml.Name   `json:"-" xml:"book" yaml:"-"`
+	ID          int64      `json:"id" xml:"id" yaml:"id"`
+	Title       string     `json:"title" xml:"title" yaml:"title" validate:"notblank,max=255"`
+	Author      string     `json:"author" xml:"author" yaml:"author" validate:"notblank,max=255"`
+	PublishDate time.Time  `json:"publish_date" xml:"publish_date" yaml:"publish_date" validate:"publish_date"`
+	Rating      int        `json:"rating" xml:"rating" yaml:"rating" validate:"rating"`
+	ISBN        string     `json:"isbn,omitempty" xml:"isbn,omitempty" yaml:"isbn,omitempty" validate:"omitempty,isbn"`
+	OwnerID     int64      `json:"owner_id,omitempty" xml:"owner_id,omitempty" yaml:"owner_id,omitempty"`
+	Version     int64      `json:"version" xml:"version" yaml:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty" yaml:"deleted_at,omitempty"`
 }
 
+// UpdateBookInput changes the fields that are set. PUT requires all of them except
+// the ISBN, which is cleared by an empty string. PATCH goes through BookPatch.
 type UpdateBookInput struct {
-	Title       *string    `json:"title"`
-	Author      *string    `json:"author"`
-	PublishDate *time.Time `json:"publish_date"`
-	Rating      *int       `json:"rating"`
+	XMLName     xml.Name   `json:"-" xml:"book" yaml:"-"`
+	Title       *string    `json:"title" xml:"title" yaml:"title" binding:"required" validate:"omitempty,notblank,max=255"`
+	Author      *string    `json:"author" xml:"author" yaml:"author" binding:"required" validate:"omitempty,notblank,max=255"`
+	PublishDate *time.Time `json:"publish_date" xml:"publish_date" yaml:"publish_date" binding:"required" validate:"omitempty,publish_date"`
+	Rating      *int       `json:"rating" xml:"rating" yaml:"rating" binding:"required" validate:"omitempty,rating"`
+	ISBN        *string    `json:"isbn" xml:"isbn" yaml:"isbn" validate:"omitempty,isbn|len=0"`
+}
+
+// Replacement is the update that makes a book equal to b.
+func (b Book) Replacement() UpdateBookInput {
+	return UpdateBookInput{
+		Title:       &b.Title,
+		Author:      &b.Author,
+		PublishDate: &b.PublishDate,
+		Rating:      &b.Rating,
+		ISBN:        &b.ISBN,
+	}
+}
+
+func (u UpdateBookInput) Empty() bool {
+	return u.Title == nil && u.Author == nil && u.PublishDate == nil && u.Rating == nil && u.ISBN == nil
+}
+
+const (
+	MergePatch = "merge"
+	JSONPatch  = "json"
+)
+
+// BookPatch is a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) document.
+type BookPatch struct {
+	Format string
+	Body   []byte
+}
+
+// BookFilter narrows a listing down, the zero value matches every book. Title and Author
+// match a part of the field regardless of case.
+type BookFilter struct {
+	Title     string
+	Author    string
+	OwnerID   int64
+	MinRating int
+	MaxRating int
 }
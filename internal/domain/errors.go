@@ -5,7 +5,35 @@ import "errors"
 // собрать написанные ошибки в этом файле и добавить новую ошибку ErrRefreshTokenExpired
 
 var (
-	ErrUserNotFound = errors.New("User not found")
-	ErrBookNotFound = errors.New("Book not found")
-	ErrRefreshTokenExpired = errors.New("The refresh token has expired")
+	ErrInvalidInput             = errors.New("The request is invalid")
+	ErrUnauthorized             = errors.New("Authentication is required")
+	ErrInvalidCredentials       = errors.New("Invalid email or password")
+	ErrRateLimited              = errors.New("Too many requests")
+	ErrUnsupportedMediaType     = errors.New("The content type is not supported")
+	ErrUserNotFound             = errors.New("User not found")
+	ErrUserAlreadyExists        = errors.New("User with this email already exists")
+	ErrBookNotFound             = errors.New("Book not found")
+	ErrRevisionNotFound         = errors.New("Revision not found")
+	ErrBatchAborted             = errors.New("The operation was rolled back because another one failed")
+	ErrImportJobNotFound        = errors.New("Import job not found")
+	ErrDuplicateBook            = errors.New("A matching book already exists")
+	ErrBookVersionMismatch      = errors.New("The book has been changed since it was read")
+	ErrMalformedPatch           = errors.New("The patch document is malformed")
+	ErrInvalidPatch             = errors.New("The patch can't be applied to the book")
+	ErrRefreshTokenNotFound     = errors.New("The refresh token was not found")
+	ErrRefreshTokenExpired      = errors.New("The refresh token has expired")
+	ErrEmailTokenInvalid        = errors.New("The email confirmation token is invalid or has expired")
+	ErrResetTokenInvalid        = errors.New("The password reset token is invalid or has expired")
+	ErrUserDisabled             = errors.New("The account is disabled")
+	ErrPasswordResetNeeded      = errors.New("The password has to be reset before signing in")
+	ErrForbidden                = errors.New("Access denied")
+	ErrIdentityNotFound         = errors.New("External identity not found")
+	ErrProviderNotFound         = errors.New("Identity provider not found")
+	ErrOIDCLoginFailed          = errors.New("Login with the identity provider failed")
+	ErrOAuthClientNotFound      = errors.New("OAuth client not found")
+	ErrAuthorizationCodeInvalid = errors.New("The authorization code is invalid or has expired")
+	ErrWebhookNotFound          = errors.New("Webhook not found")
+	ErrDeliveryNotFound         = errors.New("Webhook delivery not found")
+	ErrIdempotencyKeyReused     = errors.New("The idempotency key was used for another request")
+	ErrIdempotencyKeyInUse      = errors.New("A request with this idempotency key is still being handled")
 )
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// AudienceBookEvents is the audience of the tokens that only open the feeds of the book
+// events, the access tokens have none.
+const AudienceBookEvents = "book-events"
+
+// BookEvent tells about a change of a book, Action is one of the Revision actions and
+// Book is the book after the change. ID orders the events of every replica, it is 0 when
+// the event didn't go through the database.
+type BookEvent struct {
+	ID     int64  `json:"id,omitempty"`
+	Action string `json:"action"`
+	Book   Book   `json:"book"`
+}
+
+// EventsToken opens the feeds of the book events until ExpiresAt, the feeds opened stay open.
+type EventsToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BookEventFilter picks the events of a subscriber, the zero value picks every event.
+type BookEventFilter struct {
+	Author  string
+	BookIDs []int64
+}
+
+func (f BookEventFilter) Matches(event BookEvent) bool {
+	if f.Author != "" && !strings.EqualFold(f.Author, event.Book.Author) {
+		return false
+	}
+
+	if len(f.BookIDs) == 0 {
+		return true
+	}
+
+	for _, id := range f.BookIDs {
+		if id == event.Book.ID {
+			return true
+		}
+	}
+
+	return false
+}
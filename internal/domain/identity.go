@@ -0,0 +1,21 @@
+package domain
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect provider.
+type UserIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin carries what has to survive the round trip to the provider.
+type OIDCLogin struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
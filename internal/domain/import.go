@@ -0,0 +1,53 @@
+package domain
+
+import "time"
+
+const (
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// What an import does with a row matching an existing book by ISBN, or by title and
+// author when the row has no ISBN.
+const (
+	DuplicateSkip   = "skip"
+	DuplicateUpdate = "update"
+	DuplicateFail   = "fail"
+)
+
+type ImportOptions struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv ndjson xlsx"`
+	// Mapping maps book fields to the columns of the file, unmapped fields are read from
+	// columns with the same name.
+	Mapping     map[string]string `form:"-"`
+	DryRun      bool              `form:"dry_run"`
+	OnDuplicate string            `form:"on_duplicate" binding:"omitempty,oneof=skip update fail"`
+}
+
+// ImportRowError refers to the rows of the file starting with 1 for the first record after
+// the header.
+type ImportRowError struct {
+	Row     int          `json:"row"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+type ImportJob struct {
+	ID          int64            `json:"id"`
+	UserID      int64            `json:"user_id"`
+	Format      string           `json:"format"`
+	DryRun      bool             `json:"dry_run"`
+	OnDuplicate string           `json:"on_duplicate"`
+	Status      string           `json:"status"`
+	Processed   int              `json:"processed"`
+	Created     int              `json:"created"`
+	Updated     int              `json:"updated"`
+	Skipped     int              `json:"skipped"`
+	Failed      int              `json:"failed"`
+	Errors      []ImportRowError `json:"errors"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Error codes from RFC 6749 section 5.2 and 4.1.2.1.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthAccessDenied            = "access_denied"
+	OAuthServerError             = "server_error"
+)
+
+// Scopes guarding the resources of the API. A client granted "books" may read and change the
+// books, one granted "books:read" may only read them.
+const (
+	ScopeBooks    = "books"
+	ScopeProfile  = "profile"
+	ScopeWebhooks = "webhooks"
+	ScopeAdmin    = "admin"
+)
+
+// AccessToken is what an access token tells about its bearer. ClientID and Scope are only
+// set for the tokens of OAuth2 clients, Audience for the tokens limited to a few routes.
+type AccessToken struct {
+	UserID   int64
+	ClientID string
+	Scope    string
+	Audience string
+}
+
+// Allows reports whether the token grants scope, only for reading when write is false.
+// The tokens of our own sessions aren't limited by scopes.
+func (t AccessToken) Allows(scope string, write bool) bool {
+	if t.ClientID == "" {
+		return true
+	}
+
+	for _, granted := range strings.Fields(t.Scope) {
+		if granted == scope || (!write && granted == scope+":read") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OAuthClient is an application allowed to sign users in through this service.
+type OAuthClient struct {
+	ID           int64     `json:"id"`
+	ClientID     string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type RegisterClientInput struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris" binding:"dive,url"`
+	GrantTypes   []string `json:"grant_types" binding:"required,min=1,dive,oneof=authorization_code refresh_token client_credentials"`
+	Scopes       []string `json:"scopes" binding:"dive,oneof=books books:read profile profile:read webhooks webhooks:read admin admin:read"`
+	// Public clients such as SPAs can't keep a secret, they have to use PKCE instead.
+	Public bool `json:"public"`
+}
+
+// RegisteredClient is only returned once, the secret is stored hashed.
+type RegisteredClient struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type AuthorizationCode struct {
+	Code                string
+	ClientID            string
+	UserID              int64
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Introspection is the response of RFC 7662, only Active is set for unknown tokens.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OAuthError is reported to clients as is. RedirectURI is set once the redirect of an
+// authorization request has been verified and the error can be sent back to the client.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	RedirectURI string `json:"-"`
+	State       string `json:"-"`
+}
+
+func NewOAuthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
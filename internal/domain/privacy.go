@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// Policies for the books of an erased user.
+const (
+	ErasureDeleteBooks   = "delete"
+	ErasureReassignBooks = "reassign"
+	ErasureOrphanBooks   = "orphan"
+)
+
+type UserExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Profile    User             `json:"profile"`
+	Sessions   []RefreshSession `json:"sessions"`
+	Books      []Book           `json:"books"`
+	Identities []UserIdentity   `json:"identities"`
+	Webhooks   []Webhook        `json:"webhooks"`
+	ImportJobs []ImportJob      `json:"import_jobs"`
+	Audit      []AuditReference `json:"audit"`
+}
+
+// AuditReference points to the entries the audit log server keeps about an entity.
+type AuditReference struct {
+	Entity   string `json:"entity"`
+	EntityID int64  `json:"entity_id"`
+}
+
+type ErasureReport struct {
+	UserID          int64   `json:"user_id"`
+	Policy          string  `json:"policy"`
+	DeletedBooks    []int64 `json:"deleted_books"`
+	ReassignedBooks []int64 `json:"reassigned_books"`
+	ReassignedTo    int64   `json:"reassigned_to,omitempty"`
+}
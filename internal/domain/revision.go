@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// BookRevision is the state of a book after a change, Revision is the version of the book
+// it produced.
+type BookRevision struct {
+	BookID    int64     `json:"book_id"`
+	Revision  int64     `json:"revision"`
+	Action    string    `json:"action"`
+	ActorID   int64     `json:"actor_id,omitempty"`
+	Book      Book      `json:"book"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldChange holds the JSON values of a field before and after a revision.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type BookHistoryEntry struct {
+	Revision  int64         `json:"revision"`
+	Action    string        `json:"action"`
+	ActorID   int64         `json:"actor_id,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+type actorKey struct{}
+
+// WithActor tells the services which user makes the change.
+func WithActor(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// ActorFromContext returns 0 when the change isn't made on behalf of a user.
+func ActorFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(actorKey{}).(int64)
+	return id
+}
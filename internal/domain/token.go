@@ -3,8 +3,12 @@ package domain
 import "time"
 
 type RefreshSession struct {
-	ID        int64
-	UserID    int64
-	Token     string
-	ExpiresAt time.Time
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Token     string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// ClientID is set for sessions opened by OAuth2 clients.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
@@ -1,17 +1,60 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	_ "github.com/gin-gonic/gin"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID           int64     `json:"id"`
 	Name         string    `json:"name"`
 	Email        string    `json:"email"`
-	Password     string    `json:"password"`
+	Password     string    `json:"-"`
 	RegisteredAt time.Time `json:"registered_at"`
+	Role         string    `json:"role"`
+	Disabled     bool      `json:"disabled"`
+
+	// PendingEmail replaces Email once the user confirms it with EmailToken.
+	PendingEmail        string    `json:"pending_email,omitempty"`
+	EmailToken          string    `json:"-"`
+	EmailTokenExpiresAt time.Time `json:"-"`
+
+	// PasswordResetRequired blocks sign-in until the password is changed with ResetToken.
+	PasswordResetRequired bool      `json:"password_reset_required"`
+	ResetToken            string    `json:"-"`
+	ResetTokenExpiresAt   time.Time `json:"-"`
+}
+
+type UserFilter struct {
+	Search string `form:"search"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int    `form:"offset" binding:"omitempty,min=0"`
+}
+
+type UserList struct {
+	Users []User `json:"users"`
+	Total int64  `json:"total"`
+}
+
+type ChangeRoleInput struct {
+	Role string `json:"role" binding:"required,oneof=user admin"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,gte=6"`
+}
+
+type Impersonation struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type SignUpInput struct {
@@ -20,7 +63,21 @@ type SignUpInput struct {
 	Password string `json:"password" binding:"required,gte=6"`
 }
 
+type UpdateUserInput struct {
+	Name  *string `json:"name" binding:"omitempty,gte=2"`
+	Email *string `json:"email" binding:"omitempty,email"`
+}
+
+type ConfirmEmailInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
 type SignInInput struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,gte=6"`
 }
+
+// NormalizeEmail brings an address to the form it is stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,22 @@
+package domain
+
+import "strings"
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of an input at once.
+type ValidationError struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+" "+f.Message)
+	}
+
+	return "Validation failed: " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The events webhooks subscribe to. The user events are only for admins.
+const (
+	EventBookCreated  = "book.created"
+	EventBookUpdated  = "book.updated"
+	EventBookDeleted  = "book.deleted"
+	EventUserSignedUp = "user.signed_up"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID     int64    `json:"id"`
+	UserID int64    `json:"user_id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the payloads, it is only known to the receiver once registered.
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateWebhookInput struct {
+	URL    string   `json:"url" binding:"required,url,startswith=http"`
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=book.created book.updated book.deleted user.signed_up"`
+	Secret string   `json:"secret" binding:"required,min=16,max=255"`
+}
+
+// WebhookPayload is the body of every delivery, Data depends on the event.
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type WebhookDelivery struct {
+	ID            int64            `json:"id"`
+	WebhookID     int64            `json:"webhook_id"`
+	Event         string           `json:"event"`
+	Payload       json.RawMessage  `json:"payload" swaggertype:"object"`
+	Status        string           `json:"status"`
+	AttemptCount  int              `json:"attempt_count"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookAttempt is a single request of a delivery, StatusCode is 0 when there was no response.
+type WebhookAttempt struct {
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAuditItems bounds the records AuditLog keeps, a long running development server
+// shouldn't grow without end.
+const maxAuditItems = 1000
+
+// AuditEntry is a record kept by AuditLog along with the user who made the change.
+type AuditEntry struct {
+	audit.LogItem
+	ActorID int64
+}
+
+// AuditLog stands in for the audit server: it logs the records and keeps the last ones
+// instead of sending them anywhere.
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+func (a *AuditLog) SendLogRequest(ctx context.Context, item audit.LogItem) error {
+	entry := AuditEntry{LogItem: item, ActorID: domain.ActorFromContext(ctx)}
+
+	logrus.WithFields(logrus.Fields{
+		"action":    item.Action,
+		"entity":    item.Entity,
+		"entity_id": item.EntityID,
+		"actor_id":  entry.ActorID,
+	}).Info("audit")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > maxAuditItems {
+		a.entries = a.entries[len(a.entries)-maxAuditItems:]
+	}
+
+	return nil
+}
+
+// Items returns the records kept so far, the oldest first.
+func (a *AuditLog) Items() []audit.LogItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	items := make([]audit.LogItem, len(a.entries))
+	for i, entry := range a.entries {
+		items[i] = entry.LogItem
+	}
+
+	return items
+}
+
+// Entries returns the records kept so far with their actors, the oldest first.
+func (a *AuditLog) Entries() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]AuditEntry(nil), a.entries...)
+}
@@ -0,0 +1,375 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// Books behaves like psql.Books, including the trash and the version checks.
+type Books struct {
+	db *DB
+}
+
+func NewBookRepository(db *DB) *Books {
+	return &Books{db: db}
+}
+
+func (b *Books) Create(ctx context.Context, book *domain.Book) error {
+	defer b.db.lock(ctx)()
+
+	created := b.db.createBook(*book)
+	book.ID, book.Version = created.ID, created.Version
+
+	return nil
+}
+
+func (b *Books) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	book, ok := b.db.books[id]
+	if !ok || book.DeletedAt != nil {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	return book, nil
+}
+
+func (b *Books) GetAll(ctx context.Context) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	return b.db.selectBooks(notDeleted), nil
+}
+
+// List is GetAll narrowed down by the filter, a page at a time. The pages are ordered by ID.
+func (b *Books) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	books := b.db.selectBooks(func(book domain.Book) bool {
+		return notDeleted(book) && book.ID > afterID && matchesFilter(book, filter)
+	})
+	if len(books) > limit {
+		books = books[:limit]
+	}
+
+	return books, nil
+}
+
+// Stream calls fn with the books List returns for the filter, ordered by ID. Like the
+// cursor of psql.Books, it goes through the books as they were when it started.
+func (b *Books) Stream(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error {
+	unlock := b.db.lock(ctx)
+	books := b.db.selectBooks(func(book domain.Book) bool {
+		return notDeleted(book) && matchesFilter(book, filter)
+	})
+	unlock()
+
+	for _, book := range books {
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindDuplicate looks for a book with the ISBN, or with the title and author when the ISBN
+// is empty. Both are compared case insensitively.
+func (b *Books) FindDuplicate(ctx context.Context, isbn, title, author string) (domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	books := b.db.selectBooks(func(book domain.Book) bool {
+		if !notDeleted(book) {
+			return false
+		}
+		if isbn != "" {
+			return strings.EqualFold(book.ISBN, isbn)
+		}
+		return strings.EqualFold(book.Title, title) && strings.EqualFold(book.Author, author)
+	})
+	if len(books) == 0 {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	return books[0], nil
+}
+
+// Trash returns the deleted books that haven't been purged yet, the latest first.
+func (b *Books) Trash(ctx context.Context) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	books := b.db.selectBooks(func(book domain.Book) bool { return !notDeleted(book) })
+	sort.SliceStable(books, func(i, j int) bool {
+		return books[i].DeletedAt.After(*books[j].DeletedAt)
+	})
+
+	return books, nil
+}
+
+// GetByOwner includes the books in the trash, they are still personal data of the owner.
+func (b *Books) GetByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	return b.db.selectBooks(func(book domain.Book) bool { return book.OwnerID == ownerID }), nil
+}
+
+// DeleteByOwner moves the books of the owner to the trash and returns them as they are in it.
+func (b *Books) DeleteByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	deleted := make([]domain.Book, 0)
+	for _, book := range b.db.selectBooks(func(book domain.Book) bool { return book.OwnerID == ownerID && book.DeletedAt == nil }) {
+		book, err := b.db.deleteBook(book.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		deleted = append(deleted, book)
+	}
+
+	return deleted, nil
+}
+
+// ReassignOwner hands the books over to another user, newOwnerID 0 leaves them without an owner.
+// It returns the reassigned books. An unknown newOwnerID is reported as domain.ErrUserNotFound.
+func (b *Books) ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	if _, ok := b.db.users[newOwnerID]; newOwnerID != 0 && !ok {
+		return nil, domain.ErrUserNotFound
+	}
+
+	reassigned := make([]domain.Book, 0)
+	for _, book := range b.db.selectBooks(func(book domain.Book) bool { return book.OwnerID == ownerID }) {
+		book.OwnerID = newOwnerID
+		book.Version++
+		b.db.books[book.ID] = book
+
+		reassigned = append(reassigned, book)
+	}
+
+	return reassigned, nil
+}
+
+// Delete moves the book to the trash while it still has expectedVersion, 0 accepts any version.
+// It returns the book as it is in the trash.
+func (b *Books) Delete(ctx context.Context, id, expectedVersion int64) (domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	return b.db.deleteBook(id, expectedVersion)
+}
+
+// Restore takes the book out of the trash, books that aren't in it are reported as not found.
+func (b *Books) Restore(ctx context.Context, id int64) (domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	book, ok := b.db.books[id]
+	if !ok || book.DeletedAt == nil {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	book.DeletedAt = nil
+	book.Version++
+	b.db.books[id] = book
+
+	return book, nil
+}
+
+// Purge deletes the book for good, whether it is in the trash or not.
+func (b *Books) Purge(ctx context.Context, id int64) error {
+	defer b.db.lock(ctx)()
+
+	if _, ok := b.db.books[id]; !ok {
+		return domain.ErrBookNotFound
+	}
+
+	b.db.purgeBook(id)
+
+	return nil
+}
+
+// PurgeDeletedBefore empties the trash of the books deleted before the given time and
+// returns how many there were.
+func (b *Books) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	defer b.db.lock(ctx)()
+
+	var purged int64
+	for id, book := range b.db.books {
+		if book.DeletedAt != nil && book.DeletedAt.Before(before) {
+			b.db.purgeBook(id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// Update only changes the book while it still has expectedVersion, 0 accepts any version.
+// It returns the book as it is after the update.
+func (b *Books) Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	defer b.db.lock(ctx)()
+
+	return b.db.updateBook(id, expectedVersion, upd)
+}
+
+// ApplyBatch runs the operations under a single lock. When one of them fails, the books are
+// put back as they were and a *domain.BatchError tells which one it was.
+func (b *Books) ApplyBatch(ctx context.Context, ops []domain.BookOperation) ([]domain.Book, error) {
+	for _, op := range ops {
+		switch op.Op {
+		case domain.BatchCreate, domain.BatchUpdate, domain.BatchDelete:
+		default:
+			return nil, fmt.Errorf("unknown operation %q", op.Op)
+		}
+	}
+
+	defer b.db.lock(ctx)()
+
+	// like a rolled back transaction, a failed batch still uses up the IDs it was given
+	saved := maps.Clone(b.db.books)
+
+	books := make([]domain.Book, len(ops))
+	for i, op := range ops {
+		var (
+			book domain.Book
+			err  error
+		)
+
+		switch op.Op {
+		case domain.BatchCreate:
+			book = b.db.createBook(*op.Book)
+		case domain.BatchUpdate:
+			book, err = b.db.updateBook(op.ID, op.Version, op.Book.Replacement())
+		case domain.BatchDelete:
+			book, err = b.db.deleteBook(op.ID, op.Version)
+		}
+
+		if err != nil {
+			b.db.books = saved
+			return nil, &domain.BatchError{Index: i, Err: err}
+		}
+
+		books[i] = book
+	}
+
+	return books, nil
+}
+
+func (db *DB) createBook(book domain.Book) domain.Book {
+	created := domain.Book{
+		ID:          db.nextID("books"),
+		Title:       book.Title,
+		Author:      book.Author,
+		PublishDate: book.PublishDate,
+		Rating:      book.Rating,
+		ISBN:        book.ISBN,
+		OwnerID:     book.OwnerID,
+		Version:     1,
+	}
+	db.books[created.ID] = created
+
+	return created
+}
+
+func (db *DB) updateBook(id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	book, err := db.currentBook(id, expectedVersion)
+	if err != nil || upd.Empty() {
+		return book, err
+	}
+
+	if upd.Title != nil {
+		book.Title = *upd.Title
+	}
+	if upd.Author != nil {
+		book.Author = *upd.Author
+	}
+	if upd.PublishDate != nil {
+		book.PublishDate = *upd.PublishDate
+	}
+	if upd.Rating != nil {
+		book.Rating = *upd.Rating
+	}
+	if upd.ISBN != nil {
+		book.ISBN = *upd.ISBN
+	}
+
+	book.Version++
+	db.books[id] = book
+
+	return book, nil
+}
+
+func (db *DB) deleteBook(id, expectedVersion int64) (domain.Book, error) {
+	book, err := db.currentBook(id, expectedVersion)
+	if err != nil {
+		return book, err
+	}
+
+	deletedAt := time.Now()
+	book.DeletedAt = &deletedAt
+	book.Version++
+	db.books[id] = book
+
+	return book, nil
+}
+
+// currentBook returns the book unless it is missing, in the trash or at another version
+// than expectedVersion, 0 accepts any version.
+func (db *DB) currentBook(id, expectedVersion int64) (domain.Book, error) {
+	book, ok := db.books[id]
+	if !ok || book.DeletedAt != nil {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	if expectedVersion != 0 && book.Version != expectedVersion {
+		return domain.Book{}, domain.ErrBookVersionMismatch
+	}
+
+	return book, nil
+}
+
+// purgeBook takes the revisions of the book along.
+func (db *DB) purgeBook(id int64) {
+	delete(db.books, id)
+
+	revisions := db.revisions[:0]
+	for _, row := range db.revisions {
+		if row.rev.BookID != id {
+			revisions = append(revisions, row)
+		}
+	}
+	db.revisions = revisions
+}
+
+// selectBooks returns the books that match, ordered by ID.
+func (db *DB) selectBooks(match func(domain.Book) bool) []domain.Book {
+	books := make([]domain.Book, 0)
+	for _, id := range sortedIDs(db.books) {
+		if book := db.books[id]; match(book) {
+			books = append(books, book)
+		}
+	}
+
+	return books
+}
+
+func notDeleted(book domain.Book) bool {
+	return book.DeletedAt == nil
+}
+
+func matchesFilter(book domain.Book, filter domain.BookFilter) bool {
+	return containsFold(book.Title, filter.Title) && containsFold(book.Author, filter.Author) &&
+		(filter.OwnerID == 0 || book.OwnerID == filter.OwnerID) &&
+		(filter.MinRating == 0 || book.Rating >= filter.MinRating) &&
+		(filter.MaxRating == 0 || book.Rating <= filter.MaxRating)
+}
+
+// containsFold is ILIKE '%' || substr || '%'.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
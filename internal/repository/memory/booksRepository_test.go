@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBooks_versions(t *testing.T) {
+	books := NewBookRepository(NewDB())
+	ctx := context.Background()
+
+	book := domain.Book{Title: "Title", Author: "Author", Rating: 3}
+	books.Create(ctx, &book)
+	assert.Equal(t, book.ID, int64(1))
+	assert.Equal(t, book.Version, int64(1))
+
+	title := "Changed"
+
+	testTable := []struct {
+		name            string
+		id              int64
+		expectedVersion int64
+		wantVersion     int64
+		wantErr         error
+	}{
+		{
+			name:            "Stale version",
+			id:              1,
+			expectedVersion: 5,
+			wantErr:         domain.ErrBookVersionMismatch,
+		},
+		{
+			name:            "Current version",
+			id:              1,
+			expectedVersion: 1,
+			wantVersion:     2,
+		},
+		{
+			name:        "Any version",
+			id:          1,
+			wantVersion: 3,
+		},
+		{
+			name:    "Unknown book",
+			id:      2,
+			wantErr: domain.ErrBookNotFound,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			updated, err := books.Update(ctx, testCase.id, testCase.expectedVersion, domain.UpdateBookInput{Title: &title})
+
+			assert.Equal(t, err, testCase.wantErr)
+			assert.Equal(t, updated.Version, testCase.wantVersion)
+		})
+	}
+}
+
+func TestBooks_trash(t *testing.T) {
+	books := NewBookRepository(NewDB())
+	ctx := context.Background()
+
+	first := domain.Book{Title: "First", Author: "A"}
+	second := domain.Book{Title: "Second", Author: "B"}
+	books.Create(ctx, &first)
+	books.Create(ctx, &second)
+
+	deleted, err := books.Delete(ctx, first.ID, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted.DeletedAt != nil, true)
+
+	_, err = books.GetByID(ctx, first.ID)
+	assert.Equal(t, err, domain.ErrBookNotFound)
+
+	_, err = books.Delete(ctx, first.ID, 0)
+	assert.Equal(t, err, domain.ErrBookNotFound)
+
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 1)
+
+	trash, _ := books.Trash(ctx)
+	assert.Equal(t, len(trash), 1)
+
+	restored, err := books.Restore(ctx, first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, restored.Version, int64(3))
+
+	books.Delete(ctx, second.ID, 0)
+	purged, _ := books.PurgeDeletedBefore(ctx, time.Now().Add(time.Minute))
+	assert.Equal(t, purged, int64(1))
+
+	assert.Equal(t, books.Purge(ctx, second.ID), domain.ErrBookNotFound)
+}
+
+func TestBooks_List(t *testing.T) {
+	books := NewBookRepository(NewDB())
+	ctx := context.Background()
+
+	for _, book := range []domain.Book{
+		{Title: "Go in Action", Author: "Kennedy", Rating: 4},
+		{Title: "The Go Programming Language", Author: "Donovan", Rating: 5},
+		{Title: "Learning Python", Author: "Lutz", Rating: 3},
+		{Title: "Concurrency in Go", Author: "Cox-Buday", Rating: 5},
+	} {
+		books.Create(ctx, &book)
+	}
+
+	testTable := []struct {
+		name    string
+		filter  domain.BookFilter
+		afterID int64
+		limit   int
+		wantIDs []int64
+	}{
+		{
+			name:    "Everything",
+			limit:   10,
+			wantIDs: []int64{1, 2, 3, 4},
+		},
+		{
+			name:    "Title regardless of case",
+			filter:  domain.BookFilter{Title: "GO"},
+			limit:   10,
+			wantIDs: []int64{1, 2, 4},
+		},
+		{
+			name:    "Rating",
+			filter:  domain.BookFilter{MinRating: 5},
+			limit:   10,
+			wantIDs: []int64{2, 4},
+		},
+		{
+			name:    "Next page",
+			afterID: 1,
+			limit:   2,
+			wantIDs: []int64{2, 3},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			page, err := books.List(ctx, testCase.filter, testCase.afterID, testCase.limit)
+			assert.Equal(t, err, nil)
+
+			ids := make([]int64, 0)
+			for _, book := range page {
+				ids = append(ids, book.ID)
+			}
+			assert.Equal(t, ids, testCase.wantIDs)
+		})
+	}
+}
+
+func TestBooks_ApplyBatch(t *testing.T) {
+	books := NewBookRepository(NewDB())
+	ctx := context.Background()
+
+	book := domain.Book{Title: "Title", Author: "Author"}
+	books.Create(ctx, &book)
+
+	_, err := books.ApplyBatch(ctx, []domain.BookOperation{
+		{Op: domain.BatchCreate, Book: &domain.Book{Title: "New", Author: "Author"}},
+		{Op: domain.BatchUpdate, ID: book.ID, Book: &domain.Book{Title: "Changed", Author: "Author"}},
+		{Op: domain.BatchDelete, ID: book.ID, Version: 1},
+	})
+
+	var batchErr *domain.BatchError
+	assert.Equal(t, errors.As(err, &batchErr), true)
+	assert.Equal(t, batchErr.Index, 2)
+	assert.Equal(t, batchErr.Err, domain.ErrBookVersionMismatch)
+
+	// nothing of the failed batch is left
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 1)
+	assert.Equal(t, all[0].Title, "Title")
+	assert.Equal(t, all[0].Version, int64(1))
+
+	applied, err := books.ApplyBatch(ctx, []domain.BookOperation{
+		{Op: domain.BatchCreate, Book: &domain.Book{Title: "New", Author: "Author"}},
+		{Op: domain.BatchDelete, ID: book.ID, Version: 1},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(applied), 2)
+
+	all, _ = books.GetAll(ctx)
+	assert.Equal(t, len(all), 1)
+	assert.Equal(t, all[0].Title, "New")
+}
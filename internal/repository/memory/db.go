@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// DB holds the tables of the in-memory repositories. Like the database, it is shared by all
+// of them: a single lock makes every call atomic, deleting a user takes its sessions along
+// and a revision needs its book. Nothing outlives the process.
+type DB struct {
+	mu sync.Mutex
+
+	// sequences hands out the IDs of every table, like BIGSERIAL they aren't reused
+	sequences map[string]int64
+
+	tables
+}
+
+type tables struct {
+	books        map[int64]domain.Book
+	revisions    []revisionRow
+	users        map[int64]domain.User
+	sessions     map[string]domain.RefreshSession
+	identities   map[int64]domain.UserIdentity
+	importJobs   map[int64]domain.ImportJob
+	oauthClients map[string]domain.OAuthClient
+	oauthCodes   map[string]domain.AuthorizationCode
+	webhooks     map[int64]domain.Webhook
+	deliveries   map[int64]domain.WebhookDelivery
+	attempts     map[int64][]domain.WebhookAttempt
+}
+
+func NewDB() *DB {
+	return &DB{
+		sequences: make(map[string]int64),
+		tables: tables{
+			books:        make(map[int64]domain.Book),
+			users:        make(map[int64]domain.User),
+			sessions:     make(map[string]domain.RefreshSession),
+			identities:   make(map[int64]domain.UserIdentity),
+			importJobs:   make(map[int64]domain.ImportJob),
+			oauthClients: make(map[string]domain.OAuthClient),
+			oauthCodes:   make(map[string]domain.AuthorizationCode),
+			webhooks:     make(map[int64]domain.Webhook),
+			deliveries:   make(map[int64]domain.WebhookDelivery),
+			attempts:     make(map[int64][]domain.WebhookAttempt),
+		},
+	}
+}
+
+// clone copies the tables, so that a transaction can put them back when it fails. The rows
+// are values and are replaced rather than changed in place, the attempts are copied as they
+// are appended to.
+func (t tables) clone() tables {
+	attempts := make(map[int64][]domain.WebhookAttempt, len(t.attempts))
+	for id, a := range t.attempts {
+		attempts[id] = append([]domain.WebhookAttempt(nil), a...)
+	}
+
+	return tables{
+		books:        maps.Clone(t.books),
+		revisions:    append([]revisionRow(nil), t.revisions...),
+		users:        maps.Clone(t.users),
+		sessions:     maps.Clone(t.sessions),
+		identities:   maps.Clone(t.identities),
+		importJobs:   maps.Clone(t.importJobs),
+		oauthClients: maps.Clone(t.oauthClients),
+		oauthCodes:   maps.Clone(t.oauthCodes),
+		webhooks:     maps.Clone(t.webhooks),
+		deliveries:   maps.Clone(t.deliveries),
+		attempts:     attempts,
+	}
+}
+
+type txKey struct{}
+
+// lock takes the lock of the DB for a call and returns what releases it. A transaction
+// already holds it for all the calls made with its context.
+func (db *DB) lock(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == db {
+		return func() {}
+	}
+
+	db.mu.Lock()
+
+	return db.mu.Unlock
+}
+
+// Transactor runs functions in a transaction, like psql.Transactor. It holds the lock of the
+// DB until the function returns, so the repositories must be called with the context the
+// function gets.
+type Transactor struct {
+	db *DB
+}
+
+func NewTransactor(db *DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+// WithinTransaction puts the tables back as they were when fn fails. Called within a
+// transaction, fn joins it.
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) == t.db {
+		return fn(ctx)
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	// like rolled back sequences, the IDs handed out stay used
+	saved := t.db.tables.clone()
+
+	if err := fn(context.WithValue(ctx, txKey{}, t.db)); err != nil {
+		t.db.tables = saved
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) nextID(table string) int64 {
+	db.sequences[table]++
+
+	return db.sequences[table]
+}
+
+// deleteUser follows the foreign keys of the users table: most rows of the user go with it,
+// its books and revisions lose their owner and actor.
+func (db *DB) deleteUser(id int64) {
+	delete(db.users, id)
+	db.deleteSessions(id)
+
+	for key, identity := range db.identities {
+		if identity.UserID == id {
+			delete(db.identities, key)
+		}
+	}
+
+	for key, job := range db.importJobs {
+		if job.UserID == id {
+			delete(db.importJobs, key)
+		}
+	}
+
+	for code, c := range db.oauthCodes {
+		if c.UserID == id {
+			delete(db.oauthCodes, code)
+		}
+	}
+
+	for key, hook := range db.webhooks {
+		if hook.UserID == id {
+			db.deleteWebhook(key)
+		}
+	}
+
+	for key, book := range db.books {
+		if book.OwnerID == id {
+			book.OwnerID = 0
+			db.books[key] = book
+		}
+	}
+
+	for i := range db.revisions {
+		if db.revisions[i].rev.ActorID == id {
+			db.revisions[i].rev.ActorID = 0
+		}
+	}
+}
+
+func (db *DB) deleteWebhook(id int64) {
+	delete(db.webhooks, id)
+
+	for key, delivery := range db.deliveries {
+		if delivery.WebhookID == id {
+			delete(db.deliveries, key)
+			delete(db.attempts, key)
+		}
+	}
+}
+
+func (db *DB) deleteOAuthClient(clientID string) {
+	delete(db.oauthClients, clientID)
+
+	for token, session := range db.sessions {
+		if session.ClientID == clientID {
+			delete(db.sessions, token)
+		}
+	}
+
+	for code, c := range db.oauthCodes {
+		if c.ClientID == clientID {
+			delete(db.oauthCodes, code)
+		}
+	}
+}
+
+// sortedIDs returns the keys of a table in the order of a primary key index.
+func sortedIDs[T any](table map[int64]T) []int64 {
+	ids := make([]int64, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestTransactor(t *testing.T) {
+	db := NewDB()
+	books, revisions, transactor := NewBookRepository(db), NewBookRevisions(db), NewTransactor(db)
+	ctx := context.Background()
+
+	failed := errors.New("failed")
+	err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		book := domain.Book{Title: "Title", Author: "Author"}
+		books.Create(ctx, &book)
+		revisions.Create(ctx, domain.BookRevision{BookID: book.ID, Revision: book.Version, Book: book})
+
+		// a nested transaction joins the outer one
+		return transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			return failed
+		})
+	})
+	assert.Equal(t, err, failed)
+
+	// nothing of the transaction is left, the ID it used stays used
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 0)
+
+	events, _ := revisions.Events(ctx, 0, 10)
+	assert.Equal(t, len(events), 0)
+
+	err = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		return books.Create(ctx, &domain.Book{Title: "Title", Author: "Author"})
+	})
+	assert.Equal(t, err, nil)
+
+	all, _ = books.GetAll(ctx)
+	assert.Equal(t, len(all), 1)
+	assert.Equal(t, all[0].ID, int64(2))
+}
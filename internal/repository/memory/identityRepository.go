@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+type Identities struct {
+	db *DB
+}
+
+func NewIdentities(db *DB) *Identities {
+	return &Identities{db: db}
+}
+
+func (i *Identities) Create(ctx context.Context, identity domain.UserIdentity) error {
+	defer i.db.lock(ctx)()
+
+	if _, ok := i.db.users[identity.UserID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	if _, err := i.db.identity(identity.Provider, identity.Subject); err == nil {
+		return domain.ErrUserAlreadyExists
+	}
+
+	identity.ID = i.db.nextID("user_identities")
+	i.db.identities[identity.ID] = identity
+
+	return nil
+}
+
+func (i *Identities) Get(ctx context.Context, provider, subject string) (domain.UserIdentity, error) {
+	defer i.db.lock(ctx)()
+
+	return i.db.identity(provider, subject)
+}
+
+func (i *Identities) ListByUser(ctx context.Context, userID int64) ([]domain.UserIdentity, error) {
+	defer i.db.lock(ctx)()
+
+	identities := make([]domain.UserIdentity, 0)
+	for _, id := range sortedIDs(i.db.identities) {
+		if identity := i.db.identities[id]; identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
+
+func (i *Identities) DeleteByUser(ctx context.Context, userID int64) error {
+	defer i.db.lock(ctx)()
+
+	for id, identity := range i.db.identities {
+		if identity.UserID == userID {
+			delete(i.db.identities, id)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) identity(provider, subject string) (domain.UserIdentity, error) {
+	for _, identity := range db.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+
+	return domain.UserIdentity{}, domain.ErrIdentityNotFound
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+type ImportJobs struct {
+	db *DB
+}
+
+func NewImportJobs(db *DB) *ImportJobs {
+	return &ImportJobs{db: db}
+}
+
+func (i *ImportJobs) Create(ctx context.Context, job *domain.ImportJob) error {
+	defer i.db.lock(ctx)()
+
+	if _, ok := i.db.users[job.UserID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	job.ID = i.db.nextID("import_jobs")
+	i.db.importJobs[job.ID] = domain.ImportJob{
+		ID:          job.ID,
+		UserID:      job.UserID,
+		Format:      job.Format,
+		DryRun:      job.DryRun,
+		OnDuplicate: job.OnDuplicate,
+		Status:      job.Status,
+		Errors:      make([]domain.ImportRowError, 0),
+		CreatedAt:   job.CreatedAt,
+	}
+
+	return nil
+}
+
+// Update saves the progress and the outcome of the job.
+func (i *ImportJobs) Update(ctx context.Context, job domain.ImportJob) error {
+	defer i.db.lock(ctx)()
+
+	stored, ok := i.db.importJobs[job.ID]
+	if !ok {
+		return domain.ErrImportJobNotFound
+	}
+
+	stored.Status = job.Status
+	stored.Processed, stored.Created, stored.Updated, stored.Skipped, stored.Failed = job.Processed, job.Created, job.Updated, job.Skipped, job.Failed
+	stored.Errors = append(make([]domain.ImportRowError, 0, len(job.Errors)), job.Errors...)
+	stored.Error = job.Error
+	stored.FinishedAt = job.FinishedAt
+	i.db.importJobs[job.ID] = stored
+
+	return nil
+}
+
+func (i *ImportJobs) Get(ctx context.Context, id int64) (domain.ImportJob, error) {
+	defer i.db.lock(ctx)()
+
+	job, ok := i.db.importJobs[id]
+	if !ok {
+		return job, domain.ErrImportJobNotFound
+	}
+
+	return job, nil
+}
+
+func (i *ImportJobs) ListByUser(ctx context.Context, userID int64) ([]domain.ImportJob, error) {
+	defer i.db.lock(ctx)()
+
+	jobs := make([]domain.ImportJob, 0)
+	for _, id := range sortedIDs(i.db.importJobs) {
+		if job := i.db.importJobs[id]; job.UserID == userID {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+func (i *ImportJobs) DeleteByUser(ctx context.Context, userID int64) error {
+	defer i.db.lock(ctx)()
+
+	for id, job := range i.db.importJobs {
+		if job.UserID == userID {
+			delete(i.db.importJobs, id)
+		}
+	}
+
+	return nil
+}
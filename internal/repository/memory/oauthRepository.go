@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+var (
+	errClientExists = errors.New("the OAuth client already exists")
+	errCodeExists   = errors.New("the authorization code already exists")
+)
+
+type OAuthClients struct {
+	db *DB
+}
+
+func NewOAuthClients(db *DB) *OAuthClients {
+	return &OAuthClients{db: db}
+}
+
+func (o *OAuthClients) Create(ctx context.Context, client *domain.OAuthClient) error {
+	defer o.db.lock(ctx)()
+
+	if _, ok := o.db.oauthClients[client.ClientID]; ok {
+		return errClientExists
+	}
+
+	client.ID = o.db.nextID("oauth_clients")
+	o.db.oauthClients[client.ClientID] = *client
+
+	return nil
+}
+
+func (o *OAuthClients) GetByClientID(ctx context.Context, clientID string) (domain.OAuthClient, error) {
+	defer o.db.lock(ctx)()
+
+	client, ok := o.db.oauthClients[clientID]
+	if !ok {
+		return client, domain.ErrOAuthClientNotFound
+	}
+
+	return client, nil
+}
+
+func (o *OAuthClients) List(ctx context.Context) ([]domain.OAuthClient, error) {
+	defer o.db.lock(ctx)()
+
+	clients := make([]domain.OAuthClient, 0, len(o.db.oauthClients))
+	for _, client := range o.db.oauthClients {
+		clients = append(clients, client)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+
+	return clients, nil
+}
+
+// Delete also ends the sessions and pending codes of the client.
+func (o *OAuthClients) Delete(ctx context.Context, clientID string) error {
+	defer o.db.lock(ctx)()
+
+	if _, ok := o.db.oauthClients[clientID]; !ok {
+		return domain.ErrOAuthClientNotFound
+	}
+
+	o.db.deleteOAuthClient(clientID)
+
+	return nil
+}
+
+type AuthorizationCodes struct {
+	db *DB
+}
+
+func NewAuthorizationCodes(db *DB) *AuthorizationCodes {
+	return &AuthorizationCodes{db: db}
+}
+
+// Create reports an unknown user, or client, as domain.ErrOAuthClientNotFound like
+// psql.AuthorizationCodes.
+func (a *AuthorizationCodes) Create(ctx context.Context, code domain.AuthorizationCode) error {
+	defer a.db.lock(ctx)()
+
+	_, clientOK := a.db.oauthClients[code.ClientID]
+	_, userOK := a.db.users[code.UserID]
+	if !clientOK || !userOK {
+		return domain.ErrOAuthClientNotFound
+	}
+
+	if _, ok := a.db.oauthCodes[code.Code]; ok {
+		return errCodeExists
+	}
+
+	a.db.oauthCodes[code.Code] = code
+
+	return nil
+}
+
+// Consume deletes the code while reading it, so a code can only be exchanged once.
+func (a *AuthorizationCodes) Consume(ctx context.Context, code string) (domain.AuthorizationCode, error) {
+	defer a.db.lock(ctx)()
+
+	c, ok := a.db.oauthCodes[code]
+	if !ok {
+		return c, domain.ErrAuthorizationCodeInvalid
+	}
+
+	delete(a.db.oauthCodes, code)
+
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// revisionRow is a revision with its ID, the ID of its event in the book feed.
+type revisionRow struct {
+	id  int64
+	rev domain.BookRevision
+}
+
+type BookRevisions struct {
+	db *DB
+}
+
+func NewBookRevisions(db *DB) *BookRevisions {
+	return &BookRevisions{db: db}
+}
+
+func (r *BookRevisions) Create(ctx context.Context, rev domain.BookRevision) error {
+	defer r.db.lock(ctx)()
+
+	if _, ok := r.db.books[rev.BookID]; !ok {
+		return domain.ErrBookNotFound
+	}
+
+	for _, row := range r.db.revisions {
+		if row.rev.BookID == rev.BookID && row.rev.Revision == rev.Revision {
+			return fmt.Errorf("revision %d of book %d already exists", rev.Revision, rev.BookID)
+		}
+	}
+
+	r.db.revisions = append(r.db.revisions, revisionRow{id: r.db.nextID("book_revisions"), rev: rev})
+
+	return nil
+}
+
+// List returns the revisions of the book, the oldest first.
+func (r *BookRevisions) List(ctx context.Context, bookID int64) ([]domain.BookRevision, error) {
+	defer r.db.lock(ctx)()
+
+	revisions := make([]domain.BookRevision, 0)
+	for _, row := range r.db.revisions {
+		if row.rev.BookID == bookID {
+			revisions = append(revisions, row.rev)
+		}
+	}
+
+	return revisions, nil
+}
+
+func (r *BookRevisions) Get(ctx context.Context, bookID, revision int64) (domain.BookRevision, error) {
+	defer r.db.lock(ctx)()
+
+	for _, row := range r.db.revisions {
+		if row.rev.BookID == bookID && row.rev.Revision == revision {
+			return row.rev, nil
+		}
+	}
+
+	return domain.BookRevision{}, domain.ErrRevisionNotFound
+}
+
+// AsOf returns the last revision made until the given time.
+func (r *BookRevisions) AsOf(ctx context.Context, bookID int64, at time.Time) (domain.BookRevision, error) {
+	defer r.db.lock(ctx)()
+
+	var (
+		last  domain.BookRevision
+		found bool
+	)
+	for _, row := range r.db.revisions {
+		if row.rev.BookID == bookID && !row.rev.CreatedAt.After(at) && (!found || row.rev.Revision > last.Revision) {
+			last, found = row.rev, true
+		}
+	}
+
+	if !found {
+		return last, domain.ErrRevisionNotFound
+	}
+
+	return last, nil
+}
+
+// Events returns up to limit changes made after the event with afterID, the oldest first.
+// The events are the revisions, with their IDs.
+func (r *BookRevisions) Events(ctx context.Context, afterID int64, limit int) ([]domain.BookEvent, error) {
+	defer r.db.lock(ctx)()
+
+	events := make([]domain.BookEvent, 0)
+	for _, row := range r.db.revisions {
+		if len(events) == limit {
+			break
+		}
+		if row.id > afterID {
+			events = append(events, domain.BookEvent{ID: row.id, Action: row.rev.Action, Book: row.rev.Book})
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+var errTokenExists = errors.New("the refresh token already exists")
+
+type Tokens struct {
+	db *DB
+}
+
+func NewTokens(db *DB) *Tokens {
+	return &Tokens{db: db}
+}
+
+// Create reports an unknown user, or OAuth client, as domain.ErrUserNotFound like psql.Tokens.
+func (t *Tokens) Create(ctx context.Context, token domain.RefreshSession) error {
+	defer t.db.lock(ctx)()
+
+	if _, ok := t.db.users[token.UserID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	if _, ok := t.db.oauthClients[token.ClientID]; token.ClientID != "" && !ok {
+		return domain.ErrUserNotFound
+	}
+
+	if _, ok := t.db.sessions[token.Token]; ok {
+		return errTokenExists
+	}
+
+	token.ID = t.db.nextID("refresh_tokens")
+	token.CreatedAt = time.Now()
+	t.db.sessions[token.Token] = token
+
+	return nil
+}
+
+// Get uses up a session of our own, along with the user's other ones. The sessions of the
+// OAuth2 clients are left alone, like psql.Tokens does.
+func (t *Tokens) Get(ctx context.Context, token string) (domain.RefreshSession, error) {
+	defer t.db.lock(ctx)()
+
+	session, ok := t.db.sessions[token]
+	if !ok || session.ClientID != "" {
+		return domain.RefreshSession{}, domain.ErrRefreshTokenNotFound
+	}
+
+	for token, other := range t.db.sessions {
+		if other.UserID == session.UserID && other.ClientID == "" {
+			delete(t.db.sessions, token)
+		}
+	}
+
+	return session, nil
+}
+
+// Find looks a session up without using it.
+func (t *Tokens) Find(ctx context.Context, token string) (domain.RefreshSession, error) {
+	defer t.db.lock(ctx)()
+
+	session, ok := t.db.sessions[token]
+	if !ok {
+		return session, domain.ErrRefreshTokenNotFound
+	}
+
+	return session, nil
+}
+
+// Consume deletes only the given session, unlike Get the other sessions of the user stay alive.
+func (t *Tokens) Consume(ctx context.Context, token string) (domain.RefreshSession, error) {
+	defer t.db.lock(ctx)()
+
+	session, ok := t.db.sessions[token]
+	if !ok {
+		return session, domain.ErrRefreshTokenNotFound
+	}
+
+	delete(t.db.sessions, token)
+
+	return session, nil
+}
+
+// ListByUser returns the sessions of the user, the newest first.
+func (t *Tokens) ListByUser(ctx context.Context, userID int64) ([]domain.RefreshSession, error) {
+	defer t.db.lock(ctx)()
+
+	sessions := make([]domain.RefreshSession, 0)
+	for _, session := range t.db.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (t *Tokens) DeleteByUser(ctx context.Context, userID int64) error {
+	defer t.db.lock(ctx)()
+
+	t.db.deleteSessions(userID)
+
+	return nil
+}
+
+func (db *DB) deleteSessions(userID int64) {
+	for token, session := range db.sessions {
+		if session.UserID == userID {
+			delete(db.sessions, token)
+		}
+	}
+}
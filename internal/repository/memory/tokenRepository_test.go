@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestTokens_Get(t *testing.T) {
+	testTable := []struct {
+		name         string
+		token        string
+		wantErr      error
+		wantSessions []string
+	}{
+		{
+			name:         "Own session",
+			token:        "own",
+			wantSessions: []string{"client"},
+		},
+		{
+			name:         "Session of an OAuth2 client",
+			token:        "client",
+			wantErr:      domain.ErrRefreshTokenNotFound,
+			wantSessions: []string{"own", "other", "client"},
+		},
+		{
+			name:         "Unknown session",
+			token:        "unknown",
+			wantErr:      domain.ErrRefreshTokenNotFound,
+			wantSessions: []string{"own", "other", "client"},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := NewDB()
+			tokens := NewTokens(db)
+			ctx := context.Background()
+
+			NewUserRepository(db).CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"})
+			NewOAuthClients(db).Create(ctx, &domain.OAuthClient{ClientID: "app"})
+			for _, session := range []domain.RefreshSession{{Token: "own"}, {Token: "other"}, {Token: "client", ClientID: "app"}} {
+				session.UserID, session.ExpiresAt = 1, time.Now().Add(time.Hour)
+				if err := tokens.Create(ctx, session); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := tokens.Get(ctx, testCase.token)
+			assert.Equal(t, err, testCase.wantErr)
+
+			for _, token := range []string{"own", "other", "client"} {
+				_, err := tokens.Find(ctx, token)
+				assert.Equal(t, err == nil, slices.Contains(testCase.wantSessions, token), token)
+			}
+		})
+	}
+}
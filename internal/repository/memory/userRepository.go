@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+const defaultUsersLimit = 20
+
+// UserRepository keeps the emails unique regardless of case, like the users_email_key index.
+type UserRepository struct {
+	db *DB
+}
+
+func NewUserRepository(db *DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (u *UserRepository) CreateUser(ctx context.Context, user domain.User) error {
+	defer u.db.lock(ctx)()
+
+	created := domain.User{
+		Name:         user.Name,
+		Email:        user.Email,
+		Password:     user.Password,
+		RegisteredAt: user.RegisteredAt,
+		Role:         domain.RoleUser,
+	}
+	if u.db.userConflicts(created) {
+		return domain.ErrUserAlreadyExists
+	}
+
+	created.ID = u.db.nextID("users")
+	u.db.users[created.ID] = created
+
+	return nil
+}
+
+func (u *UserRepository) GetByCredential(ctx context.Context, email string, password string) (domain.User, error) {
+	return u.find(ctx, func(user domain.User) bool {
+		return strings.ToLower(user.Email) == email && user.Password == password
+	})
+}
+
+func (u *UserRepository) GetByEmail(ctx context.Context, email string) (domain.User, error) {
+	return u.find(ctx, func(user domain.User) bool {
+		return strings.ToLower(user.Email) == email
+	})
+}
+
+func (u *UserRepository) GetByID(ctx context.Context, id int64) (domain.User, error) {
+	defer u.db.lock(ctx)()
+
+	user, ok := u.db.users[id]
+	if !ok {
+		return domain.User{}, domain.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// GetByIDs skips the IDs of unknown users.
+func (u *UserRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error) {
+	defer u.db.lock(ctx)()
+
+	users := make([]domain.User, 0, len(ids))
+	for _, id := range ids {
+		if user, ok := u.db.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func (u *UserRepository) GetByEmailToken(ctx context.Context, token string) (domain.User, error) {
+	return u.find(ctx, func(user domain.User) bool {
+		return token != "" && user.EmailToken == token
+	})
+}
+
+func (u *UserRepository) GetByResetToken(ctx context.Context, token string) (domain.User, error) {
+	return u.find(ctx, func(user domain.User) bool {
+		return token != "" && user.ResetToken == token
+	})
+}
+
+func (u *UserRepository) List(ctx context.Context, filter domain.UserFilter) (domain.UserList, error) {
+	defer u.db.lock(ctx)()
+
+	list := domain.UserList{Users: make([]domain.User, 0)}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultUsersLimit
+	}
+
+	for _, id := range sortedIDs(u.db.users) {
+		user := u.db.users[id]
+		if !containsFold(user.Name, filter.Search) && !containsFold(user.Email, filter.Search) {
+			continue
+		}
+
+		if list.Total >= int64(filter.Offset) && len(list.Users) < limit {
+			list.Users = append(list.Users, user)
+		}
+		list.Total++
+	}
+
+	return list, nil
+}
+
+// Update leaves the registration date as it is.
+func (u *UserRepository) Update(ctx context.Context, user domain.User) error {
+	defer u.db.lock(ctx)()
+
+	stored, ok := u.db.users[user.ID]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+
+	if u.db.userConflicts(user) {
+		return domain.ErrUserAlreadyExists
+	}
+
+	user.RegisteredAt = stored.RegisteredAt
+	u.db.users[user.ID] = user
+
+	return nil
+}
+
+// Delete cleans up everything that references the user, like ON DELETE CASCADE.
+func (u *UserRepository) Delete(ctx context.Context, id int64) error {
+	defer u.db.lock(ctx)()
+
+	if _, ok := u.db.users[id]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	u.db.deleteUser(id)
+
+	return nil
+}
+
+// find returns the first user that matches.
+func (u *UserRepository) find(ctx context.Context, match func(domain.User) bool) (domain.User, error) {
+	defer u.db.lock(ctx)()
+
+	for _, id := range sortedIDs(u.db.users) {
+		if user := u.db.users[id]; match(user) {
+			return user, nil
+		}
+	}
+
+	return domain.User{}, domain.ErrUserNotFound
+}
+
+// userConflicts tells whether another user has the email or one of the tokens of user.
+func (db *DB) userConflicts(user domain.User) bool {
+	for id, other := range db.users {
+		if id == user.ID {
+			continue
+		}
+
+		if strings.EqualFold(other.Email, user.Email) ||
+			user.EmailToken != "" && other.EmailToken == user.EmailToken ||
+			user.ResetToken != "" && other.ResetToken == user.ResetToken {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestUserRepository_CreateUser(t *testing.T) {
+	users := NewUserRepository(NewDB())
+	ctx := context.Background()
+
+	assert.Equal(t, users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"}), nil)
+
+	testTable := []struct {
+		name    string
+		email   string
+		wantErr error
+	}{
+		{
+			name:    "Same email",
+			email:   "andy@example.com",
+			wantErr: domain.ErrUserAlreadyExists,
+		},
+		{
+			name:    "Same email in another case",
+			email:   "Andy@Example.com",
+			wantErr: domain.ErrUserAlreadyExists,
+		},
+		{
+			name:  "Another email",
+			email: "bob@example.com",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := users.CreateUser(ctx, domain.User{Name: "Name", Email: testCase.email, Password: "hash"})
+
+			assert.Equal(t, err, testCase.wantErr)
+		})
+	}
+
+	user, err := users.GetByCredential(ctx, "andy@example.com", "hash")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, user.Role, domain.RoleUser)
+
+	_, err = users.GetByCredential(ctx, "andy@example.com", "wrong")
+	assert.Equal(t, err, domain.ErrUserNotFound)
+}
+
+func TestUserRepository_Delete(t *testing.T) {
+	db := NewDB()
+	users, sessions, books := NewUserRepository(db), NewTokens(db), NewBookRepository(db)
+	ctx := context.Background()
+
+	users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com"})
+	users.CreateUser(ctx, domain.User{Name: "Bob", Email: "bob@example.com"})
+
+	sessions.Create(ctx, domain.RefreshSession{UserID: 1, Token: "andy", ExpiresAt: time.Now().Add(time.Hour)})
+	sessions.Create(ctx, domain.RefreshSession{UserID: 2, Token: "bob", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Equal(t, sessions.Create(ctx, domain.RefreshSession{UserID: 3, Token: "nobody"}), domain.ErrUserNotFound)
+
+	book := domain.Book{Title: "Title", Author: "Author", OwnerID: 1}
+	books.Create(ctx, &book)
+
+	assert.Equal(t, users.Delete(ctx, 1), nil)
+	assert.Equal(t, users.Delete(ctx, 1), domain.ErrUserNotFound)
+
+	// the sessions go with the user, the books lose their owner
+	_, err := sessions.Find(ctx, "andy")
+	assert.Equal(t, err, domain.ErrRefreshTokenNotFound)
+
+	_, err = sessions.Find(ctx, "bob")
+	assert.Equal(t, err, nil)
+
+	book, _ = books.GetByID(ctx, book.ID)
+	assert.Equal(t, book.OwnerID, int64(0))
+}
@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+type Webhooks struct {
+	db *DB
+}
+
+func NewWebhooks(db *DB) *Webhooks {
+	return &Webhooks{db: db}
+}
+
+func (w *Webhooks) Create(ctx context.Context, hook *domain.Webhook) error {
+	defer w.db.lock(ctx)()
+
+	if _, ok := w.db.users[hook.UserID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	hook.ID = w.db.nextID("webhooks")
+	w.db.webhooks[hook.ID] = *hook
+
+	return nil
+}
+
+func (w *Webhooks) List(ctx context.Context, userID int64) ([]domain.Webhook, error) {
+	return w.selectWebhooks(ctx, func(hook domain.Webhook) bool {
+		return hook.UserID == userID
+	}), nil
+}
+
+func (w *Webhooks) Get(ctx context.Context, id int64) (domain.Webhook, error) {
+	defer w.db.lock(ctx)()
+
+	hook, ok := w.db.webhooks[id]
+	if !ok {
+		return hook, domain.ErrWebhookNotFound
+	}
+
+	return hook, nil
+}
+
+// Delete only deletes a webhook of the user, its deliveries go with it.
+func (w *Webhooks) Delete(ctx context.Context, userID, id int64) error {
+	defer w.db.lock(ctx)()
+
+	if hook, ok := w.db.webhooks[id]; !ok || hook.UserID != userID {
+		return domain.ErrWebhookNotFound
+	}
+
+	w.db.deleteWebhook(id)
+
+	return nil
+}
+
+// DeleteByUser deletes the webhooks of the user along with their deliveries.
+func (w *Webhooks) DeleteByUser(ctx context.Context, userID int64) error {
+	defer w.db.lock(ctx)()
+
+	for id, hook := range w.db.webhooks {
+		if hook.UserID == userID {
+			w.db.deleteWebhook(id)
+		}
+	}
+
+	return nil
+}
+
+// Subscribed returns the webhooks that receive the event.
+func (w *Webhooks) Subscribed(ctx context.Context, event string) ([]domain.Webhook, error) {
+	return w.selectWebhooks(ctx, func(hook domain.Webhook) bool {
+		for _, e := range hook.Events {
+			if e == event {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (w *Webhooks) selectWebhooks(ctx context.Context, match func(domain.Webhook) bool) []domain.Webhook {
+	defer w.db.lock(ctx)()
+
+	hooks := make([]domain.Webhook, 0)
+	for _, id := range sortedIDs(w.db.webhooks) {
+		if hook := w.db.webhooks[id]; match(hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks
+}
+
+type WebhookDeliveries struct {
+	db *DB
+}
+
+func NewWebhookDeliveries(db *DB) *WebhookDeliveries {
+	return &WebhookDeliveries{db: db}
+}
+
+func (d *WebhookDeliveries) Create(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	defer d.db.lock(ctx)()
+
+	if _, ok := d.db.webhooks[delivery.WebhookID]; !ok {
+		return domain.ErrWebhookNotFound
+	}
+
+	delivery.ID = d.db.nextID("webhook_deliveries")
+	d.db.deliveries[delivery.ID] = domain.WebhookDelivery{
+		ID:            delivery.ID,
+		WebhookID:     delivery.WebhookID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        delivery.Status,
+		NextAttemptAt: copyTime(delivery.NextAttemptAt),
+		CreatedAt:     delivery.CreatedAt,
+	}
+
+	return nil
+}
+
+// List returns the last deliveries of the webhook with their attempts, the newest first.
+func (d *WebhookDeliveries) List(ctx context.Context, webhookID int64, limit int) ([]domain.WebhookDelivery, error) {
+	defer d.db.lock(ctx)()
+
+	ids := sortedIDs(d.db.deliveries)
+
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for i := len(ids) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if delivery := d.db.deliveries[ids[i]]; delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, d.db.withAttempts(delivery))
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (d *WebhookDeliveries) Get(ctx context.Context, id int64) (domain.WebhookDelivery, error) {
+	defer d.db.lock(ctx)()
+
+	delivery, ok := d.db.deliveries[id]
+	if !ok {
+		return delivery, domain.ErrDeliveryNotFound
+	}
+
+	return d.db.withAttempts(delivery), nil
+}
+
+// Claim returns the pending deliveries that are due and holds them back for lease, so
+// that another worker doesn't send them at the same time. Recording an attempt releases them.
+func (d *WebhookDeliveries) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	defer d.db.lock(ctx)()
+
+	due := make([]domain.WebhookDelivery, 0)
+	for _, delivery := range d.db.deliveries {
+		if delivery.Status == domain.DeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	leased := now.Add(lease)
+	for i := range due {
+		due[i].NextAttemptAt = copyTime(&leased)
+		d.db.deliveries[due[i].ID] = due[i]
+	}
+
+	return due, nil
+}
+
+// RecordAttempt saves the attempt along with the status of the delivery that follows from it.
+// An unknown delivery is reported as domain.ErrWebhookNotFound, like psql.WebhookDeliveries.
+func (d *WebhookDeliveries) RecordAttempt(ctx context.Context, deliveryID int64, attempt domain.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	defer d.db.lock(ctx)()
+
+	delivery, ok := d.db.deliveries[deliveryID]
+	if !ok {
+		return domain.ErrWebhookNotFound
+	}
+
+	d.db.attempts[deliveryID] = append(d.db.attempts[deliveryID], attempt)
+
+	delivery.Status = status
+	delivery.AttemptCount++
+	delivery.NextAttemptAt = copyTime(nextAttemptAt)
+	d.db.deliveries[deliveryID] = delivery
+
+	return nil
+}
+
+func (db *DB) withAttempts(delivery domain.WebhookDelivery) domain.WebhookDelivery {
+	delivery.Attempts = append(make([]domain.WebhookAttempt, 0), db.attempts[delivery.ID]...)
+
+	return delivery
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+
+	return &c
+}
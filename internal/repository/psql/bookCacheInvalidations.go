@@ -0,0 +1,94 @@
+package psql
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// bookCacheChannel carries the comma separated IDs of the books changed by a replica
+	bookCacheChannel = "book_cache"
+
+	// maxNotifyPayload stays below the 8000 bytes NOTIFY takes, longer lists of IDs are
+	// sent as everything
+	maxNotifyPayload = 7900
+	everyBook        = "*"
+)
+
+// BookCacheInvalidations tells the replicas which books to forget through NOTIFY. Like
+// BookEventListener, it listens on a connection of its own.
+type BookCacheInvalidations struct {
+	db      *pgxpool.Pool
+	connect func(ctx context.Context) (*pgx.Conn, error)
+}
+
+func NewBookCacheInvalidations(db *pgxpool.Pool, connect func(ctx context.Context) (*pgx.Conn, error)) *BookCacheInvalidations {
+	return &BookCacheInvalidations{db: db, connect: connect}
+}
+
+func (i *BookCacheInvalidations) Notify(ctx context.Context, ids []int64) error {
+	payload := make([]string, len(ids))
+	for n, id := range ids {
+		payload[n] = strconv.FormatInt(id, 10)
+	}
+
+	message := strings.Join(payload, ",")
+	if ids == nil || len(message) > maxNotifyPayload {
+		message = everyBook
+	}
+
+	_, err := i.db.Exec(ctx, `SELECT pg_notify($1, $2)`, bookCacheChannel, message)
+
+	return err
+}
+
+// Listen calls fn with the IDs of every notification until ctx is done or the connection
+// fails. The notifications sent while nobody listened are lost, so fn is first called with
+// nil, every book.
+func (i *BookCacheInvalidations) Listen(ctx context.Context, fn func(ids []int64)) error {
+	conn, err := i.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+bookCacheChannel); err != nil {
+		return err
+	}
+
+	fn(nil)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		fn(parseInvalidation(notification.Payload))
+	}
+}
+
+func parseInvalidation(payload string) []int64 {
+	ids := make([]int64, 0)
+	if payload == "" {
+		return ids
+	}
+	if payload == everyBook {
+		return nil
+	}
+
+	for _, field := range strings.Split(payload, ",") {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			// a message that can't be read may have named any book
+			return nil
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
@@ -0,0 +1,141 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// bookEventsChannel is notified by a trigger of book_revisions with the ID of every new revision
+	bookEventsChannel = "book_events"
+
+	listenerFetchSize = 500
+
+	// gapTimeout is how long an ID skipped by the events is waited for. A transaction takes
+	// its IDs when it writes but shows them when it commits, the events of one running longer
+	// are missed. A rolled back one never fills its gaps.
+	gapTimeout = time.Minute
+	// maxGaps bounds the IDs waited for, a larger gap is only waited for in part.
+	maxGaps = 10000
+
+	listenerRequest = `SELECT id, action, snapshot FROM book_revisions WHERE id > $1 OR id = ANY($2) ORDER BY id LIMIT $3`
+)
+
+// BookEventListener hears about the revisions written by every replica through LISTEN and
+// NOTIFY. A connection that listens can't be shared, so it has one of its own.
+type BookEventListener struct {
+	connect func(ctx context.Context) (*pgx.Conn, error)
+
+	// lastID is the last event handed out, a new connection carries on from there
+	lastID int64
+	// the IDs are taken in one order and committed in another, those skipped below lastID
+	// may still show up
+	gaps eventGaps
+}
+
+func NewBookEventListener(connect func(ctx context.Context) (*pgx.Conn, error)) *BookEventListener {
+	return &BookEventListener{connect: connect, gaps: make(eventGaps)}
+}
+
+// Listen calls fn with the events written from the first call on, in the order they are
+// committed, until ctx is done or the connection fails. Calling it again carries on where
+// it stopped.
+func (l *BookEventListener) Listen(ctx context.Context, fn func(domain.BookEvent)) error {
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+bookEventsChannel); err != nil {
+		return err
+	}
+
+	if l.lastID == 0 {
+		if err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM book_revisions`).Scan(&l.lastID); err != nil {
+			return err
+		}
+	}
+
+	for {
+		// the events missed while there was no connection are read right away
+		if err := l.dispatch(ctx, conn, fn); err != nil {
+			return err
+		}
+
+		// the payload isn't needed, every notification is a reason to read what's new
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *BookEventListener) dispatch(ctx context.Context, conn *pgx.Conn, fn func(domain.BookEvent)) error {
+	l.gaps.expire(time.Now().Add(-gapTimeout))
+
+	for {
+		rows, err := conn.Query(ctx, listenerRequest, l.lastID, l.gaps.ids(), listenerFetchSize)
+		if err != nil {
+			return err
+		}
+
+		events, err := scanEvents(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if !l.gaps.fill(event.ID) {
+				l.gaps.skip(l.lastID+1, event.ID, time.Now())
+				l.lastID = event.ID
+			}
+			fn(event)
+		}
+
+		if len(events) < listenerFetchSize {
+			return nil
+		}
+	}
+}
+
+// eventGaps are the IDs of the events that weren't seen yet, with when they were skipped.
+type eventGaps map[int64]time.Time
+
+// skip waits for the IDs from from up to to, to excluded.
+func (g eventGaps) skip(from, to int64, at time.Time) {
+	for id := from; id < to && len(g) < maxGaps; id++ {
+		g[id] = at
+	}
+}
+
+// fill reports whether id was waited for and stops waiting for it.
+func (g eventGaps) fill(id int64) bool {
+	if _, ok := g[id]; !ok {
+		return false
+	}
+
+	delete(g, id)
+
+	return true
+}
+
+// expire gives up the IDs skipped before the time.
+func (g eventGaps) expire(before time.Time) {
+	for id, at := range g {
+		if at.Before(before) {
+			delete(g, id)
+		}
+	}
+}
+
+func (g eventGaps) ids() []int64 {
+	ids := make([]int64, 0, len(g))
+	for id := range g {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
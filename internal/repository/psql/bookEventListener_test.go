@@ -0,0 +1,37 @@
+package psql
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestEventGaps(t *testing.T) {
+	now := time.Now()
+	gaps := make(eventGaps)
+
+	// 2 and 3 are still being written when 4 is committed
+	gaps.skip(2, 4, now)
+	assert.Equal(t, sortedIDs(gaps), []int64{2, 3})
+
+	assert.Equal(t, gaps.fill(3), true)
+	assert.Equal(t, gaps.fill(3), false)
+	assert.Equal(t, gaps.fill(5), false)
+	assert.Equal(t, sortedIDs(gaps), []int64{2})
+
+	gaps.skip(6, 7, now.Add(gapTimeout))
+	gaps.expire(now.Add(time.Second))
+	assert.Equal(t, sortedIDs(gaps), []int64{6})
+
+	gaps.skip(10, 10+2*maxGaps, now)
+	assert.Equal(t, len(gaps), maxGaps)
+}
+
+func sortedIDs(gaps eventGaps) []int64 {
+	ids := gaps.ids()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
@@ -2,9 +2,10 @@ package psql
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,19 +13,42 @@ import (
 	"github.com/andy-ahmedov/crud_service/internal/domain"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const bookColumns = "id, title, author, publish_date, rating, COALESCE(isbn, ''), COALESCE(owner_id, 0), version, deleted_at"
+
+const (
+	createBookRequest = `INSERT INTO books(title, author, publish_date, rating, isbn, owner_id) VALUES($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0)) RETURNING ` + bookColumns
+	deleteBookRequest = `UPDATE books SET deleted_at=now(), version=version+1 WHERE id=$1 AND deleted_at IS NULL AND ($2::BIGINT = 0 OR version=$2) RETURNING ` + bookColumns
+	// listBooksRequest is shared by the listing and the export, so that they return the same books.
+	listBooksRequest = `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NULL`
+	// bookFilterCondition narrows listBooksRequest down by a domain.BookFilter, see filterArgs.
+	bookFilterCondition = ` AND ($1 = '' OR title ILIKE '%' || $1 || '%') AND ($2 = '' OR author ILIKE '%' || $2 || '%')
+		AND ($3::bigint = 0 OR owner_id = $3) AND ($4 = 0 OR rating >= $4) AND ($5 = 0 OR rating <= $5)`
+)
+
+// exportFetchSize is how many books Stream fetches from the cursor at a time.
+const exportFetchSize = 500
+
+// exportCursors numbers the cursors of Stream.
+var exportCursors atomic.Int64
+
+var bookErrors = errorMapping{
+	notFound: domain.ErrBookNotFound,
+}
+
 type Books struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewBookRepository(db *pgx.Conn) *Books {
+func NewBookRepository(db *pgxpool.Pool) *Books {
 	return &Books{db}
 }
 
 func (b *Books) Create(ctx context.Context, book *domain.Book) error {
-	request := `INSERT INTO books(title, author, publish_date, rating) VALUES($1, $2, $3, $4) RETURNING id`
-	if err := b.db.QueryRow(ctx, request, book.Title, book.Author, book.PublishDate, book.Rating).Scan(&book.ID); err != nil {
+	request := `INSERT INTO books(title, author, publish_date, rating, isbn, owner_id) VALUES($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0)) RETURNING id, version`
+	if err := conn(ctx, b.db).QueryRow(ctx, request, createArgs(*book)...).Scan(&book.ID, &book.Version); err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok {
 			// newErr := fmt.Errorf(fmt.Sprintf("SQL Error: %s, Detail: %s, Where: %s, Code: %s, SQLState: %s", pgErr.Message, pgErr.Detail, pgErr.Where, pgErr.Code, pgErr.SQLState()))
 			log.WithFields(log.Fields{
@@ -37,37 +61,148 @@ func (b *Books) Create(ctx context.Context, book *domain.Book) error {
 				"code":     pgErr.Code,
 				"SQLState": pgErr.SQLState(),
 			}).Error(err)
-			return nil
 		}
-		return err
+		return bookErrors.convert(err)
 	}
 
 	return nil
 }
 
 func (b *Books) GetByID(ctx context.Context, id int64) (domain.Book, error) {
-	var book domain.Book
-	request := fmt.Sprintf(`SELECT * FROM books WHERE id=$1`)
-	err := b.db.QueryRow(ctx, request, id).Scan(&book.ID, &book.Title, &book.Author, &book.PublishDate, &book.Rating)
-	if err == sql.ErrNoRows {
-		return book, domain.ErrBookNotFound
-	}
-	return book, err
+	request := `SELECT ` + bookColumns + ` FROM books WHERE id=$1 AND deleted_at IS NULL`
+
+	book, err := scanBook(conn(ctx, b.db).QueryRow(ctx, request, id))
+
+	return book, bookErrors.convert(err)
 }
 
 func (b *Books) GetAll(ctx context.Context) ([]domain.Book, error) {
-	books := make([]domain.Book, 0)
-	request := "SELECT * FROM books"
+	return b.query(ctx, listBooksRequest)
+}
+
+// List is GetAll narrowed down by the filter, a page at a time. The pages are ordered by ID.
+func (b *Books) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	args := append(filterArgs(filter), afterID, limit)
+
+	return b.query(ctx, listBooksRequest+bookFilterCondition+` AND id > $6 ORDER BY id LIMIT $7`, args...)
+}
+
+func filterArgs(filter domain.BookFilter) []interface{} {
+	return []interface{}{filter.Title, filter.Author, filter.OwnerID, filter.MinRating, filter.MaxRating}
+}
+
+// Stream calls fn with the books List returns for the filter, ordered by ID. They are read
+// through a server-side cursor, so only a few of them are in memory at a time. An error
+// returned by fn stops the stream.
+//
+// The cursor lives in a read-only transaction on a connection of its own, which is held
+// until the stream ends: other requests never run inside it.
+func (b *Books) Stream(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error {
+	tx, err := b.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// cursor names are per connection, a unique one keeps streams apart should they ever share one
+	cursor := fmt.Sprintf("book_export_%d", exportCursors.Add(1))
+
+	declare := `DECLARE ` + cursor + ` NO SCROLL CURSOR FOR ` + listBooksRequest + bookFilterCondition + ` ORDER BY id`
+	if _, err := tx.Exec(ctx, declare, filterArgs(filter)...); err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM %s", exportFetchSize, cursor)
+
+	for {
+		books, err := b.fetch(ctx, tx, fetch)
+		if err != nil {
+			return err
+		}
+
+		for _, book := range books {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+
+		if len(books) < exportFetchSize {
+			return tx.Commit(ctx)
+		}
+	}
+}
 
-	rows, err := b.db.Query(ctx, request)
+func (b *Books) fetch(ctx context.Context, tx pgx.Tx, request string) ([]domain.Book, error) {
+	books := make([]domain.Book, 0, exportFetchSize)
+
+	rows, err := tx.Query(ctx, request)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var book domain.Book
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+// FindDuplicate looks for a book with the ISBN, or with the title and author when the ISBN
+// is empty. Both are compared case insensitively.
+func (b *Books) FindDuplicate(ctx context.Context, isbn, title, author string) (domain.Book, error) {
+	request := `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NULL AND
+		CASE WHEN $1 <> '' THEN UPPER(isbn)=UPPER($1) ELSE LOWER(title)=LOWER($2) AND LOWER(author)=LOWER($3) END
+		ORDER BY id LIMIT 1`
+
+	book, err := scanBook(conn(ctx, b.db).QueryRow(ctx, request, isbn, title, author))
+
+	return book, bookErrors.convert(err)
+}
+
+// Trash returns the deleted books that haven't been purged yet, the latest first.
+func (b *Books) Trash(ctx context.Context) ([]domain.Book, error) {
+	return b.query(ctx, `SELECT `+bookColumns+` FROM books WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)
+}
 
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.PublishDate, &book.Rating)
+// GetByOwner includes the books in the trash, they are still personal data of the owner.
+func (b *Books) GetByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	return b.query(ctx, `SELECT `+bookColumns+` FROM books WHERE owner_id=$1 ORDER BY id`, ownerID)
+}
+
+// DeleteByOwner moves the books of the owner to the trash and returns them as they are in it.
+func (b *Books) DeleteByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	request := `UPDATE books SET deleted_at=now(), version=version+1 WHERE owner_id=$1 AND deleted_at IS NULL RETURNING ` + bookColumns
+
+	return b.query(ctx, request, ownerID)
+}
+
+// ReassignOwner hands the books over to another user, newOwnerID 0 leaves them without an owner.
+// It returns the reassigned books. An unknown newOwnerID is reported as domain.ErrUserNotFound.
+func (b *Books) ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]domain.Book, error) {
+	request := `UPDATE books SET owner_id=NULLIF($2, 0), version=version+1 WHERE owner_id=$1 RETURNING ` + bookColumns
+
+	books, err := b.query(ctx, request, ownerID, newOwnerID)
+
+	return books, errorMapping{invalidReference: domain.ErrUserNotFound}.convert(err)
+}
+
+func (b *Books) query(ctx context.Context, request string, args ...interface{}) ([]domain.Book, error) {
+	books := make([]domain.Book, 0)
+
+	rows, err := conn(ctx, b.db).Query(ctx, request, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		book, err := scanBook(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -82,12 +217,139 @@ func (b *Books) GetAll(ctx context.Context) ([]domain.Book, error) {
 	return books, nil
 }
 
-func (b *Books) Delete(ctx context.Context, id int64) error {
-	_, err := b.db.Exec(ctx, "DELETE FROM books WHERE id=$1", id)
-	return err
+func scanBook(row pgx.Row) (domain.Book, error) {
+	var book domain.Book
+
+	err := row.Scan(&book.ID, &book.Title, &book.Author, &book.PublishDate, &book.Rating, &book.ISBN, &book.OwnerID, &book.Version, &book.DeletedAt)
+
+	return book, err
+}
+
+// Delete moves the book to the trash while it still has expectedVersion, 0 accepts any version.
+// It returns the book as it is in the trash.
+func (b *Books) Delete(ctx context.Context, id, expectedVersion int64) (domain.Book, error) {
+	book, err := scanBook(conn(ctx, b.db).QueryRow(ctx, deleteBookRequest, id, expectedVersion))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return book, b.missingOrChanged(ctx, id)
+	}
+
+	return book, err
+}
+
+// Restore takes the book out of the trash, books that aren't in it are reported as not found.
+func (b *Books) Restore(ctx context.Context, id int64) (domain.Book, error) {
+	request := `UPDATE books SET deleted_at=NULL, version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL RETURNING ` + bookColumns
+
+	book, err := scanBook(conn(ctx, b.db).QueryRow(ctx, request, id))
+
+	return book, bookErrors.convert(err)
+}
+
+// Purge deletes the book for good, whether it is in the trash or not.
+func (b *Books) Purge(ctx context.Context, id int64) error {
+	tag, err := conn(ctx, b.db).Exec(ctx, "DELETE FROM books WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrBookNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeletedBefore empties the trash of the books deleted before the given time and
+// returns how many there were.
+func (b *Books) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := conn(ctx, b.db).Exec(ctx, "DELETE FROM books WHERE deleted_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Update only changes the book while it still has expectedVersion, 0 accepts any version.
+// It returns the book as it is after the update.
+func (b *Books) Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	if upd.Empty() {
+		book, err := b.GetByID(ctx, id)
+		if err == nil && expectedVersion != 0 && book.Version != expectedVersion {
+			return domain.Book{}, domain.ErrBookVersionMismatch
+		}
+		return book, err
+	}
+
+	query, args := updateBookRequest(id, expectedVersion, upd)
+
+	book, err := scanBook(conn(ctx, b.db).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return book, b.missingOrChanged(ctx, id)
+	}
+
+	return book, err
+}
+
+// ApplyBatch runs the operations in a single transaction and a single round trip. When one
+// of them fails, nothing is written and a *domain.BatchError tells which one it was. The
+// transaction holds a connection of the pool, the other requests don't run inside it.
+// Within a Transactor it is a savepoint of the surrounding transaction.
+func (b *Books) ApplyBatch(ctx context.Context, ops []domain.BookOperation) ([]domain.Book, error) {
+	tx, err := conn(ctx, b.db).Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, op := range ops {
+		switch op.Op {
+		case domain.BatchCreate:
+			batch.Queue(createBookRequest, createArgs(*op.Book)...)
+		case domain.BatchUpdate:
+			query, args := updateBookRequest(op.ID, op.Version, op.Book.Replacement())
+			batch.Queue(query, args...)
+		case domain.BatchDelete:
+			batch.Queue(deleteBookRequest, op.ID, op.Version)
+		default:
+			return nil, fmt.Errorf("unknown operation %q", op.Op)
+		}
+	}
+
+	results := tx.SendBatch(ctx, batch)
+
+	books := make([]domain.Book, len(ops))
+	for i, op := range ops {
+		book, err := scanBook(results.QueryRow())
+		if err == nil {
+			books[i] = book
+			continue
+		}
+
+		results.Close()
+		tx.Rollback(ctx)
+
+		if errors.Is(err, pgx.ErrNoRows) && op.Op != domain.BatchCreate {
+			err = b.missingOrChanged(ctx, op.ID)
+		}
+
+		return nil, &domain.BatchError{Index: i, Err: bookErrors.convert(err)}
+	}
+
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+
+	return books, tx.Commit(ctx)
 }
 
-func (b *Books) Update(ctx context.Context, id int64, upd domain.UpdateBookInput) error {
+func createArgs(book domain.Book) []interface{} {
+	return []interface{}{book.Title, book.Author, book.PublishDate, book.Rating, book.ISBN, book.OwnerID}
+}
+
+// updateBookRequest sets the fields of upd, upd must not be empty.
+func updateBookRequest(id, expectedVersion int64, upd domain.UpdateBookInput) (string, []interface{}) {
 	setValues := make([]string, 0)
 	args := make([]interface{}, 0)
 	argId := 1
@@ -108,11 +370,6 @@ func (b *Books) Update(ctx context.Context, id int64, upd domain.UpdateBookInput
 		setValues = append(setValues, fmt.Sprintf("publish_date=$%d", argId))
 		args = append(args, *upd.PublishDate)
 		argId++
-	} else {
-		t := time.Now()
-		setValues = append(setValues, fmt.Sprintf("publish_date=$%d", argId))
-		args = append(args, &t)
-		argId++
 	}
 
 	if upd.Rating != nil {
@@ -121,10 +378,32 @@ func (b *Books) Update(ctx context.Context, id int64, upd domain.UpdateBookInput
 		argId++
 	}
 
+	if upd.ISBN != nil {
+		setValues = append(setValues, fmt.Sprintf("isbn=NULLIF($%d, '')", argId))
+		args = append(args, *upd.ISBN)
+		argId++
+	}
+
+	setValues = append(setValues, "version=version+1")
+
 	setQuery := strings.Join(setValues, ", ")
-	query := fmt.Sprintf("UPDATE books SET %s WHERE id=$%d", setQuery, argId)
-	args = append(args, id)
+	query := fmt.Sprintf("UPDATE books SET %s WHERE id=$%d AND deleted_at IS NULL AND ($%d::BIGINT = 0 OR version=$%d) RETURNING %s", setQuery, argId, argId+1, argId+1, bookColumns)
+	args = append(args, id, expectedVersion)
+
+	return query, args
+}
+
+// missingOrChanged tells a missing book from one changed by someone else when a conditional
+// statement didn't touch any row.
+func (b *Books) missingOrChanged(ctx context.Context, id int64) error {
+	var exists bool
+	if err := conn(ctx, b.db).QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM books WHERE id=$1 AND deleted_at IS NULL)", id).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return domain.ErrBookNotFound
+	}
 
-	_, err := b.db.Exec(ctx, query, args...)
-	return err
+	return domain.ErrBookVersionMismatch
 }
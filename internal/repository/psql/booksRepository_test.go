@@ -0,0 +1,140 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBooks_ApplyBatch(t *testing.T) {
+	books := NewBookRepository(newTestPool(t))
+	ctx := context.Background()
+
+	book := domain.Book{Title: "Title", Author: "Author", PublishDate: time.Now(), Rating: 3}
+	if err := books.Create(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := books.ApplyBatch(ctx, []domain.BookOperation{
+		{Op: domain.BatchCreate, Book: &domain.Book{Title: "New", Author: "Author", PublishDate: time.Now(), Rating: 3}},
+		{Op: domain.BatchUpdate, ID: book.ID, Book: &domain.Book{Title: "Changed", Author: "Author", PublishDate: time.Now(), Rating: 3}},
+		{Op: domain.BatchDelete, ID: book.ID, Version: 1},
+	})
+
+	var batchErr *domain.BatchError
+	assert.Equal(t, errors.As(err, &batchErr), true)
+	assert.Equal(t, batchErr.Index, 2)
+	assert.Equal(t, batchErr.Err, domain.ErrBookVersionMismatch)
+
+	// nothing of the failed batch is left
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 1)
+	assert.Equal(t, all[0].Title, "Title")
+	assert.Equal(t, all[0].Version, int64(1))
+}
+
+func TestBooks_ApplyBatch_concurrentWrites(t *testing.T) {
+	books := NewBookRepository(newTestPool(t))
+	ctx := context.Background()
+
+	// failing batches run next to plain creates, which have to survive the rollbacks
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			books.ApplyBatch(ctx, []domain.BookOperation{
+				{Op: domain.BatchCreate, Book: &domain.Book{Title: "Batch", Author: "Author", PublishDate: time.Now(), Rating: 3}},
+				{Op: domain.BatchDelete, ID: -1},
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			if err := books.Create(ctx, &domain.Book{Title: "Single", Author: "Author", PublishDate: time.Now(), Rating: 3}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 20)
+	for _, book := range all {
+		assert.Equal(t, book.Title, "Single")
+	}
+}
+
+func TestBooks_Stream(t *testing.T) {
+	books := NewBookRepository(newTestPool(t))
+	ctx := context.Background()
+
+	for _, title := range []string{"Go in Action", "Learning Python", "Concurrency in Go"} {
+		if err := books.Create(ctx, &domain.Book{Title: title, Author: "Author", PublishDate: time.Now(), Rating: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a second export and a write run while the first one is streaming
+	titles := make([]string, 0)
+	err := books.Stream(ctx, domain.BookFilter{Title: "go"}, func(book domain.Book) error {
+		titles = append(titles, book.Title)
+
+		nested := 0
+		if err := books.Stream(ctx, domain.BookFilter{}, func(domain.Book) error {
+			nested++
+			return nil
+		}); err != nil {
+			return err
+		}
+		if nested < 3 {
+			t.Errorf("nested export got %d books", nested)
+		}
+
+		return books.Create(ctx, &domain.Book{Title: "Written meanwhile", Author: "Author", PublishDate: time.Now(), Rating: 3})
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, titles, []string{"Go in Action", "Concurrency in Go"})
+
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 5)
+}
+
+func TestBooks_PurgeDeletedBefore(t *testing.T) {
+	db := newTestPool(t)
+	books := NewBookRepository(db)
+	ctx := context.Background()
+
+	ids := make([]int64, 0)
+	for _, title := range []string{"Old", "Recent", "Live"} {
+		book := domain.Book{Title: title, Author: "Author", PublishDate: time.Now(), Rating: 3}
+		if err := books.Create(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, book.ID)
+	}
+
+	for _, id := range ids[:2] {
+		if _, err := books.Delete(ctx, id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(ctx, "UPDATE books SET deleted_at = deleted_at - interval '2 hours' WHERE id=$1", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := books.PurgeDeletedBefore(ctx, time.Now().Add(-time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, purged, int64(1))
+
+	trash, _ := books.Trash(ctx)
+	assert.Equal(t, len(trash), 1)
+	assert.Equal(t, trash[0].ID, ids[1])
+
+	_, err = books.GetByID(ctx, ids[2])
+	assert.Equal(t, err, nil)
+}
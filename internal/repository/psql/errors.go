@@ -0,0 +1,46 @@
+package psql
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// errorMapping tells which domain error a repository reports instead of a raw pgx error.
+// Empty fields leave the original error untouched.
+type errorMapping struct {
+	notFound         error
+	conflict         error
+	invalidReference error
+}
+
+func (m errorMapping) convert(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) && m.notFound != nil {
+		return m.notFound
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case pgErr.Code == uniqueViolation && m.conflict != nil:
+		return m.conflict
+	case pgErr.Code == foreignKeyViolation && m.invalidReference != nil:
+		return m.invalidReference
+	}
+
+	return err
+}
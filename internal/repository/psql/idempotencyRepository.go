@@ -0,0 +1,106 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/pkg/idempotency"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+const idempotencyAttempts = 5
+
+type IdempotencyKeys struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyKeys(db *pgxpool.Pool) *IdempotencyKeys {
+	return &IdempotencyKeys{db: db}
+}
+
+// Reserve inserts the key, or takes over an expired one. When neither works the key is
+// taken and its record is read, unless it was released in the meantime and the whole
+// thing is retried.
+func (k *IdempotencyKeys) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (idempotency.Record, bool, error) {
+	request := `INSERT INTO idempotency_keys(key, fingerprint, expires_at) VALUES($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET fingerprint=EXCLUDED.fingerprint, done=false, status_code=0, headers=NULL, body=NULL, expires_at=EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= $4`
+
+	for i := 0; i < idempotencyAttempts; i++ {
+		now := time.Now().UTC()
+
+		tag, err := k.db.Exec(ctx, request, key, fingerprint, now.Add(ttl), now)
+		if err != nil {
+			return idempotency.Record{}, false, err
+		}
+		if tag.RowsAffected() == 1 {
+			return idempotency.Record{Fingerprint: fingerprint}, true, nil
+		}
+
+		var rec idempotency.Record
+		err = k.db.QueryRow(ctx, `SELECT fingerprint, done, status_code, COALESCE(headers, '{}'::JSONB), COALESCE(body, ''::BYTEA) FROM idempotency_keys WHERE key=$1`, key).
+			Scan(&rec.Fingerprint, &rec.Done, &rec.Status, &rec.Header, &rec.Body)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return idempotency.Record{}, false, err
+		}
+
+		return rec, false, nil
+	}
+
+	return idempotency.Record{}, false, errors.New("idempotency key is under contention")
+}
+
+func (k *IdempotencyKeys) Save(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) error {
+	request := `UPDATE idempotency_keys SET fingerprint=$2, done=$3, status_code=$4, headers=$5, body=$6, expires_at=$7 WHERE key=$1`
+	_, err := k.db.Exec(ctx, request, key, rec.Fingerprint, rec.Done, rec.Status, rec.Header, rec.Body, time.Now().UTC().Add(ttl))
+
+	return err
+}
+
+func (k *IdempotencyKeys) Release(ctx context.Context, key string) error {
+	_, err := k.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE key=$1`, key)
+
+	return err
+}
+
+// Purge deletes the keys expired before the given time, those nobody asks for again are
+// never taken over by Reserve.
+func (k *IdempotencyKeys) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := k.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// RunPurge purges the expired keys every interval until ctx is done.
+func (k *IdempotencyKeys) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := k.Purge(ctx, time.Now())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"method": "IdempotencyKeys.RunPurge",
+			}).Error("failed to purge the keys:", err)
+		} else if purged > 0 {
+			log.WithFields(log.Fields{
+				"method": "IdempotencyKeys.RunPurge",
+			}).Infof("purged %d expired keys", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
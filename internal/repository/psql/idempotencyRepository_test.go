@@ -0,0 +1,28 @@
+package psql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestIdempotencyKeys_Purge(t *testing.T) {
+	keys := NewIdempotencyKeys(newTestPool(t))
+	ctx := context.Background()
+
+	for key, ttl := range map[string]time.Duration{"expired": -time.Minute, "live": time.Hour} {
+		if _, _, err := keys.Reserve(ctx, key, "fingerprint", ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := keys.Purge(ctx, time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, purged, int64(1))
+
+	_, reserved, err := keys.Reserve(ctx, "live", "other", time.Hour)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, reserved, false)
+}
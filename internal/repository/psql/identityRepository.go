@@ -0,0 +1,66 @@
+package psql
+
+import (
+	"context"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const identityColumns = "id, user_id, provider, subject, email, created_at"
+
+var identityErrors = errorMapping{
+	notFound:         domain.ErrIdentityNotFound,
+	conflict:         domain.ErrUserAlreadyExists,
+	invalidReference: domain.ErrUserNotFound,
+}
+
+type Identities struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentities(db *pgxpool.Pool) *Identities {
+	return &Identities{db: db}
+}
+
+func (i *Identities) Create(ctx context.Context, identity domain.UserIdentity) error {
+	request := `INSERT INTO user_identities(user_id, provider, subject, email, created_at) VALUES($1, $2, $3, $4, $5)`
+	_, err := conn(ctx, i.db).Exec(ctx, request, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt)
+
+	return identityErrors.convert(err)
+}
+
+func (i *Identities) Get(ctx context.Context, provider, subject string) (domain.UserIdentity, error) {
+	var identity domain.UserIdentity
+
+	request := `SELECT ` + identityColumns + ` FROM user_identities WHERE provider=$1 AND subject=$2`
+	err := conn(ctx, i.db).QueryRow(ctx, request, provider, subject).Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+
+	return identity, identityErrors.convert(err)
+}
+
+func (i *Identities) ListByUser(ctx context.Context, userID int64) ([]domain.UserIdentity, error) {
+	rows, err := conn(ctx, i.db).Query(ctx, `SELECT `+identityColumns+` FROM user_identities WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]domain.UserIdentity, 0)
+	for rows.Next() {
+		var identity domain.UserIdentity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (i *Identities) DeleteByUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, i.db).Exec(ctx, `DELETE FROM user_identities WHERE user_id=$1`, userID)
+
+	return err
+}
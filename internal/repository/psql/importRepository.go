@@ -0,0 +1,101 @@
+package psql
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const importJobColumns = "id, user_id, format, dry_run, on_duplicate, status, processed, created, updated, skipped, failed, errors, COALESCE(error, ''), created_at, finished_at"
+
+var importJobErrors = errorMapping{
+	notFound:         domain.ErrImportJobNotFound,
+	invalidReference: domain.ErrUserNotFound,
+}
+
+type ImportJobs struct {
+	db *pgxpool.Pool
+}
+
+func NewImportJobs(db *pgxpool.Pool) *ImportJobs {
+	return &ImportJobs{db: db}
+}
+
+func (i *ImportJobs) Create(ctx context.Context, job *domain.ImportJob) error {
+	request := `INSERT INTO import_jobs(user_id, format, dry_run, on_duplicate, status, created_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	err := conn(ctx, i.db).QueryRow(ctx, request, job.UserID, job.Format, job.DryRun, job.OnDuplicate, job.Status, job.CreatedAt).Scan(&job.ID)
+
+	return importJobErrors.convert(err)
+}
+
+// Update saves the progress and the outcome of the job.
+func (i *ImportJobs) Update(ctx context.Context, job domain.ImportJob) error {
+	rowErrors, err := json.Marshal(job.Errors)
+	if err != nil {
+		return err
+	}
+
+	request := `UPDATE import_jobs SET status=$2, processed=$3, created=$4, updated=$5, skipped=$6, failed=$7, errors=$8::JSONB, error=NULLIF($9, ''), finished_at=$10 WHERE id=$1`
+	tag, err := conn(ctx, i.db).Exec(ctx, request, job.ID, job.Status, job.Processed, job.Created, job.Updated, job.Skipped, job.Failed, string(rowErrors), job.Error, job.FinishedAt)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrImportJobNotFound
+	}
+
+	return nil
+}
+
+func (i *ImportJobs) Get(ctx context.Context, id int64) (domain.ImportJob, error) {
+	job, err := scanImportJob(conn(ctx, i.db).QueryRow(ctx, `SELECT `+importJobColumns+` FROM import_jobs WHERE id=$1`, id))
+
+	return job, importJobErrors.convert(err)
+}
+
+func (i *ImportJobs) ListByUser(ctx context.Context, userID int64) ([]domain.ImportJob, error) {
+	rows, err := conn(ctx, i.db).Query(ctx, `SELECT `+importJobColumns+` FROM import_jobs WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]domain.ImportJob, 0)
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (i *ImportJobs) DeleteByUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, i.db).Exec(ctx, `DELETE FROM import_jobs WHERE user_id=$1`, userID)
+
+	return err
+}
+
+func scanImportJob(row pgx.Row) (domain.ImportJob, error) {
+	var (
+		job       domain.ImportJob
+		rowErrors []byte
+	)
+
+	err := row.Scan(&job.ID, &job.UserID, &job.Format, &job.DryRun, &job.OnDuplicate, &job.Status, &job.Processed,
+		&job.Created, &job.Updated, &job.Skipped, &job.Failed, &rowErrors, &job.Error, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		return job, err
+	}
+
+	err = json.Unmarshal(rowErrors, &job.Errors)
+
+	return job, err
+}
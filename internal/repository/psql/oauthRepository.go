@@ -0,0 +1,116 @@
+package psql
+
+import (
+	"context"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const oauthClientColumns = "id, client_id, secret_hash, name, redirect_uris, grant_types, scopes, public, created_at"
+
+var oauthClientErrors = errorMapping{
+	notFound: domain.ErrOAuthClientNotFound,
+}
+
+var authorizationCodeErrors = errorMapping{
+	notFound:         domain.ErrAuthorizationCodeInvalid,
+	invalidReference: domain.ErrOAuthClientNotFound,
+}
+
+type OAuthClients struct {
+	db *pgxpool.Pool
+}
+
+func NewOAuthClients(db *pgxpool.Pool) *OAuthClients {
+	return &OAuthClients{db: db}
+}
+
+func (o *OAuthClients) Create(ctx context.Context, client *domain.OAuthClient) error {
+	request := `INSERT INTO oauth_clients(client_id, secret_hash, name, redirect_uris, grant_types, scopes, public, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err := conn(ctx, o.db).QueryRow(ctx, request, client.ClientID, client.SecretHash, client.Name, client.RedirectURIs,
+		client.GrantTypes, client.Scopes, client.Public, client.CreatedAt).Scan(&client.ID)
+
+	return oauthClientErrors.convert(err)
+}
+
+func (o *OAuthClients) GetByClientID(ctx context.Context, clientID string) (domain.OAuthClient, error) {
+	client, err := scanOAuthClient(conn(ctx, o.db).QueryRow(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE client_id=$1", clientID))
+
+	return client, oauthClientErrors.convert(err)
+}
+
+func (o *OAuthClients) List(ctx context.Context) ([]domain.OAuthClient, error) {
+	clients := make([]domain.OAuthClient, 0)
+
+	rows, err := conn(ctx, o.db).Query(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// Delete also ends the sessions and pending codes of the client through the foreign keys.
+func (o *OAuthClients) Delete(ctx context.Context, clientID string) error {
+	tag, err := conn(ctx, o.db).Exec(ctx, "DELETE FROM oauth_clients WHERE client_id=$1", clientID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+func scanOAuthClient(row pgx.Row) (domain.OAuthClient, error) {
+	var client domain.OAuthClient
+
+	err := row.Scan(&client.ID, &client.ClientID, &client.SecretHash, &client.Name, &client.RedirectURIs,
+		&client.GrantTypes, &client.Scopes, &client.Public, &client.CreatedAt)
+
+	return client, err
+}
+
+type AuthorizationCodes struct {
+	db *pgxpool.Pool
+}
+
+func NewAuthorizationCodes(db *pgxpool.Pool) *AuthorizationCodes {
+	return &AuthorizationCodes{db: db}
+}
+
+func (a *AuthorizationCodes) Create(ctx context.Context, code domain.AuthorizationCode) error {
+	request := `INSERT INTO oauth_codes(code, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := conn(ctx, a.db).Exec(ctx, request, code.Code, code.ClientID, code.UserID, code.RedirectURI, code.Scope,
+		code.CodeChallenge, code.CodeChallengeMethod, code.ExpiresAt)
+
+	return authorizationCodeErrors.convert(err)
+}
+
+// Consume deletes the code while reading it, so a code can only be exchanged once.
+func (a *AuthorizationCodes) Consume(ctx context.Context, code string) (domain.AuthorizationCode, error) {
+	var c domain.AuthorizationCode
+
+	request := `DELETE FROM oauth_codes WHERE code=$1
+		RETURNING code, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, expires_at`
+	err := conn(ctx, a.db).QueryRow(ctx, request, code).Scan(&c.Code, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scope,
+		&c.CodeChallenge, &c.CodeChallengeMethod, &c.ExpiresAt)
+
+	return c, authorizationCodeErrors.convert(err)
+}
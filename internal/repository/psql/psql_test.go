@@ -0,0 +1,58 @@
+package psql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestPool connects to the database of TEST_DATABASE_URL and creates the tables of
+// script.sql in a schema of the test, which is dropped afterwards. Without the variable
+// the test is skipped.
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	script, err := os.ReadFile("../../../script.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	ctx := context.Background()
+
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, string(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
@@ -0,0 +1,98 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+const rateLimitAttempts = 5
+
+type RateLimits struct {
+	db *pgxpool.Pool
+}
+
+func NewRateLimits(db *pgxpool.Pool) *RateLimits {
+	return &RateLimits{db: db}
+}
+
+// Take updates the bucket optimistically: the row is only written if nobody else
+// touched it since it was read, otherwise the whole read-modify-write is retried.
+func (r *RateLimits) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	for i := 0; i < rateLimitAttempts; i++ {
+		now := time.Now().UTC()
+
+		var bucket ratelimit.Bucket
+		err := r.db.QueryRow(ctx, "SELECT tokens, updated_at FROM rate_limits WHERE key=$1", key).Scan(&bucket.Tokens, &bucket.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			bucket = ratelimit.NewBucket(limit, now)
+			res := bucket.Take(limit, now)
+
+			tag, err := r.db.Exec(ctx, "INSERT INTO rate_limits(key, tokens, updated_at) VALUES($1, $2, $3) ON CONFLICT (key) DO NOTHING", key, bucket.Tokens, bucket.UpdatedAt)
+			if err != nil {
+				return ratelimit.Result{}, err
+			}
+			if tag.RowsAffected() == 1 {
+				return res, nil
+			}
+			continue
+		}
+		if err != nil {
+			return ratelimit.Result{}, err
+		}
+
+		prev := bucket.UpdatedAt
+		res := bucket.Take(limit, now)
+
+		tag, err := r.db.Exec(ctx, "UPDATE rate_limits SET tokens=$1, updated_at=$2 WHERE key=$3 AND updated_at=$4", bucket.Tokens, bucket.UpdatedAt, key, prev)
+		if err != nil {
+			return ratelimit.Result{}, err
+		}
+		if tag.RowsAffected() == 1 {
+			return res, nil
+		}
+	}
+
+	return ratelimit.Result{}, errors.New("rate limit bucket is under contention")
+}
+
+// Purge deletes the buckets last taken from before the given time.
+func (r *RateLimits) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM rate_limits WHERE updated_at < $1", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// RunPurge deletes the buckets that have refilled completely every interval until ctx is
+// done, refill is the longest time a bucket of the limits takes to.
+func (r *RateLimits) RunPurge(ctx context.Context, interval, refill time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := r.Purge(ctx, time.Now().Add(-refill))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"method": "RateLimits.RunPurge",
+			}).Error("failed to purge the buckets:", err)
+		} else if purged > 0 {
+			log.WithFields(log.Fields{
+				"method": "RateLimits.RunPurge",
+			}).Infof("purged %d refilled buckets", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
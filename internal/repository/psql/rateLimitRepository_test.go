@@ -0,0 +1,32 @@
+package psql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestRateLimits_Purge(t *testing.T) {
+	db := newTestPool(t)
+	buckets := NewRateLimits(db)
+	ctx := context.Background()
+
+	limit := ratelimit.Limit{Requests: 1, Period: time.Minute}
+	for _, key := range []string{"old", "recent"} {
+		if _, err := buckets.Take(ctx, key, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Exec(ctx, "UPDATE rate_limits SET updated_at=$1 WHERE key='old'", time.Now().Add(-time.Hour))
+
+	purged, err := buckets.Purge(ctx, time.Now().Add(-limit.RefillTime()))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, purged, int64(1))
+
+	// the recent bucket is still empty
+	res, _ := buckets.Take(ctx, "recent", limit)
+	assert.Equal(t, res.Allowed, false)
+}
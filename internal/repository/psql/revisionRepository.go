@@ -0,0 +1,132 @@
+package psql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	revisionColumns = "book_id, revision, action, COALESCE(actor_id, 0), snapshot, created_at"
+
+	// eventsRequest reads the revisions as the events of the book feed
+	eventsRequest = `SELECT id, action, snapshot FROM book_revisions WHERE id > $1 ORDER BY id LIMIT $2`
+)
+
+var revisionErrors = errorMapping{
+	notFound:         domain.ErrRevisionNotFound,
+	invalidReference: domain.ErrBookNotFound,
+}
+
+type BookRevisions struct {
+	db *pgxpool.Pool
+}
+
+func NewBookRevisions(db *pgxpool.Pool) *BookRevisions {
+	return &BookRevisions{db: db}
+}
+
+func (r *BookRevisions) Create(ctx context.Context, rev domain.BookRevision) error {
+	snapshot, err := json.Marshal(rev.Book)
+	if err != nil {
+		return err
+	}
+
+	request := `INSERT INTO book_revisions(book_id, revision, action, actor_id, snapshot, created_at) VALUES($1, $2, $3, NULLIF($4, 0), $5::JSONB, $6)`
+	_, err = conn(ctx, r.db).Exec(ctx, request, rev.BookID, rev.Revision, rev.Action, rev.ActorID, string(snapshot), rev.CreatedAt)
+
+	return revisionErrors.convert(err)
+}
+
+// List returns the revisions of the book, the oldest first.
+func (r *BookRevisions) List(ctx context.Context, bookID int64) ([]domain.BookRevision, error) {
+	revisions := make([]domain.BookRevision, 0)
+
+	rows, err := conn(ctx, r.db).Query(ctx, `SELECT `+revisionColumns+` FROM book_revisions WHERE book_id=$1 ORDER BY revision`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (r *BookRevisions) Get(ctx context.Context, bookID, revision int64) (domain.BookRevision, error) {
+	request := `SELECT ` + revisionColumns + ` FROM book_revisions WHERE book_id=$1 AND revision=$2`
+
+	rev, err := scanRevision(conn(ctx, r.db).QueryRow(ctx, request, bookID, revision))
+
+	return rev, revisionErrors.convert(err)
+}
+
+// AsOf returns the last revision made until the given time.
+func (r *BookRevisions) AsOf(ctx context.Context, bookID int64, at time.Time) (domain.BookRevision, error) {
+	request := `SELECT ` + revisionColumns + ` FROM book_revisions WHERE book_id=$1 AND created_at <= $2 ORDER BY revision DESC LIMIT 1`
+
+	rev, err := scanRevision(conn(ctx, r.db).QueryRow(ctx, request, bookID, at))
+
+	return rev, revisionErrors.convert(err)
+}
+
+func scanRevision(row pgx.Row) (domain.BookRevision, error) {
+	var (
+		rev      domain.BookRevision
+		snapshot []byte
+	)
+
+	if err := row.Scan(&rev.BookID, &rev.Revision, &rev.Action, &rev.ActorID, &snapshot, &rev.CreatedAt); err != nil {
+		return rev, err
+	}
+
+	err := json.Unmarshal(snapshot, &rev.Book)
+
+	return rev, err
+}
+
+// Events returns up to limit changes made after the event with afterID, the oldest first.
+// The events are the revisions, with their IDs.
+func (r *BookRevisions) Events(ctx context.Context, afterID int64, limit int) ([]domain.BookEvent, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, eventsRequest, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEvents(rows)
+}
+
+func scanEvents(rows pgx.Rows) ([]domain.BookEvent, error) {
+	defer rows.Close()
+
+	events := make([]domain.BookEvent, 0)
+	for rows.Next() {
+		var (
+			event    domain.BookEvent
+			snapshot []byte
+		)
+
+		if err := rows.Scan(&event.ID, &event.Action, &snapshot); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(snapshot, &event.Book); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,34 @@
+package psql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBookRevisions_AsOf(t *testing.T) {
+	db := newTestPool(t)
+	books, revisions := NewBookRepository(db), NewBookRevisions(db)
+	ctx := context.Background()
+
+	book := domain.Book{Title: "Title", Author: "Author", PublishDate: time.Now(), Rating: 3}
+	if err := books.Create(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+
+	// the instant counts, not the wall clock of the zone it was written in
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60))
+	if err := revisions.Create(ctx, domain.BookRevision{BookID: book.ID, Revision: 1, Action: domain.RevisionCreate, Book: book, CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := revisions.AsOf(ctx, book.ID, time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	assert.Equal(t, err, domain.ErrRevisionNotFound)
+
+	rev, err := revisions.AsOf(ctx, book.ID, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rev.CreatedAt.Equal(created), true)
+}
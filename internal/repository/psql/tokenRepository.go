@@ -5,30 +5,88 @@ import (
 
 	"github.com/andy-ahmedov/crud_service/internal/domain"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const sessionColumns = "id, user_id, token, expires_at, created_at, COALESCE(client_id, ''), scope"
+
+var tokenErrors = errorMapping{
+	notFound:         domain.ErrRefreshTokenNotFound,
+	invalidReference: domain.ErrUserNotFound,
+}
+
 type Tokens struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewTokens(db *pgx.Conn) *Tokens {
+func NewTokens(db *pgxpool.Pool) *Tokens {
 	return &Tokens{db: db}
 }
 
 func (t *Tokens) Create(ctx context.Context, token domain.RefreshSession) error {
-	_, err := t.db.Exec(ctx, "INSERT INTO refresh_tokens(user_id, token, expires_at) VALUES($1, $2, $3)", token.UserID, token.Token, token.ExpiresAt)
+	request := "INSERT INTO refresh_tokens(user_id, token, expires_at, client_id, scope) VALUES($1, $2, $3, NULLIF($4, ''), $5)"
+	_, err := conn(ctx, t.db).Exec(ctx, request, token.UserID, token.Token, token.ExpiresAt, token.ClientID, token.Scope)
 
-	return err
+	return tokenErrors.convert(err)
 }
 
+// Get uses up a session of our own, along with the user's other ones. The sessions of the
+// OAuth2 clients are left alone, they are only refreshed through the token endpoint.
 func (t *Tokens) Get(ctx context.Context, token string) (domain.RefreshSession, error) {
-	var session domain.RefreshSession
+	session, err := scanSession(conn(ctx, t.db).QueryRow(ctx, "SELECT "+sessionColumns+" FROM refresh_tokens WHERE token=$1 AND client_id IS NULL", token))
+	if err != nil {
+		return session, tokenErrors.convert(err)
+	}
+	_, err = conn(ctx, t.db).Exec(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1 AND client_id IS NULL", session.UserID)
 
-	err := t.db.QueryRow(ctx, "SELECT id, user_id, token, expires_at FROM refresh_tokens WHERE token=$1", token).Scan(&session.ID, &session.UserID, &session.Token, &session.ExpiresAt)
+	return session, err
+}
+
+// Find looks a session up without using it.
+func (t *Tokens) Find(ctx context.Context, token string) (domain.RefreshSession, error) {
+	session, err := scanSession(conn(ctx, t.db).QueryRow(ctx, "SELECT "+sessionColumns+" FROM refresh_tokens WHERE token=$1", token))
+
+	return session, tokenErrors.convert(err)
+}
+
+// Consume deletes only the given session, unlike Get the other sessions of the user stay alive.
+func (t *Tokens) Consume(ctx context.Context, token string) (domain.RefreshSession, error) {
+	session, err := scanSession(conn(ctx, t.db).QueryRow(ctx, "DELETE FROM refresh_tokens WHERE token=$1 RETURNING "+sessionColumns, token))
+
+	return session, tokenErrors.convert(err)
+}
+
+func (t *Tokens) ListByUser(ctx context.Context, userID int64) ([]domain.RefreshSession, error) {
+	sessions := make([]domain.RefreshSession, 0)
+
+	rows, err := conn(ctx, t.db).Query(ctx, "SELECT "+sessionColumns+" FROM refresh_tokens WHERE user_id=$1 ORDER BY created_at DESC", userID)
 	if err != nil {
-		return session, err
+		return nil, err
 	}
-	_, err = t.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1", session.UserID)
+	defer rows.Close()
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (t *Tokens) DeleteByUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, t.db).Exec(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1", userID)
+
+	return err
+}
+
+func scanSession(row pgx.Row) (domain.RefreshSession, error) {
+	var session domain.RefreshSession
+
+	err := row.Scan(&session.ID, &session.UserID, &session.Token, &session.ExpiresAt, &session.CreatedAt, &session.ClientID, &session.Scope)
 
 	return session, err
 }
@@ -0,0 +1,60 @@
+package psql
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// querier is what the repositories send their requests to: the pool, or the transaction
+// of the context.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type txKey struct{}
+
+// conn returns the transaction Transactor put into ctx, or db outside of one.
+func conn(ctx context.Context, db *pgxpool.Pool) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+
+	return db
+}
+
+// Transactor runs functions in a transaction. The repositories called with the context
+// the function gets take part in it.
+type Transactor struct {
+	db *pgxpool.Pool
+}
+
+func NewTransactor(db *pgxpool.Pool) *Transactor {
+	return &Transactor{db: db}
+}
+
+// WithinTransaction commits when fn succeeds and rolls back otherwise. Called within a
+// transaction, fn joins it.
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,37 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestTransactor(t *testing.T) {
+	db := newTestPool(t)
+	books, revisions, transactor := NewBookRepository(db), NewBookRevisions(db), NewTransactor(db)
+	ctx := context.Background()
+
+	failed := errors.New("failed")
+	err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		book := domain.Book{Title: "Title", Author: "Author", PublishDate: time.Now(), Rating: 3}
+		if err := books.Create(ctx, &book); err != nil {
+			return err
+		}
+		if err := revisions.Create(ctx, domain.BookRevision{BookID: book.ID, Revision: book.Version, Action: domain.RevisionCreate, Book: book, CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+
+		return failed
+	})
+	assert.Equal(t, err, failed)
+
+	all, _ := books.GetAll(ctx)
+	assert.Equal(t, len(all), 0)
+
+	events, _ := revisions.Events(ctx, 0, 10)
+	assert.Equal(t, len(events), 0)
+}
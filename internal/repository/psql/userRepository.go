@@ -2,31 +2,203 @@ package psql
 
 import (
 	"context"
+	"time"
 
 	"github.com/andy-ahmedov/crud_service/internal/domain"
 	_ "github.com/andy-ahmedov/crud_service/internal/transport/rest"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	userColumns = `id, name, email, password, registered_at, role, disabled, pending_email, email_token, email_token_expires_at,
+		password_reset_required, reset_token, reset_token_expires_at`
+
+	defaultUsersLimit = 20
+)
+
+var userErrors = errorMapping{
+	notFound: domain.ErrUserNotFound,
+	conflict: domain.ErrUserAlreadyExists,
+}
+
 type UserRepository struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewUserRepository(db *pgx.Conn) *UserRepository {
+func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 	return &UserRepository{db: db}
 }
 
 func (u *UserRepository) CreateUser(ctx context.Context, user domain.User) error {
 	request := `INSERT INTO users(name, email, password, registered_at) VALUES($1, $2, $3, $4) RETURNING id`
-	err := u.db.QueryRow(ctx, request, user.Name, user.Email, user.Password, user.RegisteredAt).Scan(&user.ID)
+	err := conn(ctx, u.db).QueryRow(ctx, request, user.Name, user.Email, user.Password, user.RegisteredAt).Scan(&user.ID)
 
-	return err
+	return userErrors.convert(err)
 }
 
 func (u *UserRepository) GetByCredential(ctx context.Context, email string, password string) (domain.User, error) {
-	var user domain.User
-	request := `SELECT id, name, email, password, registered_at FROM users WHERE email=$1 AND password=$2`
-	err := u.db.QueryRow(ctx, request, email, password).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.RegisteredAt)
+	request := `SELECT ` + userColumns + ` FROM users WHERE LOWER(email)=$1 AND password=$2`
+
+	return scanUser(conn(ctx, u.db).QueryRow(ctx, request, email, password))
+}
+
+func (u *UserRepository) GetByEmail(ctx context.Context, email string) (domain.User, error) {
+	request := `SELECT ` + userColumns + ` FROM users WHERE LOWER(email)=$1`
+
+	return scanUser(conn(ctx, u.db).QueryRow(ctx, request, email))
+}
+
+func (u *UserRepository) GetByID(ctx context.Context, id int64) (domain.User, error) {
+	request := `SELECT ` + userColumns + ` FROM users WHERE id=$1`
+
+	return scanUser(conn(ctx, u.db).QueryRow(ctx, request, id))
+}
+
+// GetByIDs skips the IDs of unknown users.
+func (u *UserRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error) {
+	rows, err := conn(ctx, u.db).Query(ctx, `SELECT `+userColumns+` FROM users WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]domain.User, 0, len(ids))
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (u *UserRepository) GetByEmailToken(ctx context.Context, token string) (domain.User, error) {
+	request := `SELECT ` + userColumns + ` FROM users WHERE email_token=$1`
+
+	return scanUser(conn(ctx, u.db).QueryRow(ctx, request, token))
+}
+
+func (u *UserRepository) GetByResetToken(ctx context.Context, token string) (domain.User, error) {
+	request := `SELECT ` + userColumns + ` FROM users WHERE reset_token=$1`
+
+	return scanUser(conn(ctx, u.db).QueryRow(ctx, request, token))
+}
+
+func (u *UserRepository) List(ctx context.Context, filter domain.UserFilter) (domain.UserList, error) {
+	list := domain.UserList{Users: make([]domain.User, 0)}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultUsersLimit
+	}
+
+	where := `WHERE $1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%'`
+
+	if err := conn(ctx, u.db).QueryRow(ctx, `SELECT COUNT(*) FROM users `+where, filter.Search).Scan(&list.Total); err != nil {
+		return list, err
+	}
+
+	rows, err := conn(ctx, u.db).Query(ctx, `SELECT `+userColumns+` FROM users `+where+` ORDER BY id LIMIT $2 OFFSET $3`, filter.Search, limit, filter.Offset)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return list, err
+		}
+
+		list.Users = append(list.Users, user)
+	}
+
+	return list, rows.Err()
+}
+
+func (u *UserRepository) Update(ctx context.Context, user domain.User) error {
+	request := `UPDATE users SET name=$1, email=$2, password=$3, role=$4, disabled=$5,
+		pending_email=NULLIF($6, ''), email_token=NULLIF($7, ''), email_token_expires_at=$8,
+		password_reset_required=$9, reset_token=NULLIF($10, ''), reset_token_expires_at=$11
+		WHERE id=$12`
+
+	tag, err := conn(ctx, u.db).Exec(ctx, request, user.Name, user.Email, user.Password, user.Role, user.Disabled,
+		user.PendingEmail, user.EmailToken, nullTime(user.EmailTokenExpiresAt),
+		user.PasswordResetRequired, user.ResetToken, nullTime(user.ResetTokenExpiresAt),
+		user.ID)
+	if err != nil {
+		return userErrors.convert(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Delete relies on ON DELETE CASCADE to clean up everything that references the user.
+func (u *UserRepository) Delete(ctx context.Context, id int64) error {
+	tag, err := conn(ctx, u.db).Exec(ctx, "DELETE FROM users WHERE id=$1", id)
+	if err != nil {
+		return userErrors.convert(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func scanUser(row pgx.Row) (domain.User, error) {
+	var (
+		user                       domain.User
+		pendingEmail               *string
+		emailToken, resetToken     *string
+		emailExpires, resetExpires *time.Time
+	)
+
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.RegisteredAt, &user.Role, &user.Disabled,
+		&pendingEmail, &emailToken, &emailExpires,
+		&user.PasswordResetRequired, &resetToken, &resetExpires)
+	if err != nil {
+		return user, userErrors.convert(err)
+	}
+
+	user.PendingEmail = fromNullString(pendingEmail)
+	user.EmailToken = fromNullString(emailToken)
+	user.EmailTokenExpiresAt = fromNullTime(emailExpires)
+	user.ResetToken = fromNullString(resetToken)
+	user.ResetTokenExpiresAt = fromNullTime(resetExpires)
+
+	return user, nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func fromNullString(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func fromNullTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
 
-	return user, err
+	return *t
 }
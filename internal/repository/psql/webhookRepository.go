@@ -0,0 +1,237 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	webhookColumns  = "id, user_id, url, events, secret, created_at"
+	deliveryColumns = "id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at"
+)
+
+var webhookErrors = errorMapping{
+	notFound:         domain.ErrWebhookNotFound,
+	invalidReference: domain.ErrUserNotFound,
+}
+
+var deliveryErrors = errorMapping{
+	notFound:         domain.ErrDeliveryNotFound,
+	invalidReference: domain.ErrWebhookNotFound,
+}
+
+type Webhooks struct {
+	db *pgxpool.Pool
+}
+
+func NewWebhooks(db *pgxpool.Pool) *Webhooks {
+	return &Webhooks{db: db}
+}
+
+func (w *Webhooks) Create(ctx context.Context, hook *domain.Webhook) error {
+	request := `INSERT INTO webhooks(user_id, url, events, secret, created_at) VALUES($1, $2, $3, $4, $5) RETURNING id`
+	err := conn(ctx, w.db).QueryRow(ctx, request, hook.UserID, hook.URL, hook.Events, hook.Secret, hook.CreatedAt).Scan(&hook.ID)
+
+	return webhookErrors.convert(err)
+}
+
+func (w *Webhooks) List(ctx context.Context, userID int64) ([]domain.Webhook, error) {
+	rows, err := conn(ctx, w.db).Query(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWebhooks(rows)
+}
+
+func (w *Webhooks) Get(ctx context.Context, id int64) (domain.Webhook, error) {
+	hook, err := scanWebhook(conn(ctx, w.db).QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id))
+
+	return hook, webhookErrors.convert(err)
+}
+
+// Delete only deletes a webhook of the user, its deliveries go with it.
+func (w *Webhooks) Delete(ctx context.Context, userID, id int64) error {
+	tag, err := conn(ctx, w.db).Exec(ctx, `DELETE FROM webhooks WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// DeleteByUser deletes the webhooks of the user along with their deliveries.
+func (w *Webhooks) DeleteByUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, w.db).Exec(ctx, `DELETE FROM webhooks WHERE user_id=$1`, userID)
+
+	return err
+}
+
+// Subscribed returns the webhooks that receive the event.
+func (w *Webhooks) Subscribed(ctx context.Context, event string) ([]domain.Webhook, error) {
+	rows, err := conn(ctx, w.db).Query(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE $1 = ANY(events) ORDER BY id`, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWebhooks(rows)
+}
+
+func scanWebhooks(rows pgx.Rows) ([]domain.Webhook, error) {
+	defer rows.Close()
+
+	hooks := make([]domain.Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+func scanWebhook(row pgx.Row) (domain.Webhook, error) {
+	var hook domain.Webhook
+	err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Events, &hook.Secret, &hook.CreatedAt)
+
+	return hook, err
+}
+
+type WebhookDeliveries struct {
+	db *pgxpool.Pool
+}
+
+func NewWebhookDeliveries(db *pgxpool.Pool) *WebhookDeliveries {
+	return &WebhookDeliveries{db: db}
+}
+
+func (d *WebhookDeliveries) Create(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	request := `INSERT INTO webhook_deliveries(webhook_id, event, payload, status, next_attempt_at, created_at) VALUES($1, $2, $3::JSONB, $4, $5, $6) RETURNING id`
+	err := conn(ctx, d.db).QueryRow(ctx, request, delivery.WebhookID, delivery.Event, string(delivery.Payload), delivery.Status,
+		delivery.NextAttemptAt, delivery.CreatedAt).Scan(&delivery.ID)
+
+	return deliveryErrors.convert(err)
+}
+
+// List returns the last deliveries of the webhook with their attempts, the newest first.
+func (d *WebhookDeliveries) List(ctx context.Context, webhookID int64, limit int) ([]domain.WebhookDelivery, error) {
+	request := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2`
+
+	rows, err := conn(ctx, d.db).Query(ctx, request, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, d.attachAttempts(ctx, deliveries)
+}
+
+func (d *WebhookDeliveries) Get(ctx context.Context, id int64) (domain.WebhookDelivery, error) {
+	delivery, err := scanDelivery(conn(ctx, d.db).QueryRow(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id=$1`, id))
+	if err != nil {
+		return delivery, deliveryErrors.convert(err)
+	}
+
+	deliveries := []domain.WebhookDelivery{delivery}
+	err = d.attachAttempts(ctx, deliveries)
+
+	return deliveries[0], err
+}
+
+// Claim returns the pending deliveries that are due and holds them back for lease, so
+// that another worker doesn't send them at the same time. Recording an attempt releases them.
+func (d *WebhookDeliveries) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	request := `UPDATE webhook_deliveries SET next_attempt_at = $2 WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE status = $3 AND next_attempt_at <= $1 ORDER BY next_attempt_at LIMIT $4 FOR UPDATE SKIP LOCKED
+	) RETURNING ` + deliveryColumns
+
+	rows, err := conn(ctx, d.db).Query(ctx, request, now, now.Add(lease), domain.DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDeliveries(rows)
+}
+
+// RecordAttempt saves the attempt along with the status of the delivery that follows from it.
+func (d *WebhookDeliveries) RecordAttempt(ctx context.Context, deliveryID int64, attempt domain.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	request := `WITH attempt AS (
+		INSERT INTO webhook_attempts(delivery_id, status_code, error, duration_ms, created_at) VALUES($1, NULLIF($2, 0), NULLIF($3, ''), $4, $5)
+	) UPDATE webhook_deliveries SET status=$6, attempts = attempts + 1, next_attempt_at=$7 WHERE id=$1`
+
+	_, err := conn(ctx, d.db).Exec(ctx, request, deliveryID, attempt.StatusCode, attempt.Error, attempt.DurationMS, attempt.CreatedAt, status, nextAttemptAt)
+
+	return deliveryErrors.convert(err)
+}
+
+func (d *WebhookDeliveries) attachAttempts(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	ids := make([]int64, len(deliveries))
+	byID := make(map[int64]*domain.WebhookDelivery, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].Attempts = make([]domain.WebhookAttempt, 0)
+		ids[i] = deliveries[i].ID
+		byID[deliveries[i].ID] = &deliveries[i]
+	}
+
+	request := `SELECT delivery_id, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms, created_at FROM webhook_attempts WHERE delivery_id = ANY($1) ORDER BY id`
+
+	rows, err := conn(ctx, d.db).Query(ctx, request, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			deliveryID int64
+			attempt    domain.WebhookAttempt
+		)
+		if err := rows.Scan(&deliveryID, &attempt.StatusCode, &attempt.Error, &attempt.DurationMS, &attempt.CreatedAt); err != nil {
+			return err
+		}
+
+		delivery := byID[deliveryID]
+		delivery.Attempts = append(delivery.Attempts, attempt)
+	}
+
+	return rows.Err()
+}
+
+func scanDeliveries(rows pgx.Rows) ([]domain.WebhookDelivery, error) {
+	defer rows.Close()
+
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func scanDelivery(row pgx.Row) (domain.WebhookDelivery, error) {
+	var delivery domain.WebhookDelivery
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.AttemptCount,
+		&delivery.NextAttemptAt, &delivery.CreatedAt)
+
+	return delivery, err
+}
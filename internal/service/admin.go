@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/golang-jwt/jwt"
+)
+
+// impersonationClaims marks the admin acting on behalf of the subject, see RFC 8693 section 4.1.
+type impersonationClaims struct {
+	jwt.StandardClaims
+	Actor actorClaim `json:"act"`
+}
+
+type actorClaim struct {
+	Subject string `json:"sub"`
+}
+
+func (u *Users) IsAdmin(ctx context.Context, id int64) (bool, error) {
+	user, err := u.Repo.GetByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role == domain.RoleAdmin && !user.Disabled, nil
+}
+
+func (u *Users) List(ctx context.Context, filter domain.UserFilter) (domain.UserList, error) {
+	return u.Repo.List(ctx, filter)
+}
+
+func (u *Users) Sessions(ctx context.Context, id int64) ([]domain.RefreshSession, error) {
+	if _, err := u.Repo.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return u.SessionRepo.ListByUser(ctx, id)
+}
+
+// SetDisabled also ends every session of a disabled user so it can't refresh its tokens.
+func (u *Users) SetDisabled(ctx context.Context, id int64, disabled bool) (domain.User, error) {
+	user, err := u.Repo.GetByID(ctx, id)
+	if err != nil {
+		return user, err
+	}
+
+	user.Disabled = disabled
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return user, err
+	}
+
+	if disabled {
+		if err := u.SessionRepo.DeleteByUser(ctx, id); err != nil {
+			return user, err
+		}
+	}
+
+	u.sendAudit(ctx, "Users.SetDisabled", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: id,
+	})
+
+	return user, nil
+}
+
+func (u *Users) ForcePasswordReset(ctx context.Context, id int64) error {
+	user, err := u.Repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	token, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	user.PasswordResetRequired = true
+	user.ResetToken = token
+	user.ResetTokenExpiresAt = time.Now().Add(resetTokenTTL)
+
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	if err := u.SessionRepo.DeleteByUser(ctx, id); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("An administrator requested a password reset for your account. Use this token to set a new password: %s", token)
+	if err := u.Mailer.Send(ctx, user.Email, "Reset your password", body); err != nil {
+		return err
+	}
+
+	u.sendAudit(ctx, "Users.ForcePasswordReset", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: id,
+	})
+
+	return nil
+}
+
+func (u *Users) ChangeRole(ctx context.Context, id int64, role string) (domain.User, error) {
+	user, err := u.Repo.GetByID(ctx, id)
+	if err != nil {
+		return user, err
+	}
+
+	user.Role = role
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return user, err
+	}
+
+	u.sendAudit(ctx, "Users.ChangeRole", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: id,
+	})
+
+	return user, nil
+}
+
+// Impersonate issues a short-lived access token for the user without a refresh token,
+// so support sessions end on their own.
+func (u *Users) Impersonate(ctx context.Context, adminID, userID int64) (domain.Impersonation, error) {
+	var imp domain.Impersonation
+
+	user, err := u.Repo.GetByID(ctx, userID)
+	if err != nil {
+		return imp, err
+	}
+
+	if user.Disabled {
+		return imp, domain.ErrUserDisabled
+	}
+
+	now := time.Now()
+	imp.ExpiresAt = now.Add(u.ImpersonationTtl)
+
+	imp.Token, err = u.signToken(impersonationClaims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: imp.ExpiresAt.Unix(),
+			Subject:   strconv.FormatInt(userID, 10),
+		},
+		Actor: actorClaim{Subject: strconv.FormatInt(adminID, 10)},
+	})
+	if err != nil {
+		return imp, err
+	}
+
+	u.sendAudit(ctx, "Users.Impersonate", audit.LogItem{
+		Action:   audit.ACTION_LOGIN,
+		Entity:   audit.ENTITY_USER,
+		EntityID: userID,
+	})
+
+	return imp, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestUsers_adminAudit(t *testing.T) {
+	testTable := []struct {
+		name   string
+		action func(ctx context.Context, users *Users) error
+	}{
+		{
+			name: "Disable",
+			action: func(ctx context.Context, users *Users) error {
+				_, err := users.SetDisabled(ctx, 2, true)
+				return err
+			},
+		},
+		{
+			name: "Change role",
+			action: func(ctx context.Context, users *Users) error {
+				_, err := users.ChangeRole(ctx, 2, domain.RoleAdmin)
+				return err
+			},
+		},
+		{
+			name: "Force password reset",
+			action: func(ctx context.Context, users *Users) error {
+				return users.ForcePasswordReset(ctx, 2)
+			},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			mailer := mock_service.NewMockMailer(c)
+			mailer.EXPECT().Send(gomock.Any(), "bob@example.com", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+			db := memory.NewDB()
+			repo, auditLog := memory.NewUserRepository(db), memory.NewAuditLog()
+			users := NewUsers(repo, nil, memory.NewTokens(db), auditLog, mailer, []byte("secret"), 0, 0)
+
+			ctx := context.Background()
+			repo.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Role: domain.RoleAdmin})
+			repo.CreateUser(ctx, domain.User{Name: "Bob", Email: "bob@example.com", Role: domain.RoleUser})
+
+			if err := testCase.action(domain.WithActor(ctx, 1), users); err != nil {
+				t.Fatal(err)
+			}
+
+			entries := auditLog.Entries()
+			assert.Equal(t, len(entries), 1)
+			assert.Equal(t, entries[0].EntityID, int64(2))
+			assert.Equal(t, entries[0].ActorID, int64(1))
+		})
+	}
+}
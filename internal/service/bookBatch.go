@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// Batch runs the operations atomically unless the batch asks for best effort. The error is
+// only set when the batch couldn't be run at all, the results tell how each operation went.
+func (b *BookStorage) Batch(ctx context.Context, batch domain.BookBatch) ([]domain.BookOperationResult, error) {
+	results := make([]domain.BookOperationResult, len(batch.Operations))
+
+	valid := true
+	for i := range batch.Operations {
+		op := &batch.Operations[i]
+		results[i] = domain.BookOperationResult{Index: i, Op: op.Op, Err: b.prepare(op)}
+		valid = valid && results[i].Err == nil
+	}
+
+	if batch.Mode == domain.BatchBestEffort {
+		for i, op := range batch.Operations {
+			if results[i].Err == nil {
+				results[i].Book, results[i].Err = b.apply(ctx, op)
+			}
+		}
+
+		return results, nil
+	}
+
+	if !valid {
+		return abortBatch(results), nil
+	}
+
+	var books []domain.Book
+	err := b.write(ctx, func(ctx context.Context) ([]bookChange, error) {
+		var err error
+		if books, err = b.repo.ApplyBatch(ctx, batch.Operations); err != nil {
+			return nil, err
+		}
+
+		changes := make([]bookChange, len(books))
+		for i, op := range batch.Operations {
+			changes[i] = bookChange{action: revisionAction(op.Op), book: books[i]}
+		}
+
+		return changes, nil
+	})
+	if err != nil {
+		var batchErr *domain.BatchError
+		if !errors.As(err, &batchErr) {
+			return nil, err
+		}
+
+		results[batchErr.Index].Err = batchErr.Err
+
+		return abortBatch(results), nil
+	}
+
+	for i := range batch.Operations {
+		results[i].Book = &books[i]
+	}
+
+	return results, nil
+}
+
+// prepare fills in the defaults of an operation and validates it.
+func (b *BookStorage) prepare(op *domain.BookOperation) error {
+	if op.Op == domain.BatchDelete {
+		return nil
+	}
+
+	if op.Op == domain.BatchCreate && op.Book.PublishDate.IsZero() {
+		op.Book.PublishDate = time.Now()
+	}
+
+	return b.Validate(*op.Book)
+}
+
+func (b *BookStorage) apply(ctx context.Context, op domain.BookOperation) (*domain.Book, error) {
+	book, err := b.writeBook(ctx, revisionAction(op.Op), func(ctx context.Context) (domain.Book, error) {
+		switch op.Op {
+		case domain.BatchCreate:
+			book := *op.Book
+			err := b.repo.Create(ctx, &book)
+			return book, err
+		case domain.BatchUpdate:
+			return b.repo.Update(ctx, op.ID, op.Version, op.Book.Replacement())
+		default:
+			return b.repo.Delete(ctx, op.ID, op.Version)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// abortBatch marks the operations that didn't fail themselves as rolled back.
+func abortBatch(results []domain.BookOperationResult) []domain.BookOperationResult {
+	for i := range results {
+		results[i].Book = nil
+		if results[i].Err == nil {
+			results[i].Err = domain.ErrBatchAborted
+		}
+	}
+
+	return results
+}
+
+func revisionAction(op string) string {
+	switch op {
+	case domain.BatchCreate:
+		return domain.RevisionCreate
+	case domain.BatchDelete:
+		return domain.RevisionDelete
+	default:
+		return domain.RevisionUpdate
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBookStorage_Batch(t *testing.T) {
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	valid := domain.Book{Title: "Title", Author: "Author", PublishDate: published, Rating: 4}
+	invalid := domain.Book{Title: "Title", Author: "Author", PublishDate: published, Rating: 9}
+
+	testTable := []struct {
+		name       string
+		batch      domain.BookBatch
+		wantErrors []error
+		wantBooks  int
+		wantEvents int
+	}{
+		{
+			name: "Atomic",
+			batch: domain.BookBatch{Operations: []domain.BookOperation{
+				{Op: domain.BatchCreate, Book: &valid},
+				{Op: domain.BatchUpdate, ID: 1, Book: &valid},
+			}},
+			wantErrors: []error{nil, nil},
+			wantBooks:  2,
+			wantEvents: 2,
+		},
+		{
+			name: "Atomic with an invalid book",
+			batch: domain.BookBatch{Operations: []domain.BookOperation{
+				{Op: domain.BatchCreate, Book: &valid},
+				{Op: domain.BatchCreate, Book: &invalid},
+			}},
+			wantErrors: []error{domain.ErrBatchAborted, &domain.ValidationError{}},
+			wantBooks:  1,
+		},
+		{
+			name: "Atomic with a failed write",
+			batch: domain.BookBatch{Operations: []domain.BookOperation{
+				{Op: domain.BatchCreate, Book: &valid},
+				{Op: domain.BatchUpdate, ID: 99, Book: &valid},
+			}},
+			wantErrors: []error{domain.ErrBatchAborted, domain.ErrBookNotFound},
+			wantBooks:  1,
+		},
+		{
+			name: "Best effort",
+			batch: domain.BookBatch{Mode: domain.BatchBestEffort, Operations: []domain.BookOperation{
+				{Op: domain.BatchCreate, Book: &valid},
+				{Op: domain.BatchCreate, Book: &invalid},
+			}},
+			wantErrors: []error{nil, &domain.ValidationError{}},
+			wantBooks:  2,
+			wantEvents: 1,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books, repo := newTestBooks(DefaultBookRules)
+			ctx := context.Background()
+
+			existing := valid
+			repo.Create(ctx, &existing)
+
+			results, err := books.Batch(ctx, testCase.batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a failed atomic batch leaves neither books nor revisions behind
+			all, _ := books.GetAll(ctx)
+			assert.Equal(t, len(all), testCase.wantBooks)
+
+			events, _ := books.revisions.Events(ctx, 0, 10)
+			assert.Equal(t, len(events), testCase.wantEvents)
+
+			for i, wantErr := range testCase.wantErrors {
+				var validationErr *domain.ValidationError
+
+				switch {
+				case wantErr == nil:
+					if results[i].Err != nil || results[i].Book == nil {
+						t.Fatalf("operation %d: unexpected result %+v", i, results[i])
+					}
+				case errors.As(wantErr, &validationErr):
+					if !errors.As(results[i].Err, &validationErr) {
+						t.Fatalf("operation %d: expected a validation error, got %v", i, results[i].Err)
+					}
+				default:
+					if !errors.Is(results[i].Err, wantErr) || results[i].Book != nil {
+						t.Fatalf("operation %d: expected %v, got %+v", i, wantErr, results[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/cache"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultBookCacheTTL is used when no ttl is set, a Redis key without one would never expire.
+const defaultBookCacheTTL = time.Minute
+
+// BookCacheInvalidations tells the other replicas which books changed. nil IDs stand for
+// every book, Listen reports them too when it had to reconnect and may have missed some.
+type BookCacheInvalidations interface {
+	Notify(ctx context.Context, ids []int64) error
+	Listen(ctx context.Context, fn func(ids []int64)) error
+}
+
+// CachedBooks reads books through the cache and forgets them once they change. The lists
+// are kept under a generation of this process that every change moves on, so they don't
+// have to be found to be forgotten and replicas sharing a store never mix them up.
+type CachedBooks struct {
+	BooksInterface
+
+	store         cache.Store
+	ttl           time.Duration
+	invalidations BookCacheInvalidations
+
+	// instance tells the lists of this process apart in a shared store
+	instance   string
+	generation atomic.Int64
+	group      singleflight.Group
+}
+
+// NewCachedBooks keeps books for ttl. A read racing a change through another replica may
+// keep the book it read before the change until the replica hears of it, ttl bounds how
+// stale a book can be then. invalidations may be nil when there is a single replica.
+func NewCachedBooks(repo BooksInterface, store cache.Store, ttl time.Duration, invalidations BookCacheInvalidations) *CachedBooks {
+	if ttl <= 0 {
+		ttl = defaultBookCacheTTL
+	}
+
+	instance := make([]byte, 8)
+	rand.Read(instance)
+
+	return &CachedBooks{
+		BooksInterface: repo,
+		store:          store,
+		ttl:            ttl,
+		invalidations:  invalidations,
+		instance:       hex.EncodeToString(instance),
+	}
+}
+
+func (c *CachedBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	var book domain.Book
+	err := c.read(ctx, bookCacheKey(id), &book, func(ctx context.Context) (interface{}, error) {
+		return c.BooksInterface.GetByID(ctx, id)
+	})
+
+	return book, err
+}
+
+func (c *CachedBooks) GetAll(ctx context.Context) ([]domain.Book, error) {
+	var books []domain.Book
+	err := c.read(ctx, c.listKey("all"), &books, func(ctx context.Context) (interface{}, error) {
+		return c.BooksInterface.GetAll(ctx)
+	})
+
+	return books, err
+}
+
+func (c *CachedBooks) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	query := fmt.Sprintf("list:%q:%q:%d:%d:%d:%d:%d", filter.Title, filter.Author, filter.OwnerID,
+		filter.MinRating, filter.MaxRating, afterID, limit)
+
+	var books []domain.Book
+	err := c.read(ctx, c.listKey(query), &books, func(ctx context.Context) (interface{}, error) {
+		return c.BooksInterface.List(ctx, filter, afterID, limit)
+	})
+
+	return books, err
+}
+
+func (c *CachedBooks) Create(ctx context.Context, book *domain.Book) error {
+	if err := c.BooksInterface.Create(ctx, book); err != nil {
+		return err
+	}
+
+	c.invalidate(ctx, []int64{book.ID})
+
+	return nil
+}
+
+func (c *CachedBooks) Delete(ctx context.Context, id, expectedVersion int64) (domain.Book, error) {
+	book, err := c.BooksInterface.Delete(ctx, id, expectedVersion)
+	if err == nil {
+		c.invalidate(ctx, []int64{id})
+	}
+
+	return book, err
+}
+
+func (c *CachedBooks) Update(ctx context.Context, id, expectedVersion int64, updBook domain.UpdateBookInput) (domain.Book, error) {
+	book, err := c.BooksInterface.Update(ctx, id, expectedVersion, updBook)
+	if err == nil {
+		c.invalidate(ctx, []int64{id})
+	}
+
+	return book, err
+}
+
+func (c *CachedBooks) Restore(ctx context.Context, id int64) (domain.Book, error) {
+	book, err := c.BooksInterface.Restore(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, []int64{id})
+	}
+
+	return book, err
+}
+
+func (c *CachedBooks) Purge(ctx context.Context, id int64) error {
+	err := c.BooksInterface.Purge(ctx, id)
+	if err == nil {
+		c.invalidate(ctx, []int64{id})
+	}
+
+	return err
+}
+
+// PurgeDeletedBefore doesn't say which books it purged, they were deleted before and are
+// no longer read through the cache anyway.
+func (c *CachedBooks) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	return c.BooksInterface.PurgeDeletedBefore(ctx, before)
+}
+
+func (c *CachedBooks) ApplyBatch(ctx context.Context, ops []domain.BookOperation) ([]domain.Book, error) {
+	books, err := c.BooksInterface.ApplyBatch(ctx, ops)
+	if err != nil {
+		return books, err
+	}
+
+	c.invalidate(ctx, bookIDs(books))
+
+	return books, nil
+}
+
+func (c *CachedBooks) DeleteByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	books, err := c.BooksInterface.DeleteByOwner(ctx, ownerID)
+	if err == nil {
+		c.invalidate(ctx, bookIDs(books))
+	}
+
+	return books, err
+}
+
+func (c *CachedBooks) ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]domain.Book, error) {
+	books, err := c.BooksInterface.ReassignOwner(ctx, ownerID, newOwnerID)
+	if err == nil {
+		c.invalidate(ctx, bookIDs(books))
+	}
+
+	return books, err
+}
+
+// RunInvalidations forgets the books changed through the other replicas until ctx is done.
+func (c *CachedBooks) RunInvalidations(ctx context.Context) {
+	if c.invalidations == nil {
+		return
+	}
+
+	for {
+		err := c.invalidations.Listen(ctx, func(ids []int64) {
+			c.forget(ctx, ids)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"method": "CachedBooks.RunInvalidations",
+		}).Error("lost the invalidations:", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(feedRetryInterval):
+		}
+	}
+}
+
+// read returns the cached value of key, or loads and keeps it. Concurrent reads of a key
+// that isn't cached share a single load, so an expired popular book doesn't flood the database.
+// A load some change was made during isn't kept, it may have read the books as they were,
+// and the reads started after the change don't share it.
+func (c *CachedBooks) read(ctx context.Context, key string, dst interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	generation := c.generation.Load()
+
+	if data, ok, err := c.store.Get(ctx, key); err != nil {
+		logCacheError("reading", key, err)
+	} else if ok && json.Unmarshal(data, dst) == nil {
+		return nil
+	}
+
+	data, err, _ := c.group.Do(key+"@"+strconv.FormatInt(generation, 10), func() (interface{}, error) {
+		// the load is shared, the request that happens to start it going away mustn't fail the others
+		ctx := context.WithoutCancel(ctx)
+
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.generation.Load() != generation {
+			return data, nil
+		}
+
+		if err := c.store.Set(ctx, key, data, c.ttl); err != nil {
+			logCacheError("writing", key, err)
+		}
+
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data.([]byte), dst)
+}
+
+// invalidate forgets the books changed through this replica and tells the others. Within a
+// transaction it waits for the commit, a read in between would cache the books as they were.
+func (c *CachedBooks) invalidate(ctx context.Context, ids []int64) {
+	afterCommit(ctx, func(ctx context.Context) {
+		c.forget(ctx, ids)
+
+		if c.invalidations == nil {
+			return
+		}
+
+		if err := c.invalidations.Notify(ctx, ids); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"method": "CachedBooks.invalidate",
+			}).Error("failed to notify the other replicas:", err)
+		}
+	})
+}
+
+// forget drops the books and moves on to a new generation of lists. nil IDs clear a store
+// of this process, the books of a shared one are dropped by the replica that changed them.
+func (c *CachedBooks) forget(ctx context.Context, ids []int64) {
+	c.generation.Add(1)
+
+	if ids == nil {
+		if local, ok := c.store.(interface{ Clear() }); ok {
+			local.Clear()
+		}
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = bookCacheKey(id)
+	}
+
+	if err := c.store.Delete(ctx, keys...); err != nil {
+		logCacheError("deleting", keys[0], err)
+	}
+}
+
+func (c *CachedBooks) listKey(query string) string {
+	return "books:" + c.instance + ":" + strconv.FormatInt(c.generation.Load(), 10) + ":" + query
+}
+
+func bookIDs(books []domain.Book) []int64 {
+	ids := make([]int64, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+
+	return ids
+}
+
+func bookCacheKey(id int64) string {
+	return "book:" + strconv.FormatInt(id, 10)
+}
+
+// a failing cache only makes the reads slower, they fall back to the database
+func logCacheError(action, key string, err error) {
+	logrus.WithFields(logrus.Fields{
+		"method": "CachedBooks",
+		"key":    key,
+	}).Errorf("failed %s the cache: %v", action, err)
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	"github.com/andy-ahmedov/crud_service/pkg/cache"
+	"github.com/magiconair/properties/assert"
+)
+
+type countingBooks struct {
+	BooksInterface
+
+	reads   atomic.Int32
+	release chan struct{}
+	books   map[int64]domain.Book
+}
+
+func (c *countingBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	c.reads.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+
+	book, ok := c.books[id]
+	if !ok {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	return book, nil
+}
+
+func (c *countingBooks) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	c.reads.Add(1)
+
+	books := make([]domain.Book, 0)
+	for id := int64(1); id <= int64(len(c.books)); id++ {
+		if book := c.books[id]; id > afterID && len(books) < limit && (filter.Author == "" || book.Author == filter.Author) {
+			books = append(books, book)
+		}
+	}
+
+	return books, nil
+}
+
+func (c *countingBooks) Create(ctx context.Context, book *domain.Book) error {
+	book.ID = int64(len(c.books) + 1)
+	c.books[book.ID] = *book
+
+	return nil
+}
+
+func (c *countingBooks) Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	book := c.books[id]
+	book.Title = *upd.Title
+	book.Version++
+	c.books[id] = book
+
+	return book, nil
+}
+
+type fakeInvalidations struct {
+	notified [][]int64
+	listen   func(fn func(ids []int64))
+}
+
+func (f *fakeInvalidations) Notify(ctx context.Context, ids []int64) error {
+	f.notified = append(f.notified, ids)
+	return nil
+}
+
+func (f *fakeInvalidations) Listen(ctx context.Context, fn func(ids []int64)) error {
+	f.listen(fn)
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func TestCachedBooks(t *testing.T) {
+	repo := &countingBooks{books: map[int64]domain.Book{
+		1: {ID: 1, Title: "First", Author: "A", Version: 1},
+		2: {ID: 2, Title: "Second", Author: "B", Version: 1},
+	}}
+	invalidations := &fakeInvalidations{}
+
+	books := NewCachedBooks(repo, cache.NewMemoryStore(100), time.Minute, invalidations)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		book, err := books.GetByID(ctx, 1)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, book.Title, "First")
+	}
+	assert.Equal(t, repo.reads.Load(), int32(1))
+
+	// missing books aren't cached
+	for i := 0; i < 2; i++ {
+		_, err := books.GetByID(ctx, 3)
+		assert.Equal(t, err, domain.ErrBookNotFound)
+	}
+	assert.Equal(t, repo.reads.Load(), int32(3))
+
+	title := "Changed"
+	books.Update(ctx, 1, 1, domain.UpdateBookInput{Title: &title})
+
+	book, _ := books.GetByID(ctx, 1)
+	assert.Equal(t, book.Title, "Changed")
+	assert.Equal(t, repo.reads.Load(), int32(4))
+
+	page, _ := books.List(ctx, domain.BookFilter{Author: "A"}, 0, 10)
+	books.List(ctx, domain.BookFilter{Author: "A"}, 0, 10)
+	assert.Equal(t, len(page), 1)
+	assert.Equal(t, repo.reads.Load(), int32(5))
+
+	// a new book changes every list
+	books.Create(ctx, &domain.Book{Title: "Third", Author: "A"})
+
+	page, _ = books.List(ctx, domain.BookFilter{Author: "A"}, 0, 10)
+	assert.Equal(t, len(page), 2)
+	assert.Equal(t, repo.reads.Load(), int32(6))
+
+	assert.Equal(t, invalidations.notified, [][]int64{{1}, {3}})
+}
+
+func TestCachedBooks_transaction(t *testing.T) {
+	db := memory.NewDB()
+	invalidations := &fakeInvalidations{}
+	books := NewCachedBooks(memory.NewBookRepository(db), cache.NewMemoryStore(100), time.Minute, invalidations)
+	transactor := memory.NewTransactor(db)
+	ctx := context.Background()
+
+	book := domain.Book{Title: "First", Author: "A", Rating: 3}
+	books.Create(ctx, &book)
+	books.GetByID(ctx, book.ID)
+
+	title := "Changed"
+
+	// a rolled back change tells nobody
+	err := inTransaction(ctx, transactor, func(ctx context.Context) error {
+		books.Update(ctx, book.ID, 0, domain.UpdateBookInput{Title: &title})
+		return errors.New("rolled back")
+	})
+	assert.Equal(t, err.Error(), "rolled back")
+	assert.Equal(t, invalidations.notified, [][]int64{{1}})
+
+	cached, _ := books.GetByID(ctx, book.ID)
+	assert.Equal(t, cached.Title, "First")
+
+	// the book is only forgotten once the change is committed
+	err = inTransaction(ctx, transactor, func(ctx context.Context) error {
+		_, err := books.Update(ctx, book.ID, 0, domain.UpdateBookInput{Title: &title})
+		assert.Equal(t, len(invalidations.notified), 1)
+		return err
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, invalidations.notified, [][]int64{{1}, {1}})
+
+	cached, _ = books.GetByID(ctx, book.ID)
+	assert.Equal(t, cached.Title, "Changed")
+}
+
+func TestCachedBooks_singleflight(t *testing.T) {
+	repo := &countingBooks{release: make(chan struct{}), books: map[int64]domain.Book{1: {ID: 1, Title: "First"}}}
+	books := NewCachedBooks(repo, cache.NewMemoryStore(100), time.Minute, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			book, err := books.GetByID(context.Background(), 1)
+			if err != nil || book.Title != "First" {
+				t.Errorf("unexpected book %+v: %v", book, err)
+			}
+		}()
+	}
+
+	// the reads pile up behind the first one
+	time.Sleep(50 * time.Millisecond)
+	close(repo.release)
+	wg.Wait()
+
+	assert.Equal(t, repo.reads.Load(), int32(1))
+}
+
+func TestCachedBooks_RunInvalidations(t *testing.T) {
+	repo := &countingBooks{books: map[int64]domain.Book{
+		1: {ID: 1, Title: "First"},
+		2: {ID: 2, Title: "Second"},
+	}}
+
+	listening := make(chan func(ids []int64))
+	invalidations := &fakeInvalidations{listen: func(fn func(ids []int64)) {
+		listening <- fn
+	}}
+
+	books := NewCachedBooks(repo, cache.NewMemoryStore(100), time.Minute, invalidations)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go books.RunInvalidations(ctx)
+
+	notify := <-listening
+
+	books.GetByID(ctx, 1)
+	books.GetByID(ctx, 2)
+	assert.Equal(t, repo.reads.Load(), int32(2))
+
+	// another replica changed the first book
+	notify([]int64{1})
+	books.GetByID(ctx, 1)
+	books.GetByID(ctx, 2)
+	assert.Equal(t, repo.reads.Load(), int32(3))
+
+	// notifications may have been missed
+	notify(nil)
+	books.GetByID(ctx, 2)
+	assert.Equal(t, repo.reads.Load(), int32(4))
+}
+
+// racingBooks reads the book, and then it is changed before the read returns.
+type racingBooks struct {
+	*countingBooks
+	change func()
+}
+
+func (r *racingBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	book, err := r.countingBooks.GetByID(ctx, id)
+	if r.change != nil {
+		change := r.change
+		r.change = nil
+		change()
+	}
+
+	return book, err
+}
+
+func TestCachedBooks_staleRead(t *testing.T) {
+	repo := &racingBooks{countingBooks: &countingBooks{books: map[int64]domain.Book{1: {ID: 1, Title: "First"}}}}
+	books := NewCachedBooks(repo, cache.NewMemoryStore(100), time.Minute, nil)
+	ctx := context.Background()
+
+	title := "Changed"
+	repo.change = func() {
+		books.Update(ctx, 1, 0, domain.UpdateBookInput{Title: &title})
+	}
+
+	// the read overlapped the change
+	book, _ := books.GetByID(ctx, 1)
+	assert.Equal(t, book.Title, "First")
+
+	book, _ = books.GetByID(ctx, 1)
+	assert.Equal(t, book.Title, "Changed")
+	assert.Equal(t, repo.reads.Load(), int32(2))
+}
+
+func TestCachedBooks_singleflightAfterChange(t *testing.T) {
+	repo := &countingBooks{release: make(chan struct{}), books: map[int64]domain.Book{1: {ID: 1, Title: "First"}}}
+	books := NewCachedBooks(repo, cache.NewMemoryStore(100), time.Minute, nil)
+	ctx := context.Background()
+
+	waitReads := func(n int32) {
+		for deadline := time.Now().Add(time.Second); repo.reads.Load() < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d reads instead of %d", repo.reads.Load(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	titles := make(chan string, 2)
+	read := func() {
+		book, _ := books.GetByID(ctx, 1)
+		titles <- book.Title
+	}
+
+	go read()
+	waitReads(1)
+
+	title := "Changed"
+	books.Update(ctx, 1, 0, domain.UpdateBookInput{Title: &title})
+
+	// started after the change, it doesn't wait for the load started before it
+	go read()
+	waitReads(2)
+	close(repo.release)
+
+	<-titles
+	assert.Equal(t, <-titles, "Changed")
+
+	book, _ := books.GetByID(ctx, 1)
+	assert.Equal(t, book.Title, "Changed")
+	assert.Equal(t, repo.reads.Load(), int32(2))
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// historyFields are the JSON fields of a book compared between revisions, id and version
+// change with every revision anyway.
+var historyFields = []string{"title", "author", "publish_date", "rating", "isbn", "owner_id", "deleted_at"}
+
+// History returns the revisions of the book, the oldest first, with the fields each of
+// them changed.
+func (b *BookStorage) History(ctx context.Context, id int64) ([]domain.BookHistoryEntry, error) {
+	revisions, err := b.revisions.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// books written before the history was kept have no revisions
+	if len(revisions) == 0 {
+		if _, err := b.repo.GetByID(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	history := make([]domain.BookHistoryEntry, 0, len(revisions))
+
+	var previous map[string]interface{}
+	for _, rev := range revisions {
+		current, err := bookFields(rev.Book)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, domain.BookHistoryEntry{
+			Revision:  rev.Revision,
+			Action:    rev.Action,
+			ActorID:   rev.ActorID,
+			CreatedAt: rev.CreatedAt,
+			Changes:   diffFields(previous, current),
+		})
+
+		previous = current
+	}
+
+	return history, nil
+}
+
+// GetAsOf returns the book as it was at the given time. Books that didn't exist yet or
+// were in the trash back then are reported as not found.
+func (b *BookStorage) GetAsOf(ctx context.Context, id int64, at time.Time) (domain.Book, error) {
+	rev, err := b.revisions.AsOf(ctx, id, at)
+	if err != nil {
+		if errors.Is(err, domain.ErrRevisionNotFound) {
+			return domain.Book{}, domain.ErrBookNotFound
+		}
+		return domain.Book{}, err
+	}
+
+	if rev.Book.DeletedAt != nil {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	return rev.Book, nil
+}
+
+// Revert writes the fields of the given revision as a new version of the book. The
+// revision has to pass the current validation rules.
+func (b *BookStorage) Revert(ctx context.Context, id, revision, expectedVersion int64) (domain.Book, error) {
+	rev, err := b.revisions.Get(ctx, id, revision)
+	if err != nil {
+		return domain.Book{}, err
+	}
+
+	upd := rev.Book.Replacement()
+
+	if err := b.validator.check(upd); err != nil {
+		return domain.Book{}, err
+	}
+
+	return b.writeBook(ctx, domain.RevisionRevert, func(ctx context.Context) (domain.Book, error) {
+		return b.repo.Update(ctx, id, expectedVersion, upd)
+	})
+}
+
+// bookChange is a change write records as a revision.
+type bookChange struct {
+	action string
+	book   domain.Book
+}
+
+// write runs change in a transaction along with the revisions of the books it changed, so
+// that a change is never kept without its revision. The watchers, unless the event feed
+// tells them, and the webhooks only hear of the changes once they are committed, within
+// the transaction of ctx that is once it is.
+func (b *BookStorage) write(ctx context.Context, change func(ctx context.Context) ([]bookChange, error)) error {
+	var changes []bookChange
+
+	err := inTransaction(ctx, b.transactor, func(ctx context.Context) error {
+		var err error
+		if changes, err = change(ctx); err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if err := b.record(ctx, c.action, c.book); err != nil {
+				return fmt.Errorf("recording revision %d of book %d: %w", c.book.Version, c.book.ID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	afterCommit(ctx, func(ctx context.Context) {
+		for _, c := range changes {
+			b.publish(ctx, c.action, c.book)
+		}
+	})
+
+	return nil
+}
+
+// writeBook is write for a change of a single book.
+func (b *BookStorage) writeBook(ctx context.Context, action string, change func(ctx context.Context) (domain.Book, error)) (domain.Book, error) {
+	var book domain.Book
+
+	err := b.write(ctx, func(ctx context.Context) ([]bookChange, error) {
+		var err error
+		book, err = change(ctx)
+
+		return []bookChange{{action: action, book: book}}, err
+	})
+	if err != nil {
+		return domain.Book{}, err
+	}
+
+	return book, nil
+}
+
+// writeBooks is write for a change of several books with the same action, it returns their IDs.
+func (b *BookStorage) writeBooks(ctx context.Context, action string, change func(ctx context.Context) ([]domain.Book, error)) ([]int64, error) {
+	var books []domain.Book
+
+	err := b.write(ctx, func(ctx context.Context) ([]bookChange, error) {
+		var err error
+		if books, err = change(ctx); err != nil {
+			return nil, err
+		}
+
+		changes := make([]bookChange, len(books))
+		for i, book := range books {
+			changes[i] = bookChange{action: action, book: book}
+		}
+
+		return changes, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bookIDs(books), nil
+}
+
+// record keeps the book as a revision.
+func (b *BookStorage) record(ctx context.Context, action string, book domain.Book) error {
+	return b.revisions.Create(ctx, domain.BookRevision{
+		BookID:    book.ID,
+		Revision:  book.Version,
+		Action:    action,
+		ActorID:   domain.ActorFromContext(ctx),
+		Book:      book,
+		CreatedAt: b.now(),
+	})
+}
+
+func (b *BookStorage) publish(ctx context.Context, action string, book domain.Book) {
+	if !b.watchers.fed.Load() {
+		b.watchers.publish(domain.BookEvent{Action: action, Book: book})
+	}
+
+	if b.webhooks != nil {
+		b.webhooks.Publish(ctx, webhookEvent(action), book)
+	}
+}
+
+// webhookEvent is the webhook event of a revision, restoring and reverting update the book.
+func webhookEvent(action string) string {
+	switch action {
+	case domain.RevisionCreate:
+		return domain.EventBookCreated
+	case domain.RevisionDelete:
+		return domain.EventBookDeleted
+	default:
+		return domain.EventBookUpdated
+	}
+}
+
+func bookFields(book domain.Book) (map[string]interface{}, error) {
+	doc, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(doc, &fields)
+
+	return fields, err
+}
+
+// diffFields compares the JSON values, so that a field missing before is a change from null.
+func diffFields(from, to map[string]interface{}) []domain.FieldChange {
+	changes := make([]domain.FieldChange, 0)
+
+	for _, field := range historyFields {
+		if reflect.DeepEqual(from[field], to[field]) {
+			continue
+		}
+
+		changes = append(changes, domain.FieldChange{Field: field, From: from[field], To: to[field]})
+	}
+
+	return changes
+}
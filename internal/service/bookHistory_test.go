@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBookStorage_History(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	books, _ := newTestBooks(DefaultBookRules)
+
+	// each revision is made a minute after the previous one
+	revisions := 0
+	books.now = func() time.Time {
+		revisions++
+		return start.Add(time.Duration(revisions-1) * time.Minute)
+	}
+
+	ctx := domain.WithActor(context.Background(), 7)
+
+	book := domain.Book{Title: "Title", Author: "Author", PublishDate: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Rating: 4}
+	if err := books.Create(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+
+	title, author, rating := "New title", "Author", 5
+	if _, err := books.Update(ctx, 1, 0, domain.UpdateBookInput{Title: &title, Author: &author, PublishDate: &book.PublishDate, Rating: &rating}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := books.Delete(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := books.History(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(history))
+	}
+
+	update := history[1]
+	if update.Action != domain.RevisionUpdate || update.ActorID != 7 || len(update.Changes) != 2 {
+		t.Fatalf("unexpected update revision %+v", update)
+	}
+	if update.Changes[0].Field != "title" || update.Changes[0].From != "Title" || update.Changes[0].To != "New title" {
+		t.Fatalf("unexpected title change %+v", update.Changes[0])
+	}
+	if history[2].Action != domain.RevisionDelete || history[2].Changes[0].Field != "deleted_at" {
+		t.Fatalf("unexpected delete revision %+v", history[2])
+	}
+
+	testTable := []struct {
+		name      string
+		at        time.Time
+		wantTitle string
+		wantErr   error
+	}{
+		{
+			name:    "Before it was created",
+			at:      start.Add(-time.Minute),
+			wantErr: domain.ErrBookNotFound,
+		},
+		{
+			name:      "After it was created",
+			at:        start.Add(30 * time.Second),
+			wantTitle: "Title",
+		},
+		{
+			name:      "After it was updated",
+			at:        start.Add(time.Minute),
+			wantTitle: "New title",
+		},
+		{
+			name:    "After it was deleted",
+			at:      start.Add(time.Hour),
+			wantErr: domain.ErrBookNotFound,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			book, err := books.GetAsOf(ctx, 1, testCase.at)
+			if testCase.wantErr != nil {
+				if !errors.Is(err, testCase.wantErr) {
+					t.Fatalf("expected %v, got %v", testCase.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if book.Title != testCase.wantTitle {
+				t.Fatalf("expected %q, got %q", testCase.wantTitle, book.Title)
+			}
+		})
+	}
+}
+
+func TestBookStorage_Revert(t *testing.T) {
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name           string
+		revision       int64
+		wantErr        error
+		wantValidation bool
+	}{
+		{
+			name:     "OK",
+			revision: 1,
+		},
+		{
+			name:     "Unknown revision",
+			revision: 5,
+			wantErr:  domain.ErrRevisionNotFound,
+		},
+		{
+			name:           "Revision breaking the current rules",
+			revision:       2,
+			wantValidation: true,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books, _ := newTestBooks(DefaultBookRules)
+			ctx := context.Background()
+
+			// the second revision was made when the ratings went up to 10
+			books.validator = newBookValidator(BookRules{MinRating: 0, MaxRating: 10, EarliestPublishDate: DefaultBookRules.EarliestPublishDate})
+
+			title, author, low, high := "Changed", "Author", 2, 9
+			book := domain.Book{Title: "Title", Author: "Author", PublishDate: published, Rating: 4}
+			if err := books.Create(ctx, &book); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := books.Update(ctx, book.ID, 1, domain.UpdateBookInput{Rating: &high}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := books.Update(ctx, book.ID, 2, domain.UpdateBookInput{Title: &title, Author: &author, PublishDate: &published, Rating: &low}); err != nil {
+				t.Fatal(err)
+			}
+
+			books.validator = newBookValidator(DefaultBookRules)
+
+			book, err := books.Revert(ctx, book.ID, testCase.revision, 3)
+			if testCase.wantValidation {
+				var validationErr *domain.ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if testCase.wantErr != nil {
+				if !errors.Is(err, testCase.wantErr) {
+					t.Fatalf("expected %v, got %v", testCase.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if book.Title != "Title" || book.Rating != 4 || book.Version != 4 {
+				t.Fatalf("unexpected book %+v", book)
+			}
+		})
+	}
+}
+
+// failingRevisions can't keep any revision.
+type failingRevisions struct {
+	RevisionRepository
+}
+
+func (f failingRevisions) Create(ctx context.Context, rev domain.BookRevision) error {
+	return errors.New("disk full")
+}
+
+func TestBookStorage_failedRevision(t *testing.T) {
+	db := memory.NewDB()
+	repo := memory.NewBookRepository(db)
+	books := NewBooksStorage(repo, failingRevisions{memory.NewBookRevisions(db)}, memory.NewTransactor(db), DefaultBookRules)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := books.Watch(ctx)
+
+	// a change is rolled back along with its revision, and nobody hears of it
+	err := books.Create(ctx, &domain.Book{Title: "Title", Author: "Author", Rating: 4})
+	assert.Equal(t, err != nil, true)
+
+	all, _ := repo.GetAll(ctx)
+	assert.Equal(t, len(all), 0)
+
+	book := domain.Book{Title: "Title", Author: "Author", Rating: 4}
+	repo.Create(ctx, &book)
+
+	title := "New title"
+	_, err = books.Update(ctx, book.ID, 0, domain.UpdateBookInput{Title: &title})
+	assert.Equal(t, err != nil, true)
+
+	book, _ = repo.GetByID(ctx, book.ID)
+	assert.Equal(t, book.Title, "Title")
+	assert.Equal(t, book.Version, int64(1))
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/tabular"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// importProgressEvery is how many rows are imported between saving the progress of a job.
+	importProgressEvery = 100
+	// maxImportErrors bounds the row errors kept in a job, the rest are only counted.
+	maxImportErrors = 1000
+)
+
+var importFields = []string{"title", "author", "publish_date", "rating", "isbn"}
+
+var publishDateLayouts = []string{time.RFC3339, "2006-01-02", "2006"}
+
+type ImportJobRepository interface {
+	Create(ctx context.Context, job *domain.ImportJob) error
+	Update(ctx context.Context, job domain.ImportJob) error
+	Get(ctx context.Context, id int64) (domain.ImportJob, error)
+	ListByUser(ctx context.Context, userID int64) ([]domain.ImportJob, error)
+	DeleteByUser(ctx context.Context, userID int64) error
+}
+
+type BookImporter struct {
+	books *BookStorage
+	jobs  ImportJobRepository
+}
+
+func NewBookImporter(books *BookStorage, jobs ImportJobRepository) *BookImporter {
+	return &BookImporter{books: books, jobs: jobs}
+}
+
+// Import reads the books of src and closes it. A dry run is finished before Import returns,
+// other imports go on in the background and their job is returned right away.
+func (i *BookImporter) Import(ctx context.Context, userID int64, opts domain.ImportOptions, src io.ReadCloser) (domain.ImportJob, error) {
+	for field := range opts.Mapping {
+		if !contains(importFields, field) {
+			src.Close()
+			return domain.ImportJob{}, fmt.Errorf("%w: unknown field %q in the mapping", domain.ErrInvalidInput, field)
+		}
+	}
+
+	if opts.OnDuplicate == "" {
+		opts.OnDuplicate = domain.DuplicateFail
+	}
+
+	job := domain.ImportJob{
+		UserID:      userID,
+		Format:      opts.Format,
+		DryRun:      opts.DryRun,
+		OnDuplicate: opts.OnDuplicate,
+		Status:      domain.ImportPending,
+		Errors:      make([]domain.ImportRowError, 0),
+		CreatedAt:   time.Now(),
+	}
+
+	if err := i.jobs.Create(ctx, &job); err != nil {
+		src.Close()
+		return domain.ImportJob{}, err
+	}
+
+	if opts.DryRun {
+		i.run(ctx, &job, opts, src)
+		return job, nil
+	}
+
+	// the request is over long before a large file is, the books are still written on behalf of the user
+	running := job
+	go i.run(domain.WithActor(context.Background(), userID), &running, opts, src)
+
+	return job, nil
+}
+
+// Job only returns the jobs of the user who started them.
+func (i *BookImporter) Job(ctx context.Context, userID, id int64) (domain.ImportJob, error) {
+	job, err := i.jobs.Get(ctx, id)
+	if err != nil {
+		return domain.ImportJob{}, err
+	}
+
+	if job.UserID != userID {
+		return domain.ImportJob{}, domain.ErrImportJobNotFound
+	}
+
+	return job, nil
+}
+
+func (i *BookImporter) run(ctx context.Context, job *domain.ImportJob, opts domain.ImportOptions, src io.ReadCloser) {
+	defer src.Close()
+
+	job.Status = domain.ImportRunning
+	i.save(ctx, *job)
+
+	if err := i.importRows(ctx, job, opts, src); err != nil {
+		job.Status, job.Error = domain.ImportFailed, err.Error()
+	} else {
+		job.Status = domain.ImportDone
+	}
+
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	i.save(ctx, *job)
+}
+
+func (i *BookImporter) importRows(ctx context.Context, job *domain.ImportJob, opts domain.ImportOptions, src io.Reader) error {
+	reader, err := tabular.NewReader(opts.Format, src)
+	if err != nil {
+		return err
+	}
+
+	// a dry run doesn't write the books, so duplicates within the file are tracked here
+	seen := make(map[string]bool)
+
+	for row := 1; ; row++ {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// a malformed row is reported like an invalid book, the file can't be read past other errors
+		var rowErr *tabular.RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+
+		var outcome string
+		if err == nil {
+			outcome, err = i.importRow(ctx, job, opts, rec, seen)
+		}
+
+		job.Processed++
+		switch {
+		case err != nil:
+			job.Failed++
+			addRowError(job, row, err)
+		case outcome == domain.DuplicateSkip:
+			job.Skipped++
+		case outcome == domain.DuplicateUpdate:
+			job.Updated++
+		default:
+			job.Created++
+		}
+
+		if job.Processed%importProgressEvery == 0 {
+			i.save(ctx, *job)
+		}
+	}
+}
+
+// importRow returns how a duplicate was handled, or an empty string for a new book.
+func (i *BookImporter) importRow(ctx context.Context, job *domain.ImportJob, opts domain.ImportOptions, rec map[string]string, seen map[string]bool) (string, error) {
+	book, err := bookFromRecord(rec, opts.Mapping)
+	if err != nil {
+		return "", err
+	}
+
+	book.OwnerID = job.UserID
+	if err := i.books.Validate(book); err != nil {
+		return "", err
+	}
+
+	key := duplicateKey(book)
+
+	existing, err := i.books.FindDuplicate(ctx, book.ISBN, book.Title, book.Author)
+	found := err == nil || (opts.DryRun && seen[key])
+	if err != nil && !errors.Is(err, domain.ErrBookNotFound) {
+		return "", err
+	}
+	seen[key] = true
+
+	if found {
+		switch opts.OnDuplicate {
+		case domain.DuplicateSkip:
+			return domain.DuplicateSkip, nil
+		case domain.DuplicateFail:
+			return "", domain.ErrDuplicateBook
+		}
+	}
+
+	if opts.DryRun {
+		if found {
+			return domain.DuplicateUpdate, nil
+		}
+		return "", nil
+	}
+
+	if found && existing.ID != 0 {
+		upd := book.Replacement()
+		// a match by title and author keeps the ISBN the book has
+		if book.ISBN == "" {
+			upd.ISBN = nil
+		}
+
+		_, err := i.books.Update(ctx, existing.ID, existing.Version, upd)
+		return domain.DuplicateUpdate, err
+	}
+
+	return "", i.books.Create(ctx, &book)
+}
+
+func (i *BookImporter) save(ctx context.Context, job domain.ImportJob) {
+	if err := i.jobs.Update(ctx, job); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": "BookImporter.save",
+			"job":    job.ID,
+		}).Error("failed to save the import job:", err)
+	}
+}
+
+func addRowError(job *domain.ImportJob, row int, err error) {
+	if len(job.Errors) >= maxImportErrors {
+		return
+	}
+
+	rowErr := domain.ImportRowError{Row: row, Message: err.Error()}
+
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		rowErr.Fields = validationErr.Fields
+	}
+
+	job.Errors = append(job.Errors, rowErr)
+}
+
+// bookFromRecord reports the values that can't be parsed like the validation does.
+func bookFromRecord(rec map[string]string, mapping map[string]string) (domain.Book, error) {
+	values := make(map[string]string, len(importFields))
+	for _, field := range importFields {
+		column := field
+		if mapped, ok := mapping[field]; ok {
+			column = mapped
+		}
+		values[field] = strings.TrimSpace(lookupColumn(rec, column))
+	}
+
+	book := domain.Book{Title: values["title"], Author: values["author"], ISBN: values["isbn"]}
+	fields := make([]domain.FieldError, 0)
+
+	if values["publish_date"] != "" {
+		publishDate, ok := parsePublishDate(values["publish_date"])
+		if !ok {
+			fields = append(fields, domain.FieldError{Field: "publish_date", Message: "must be a date like 2006-01-02"})
+		}
+		book.PublishDate = publishDate
+	}
+
+	rating, err := strconv.Atoi(values["rating"])
+	if err != nil {
+		fields = append(fields, domain.FieldError{Field: "rating", Message: "must be a whole number"})
+	}
+	book.Rating = rating
+
+	if len(fields) > 0 {
+		return book, &domain.ValidationError{Fields: fields}
+	}
+
+	// like a single book, a book without a publish date is published now
+	if book.PublishDate.IsZero() {
+		book.PublishDate = time.Now()
+	}
+
+	return book, nil
+}
+
+// lookupColumn matches column names regardless of case and surrounding spaces.
+func lookupColumn(rec map[string]string, column string) string {
+	if value, ok := rec[column]; ok {
+		return value
+	}
+
+	for name, value := range rec {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func parsePublishDate(value string) (time.Time, bool) {
+	for _, layout := range publishDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func duplicateKey(book domain.Book) string {
+	if book.ISBN != "" {
+		return "isbn:" + strings.ToUpper(book.ISBN)
+	}
+
+	return "book:" + strings.ToLower(book.Title) + "\x00" + strings.ToLower(book.Author)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+type fakeImportJobs struct {
+	ImportJobRepository
+
+	job domain.ImportJob
+}
+
+func (f *fakeImportJobs) Create(ctx context.Context, job *domain.ImportJob) error {
+	job.ID = 1
+	f.job = *job
+
+	return nil
+}
+
+func (f *fakeImportJobs) Update(ctx context.Context, job domain.ImportJob) error {
+	f.job = job
+
+	return nil
+}
+
+func (f *fakeImportJobs) Get(ctx context.Context, id int64) (domain.ImportJob, error) {
+	if id != f.job.ID {
+		return domain.ImportJob{}, domain.ErrImportJobNotFound
+	}
+
+	return f.job, nil
+}
+
+func TestBookImporter_Import(t *testing.T) {
+	const file = "Name,author,publish_date,rating,isbn\n" +
+		"Existing,Author,2020-01-02,4,\n" +
+		"New,Author,2021,5,\n" +
+		"Broken,Author,yesterday,x,\n"
+
+	testTable := []struct {
+		name        string
+		opts        domain.ImportOptions
+		wantCreated int
+		wantUpdated int
+		wantSkipped int
+		wantFailed  int
+		wantTitle   string
+	}{
+		{
+			name:        "Dry run",
+			opts:        domain.ImportOptions{DryRun: true, OnDuplicate: domain.DuplicateUpdate},
+			wantCreated: 1,
+			wantUpdated: 1,
+			wantFailed:  1,
+			wantTitle:   "Existing",
+		},
+		{
+			name:        "Skip duplicates",
+			opts:        domain.ImportOptions{DryRun: true, OnDuplicate: domain.DuplicateSkip},
+			wantCreated: 1,
+			wantSkipped: 1,
+			wantFailed:  1,
+			wantTitle:   "Existing",
+		},
+		{
+			name:        "Fail on duplicates",
+			opts:        domain.ImportOptions{DryRun: true},
+			wantCreated: 1,
+			wantFailed:  2,
+			wantTitle:   "Existing",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books, repo := newTestBooks(DefaultBookRules)
+			repo.Create(context.Background(), &domain.Book{Title: "Existing", Author: "Author", Rating: 4})
+
+			jobs := &fakeImportJobs{}
+			importer := NewBookImporter(books, jobs)
+
+			testCase.opts.Format = "csv"
+			testCase.opts.Mapping = map[string]string{"title": "Name"}
+
+			job, err := importer.Import(context.Background(), 7, testCase.opts, io.NopCloser(strings.NewReader(file)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assert.Equal(t, job.Status, domain.ImportDone)
+			assert.Equal(t, job.Processed, 3)
+			assert.Equal(t, job.Created, testCase.wantCreated)
+			assert.Equal(t, job.Updated, testCase.wantUpdated)
+			assert.Equal(t, job.Skipped, testCase.wantSkipped)
+			assert.Equal(t, job.Failed, testCase.wantFailed)
+			assert.Equal(t, jobs.job.Status, domain.ImportDone)
+			// a dry run writes nothing
+			all, _ := repo.GetAll(context.Background())
+			assert.Equal(t, len(all), 1)
+			assert.Equal(t, all[0].Title, testCase.wantTitle)
+			assert.Equal(t, all[0].Version, int64(1))
+
+			last := job.Errors[len(job.Errors)-1]
+			assert.Equal(t, last.Row, 3)
+			assert.Equal(t, len(last.Fields), 2)
+		})
+	}
+}
+
+func TestBookImporter_Import_malformedRow(t *testing.T) {
+	const file = "{\"title\":\"First\",\"author\":\"Author\",\"rating\":4}\n" +
+		"{\"title\":\"Broken\",\n" +
+		"{\"title\":\"Second\",\"author\":\"Author\",\"rating\":5}\n"
+
+	books, _ := newTestBooks(DefaultBookRules)
+	importer := NewBookImporter(books, &fakeImportJobs{})
+
+	opts := domain.ImportOptions{Format: "ndjson", DryRun: true}
+	job, err := importer.Import(context.Background(), 7, opts, io.NopCloser(strings.NewReader(file)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.Equal(t, job.Status, domain.ImportDone)
+	assert.Equal(t, job.Processed, 3)
+	assert.Equal(t, job.Created, 2)
+	assert.Equal(t, job.Failed, 1)
+	assert.Equal(t, len(job.Errors), 1)
+	assert.Equal(t, job.Errors[0].Row, 2)
+}
+
+func TestBookImporter_Job(t *testing.T) {
+	jobs := &fakeImportJobs{job: domain.ImportJob{ID: 1, UserID: 7}}
+	importer := NewBookImporter(nil, jobs)
+
+	if _, err := importer.Job(context.Background(), 7, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := importer.Job(context.Background(), 8, 1)
+	assert.Equal(t, err, domain.ErrImportJobNotFound)
+}
@@ -2,25 +2,78 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/andy-ahmedov/crud_service/internal/domain"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/sirupsen/logrus"
 )
 
+// patchAttempts bounds how often a patch without If-Match is reapplied when the book
+// changes between reading and writing it.
+const patchAttempts = 3
+
 type BooksInterface interface {
 	Create(ctx context.Context, book *domain.Book) error
 	GetByID(ctx context.Context, id int64) (domain.Book, error)
 	GetAll(ctx context.Context) ([]domain.Book, error)
-	Delete(ctx context.Context, id int64) error
-	Update(ctx context.Context, id int64, updBook domain.UpdateBookInput) error
+	List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error)
+	Stream(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error
+	Delete(ctx context.Context, id, expectedVersion int64) (domain.Book, error)
+	Update(ctx context.Context, id, expectedVersion int64, updBook domain.UpdateBookInput) (domain.Book, error)
+	Trash(ctx context.Context) ([]domain.Book, error)
+	Restore(ctx context.Context, id int64) (domain.Book, error)
+	Purge(ctx context.Context, id int64) error
+	PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error)
+	ApplyBatch(ctx context.Context, ops []domain.BookOperation) ([]domain.Book, error)
+	FindDuplicate(ctx context.Context, isbn, title, author string) (domain.Book, error)
+	GetByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error)
+	DeleteByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error)
+	ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]domain.Book, error)
+}
+
+type RevisionRepository interface {
+	Create(ctx context.Context, rev domain.BookRevision) error
+	List(ctx context.Context, bookID int64) ([]domain.BookRevision, error)
+	Get(ctx context.Context, bookID, revision int64) (domain.BookRevision, error)
+	AsOf(ctx context.Context, bookID int64, at time.Time) (domain.BookRevision, error)
+	Events(ctx context.Context, afterID int64, limit int) ([]domain.BookEvent, error)
 }
 
 type BookStorage struct {
-	repo BooksInterface
+	repo      BooksInterface
+	revisions RevisionRepository
+	validator *bookValidator
+	watchers  *bookWatchers
+	webhooks  WebhookPublisher
+	// transactor writes the books along with their revisions
+	transactor Transactor
+	// now is the clock of the revisions and of the trash retention
+	now func() time.Time
+}
+
+func NewBooksStorage(repo BooksInterface, revisions RevisionRepository, transactor Transactor, rules BookRules) *BookStorage {
+	return &BookStorage{
+		repo:       repo,
+		revisions:  revisions,
+		transactor: transactor,
+		validator:  newBookValidator(rules),
+		watchers:   newBookWatchers(),
+		now:        time.Now,
+	}
+}
+
+// PublishTo tells the webhooks about the changes to books from now on.
+func (b *BookStorage) PublishTo(webhooks WebhookPublisher) {
+	b.webhooks = webhooks
 }
 
-func NewBooksStorage(repo BooksInterface) *BookStorage {
-	return &BookStorage{repo: repo}
+// Validate reports every rule the book breaks as a *domain.ValidationError.
+func (b *BookStorage) Validate(book domain.Book) error {
+	return b.validator.check(book)
 }
 
 func (b BookStorage) Create(ctx context.Context, book *domain.Book) error {
@@ -28,7 +81,16 @@ func (b BookStorage) Create(ctx context.Context, book *domain.Book) error {
 		book.PublishDate = time.Now()
 	}
 
-	return b.repo.Create(ctx, book)
+	if err := b.Validate(*book); err != nil {
+		return err
+	}
+
+	_, err := b.writeBook(ctx, domain.RevisionCreate, func(ctx context.Context) (domain.Book, error) {
+		err := b.repo.Create(ctx, book)
+		return *book, err
+	})
+
+	return err
 }
 
 func (b *BookStorage) GetByID(ctx context.Context, id int64) (domain.Book, error) {
@@ -39,10 +101,217 @@ func (b *BookStorage) GetAll(ctx context.Context) ([]domain.Book, error) {
 	return b.repo.GetAll(ctx)
 }
 
-func (b *BookStorage) Delete(ctx context.Context, id int64) error {
-	return b.repo.Delete(ctx, id)
+// List returns up to limit books of the filter with an ID greater than afterID, ordered by
+// ID, so that the last ID of a page starts the next one.
+func (b *BookStorage) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	return b.repo.List(ctx, filter, afterID, limit)
+}
+
+// Export calls fn with the books of the filter, ordered by ID, without reading them all at once.
+func (b *BookStorage) Export(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error {
+	return b.repo.Stream(ctx, filter, fn)
+}
+
+// FindDuplicate finds the book with the ISBN, or by title and author when isbn is empty.
+// It fails with domain.ErrBookNotFound when there is none.
+func (b *BookStorage) FindDuplicate(ctx context.Context, isbn, title, author string) (domain.Book, error) {
+	return b.repo.FindDuplicate(ctx, isbn, title, author)
+}
+
+// Delete moves the book to the trash. It fails with domain.ErrBookVersionMismatch when the
+// book no longer has expectedVersion, 0 deletes whatever version there is.
+func (b *BookStorage) Delete(ctx context.Context, id, expectedVersion int64) error {
+	_, err := b.writeBook(ctx, domain.RevisionDelete, func(ctx context.Context) (domain.Book, error) {
+		return b.repo.Delete(ctx, id, expectedVersion)
+	})
+
+	return err
+}
+
+func (b *BookStorage) Trash(ctx context.Context) ([]domain.Book, error) {
+	return b.repo.Trash(ctx)
+}
+
+func (b *BookStorage) Restore(ctx context.Context, id int64) (domain.Book, error) {
+	return b.writeBook(ctx, domain.RevisionRestore, func(ctx context.Context) (domain.Book, error) {
+		return b.repo.Restore(ctx, id)
+	})
+}
+
+// Purge deletes the book for good, it can't be restored afterwards.
+func (b *BookStorage) Purge(ctx context.Context, id int64) error {
+	return b.repo.Purge(ctx, id)
+}
+
+// PurgeTrash deletes the books that have been in the trash for longer than retention.
+func (b *BookStorage) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	return b.repo.PurgeDeletedBefore(ctx, b.now().Add(-retention))
+}
+
+// GetByOwner includes the books in the trash, they are still personal data of the owner.
+func (b *BookStorage) GetByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error) {
+	return b.repo.GetByOwner(ctx, ownerID)
+}
+
+// DeleteByOwner moves the books of the owner to the trash and returns their IDs.
+func (b *BookStorage) DeleteByOwner(ctx context.Context, ownerID int64) ([]int64, error) {
+	return b.writeBooks(ctx, domain.RevisionDelete, func(ctx context.Context) ([]domain.Book, error) {
+		return b.repo.DeleteByOwner(ctx, ownerID)
+	})
+}
+
+// ReassignOwner hands the books of the owner over to another user, newOwnerID 0 leaves them
+// without an owner. It returns their IDs.
+func (b *BookStorage) ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]int64, error) {
+	return b.writeBooks(ctx, domain.RevisionUpdate, func(ctx context.Context) ([]domain.Book, error) {
+		return b.repo.ReassignOwner(ctx, ownerID, newOwnerID)
+	})
+}
+
+// RunTrashRetention purges the trash every interval until ctx is done.
+func (b *BookStorage) RunTrashRetention(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := b.PurgeTrash(ctx, retention)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"method": "BookStorage.RunTrashRetention",
+			}).Error("failed to purge the trash:", err)
+		} else if purged > 0 {
+			logrus.WithFields(logrus.Fields{
+				"method": "BookStorage.RunTrashRetention",
+			}).Infof("purged %d books from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Update fails with domain.ErrBookVersionMismatch when the book no longer has expectedVersion,
+// 0 overwrites whatever version there is.
+func (b *BookStorage) Update(ctx context.Context, id, expectedVersion int64, updBook domain.UpdateBookInput) (domain.Book, error) {
+	if err := b.validator.check(updBook); err != nil {
+		return domain.Book{}, err
+	}
+
+	// an empty update doesn't make a new version
+	if updBook.Empty() {
+		return b.repo.Update(ctx, id, expectedVersion, updBook)
+	}
+
+	return b.writeBook(ctx, domain.RevisionUpdate, func(ctx context.Context) (domain.Book, error) {
+		return b.repo.Update(ctx, id, expectedVersion, updBook)
+	})
 }
 
-func (b *BookStorage) Update(ctx context.Context, id int64, updBook domain.UpdateBookInput) error {
-	return b.repo.Update(ctx, id, updBook)
+// Patch applies the patch to the JSON representation of the book. Without expectedVersion
+// it works on the latest version and is reapplied if someone else writes in between.
+func (b *BookStorage) Patch(ctx context.Context, id, expectedVersion int64, patch domain.BookPatch) (domain.Book, error) {
+	for attempt := 1; ; attempt++ {
+		book, err := b.repo.GetByID(ctx, id)
+		if err != nil {
+			return domain.Book{}, err
+		}
+
+		if expectedVersion != 0 && book.Version != expectedVersion {
+			return domain.Book{}, domain.ErrBookVersionMismatch
+		}
+
+		upd, err := applyPatch(book, patch)
+		if err != nil {
+			return domain.Book{}, err
+		}
+
+		if err := b.validator.check(upd); err != nil {
+			return domain.Book{}, err
+		}
+
+		// a patch that changes nothing doesn't make a new version
+		if unchanged(book, upd) {
+			return book, nil
+		}
+
+		updated, err := b.writeBook(ctx, domain.RevisionUpdate, func(ctx context.Context) (domain.Book, error) {
+			return b.repo.Update(ctx, id, book.Version, upd)
+		})
+		if errors.Is(err, domain.ErrBookVersionMismatch) && expectedVersion == 0 && attempt < patchAttempts {
+			continue
+		}
+
+		return updated, err
+	}
+}
+
+// unchanged tells whether the update writes the fields the book already has.
+func unchanged(book domain.Book, upd domain.UpdateBookInput) bool {
+	return (upd.Title == nil || *upd.Title == book.Title) &&
+		(upd.Author == nil || *upd.Author == book.Author) &&
+		(upd.PublishDate == nil || upd.PublishDate.Equal(book.PublishDate)) &&
+		(upd.Rating == nil || *upd.Rating == book.Rating) &&
+		(upd.ISBN == nil || *upd.ISBN == book.ISBN)
+}
+
+func applyPatch(book domain.Book, patch domain.BookPatch) (domain.UpdateBookInput, error) {
+	var upd domain.UpdateBookInput
+
+	doc, err := json.Marshal(book)
+	if err != nil {
+		return upd, err
+	}
+
+	var patched []byte
+
+	switch patch.Format {
+	case domain.MergePatch:
+		if !json.Valid(patch.Body) {
+			return upd, domain.ErrMalformedPatch
+		}
+
+		patched, err = jsonpatch.MergePatch(doc, patch.Body)
+	case domain.JSONPatch:
+		operations, decodeErr := jsonpatch.DecodePatch(patch.Body)
+		if decodeErr != nil {
+			return upd, fmt.Errorf("%w: %v", domain.ErrMalformedPatch, decodeErr)
+		}
+
+		patched, err = operations.Apply(doc)
+	default:
+		return upd, fmt.Errorf("%w: unsupported format %q", domain.ErrMalformedPatch, patch.Format)
+	}
+
+	if err != nil {
+		return upd, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	var result domain.Book
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return upd, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	// books in the trash aren't patched, so deleted_at can only have been added by the patch
+	if result.ID != book.ID || result.Version != book.Version || result.OwnerID != book.OwnerID || result.DeletedAt != nil {
+		return upd, fmt.Errorf("%w: id, owner_id, version and deleted_at can't be changed", domain.ErrInvalidPatch)
+	}
+
+	// a removed field would be written as its zero value
+	if err := json.Unmarshal(patched, &upd); err != nil {
+		return upd, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	if upd.Title == nil || upd.Author == nil || upd.PublishDate == nil || upd.Rating == nil {
+		return upd, fmt.Errorf("%w: title, author, publish_date and rating can't be removed", domain.ErrInvalidPatch)
+	}
+
+	// the ISBN is optional, removing it clears it
+	if upd.ISBN == nil && book.ISBN != "" {
+		upd.ISBN = new(string)
+	}
+
+	return upd, nil
 }
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	"github.com/magiconair/properties/assert"
+)
+
+// newTestBooks returns a BookStorage on in-memory repositories, along with its book repository.
+func newTestBooks(rules BookRules) (*BookStorage, *memory.Books) {
+	db := memory.NewDB()
+	repo := memory.NewBookRepository(db)
+
+	return NewBooksStorage(repo, memory.NewBookRevisions(db), memory.NewTransactor(db), rules), repo
+}
+
+// concurrentWrites changes the book right before each of the first conflicts updates, as if
+// another request got there first.
+type concurrentWrites struct {
+	BooksInterface
+
+	conflicts int
+}
+
+func (c *concurrentWrites) Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	if c.conflicts > 0 {
+		c.conflicts--
+
+		book, err := c.BooksInterface.GetByID(ctx, id)
+		if err != nil {
+			return domain.Book{}, err
+		}
+		if _, err := c.BooksInterface.Update(ctx, id, 0, domain.UpdateBookInput{Rating: &book.Rating}); err != nil {
+			return domain.Book{}, err
+		}
+	}
+
+	return c.BooksInterface.Update(ctx, id, expectedVersion, upd)
+}
+
+func TestBookStorage_Patch(t *testing.T) {
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name            string
+		patch           domain.BookPatch
+		expectedVersion int64
+		conflicts       int
+		wantTitle       string
+		wantRating      int
+		wantVersion     int64
+		wantErr         error
+	}{
+		{
+			name:       "Merge patch",
+			patch:      domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"title":"New title"}`)},
+			wantTitle:   "New title",
+			wantRating:  4,
+			wantVersion: 3,
+		},
+		{
+			name:        "Patch changing nothing",
+			patch:       domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"title":"Title","publish_date":"2020-01-02T03:00:00+03:00"}`)},
+			wantTitle:   "Title",
+			wantRating:  4,
+			wantVersion: 2,
+		},
+		{
+			name:       "JSON patch",
+			patch:      domain.BookPatch{Format: domain.JSONPatch, Body: []byte(`[{"op":"test","path":"/title","value":"Title"},{"op":"replace","path":"/rating","value":5}]`)},
+			wantTitle:  "Title",
+			wantRating: 5,
+		},
+		{
+			name:    "Failed test operation",
+			patch:   domain.BookPatch{Format: domain.JSONPatch, Body: []byte(`[{"op":"test","path":"/title","value":"Other"}]`)},
+			wantErr: domain.ErrInvalidPatch,
+		},
+		{
+			name:    "Removed field",
+			patch:   domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"author":null}`)},
+			wantErr: domain.ErrInvalidPatch,
+		},
+		{
+			name:    "Read only field",
+			patch:   domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"version":10}`)},
+			wantErr: domain.ErrInvalidPatch,
+		},
+		{
+			name:    "Deleting with a patch",
+			patch:   domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"deleted_at":"2020-01-02T00:00:00Z"}`)},
+			wantErr: domain.ErrInvalidPatch,
+		},
+		{
+			name:    "Malformed document",
+			patch:   domain.BookPatch{Format: domain.JSONPatch, Body: []byte(`{"op":"add"`)},
+			wantErr: domain.ErrMalformedPatch,
+		},
+		{
+			name:       "Retried after a concurrent write",
+			patch:      domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"title":"New title"}`)},
+			conflicts:  1,
+			wantTitle:  "New title",
+			wantRating: 4,
+		},
+		{
+			name:            "Stale If-Match is not retried",
+			patch:           domain.BookPatch{Format: domain.MergePatch, Body: []byte(`{"title":"New title"}`)},
+			expectedVersion: 1,
+			wantErr:         domain.ErrBookVersionMismatch,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := memory.NewDB()
+			repo := memory.NewBookRepository(db)
+			ctx := context.Background()
+
+			// the book is at version 2
+			book := domain.Book{Title: "Title", Author: "Author", PublishDate: published, Rating: 4}
+			repo.Create(ctx, &book)
+			repo.Update(ctx, book.ID, 0, domain.UpdateBookInput{Rating: &book.Rating})
+
+			books := NewBooksStorage(&concurrentWrites{BooksInterface: repo, conflicts: testCase.conflicts}, memory.NewBookRevisions(db), memory.NewTransactor(db), DefaultBookRules)
+
+			book, err := books.Patch(ctx, book.ID, testCase.expectedVersion, testCase.patch)
+			if testCase.wantErr != nil {
+				if !errors.Is(err, testCase.wantErr) {
+					t.Fatalf("expected %v, got %v", testCase.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if book.Title != testCase.wantTitle || book.Rating != testCase.wantRating || !book.PublishDate.Equal(published) {
+				t.Fatalf("unexpected book %+v", book)
+			}
+			if testCase.wantVersion != 0 {
+				assert.Equal(t, book.Version, testCase.wantVersion)
+
+				// the revisions follow the versions, the first one was written without a revision
+				history, _ := books.History(ctx, book.ID)
+				assert.Equal(t, len(history), int(testCase.wantVersion)-2)
+			}
+		})
+	}
+}
+
+func TestBookStorage_Validation(t *testing.T) {
+	valid := domain.Book{
+		Title:       "Title",
+		Author:      "Author",
+		PublishDate: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Rating:      5,
+		ISBN:        "978-3-16-148410-0",
+	}
+
+	testTable := []struct {
+		name       string
+		rules      BookRules
+		modify     func(b *domain.Book)
+		wantFields []string
+	}{
+		{
+			name:   "OK",
+			rules:  DefaultBookRules,
+			modify: func(b *domain.Book) {},
+		},
+		{
+			name:  "Every field at once",
+			rules: DefaultBookRules,
+			modify: func(b *domain.Book) {
+				b.Title = "  "
+				b.Author = string(make([]byte, 256))
+				b.PublishDate = time.Now().Add(time.Hour * 24)
+				b.Rating = 0
+				b.ISBN = "978-3-16-148410-1"
+			},
+			wantFields: []string{"title", "author", "publish_date", "rating", "isbn"},
+		},
+		{
+			name:       "Rating out of the default range",
+			rules:      DefaultBookRules,
+			modify:     func(b *domain.Book) { b.Rating = 8 },
+			wantFields: []string{"rating"},
+		},
+		{
+			name:   "Rating within a configured range",
+			rules:  BookRules{MinRating: 0, MaxRating: 10, EarliestPublishDate: DefaultBookRules.EarliestPublishDate},
+			modify: func(b *domain.Book) { b.Rating = 8 },
+		},
+		{
+			name:       "Published too early",
+			rules:      DefaultBookRules,
+			modify:     func(b *domain.Book) { b.PublishDate = time.Date(1200, 1, 1, 0, 0, 0, 0, time.UTC) },
+			wantFields: []string{"publish_date"},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			book := valid
+			testCase.modify(&book)
+
+			books, _ := newTestBooks(testCase.rules)
+
+			err := books.Create(context.Background(), &book)
+			if len(testCase.wantFields) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var validationErr *domain.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			fields := make([]string, 0, len(validationErr.Fields))
+			for _, f := range validationErr.Fields {
+				fields = append(fields, f.Field)
+			}
+
+			if strings.Join(fields, ",") != strings.Join(testCase.wantFields, ",") {
+				t.Fatalf("expected errors for %v, got %v", testCase.wantFields, validationErr.Fields)
+			}
+		})
+	}
+}
+
+func TestBookStorage_PurgeTrash(t *testing.T) {
+	const retention = 30 * 24 * time.Hour
+
+	testTable := []struct {
+		name       string
+		sinceTrash time.Duration
+		wantPurged int64
+		wantTrash  int
+	}{
+		{
+			name:       "Within the retention",
+			sinceTrash: retention - time.Second,
+			wantTrash:  1,
+		},
+		{
+			name:       "Exactly the retention",
+			sinceTrash: retention,
+			wantTrash:  1,
+		},
+		{
+			name:       "Older than the retention",
+			sinceTrash: retention + time.Second,
+			wantPurged: 1,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books, _ := newTestBooks(DefaultBookRules)
+			ctx := context.Background()
+
+			trashed := domain.Book{Title: "Trashed", Author: "Author", Rating: 3}
+			live := domain.Book{Title: "Live", Author: "Author", Rating: 3}
+			for _, book := range []*domain.Book{&trashed, &live} {
+				if err := books.Create(ctx, book); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := books.Delete(ctx, trashed.ID, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			trash, _ := books.Trash(ctx)
+			deletedAt := *trash[0].DeletedAt
+			books.now = func() time.Time { return deletedAt.Add(testCase.sinceTrash) }
+
+			purged, err := books.PurgeTrash(ctx, retention)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, purged, testCase.wantPurged)
+
+			trash, _ = books.Trash(ctx)
+			assert.Equal(t, len(trash), testCase.wantTrash)
+
+			// a book that isn't in the trash is never purged
+			_, err = books.GetByID(ctx, live.ID)
+			assert.Equal(t, err, nil)
+		})
+	}
+}
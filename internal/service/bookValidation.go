@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/go-playground/validator/v10"
+)
+
+// BookRules configures the book rules that differ between deployments.
+type BookRules struct {
+	MinRating int
+	MaxRating int
+	// EarliestPublishDate is the lower bound of publish dates, they can't be in the future.
+	EarliestPublishDate time.Time
+}
+
+var DefaultBookRules = BookRules{
+	MinRating:           1,
+	MaxRating:           5,
+	EarliestPublishDate: time.Date(1450, time.January, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// bookValidator checks the validate tags of domain.Book and domain.UpdateBookInput.
+type bookValidator struct {
+	validate *validator.Validate
+	rules    BookRules
+}
+
+func newBookValidator(rules BookRules) *bookValidator {
+	v := &bookValidator{validate: validator.New(), rules: rules}
+
+	v.validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	})
+
+	// the functions can't fail, the tags are registered once with fixed names
+	_ = v.validate.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	_ = v.validate.RegisterValidation("rating", func(fl validator.FieldLevel) bool {
+		rating := fl.Field().Int()
+		return rating >= int64(rules.MinRating) && rating <= int64(rules.MaxRating)
+	})
+	_ = v.validate.RegisterValidation("publish_date", func(fl validator.FieldLevel) bool {
+		date, ok := fl.Field().Interface().(time.Time)
+		return ok && !date.Before(rules.EarliestPublishDate) && !date.After(time.Now())
+	})
+
+	return v
+}
+
+// check returns a *domain.ValidationError listing every invalid field.
+func (v *bookValidator) check(input interface{}) error {
+	err := v.validate.Struct(input)
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	validationErr := &domain.ValidationError{Fields: make([]domain.FieldError, 0, len(fieldErrs))}
+	for _, fe := range fieldErrs {
+		validationErr.Fields = append(validationErr.Fields, domain.FieldError{
+			Field:   fe.Field(),
+			Message: v.message(fe),
+		})
+	}
+
+	return validationErr
+}
+
+func (v *bookValidator) message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "notblank":
+		return "must not be empty"
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	case "rating":
+		return fmt.Sprintf("must be between %d and %d", v.rules.MinRating, v.rules.MaxRating)
+	case "publish_date":
+		return fmt.Sprintf("must be between %s and now", v.rules.EarliestPublishDate.Format(time.DateOnly))
+	case "isbn":
+		return "must be a valid ISBN-10 or ISBN-13"
+	default:
+		return "is invalid"
+	}
+}
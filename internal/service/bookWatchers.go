@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// watcherBuffer is how many events a watcher may fall behind before it is dropped.
+	watcherBuffer = 64
+
+	// replayPageSize is how many missed events Events reads at a time.
+	replayPageSize = 500
+
+	// feedRetryInterval is how long the event feed waits before it listens again.
+	feedRetryInterval = 5 * time.Second
+)
+
+type BookEventSource interface {
+	Listen(ctx context.Context, fn func(domain.BookEvent)) error
+}
+
+// bookWatchers hands the changes of books to whoever watches them in this process.
+type bookWatchers struct {
+	mu       sync.Mutex
+	watchers map[chan domain.BookEvent]struct{}
+
+	// fed is set once the changes come from the event feed rather than from this process
+	fed atomic.Bool
+}
+
+func newBookWatchers() *bookWatchers {
+	return &bookWatchers{watchers: make(map[chan domain.BookEvent]struct{})}
+}
+
+// Watch returns the changes made to books from now on. The channel is closed when ctx is
+// done, or as soon as the watcher falls behind by more than watcherBuffer events, so that
+// a slow watcher never holds up the writes nor silently misses a change.
+func (b *BookStorage) Watch(ctx context.Context) <-chan domain.BookEvent {
+	ch := make(chan domain.BookEvent, watcherBuffer)
+
+	b.watchers.mu.Lock()
+	b.watchers.watchers[ch] = struct{}{}
+	b.watchers.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.watchers.remove(ch)
+	}()
+
+	return ch
+}
+
+// Events is Watch resumed after the event with lastEventID: the events made since then are
+// read back first. 0 starts with the changes made from now on.
+func (b *BookStorage) Events(ctx context.Context, lastEventID int64) (<-chan domain.BookEvent, error) {
+	live := b.Watch(ctx)
+	if lastEventID == 0 {
+		return live, nil
+	}
+
+	// the first page is read now, so that an error is reported before anything is sent
+	missed, err := b.revisions.Events(ctx, lastEventID, replayPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan domain.BookEvent, watcherBuffer)
+
+	go func() {
+		defer close(events)
+
+		send := func(event domain.BookEvent) bool {
+			select {
+			case events <- event:
+				lastEventID = event.ID
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for len(missed) > 0 {
+			for _, event := range missed {
+				if !send(event) {
+					return
+				}
+			}
+
+			if len(missed) < replayPageSize {
+				break
+			}
+
+			if missed, err = b.revisions.Events(ctx, lastEventID, replayPageSize); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"method": "BookStorage.Events",
+				}).Error("failed to read the missed events:", err)
+				return
+			}
+		}
+
+		// the watcher was there before the missed events were read, what it has seen
+		// already has been sent
+		for event := range live {
+			if event.ID != 0 && event.ID <= lastEventID {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// RunEventFeed hands the events of the source to the watchers until ctx is done, instead of
+// the changes made by this process. With a source that every replica listens to, the
+// watchers of one replica see the changes made through the others.
+func (b *BookStorage) RunEventFeed(ctx context.Context, source BookEventSource) {
+	b.watchers.fed.Store(true)
+
+	for {
+		err := source.Listen(ctx, b.watchers.publish)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"method": "BookStorage.RunEventFeed",
+		}).Error("lost the event feed:", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(feedRetryInterval):
+		}
+	}
+}
+
+func (w *bookWatchers) publish(event domain.BookEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.watchers {
+		select {
+		case ch <- event:
+		default:
+			delete(w.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (w *bookWatchers) remove(ch chan domain.BookEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.watchers[ch]; ok {
+		delete(w.watchers, ch)
+		close(ch)
+	}
+}
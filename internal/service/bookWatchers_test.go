@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBookStorage_Watch(t *testing.T) {
+	books, _ := newTestBooks(DefaultBookRules)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := books.Watch(ctx)
+	slow := books.Watch(context.Background())
+
+	for i := 0; i <= watcherBuffer; i++ {
+		if err := books.Create(ctx, &domain.Book{Title: "Title", Author: "Author", Rating: 3}); err != nil {
+			t.Fatal(err)
+		}
+
+		event := <-events
+		assert.Equal(t, event.Book.ID, int64(i+1))
+	}
+
+	// the slow watcher never read, its channel is closed once the buffer is full
+	for i := 0; i < watcherBuffer; i++ {
+		<-slow
+	}
+	_, ok := <-slow
+	assert.Equal(t, ok, false)
+
+	cancel()
+	_, ok = <-events
+	assert.Equal(t, ok, false)
+}
+
+type fakeEventSource struct {
+	events chan domain.BookEvent
+}
+
+func (f *fakeEventSource) Listen(ctx context.Context, fn func(domain.BookEvent)) error {
+	for {
+		select {
+		case event := <-f.events:
+			fn(event)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestBookStorage_Events(t *testing.T) {
+	books, _ := newTestBooks(DefaultBookRules)
+
+	source := &fakeEventSource{events: make(chan domain.BookEvent)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	books.watchers.fed.Store(true)
+	go books.RunEventFeed(ctx, source)
+
+	for i := 1; i <= 3; i++ {
+		if err := books.Create(ctx, &domain.Book{Title: "Title", Author: "Author", Rating: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first event was seen, the two others were missed
+	events, err := books.Events(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the feed brings the third event once more, and a new one
+	source.events <- domain.BookEvent{ID: 3, Action: domain.RevisionCreate, Book: domain.Book{ID: 3}}
+	source.events <- domain.BookEvent{ID: 4, Action: domain.RevisionUpdate, Book: domain.Book{ID: 1}}
+
+	ids := make([]int64, 0)
+	for len(ids) < 3 {
+		ids = append(ids, (<-events).ID)
+	}
+	assert.Equal(t, ids, []int64{2, 3, 4})
+
+	// with the feed running, the changes of this process only reach the watchers through it
+	live := books.Watch(ctx)
+	if err := books.Delete(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-live:
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+}
@@ -88,6 +88,43 @@ func (mr *MockPasswordHasherMockRecorder) Hash(password interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Hash", reflect.TypeOf((*MockPasswordHasher)(nil).Hash), password)
 }
 
+// MockMailer is a mock of Mailer interface.
+type MockMailer struct {
+	ctrl     *gomock.Controller
+	recorder *MockMailerMockRecorder
+}
+
+// MockMailerMockRecorder is the mock recorder for MockMailer.
+type MockMailerMockRecorder struct {
+	mock *MockMailer
+}
+
+// NewMockMailer creates a new mock instance.
+func NewMockMailer(ctrl *gomock.Controller) *MockMailer {
+	mock := &MockMailer{ctrl: ctrl}
+	mock.recorder = &MockMailerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMailer) EXPECT() *MockMailerMockRecorder {
+	return m.recorder
+}
+
+// Send mocks base method.
+func (m *MockMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", ctx, to, subject, body)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockMailerMockRecorder) Send(ctx, to, subject, body interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockMailer)(nil).Send), ctx, to, subject, body)
+}
+
 // MockUserStorage is a mock of UserStorage interface.
 type MockUserStorage struct {
 	ctrl     *gomock.Controller
@@ -125,6 +162,20 @@ func (mr *MockUserStorageMockRecorder) CreateUser(ctx, inp interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUserStorage)(nil).CreateUser), ctx, inp)
 }
 
+// Delete mocks base method.
+func (m *MockUserStorage) Delete(ctx context.Context, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockUserStorageMockRecorder) Delete(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockUserStorage)(nil).Delete), ctx, id)
+}
+
 // GetByCredential mocks base method.
 func (m *MockUserStorage) GetByCredential(ctx context.Context, email, passwords string) (domain0.User, error) {
 	m.ctrl.T.Helper()
@@ -140,6 +191,324 @@ func (mr *MockUserStorageMockRecorder) GetByCredential(ctx, email, passwords int
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByCredential", reflect.TypeOf((*MockUserStorage)(nil).GetByCredential), ctx, email, passwords)
 }
 
+// GetByEmail mocks base method.
+func (m *MockUserStorage) GetByEmail(ctx context.Context, email string) (domain0.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByEmail", ctx, email)
+	ret0, _ := ret[0].(domain0.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByEmail indicates an expected call of GetByEmail.
+func (mr *MockUserStorageMockRecorder) GetByEmail(ctx, email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByEmail", reflect.TypeOf((*MockUserStorage)(nil).GetByEmail), ctx, email)
+}
+
+// GetByEmailToken mocks base method.
+func (m *MockUserStorage) GetByEmailToken(ctx context.Context, token string) (domain0.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByEmailToken", ctx, token)
+	ret0, _ := ret[0].(domain0.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByEmailToken indicates an expected call of GetByEmailToken.
+func (mr *MockUserStorageMockRecorder) GetByEmailToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByEmailToken", reflect.TypeOf((*MockUserStorage)(nil).GetByEmailToken), ctx, token)
+}
+
+// GetByID mocks base method.
+func (m *MockUserStorage) GetByID(ctx context.Context, id int64) (domain0.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByID", ctx, id)
+	ret0, _ := ret[0].(domain0.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByID indicates an expected call of GetByID.
+func (mr *MockUserStorageMockRecorder) GetByID(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockUserStorage)(nil).GetByID), ctx, id)
+}
+
+// GetByIDs mocks base method.
+func (m *MockUserStorage) GetByIDs(ctx context.Context, ids []int64) ([]domain0.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByIDs", ctx, ids)
+	ret0, _ := ret[0].([]domain0.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByIDs indicates an expected call of GetByIDs.
+func (mr *MockUserStorageMockRecorder) GetByIDs(ctx, ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByIDs", reflect.TypeOf((*MockUserStorage)(nil).GetByIDs), ctx, ids)
+}
+
+// GetByResetToken mocks base method.
+func (m *MockUserStorage) GetByResetToken(ctx context.Context, token string) (domain0.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByResetToken", ctx, token)
+	ret0, _ := ret[0].(domain0.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByResetToken indicates an expected call of GetByResetToken.
+func (mr *MockUserStorageMockRecorder) GetByResetToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByResetToken", reflect.TypeOf((*MockUserStorage)(nil).GetByResetToken), ctx, token)
+}
+
+// List mocks base method.
+func (m *MockUserStorage) List(ctx context.Context, filter domain0.UserFilter) (domain0.UserList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, filter)
+	ret0, _ := ret[0].(domain0.UserList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockUserStorageMockRecorder) List(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockUserStorage)(nil).List), ctx, filter)
+}
+
+// Update mocks base method.
+func (m *MockUserStorage) Update(ctx context.Context, user domain0.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockUserStorageMockRecorder) Update(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockUserStorage)(nil).Update), ctx, user)
+}
+
+// MockIdentityRepository is a mock of IdentityRepository interface.
+type MockIdentityRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdentityRepositoryMockRecorder
+}
+
+// MockIdentityRepositoryMockRecorder is the mock recorder for MockIdentityRepository.
+type MockIdentityRepositoryMockRecorder struct {
+	mock *MockIdentityRepository
+}
+
+// NewMockIdentityRepository creates a new mock instance.
+func NewMockIdentityRepository(ctrl *gomock.Controller) *MockIdentityRepository {
+	mock := &MockIdentityRepository{ctrl: ctrl}
+	mock.recorder = &MockIdentityRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdentityRepository) EXPECT() *MockIdentityRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockIdentityRepository) Create(ctx context.Context, identity domain0.UserIdentity) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, identity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockIdentityRepositoryMockRecorder) Create(ctx, identity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockIdentityRepository)(nil).Create), ctx, identity)
+}
+
+// DeleteByUser mocks base method.
+func (m *MockIdentityRepository) DeleteByUser(ctx context.Context, userID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByUser", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByUser indicates an expected call of DeleteByUser.
+func (mr *MockIdentityRepositoryMockRecorder) DeleteByUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByUser", reflect.TypeOf((*MockIdentityRepository)(nil).DeleteByUser), ctx, userID)
+}
+
+// Get mocks base method.
+func (m *MockIdentityRepository) Get(ctx context.Context, provider, subject string) (domain0.UserIdentity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, provider, subject)
+	ret0, _ := ret[0].(domain0.UserIdentity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockIdentityRepositoryMockRecorder) Get(ctx, provider, subject interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockIdentityRepository)(nil).Get), ctx, provider, subject)
+}
+
+// ListByUser mocks base method.
+func (m *MockIdentityRepository) ListByUser(ctx context.Context, userID int64) ([]domain0.UserIdentity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByUser", ctx, userID)
+	ret0, _ := ret[0].([]domain0.UserIdentity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByUser indicates an expected call of ListByUser.
+func (mr *MockIdentityRepositoryMockRecorder) ListByUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByUser", reflect.TypeOf((*MockIdentityRepository)(nil).ListByUser), ctx, userID)
+}
+
+// MockOAuthClientRepository is a mock of OAuthClientRepository interface.
+type MockOAuthClientRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockOAuthClientRepositoryMockRecorder
+}
+
+// MockOAuthClientRepositoryMockRecorder is the mock recorder for MockOAuthClientRepository.
+type MockOAuthClientRepositoryMockRecorder struct {
+	mock *MockOAuthClientRepository
+}
+
+// NewMockOAuthClientRepository creates a new mock instance.
+func NewMockOAuthClientRepository(ctrl *gomock.Controller) *MockOAuthClientRepository {
+	mock := &MockOAuthClientRepository{ctrl: ctrl}
+	mock.recorder = &MockOAuthClientRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOAuthClientRepository) EXPECT() *MockOAuthClientRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockOAuthClientRepository) Create(ctx context.Context, client *domain0.OAuthClient) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, client)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockOAuthClientRepositoryMockRecorder) Create(ctx, client interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockOAuthClientRepository)(nil).Create), ctx, client)
+}
+
+// Delete mocks base method.
+func (m *MockOAuthClientRepository) Delete(ctx context.Context, clientID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, clientID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockOAuthClientRepositoryMockRecorder) Delete(ctx, clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockOAuthClientRepository)(nil).Delete), ctx, clientID)
+}
+
+// GetByClientID mocks base method.
+func (m *MockOAuthClientRepository) GetByClientID(ctx context.Context, clientID string) (domain0.OAuthClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByClientID", ctx, clientID)
+	ret0, _ := ret[0].(domain0.OAuthClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByClientID indicates an expected call of GetByClientID.
+func (mr *MockOAuthClientRepositoryMockRecorder) GetByClientID(ctx, clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByClientID", reflect.TypeOf((*MockOAuthClientRepository)(nil).GetByClientID), ctx, clientID)
+}
+
+// List mocks base method.
+func (m *MockOAuthClientRepository) List(ctx context.Context) ([]domain0.OAuthClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]domain0.OAuthClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockOAuthClientRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockOAuthClientRepository)(nil).List), ctx)
+}
+
+// MockAuthorizationCodeRepository is a mock of AuthorizationCodeRepository interface.
+type MockAuthorizationCodeRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuthorizationCodeRepositoryMockRecorder
+}
+
+// MockAuthorizationCodeRepositoryMockRecorder is the mock recorder for MockAuthorizationCodeRepository.
+type MockAuthorizationCodeRepositoryMockRecorder struct {
+	mock *MockAuthorizationCodeRepository
+}
+
+// NewMockAuthorizationCodeRepository creates a new mock instance.
+func NewMockAuthorizationCodeRepository(ctrl *gomock.Controller) *MockAuthorizationCodeRepository {
+	mock := &MockAuthorizationCodeRepository{ctrl: ctrl}
+	mock.recorder = &MockAuthorizationCodeRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuthorizationCodeRepository) EXPECT() *MockAuthorizationCodeRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Consume mocks base method.
+func (m *MockAuthorizationCodeRepository) Consume(ctx context.Context, code string) (domain0.AuthorizationCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Consume", ctx, code)
+	ret0, _ := ret[0].(domain0.AuthorizationCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Consume indicates an expected call of Consume.
+func (mr *MockAuthorizationCodeRepositoryMockRecorder) Consume(ctx, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consume", reflect.TypeOf((*MockAuthorizationCodeRepository)(nil).Consume), ctx, code)
+}
+
+// Create mocks base method.
+func (m *MockAuthorizationCodeRepository) Create(ctx context.Context, code domain0.AuthorizationCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, code)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAuthorizationCodeRepositoryMockRecorder) Create(ctx, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAuthorizationCodeRepository)(nil).Create), ctx, code)
+}
+
 // MockSessionRepository is a mock of SessionRepository interface.
 type MockSessionRepository struct {
 	ctrl     *gomock.Controller
@@ -163,6 +532,21 @@ func (m *MockSessionRepository) EXPECT() *MockSessionRepositoryMockRecorder {
 	return m.recorder
 }
 
+// Consume mocks base method.
+func (m *MockSessionRepository) Consume(ctx context.Context, token string) (domain0.RefreshSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Consume", ctx, token)
+	ret0, _ := ret[0].(domain0.RefreshSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Consume indicates an expected call of Consume.
+func (mr *MockSessionRepositoryMockRecorder) Consume(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consume", reflect.TypeOf((*MockSessionRepository)(nil).Consume), ctx, token)
+}
+
 // Create mocks base method.
 func (m *MockSessionRepository) Create(ctx context.Context, token domain0.RefreshSession) error {
 	m.ctrl.T.Helper()
@@ -177,6 +561,35 @@ func (mr *MockSessionRepositoryMockRecorder) Create(ctx, token interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionRepository)(nil).Create), ctx, token)
 }
 
+// DeleteByUser mocks base method.
+func (m *MockSessionRepository) DeleteByUser(ctx context.Context, userID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByUser", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByUser indicates an expected call of DeleteByUser.
+func (mr *MockSessionRepositoryMockRecorder) DeleteByUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByUser", reflect.TypeOf((*MockSessionRepository)(nil).DeleteByUser), ctx, userID)
+}
+
+// Find mocks base method.
+func (m *MockSessionRepository) Find(ctx context.Context, token string) (domain0.RefreshSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Find", ctx, token)
+	ret0, _ := ret[0].(domain0.RefreshSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Find indicates an expected call of Find.
+func (mr *MockSessionRepositoryMockRecorder) Find(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockSessionRepository)(nil).Find), ctx, token)
+}
+
 // Get mocks base method.
 func (m *MockSessionRepository) Get(ctx context.Context, token string) (domain0.RefreshSession, error) {
 	m.ctrl.T.Helper()
@@ -191,3 +604,18 @@ func (mr *MockSessionRepositoryMockRecorder) Get(ctx, token interface{}) *gomock
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockSessionRepository)(nil).Get), ctx, token)
 }
+
+// ListByUser mocks base method.
+func (m *MockSessionRepository) ListByUser(ctx context.Context, userID int64) ([]domain0.RefreshSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByUser", ctx, userID)
+	ret0, _ := ret[0].([]domain0.RefreshSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByUser indicates an expected call of ListByUser.
+func (mr *MockSessionRepositoryMockRecorder) ListByUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByUser", reflect.TypeOf((*MockSessionRepository)(nil).ListByUser), ctx, userID)
+}
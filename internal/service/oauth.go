@@ -0,0 +1,506 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	authorizationCodeTTL = time.Minute * 5
+
+	pkceS256  = "S256"
+	pkcePlain = "plain"
+
+	// RFC 7591 section 3.2.2
+	oauthInvalidClientMetadata = "invalid_client_metadata"
+	oauthInvalidRedirectURI    = "invalid_redirect_uri"
+)
+
+// OAuthServer lets our other apps sign users in through this service, see RFC 6749.
+// The tokens are the ones Users issues, tagged with the client and the granted scope.
+type OAuthServer struct {
+	users   *Users
+	clients OAuthClientRepository
+	codes   AuthorizationCodeRepository
+}
+
+func NewOAuthServer(users *Users, clients OAuthClientRepository, codes AuthorizationCodeRepository) *OAuthServer {
+	return &OAuthServer{
+		users:   users,
+		clients: clients,
+		codes:   codes,
+	}
+}
+
+// RegisterClient returns the only copy of the client secret, public clients don't get one.
+func (o *OAuthServer) RegisterClient(ctx context.Context, inp domain.RegisterClientInput) (domain.RegisteredClient, error) {
+	var registered domain.RegisteredClient
+
+	if inp.Public && slices.Contains(inp.GrantTypes, domain.GrantClientCredentials) {
+		return registered, domain.NewOAuthError(oauthInvalidClientMetadata, "public clients can't use the client_credentials grant")
+	}
+
+	if slices.Contains(inp.GrantTypes, domain.GrantAuthorizationCode) && len(inp.RedirectURIs) == 0 {
+		return registered, domain.NewOAuthError(oauthInvalidRedirectURI, "the authorization_code grant needs at least one redirect URI")
+	}
+
+	clientID, err := newRefreshToken()
+	if err != nil {
+		return registered, err
+	}
+
+	registered.OAuthClient = domain.OAuthClient{
+		ClientID:     clientID[:32],
+		Name:         inp.Name,
+		RedirectURIs: orEmpty(inp.RedirectURIs),
+		GrantTypes:   inp.GrantTypes,
+		Scopes:       orEmpty(inp.Scopes),
+		Public:       inp.Public,
+		CreatedAt:    time.Now(),
+	}
+
+	if !inp.Public {
+		if registered.ClientSecret, err = newRefreshToken(); err != nil {
+			return registered, err
+		}
+
+		if registered.SecretHash, err = o.users.Hasher.Hash(registered.ClientSecret); err != nil {
+			return registered, err
+		}
+	}
+
+	if err := o.clients.Create(ctx, &registered.OAuthClient); err != nil {
+		return registered, err
+	}
+
+	return registered, nil
+}
+
+func (o *OAuthServer) ListClients(ctx context.Context) ([]domain.OAuthClient, error) {
+	return o.clients.List(ctx)
+}
+
+func (o *OAuthServer) DeleteClient(ctx context.Context, clientID string) error {
+	return o.clients.Delete(ctx, clientID)
+}
+
+// Authorize issues an authorization code for the signed in user and returns where to redirect
+// them. Our apps are trusted, so there is no consent screen.
+func (o *OAuthServer) Authorize(ctx context.Context, userID int64, req domain.AuthorizeRequest) (string, error) {
+	client, err := o.clients.GetByClientID(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, domain.ErrOAuthClientNotFound) {
+			return "", domain.NewOAuthError(domain.OAuthInvalidRequest, "unknown client_id")
+		}
+		return "", err
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return "", domain.NewOAuthError(domain.OAuthInvalidRequest, "the redirect_uri is not registered for the client")
+	}
+
+	// from here on the errors are sent back to the client through the redirect
+	fail := func(code, description string) error {
+		e := domain.NewOAuthError(code, description)
+		e.RedirectURI = redirectURI
+		e.State = req.State
+		return e
+	}
+
+	if req.ResponseType != "code" {
+		return "", fail(domain.OAuthUnsupportedResponseType, "only the code response type is supported")
+	}
+
+	if !slices.Contains(client.GrantTypes, domain.GrantAuthorizationCode) {
+		return "", fail(domain.OAuthUnauthorizedClient, "the client can't use the authorization_code grant")
+	}
+
+	scope, ok := grantScope(client.Scopes, req.Scope)
+	if !ok {
+		return "", fail(domain.OAuthInvalidScope, "the requested scope is not allowed for the client")
+	}
+
+	method := req.CodeChallengeMethod
+	switch {
+	case req.CodeChallenge == "" && client.Public:
+		return "", fail(domain.OAuthInvalidRequest, "public clients have to use PKCE")
+	case req.CodeChallenge == "":
+		method = ""
+	case method == "":
+		method = pkcePlain
+	case method != pkceS256 && method != pkcePlain:
+		return "", fail(domain.OAuthInvalidRequest, "unsupported code_challenge_method")
+	}
+
+	user, err := o.users.Repo.GetByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkCanSignIn(user); err != nil {
+		return "", fail(domain.OAuthAccessDenied, err.Error())
+	}
+
+	code, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = o.codes.Create(ctx, domain.AuthorizationCode{
+		Code:                code,
+		ClientID:            client.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: method,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	return withQuery(redirectURI, params)
+}
+
+func (o *OAuthServer) Token(ctx context.Context, creds domain.ClientCredentials, req domain.TokenRequest) (domain.TokenResponse, error) {
+	client, err := o.authenticate(ctx, creds)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	switch req.GrantType {
+	case domain.GrantAuthorizationCode, domain.GrantRefreshToken, domain.GrantClientCredentials:
+	default:
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthUnsupportedGrantType, "unsupported grant_type")
+	}
+
+	if !slices.Contains(client.GrantTypes, req.GrantType) {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthUnauthorizedClient, "the client can't use the "+req.GrantType+" grant")
+	}
+
+	switch req.GrantType {
+	case domain.GrantAuthorizationCode:
+		return o.exchangeCode(ctx, client, req)
+	case domain.GrantRefreshToken:
+		return o.refresh(ctx, client, req)
+	default:
+		return o.clientCredentials(ctx, client, req)
+	}
+}
+
+func (o *OAuthServer) exchangeCode(ctx context.Context, client domain.OAuthClient, req domain.TokenRequest) (domain.TokenResponse, error) {
+	code, err := o.codes.Consume(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrAuthorizationCodeInvalid) {
+			return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, err.Error())
+		}
+		return domain.TokenResponse{}, err
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != req.RedirectURI || time.Now().After(code.ExpiresAt) {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, domain.ErrAuthorizationCodeInvalid.Error())
+	}
+
+	if !verifyCodeChallenge(code, req.CodeVerifier) {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, "the code_verifier doesn't match the code_challenge")
+	}
+
+	resp, err := o.issueForUser(ctx, client, code.UserID, code.Scope)
+	if err != nil {
+		return resp, err
+	}
+
+	o.users.sendAudit(ctx, "OAuthServer.Token", audit.LogItem{
+		Action:   audit.ACTION_LOGIN,
+		Entity:   audit.ENTITY_USER,
+		EntityID: code.UserID,
+	})
+
+	return resp, nil
+}
+
+// refresh rotates the refresh token of the client, the sessions of the user elsewhere stay alive.
+func (o *OAuthServer) refresh(ctx context.Context, client domain.OAuthClient, req domain.TokenRequest) (domain.TokenResponse, error) {
+	session, err := o.users.SessionRepo.Find(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrRefreshTokenNotFound) {
+			return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, err.Error())
+		}
+		return domain.TokenResponse{}, err
+	}
+
+	if session.ClientID != client.ClientID {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, domain.ErrRefreshTokenNotFound.Error())
+	}
+
+	if _, err := o.users.SessionRepo.Consume(ctx, req.RefreshToken); err != nil {
+		if errors.Is(err, domain.ErrRefreshTokenNotFound) {
+			return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, err.Error())
+		}
+		return domain.TokenResponse{}, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, domain.ErrRefreshTokenExpired.Error())
+	}
+
+	// a refreshed token may only narrow the scope, see RFC 6749 section 6
+	scope, ok := grantScope(strings.Fields(session.Scope), req.Scope)
+	if !ok {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidScope, "the scope exceeds the one originally granted")
+	}
+
+	return o.issueForUser(ctx, client, session.UserID, scope)
+}
+
+func (o *OAuthServer) clientCredentials(ctx context.Context, client domain.OAuthClient, req domain.TokenRequest) (domain.TokenResponse, error) {
+	if client.Public {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthUnauthorizedClient, "public clients can't use the client_credentials grant")
+	}
+
+	scope, ok := grantScope(client.Scopes, req.Scope)
+	if !ok {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidScope, "the requested scope is not allowed for the client")
+	}
+
+	// the subject can't be parsed as a user ID, so these tokens don't open the user API
+	accessToken, _, err := o.users.issueTokens(ctx, clientSubject(client.ClientID), 0, client.ClientID, scope)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	return o.tokenResponse(accessToken, "", scope), nil
+}
+
+func (o *OAuthServer) issueForUser(ctx context.Context, client domain.OAuthClient, userID int64, scope string) (domain.TokenResponse, error) {
+	user, err := o.users.Repo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, err.Error())
+		}
+		return domain.TokenResponse{}, err
+	}
+
+	if err := checkCanSignIn(user); err != nil {
+		return domain.TokenResponse{}, domain.NewOAuthError(domain.OAuthInvalidGrant, err.Error())
+	}
+
+	// refresh tokens are only handed to clients allowed to use them
+	sessionUserID := user.ID
+	if !slices.Contains(client.GrantTypes, domain.GrantRefreshToken) {
+		sessionUserID = 0
+	}
+
+	accessToken, refreshToken, err := o.users.issueTokens(ctx, strconv.FormatInt(user.ID, 10), sessionUserID, client.ClientID, scope)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	return o.tokenResponse(accessToken, refreshToken, scope), nil
+}
+
+func (o *OAuthServer) tokenResponse(accessToken, refreshToken, scope string) domain.TokenResponse {
+	return domain.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(o.users.TokenTtl.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+}
+
+// Introspect describes access and refresh tokens to authenticated clients, see RFC 7662.
+func (o *OAuthServer) Introspect(ctx context.Context, creds domain.ClientCredentials, token string) (domain.Introspection, error) {
+	if _, err := o.authenticate(ctx, creds); err != nil {
+		return domain.Introspection{}, err
+	}
+
+	var claims accessClaims
+	if _, err := jwt.ParseWithClaims(token, &claims, o.users.keyFunc); err == nil {
+		if !o.subjectActive(ctx, claims.Subject) {
+			return domain.Introspection{}, nil
+		}
+
+		return domain.Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			ExpiresAt: claims.ExpiresAt,
+			IssuedAt:  claims.IssuedAt,
+		}, nil
+	}
+
+	session, err := o.users.SessionRepo.Find(ctx, token)
+	if err != nil {
+		if errors.Is(err, domain.ErrRefreshTokenNotFound) {
+			return domain.Introspection{}, nil
+		}
+		return domain.Introspection{}, err
+	}
+
+	subject := strconv.FormatInt(session.UserID, 10)
+	if time.Now().After(session.ExpiresAt) || !o.subjectActive(ctx, subject) {
+		return domain.Introspection{}, nil
+	}
+
+	return domain.Introspection{
+		Active:    true,
+		Scope:     session.Scope,
+		ClientID:  session.ClientID,
+		Subject:   subject,
+		ExpiresAt: session.ExpiresAt.Unix(),
+		IssuedAt:  session.CreatedAt.Unix(),
+	}, nil
+}
+
+// Revoke ends a refresh session of the client, see RFC 7009. Access tokens are not stored,
+// they stay valid until they expire, which is why they are short lived.
+func (o *OAuthServer) Revoke(ctx context.Context, creds domain.ClientCredentials, token string) error {
+	client, err := o.authenticate(ctx, creds)
+	if err != nil {
+		return err
+	}
+
+	session, err := o.users.SessionRepo.Find(ctx, token)
+	if err != nil {
+		// unknown tokens are not an error for the client, see RFC 7009 section 2.2
+		if errors.Is(err, domain.ErrRefreshTokenNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if session.ClientID != client.ClientID {
+		return domain.NewOAuthError(domain.OAuthUnauthorizedClient, "the token was issued to another client")
+	}
+
+	if _, err := o.users.SessionRepo.Consume(ctx, token); err != nil && !errors.Is(err, domain.ErrRefreshTokenNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (o *OAuthServer) authenticate(ctx context.Context, creds domain.ClientCredentials) (domain.OAuthClient, error) {
+	client, err := o.clients.GetByClientID(ctx, creds.ClientID)
+	if err != nil {
+		if errors.Is(err, domain.ErrOAuthClientNotFound) {
+			return client, domain.NewOAuthError(domain.OAuthInvalidClient, "client authentication failed")
+		}
+		return client, err
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	hash, err := o.users.Hasher.Hash(creds.ClientSecret)
+	if err != nil {
+		return client, err
+	}
+
+	if creds.ClientSecret == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash)) != 1 {
+		return client, domain.NewOAuthError(domain.OAuthInvalidClient, "client authentication failed")
+	}
+
+	return client, nil
+}
+
+// subjectActive tells whether the subject of a token may still use it. Clients stay active
+// as long as they are registered.
+func (o *OAuthServer) subjectActive(ctx context.Context, subject string) bool {
+	if clientID, ok := strings.CutPrefix(subject, clientSubject("")); ok {
+		_, err := o.clients.GetByClientID(ctx, clientID)
+		return err == nil
+	}
+
+	id, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	user, err := o.users.Repo.GetByID(ctx, id)
+
+	return err == nil && checkCanSignIn(user) == nil
+}
+
+func clientSubject(clientID string) string {
+	return "client:" + clientID
+}
+
+// grantScope checks the requested space separated scope against the allowed one,
+// an empty request gets everything allowed.
+func grantScope(allowed []string, requested string) (string, bool) {
+	if requested == "" {
+		return strings.Join(allowed, " "), true
+	}
+
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(allowed, s) {
+			return "", false
+		}
+	}
+
+	return strings.Join(strings.Fields(requested), " "), true
+}
+
+// verifyCodeChallenge checks the PKCE verifier, see RFC 7636 section 4.6.
+func verifyCodeChallenge(code domain.AuthorizationCode, verifier string) bool {
+	switch code.CodeChallengeMethod {
+	case "":
+		return true
+	case pkceS256:
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	return verifier != "" && subtle.ConstantTimeCompare([]byte(verifier), []byte(code.CodeChallenge)) == 1
+}
+
+func withQuery(uri string, params url.Values) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func orEmpty(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+
+	return list
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestOAuthServer_AuthorizationCode(t *testing.T) {
+	const verifier = "a-long-enough-verifier-for-the-test"
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	user := domain.User{ID: 7, Email: "jane@example.com", Role: domain.RoleUser}
+	client := domain.OAuthClient{
+		ClientID:     "app",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{domain.GrantAuthorizationCode, domain.GrantRefreshToken},
+		Scopes:       []string{"books", "profile"},
+		Public:       true,
+	}
+
+	testTable := []struct {
+		name         string
+		verifier     string
+		wantErrCode  string
+		wantSessions int
+	}{
+		{
+			name:         "OK",
+			verifier:     verifier,
+			wantSessions: 1,
+		},
+		{
+			name:        "Wrong verifier",
+			verifier:    "something-else",
+			wantErrCode: domain.OAuthInvalidGrant,
+		},
+		{
+			name:        "Missing verifier",
+			wantErrCode: domain.OAuthInvalidGrant,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			repo := mock_service.NewMockUserStorage(c)
+			sessions := mock_service.NewMockSessionRepository(c)
+			audit := mock_service.NewMockAuditClient(c)
+			clients := mock_service.NewMockOAuthClientRepository(c)
+			codes := mock_service.NewMockAuthorizationCodeRepository(c)
+
+			var issued domain.AuthorizationCode
+
+			clients.EXPECT().GetByClientID(gomock.Any(), client.ClientID).Return(client, nil).Times(2)
+			repo.EXPECT().GetByID(gomock.Any(), user.ID).Return(user, nil).MinTimes(1)
+			codes.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, code domain.AuthorizationCode) error {
+				issued = code
+				return nil
+			})
+			codes.EXPECT().Consume(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, code string) (domain.AuthorizationCode, error) {
+				if code != issued.Code {
+					return domain.AuthorizationCode{}, domain.ErrAuthorizationCodeInvalid
+				}
+				return issued, nil
+			})
+			sessions.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil).Times(testCase.wantSessions)
+			audit.EXPECT().SendLogRequest(gomock.Any(), gomock.Any()).Return(nil).Times(testCase.wantSessions)
+
+			users := NewUsers(repo, nil, sessions, audit, nil, []byte("secret"), time.Minute, time.Minute)
+			o := NewOAuthServer(users, clients, codes)
+
+			ctx := context.Background()
+
+			redirect, err := o.Authorize(ctx, user.ID, domain.AuthorizeRequest{
+				ResponseType:        "code",
+				ClientID:            client.ClientID,
+				RedirectURI:         client.RedirectURIs[0],
+				Scope:               "books",
+				State:               "xyz",
+				CodeChallenge:       challenge,
+				CodeChallengeMethod: "S256",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := url.Parse(redirect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Query().Get("state") != "xyz" || u.Query().Get("code") == "" {
+				t.Fatalf("expected the code and the state in the redirect, got %q", redirect)
+			}
+
+			resp, err := o.Token(ctx, domain.ClientCredentials{ClientID: client.ClientID}, domain.TokenRequest{
+				GrantType:    domain.GrantAuthorizationCode,
+				Code:         u.Query().Get("code"),
+				RedirectURI:  client.RedirectURIs[0],
+				CodeVerifier: testCase.verifier,
+			})
+			if testCase.wantErrCode != "" {
+				var oauthErr *domain.OAuthError
+				if !errors.As(err, &oauthErr) || oauthErr.Code != testCase.wantErrCode {
+					t.Fatalf("expected %s, got %v", testCase.wantErrCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Scope != "books" || resp.RefreshToken == "" {
+				t.Fatalf("unexpected token response %+v", resp)
+			}
+
+			access, err := users.ParseAccessToken(ctx, resp.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if access != (domain.AccessToken{UserID: user.ID, ClientID: client.ClientID, Scope: "books"}) {
+				t.Fatalf("unexpected access token %+v", access)
+			}
+		})
+	}
+}
+
+func TestOAuthServer_ClientCredentials(t *testing.T) {
+	client := domain.OAuthClient{
+		ClientID:   "worker",
+		SecretHash: "hashed-secret",
+		GrantTypes: []string{domain.GrantClientCredentials},
+		Scopes:     []string{"books"},
+	}
+
+	testTable := []struct {
+		name        string
+		secret      string
+		scope       string
+		wantErrCode string
+	}{
+		{
+			name:   "OK",
+			secret: "secret",
+		},
+		{
+			name:        "Wrong secret",
+			secret:      "guess",
+			wantErrCode: domain.OAuthInvalidClient,
+		},
+		{
+			name:        "Scope not allowed",
+			secret:      "secret",
+			scope:       "admin",
+			wantErrCode: domain.OAuthInvalidScope,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			hasher := mock_service.NewMockPasswordHasher(c)
+			clients := mock_service.NewMockOAuthClientRepository(c)
+
+			clients.EXPECT().GetByClientID(gomock.Any(), client.ClientID).Return(client, nil)
+			hasher.EXPECT().Hash(testCase.secret).Return("hashed-"+testCase.secret, nil)
+
+			users := NewUsers(nil, hasher, nil, nil, nil, []byte("secret"), time.Minute, time.Minute)
+			o := NewOAuthServer(users, clients, nil)
+
+			ctx := context.Background()
+
+			resp, err := o.Token(ctx, domain.ClientCredentials{ClientID: client.ClientID, ClientSecret: testCase.secret}, domain.TokenRequest{
+				GrantType: domain.GrantClientCredentials,
+				Scope:     testCase.scope,
+			})
+			if testCase.wantErrCode != "" {
+				var oauthErr *domain.OAuthError
+				if !errors.As(err, &oauthErr) || oauthErr.Code != testCase.wantErrCode {
+					t.Fatalf("expected %s, got %v", testCase.wantErrCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.RefreshToken != "" {
+				t.Fatal("client credentials must not get a refresh token")
+			}
+
+			// a client token must not pass as a user token
+			if _, err := users.ParseToken(ctx, resp.AccessToken); err == nil {
+				t.Fatal("expected the client token to be rejected by the user API")
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+// oidcDiscoveryTimeout bounds the discovery of a provider, the logins waiting for it fail afterwards.
+const oidcDiscoveryTimeout = 10 * time.Second
+
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type oidcClient struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// OIDC signs users in with external OpenID Connect providers using the authorization
+// code flow with PKCE and issues our own tokens afterwards.
+type OIDC struct {
+	users      *Users
+	identities IdentityRepository
+	providers  map[string]OIDCProvider
+
+	mu        sync.Mutex
+	clients   map[string]*oidcClient
+	discovery singleflight.Group
+}
+
+func NewOIDC(users *Users, identities IdentityRepository, providers []OIDCProvider) *OIDC {
+	o := &OIDC{
+		users:      users,
+		identities: identities,
+		providers:  make(map[string]OIDCProvider),
+		clients:    make(map[string]*oidcClient),
+	}
+
+	for _, p := range providers {
+		o.providers[p.Name] = p
+	}
+
+	return o
+}
+
+func (o *OIDC) LoginURL(ctx context.Context, provider string) (domain.OIDCLogin, error) {
+	var login domain.OIDCLogin
+
+	client, err := o.client(ctx, provider)
+	if err != nil {
+		return login, err
+	}
+
+	if login.State, err = newRefreshToken(); err != nil {
+		return login, err
+	}
+	if login.Nonce, err = newRefreshToken(); err != nil {
+		return login, err
+	}
+	login.Verifier = oauth2.GenerateVerifier()
+
+	login.URL = client.oauth.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+
+	return login, nil
+}
+
+// SignIn finishes the login started with LoginURL, the caller is responsible for checking the state.
+func (o *OIDC) SignIn(ctx context.Context, provider, code string, login domain.OIDCLogin) (string, string, error) {
+	client, err := o.client(ctx, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := client.oauth.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", domain.ErrOIDCLoginFailed, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", fmt.Errorf("%w: no id_token in the token response", domain.ErrOIDCLoginFailed)
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", domain.ErrOIDCLoginFailed, err)
+	}
+
+	if idToken.Nonce != login.Nonce {
+		return "", "", fmt.Errorf("%w: nonce mismatch", domain.ErrOIDCLoginFailed)
+	}
+
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return "", "", fmt.Errorf("%w: %v", domain.ErrOIDCLoginFailed, err)
+	}
+
+	userID, err := o.resolveUser(ctx, provider, idToken.Subject, claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	user, err := o.users.Repo.GetByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := checkCanSignIn(user); err != nil {
+		return "", "", err
+	}
+
+	return o.users.generateTokens(ctx, userID)
+}
+
+// resolveUser finds the user linked to the external identity. Unknown identities are linked
+// to the account with their email, or a new account is provisioned, only when the provider
+// vouches for the email: anyone could claim the email of someone else otherwise.
+func (o *OIDC) resolveUser(ctx context.Context, provider, subject string, claims idTokenClaims) (int64, error) {
+	identity, err := o.identities.Get(ctx, provider, subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, domain.ErrIdentityNotFound) {
+		return 0, err
+	}
+
+	email := domain.NormalizeEmail(claims.Email)
+	if email == "" {
+		return 0, fmt.Errorf("%w: the provider didn't share an email", domain.ErrOIDCLoginFailed)
+	}
+	if !claims.EmailVerified {
+		return 0, fmt.Errorf("%w: the provider didn't verify the email", domain.ErrOIDCLoginFailed)
+	}
+
+	user, err := o.users.Repo.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+	case errors.Is(err, domain.ErrUserNotFound):
+		if user, err = o.provisionUser(ctx, email, claims.Name); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	err = o.identities.Create(ctx, domain.UserIdentity{
+		UserID:    user.ID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return user.ID, nil
+}
+
+// provisionUser creates an account with a random password, it can only be used through
+// the provider until the user resets the password.
+func (o *OIDC) provisionUser(ctx context.Context, email, name string) (domain.User, error) {
+	secret, err := newRefreshToken()
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	password, err := o.users.Hasher.Hash(secret)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+
+	if err := o.users.Repo.CreateUser(ctx, domain.User{
+		Name:         name,
+		Email:        email,
+		Password:     password,
+		RegisteredAt: time.Now(),
+	}); err != nil {
+		return domain.User{}, err
+	}
+
+	user, err := o.users.Repo.GetByEmail(ctx, email)
+	if err != nil {
+		return user, err
+	}
+
+	o.users.sendAudit(ctx, "OIDC.SignIn", audit.LogItem{
+		Action:   audit.ACTION_REGISTER,
+		Entity:   audit.ENTITY_USER,
+		EntityID: user.ID,
+	})
+
+	return user, nil
+}
+
+// client runs the provider discovery on first use, so a provider being down doesn't stop the
+// service. The logins of a provider share its discovery, which outlives the request that
+// started it, and only a successful one is kept.
+func (o *OIDC) client(ctx context.Context, name string) (*oidcClient, error) {
+	o.mu.Lock()
+	client, ok := o.clients[name]
+	o.mu.Unlock()
+
+	if ok {
+		return client, nil
+	}
+
+	cfg, ok := o.providers[name]
+	if !ok {
+		return nil, domain.ErrProviderNotFound
+	}
+
+	discovered := o.discovery.DoChan(name, func() (interface{}, error) {
+		return o.discover(cfg)
+	})
+
+	select {
+	case res := <-discovered:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*oidcClient), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (o *OIDC) discover(cfg OIDCProvider) (*oidcClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcDiscoveryTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	client := &oidcClient{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+
+	o.mu.Lock()
+	o.clients[cfg.Name] = client
+	o.mu.Unlock()
+
+	return client, nil
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+)
+
+// mockOIDCServer is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that checks the PKCE verifier against the challenge sent to the authorization endpoint.
+type mockOIDCServer struct {
+	*httptest.Server
+
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+	verified  bool
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &mockOIDCServer{key: key, subject: "external-42", email: "Jane@Example.com", verified: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/authorize",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := r.FormValue("code_verifier")
+		sum := sha256.Sum256([]byte(verifier))
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            s.URL,
+			"aud":            "client",
+			"sub":            s.subject,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          s.nonce,
+			"email":          s.email,
+			"email_verified": s.verified,
+			"name":           "Jane",
+		})
+		idToken.Header["kid"] = "test"
+
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     signed,
+		})
+	})
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// authorize plays the browser: it remembers what the login URL asked for.
+func (s *mockOIDCServer) authorize(t *testing.T, loginURL string) {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected a S256 PKCE challenge, got %q", loginURL)
+	}
+
+	s.challenge = u.Query().Get("code_challenge")
+	s.nonce = u.Query().Get("nonce")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestOIDC_SignIn(t *testing.T) {
+	type mockBehavior func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient)
+
+	user := domain.User{ID: 7, Name: "Jane", Email: "jane@example.com", Role: domain.RoleUser}
+
+	testTable := []struct {
+		name         string
+		code         string
+		unverified   bool
+		mockBehavior mockBehavior
+		wantErr      error
+	}{
+		{
+			name: "Provisions a new user",
+			code: "good-code",
+			mockBehavior: func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient) {
+				identities.EXPECT().Get(gomock.Any(), "mock", "external-42").Return(domain.UserIdentity{}, domain.ErrIdentityNotFound)
+				repo.EXPECT().GetByEmail(gomock.Any(), user.Email).Return(domain.User{}, domain.ErrUserNotFound)
+				hasher.EXPECT().Hash(gomock.Any()).Return("hashed", nil)
+				repo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(nil)
+				repo.EXPECT().GetByEmail(gomock.Any(), user.Email).Return(user, nil)
+				audit.EXPECT().SendLogRequest(gomock.Any(), gomock.Any()).Return(nil)
+				identities.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+				repo.EXPECT().GetByID(gomock.Any(), user.ID).Return(user, nil)
+			},
+		},
+		{
+			name: "Known identity",
+			code: "good-code",
+			mockBehavior: func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient) {
+				identities.EXPECT().Get(gomock.Any(), "mock", "external-42").Return(domain.UserIdentity{UserID: user.ID}, nil)
+				repo.EXPECT().GetByID(gomock.Any(), user.ID).Return(user, nil)
+			},
+		},
+		{
+			name:       "Known identity with an unverified email",
+			code:       "good-code",
+			unverified: true,
+			mockBehavior: func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient) {
+				identities.EXPECT().Get(gomock.Any(), "mock", "external-42").Return(domain.UserIdentity{UserID: user.ID}, nil)
+				repo.EXPECT().GetByID(gomock.Any(), user.ID).Return(user, nil)
+			},
+		},
+		{
+			name:       "Unverified email",
+			code:       "good-code",
+			unverified: true,
+			mockBehavior: func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient) {
+				// neither linked to the account with the email nor provisioned
+				identities.EXPECT().Get(gomock.Any(), "mock", "external-42").Return(domain.UserIdentity{}, domain.ErrIdentityNotFound)
+			},
+			wantErr: domain.ErrOIDCLoginFailed,
+		},
+		{
+			name: "Rejected code",
+			code: "bad-code",
+			mockBehavior: func(repo *mock_service.MockUserStorage, identities *mock_service.MockIdentityRepository, hasher *mock_service.MockPasswordHasher, audit *mock_service.MockAuditClient) {
+			},
+			wantErr: domain.ErrOIDCLoginFailed,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			server := newMockOIDCServer(t)
+			defer server.Close()
+			server.verified = !testCase.unverified
+
+			repo := mock_service.NewMockUserStorage(c)
+			identities := mock_service.NewMockIdentityRepository(c)
+			hasher := mock_service.NewMockPasswordHasher(c)
+			audit := mock_service.NewMockAuditClient(c)
+			sessions := mock_service.NewMockSessionRepository(c)
+			testCase.mockBehavior(repo, identities, hasher, audit)
+
+			if testCase.wantErr == nil {
+				sessions.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			users := NewUsers(repo, hasher, sessions, audit, nil, []byte("secret"), time.Minute, time.Minute)
+			o := NewOIDC(users, identities, []OIDCProvider{{
+				Name:        "mock",
+				Issuer:      server.URL,
+				ClientID:    "client",
+				RedirectURL: "http://localhost:8080/auth/oidc/mock/callback",
+			}})
+
+			ctx := context.Background()
+
+			login, err := o.LoginURL(ctx, "mock")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.authorize(t, login.URL)
+
+			access, refresh, err := o.SignIn(ctx, "mock", testCase.code, login)
+			if testCase.wantErr != nil {
+				if !errors.Is(err, testCase.wantErr) {
+					t.Fatalf("expected %v, got %v", testCase.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if refresh == "" {
+				t.Fatal("expected a refresh token")
+			}
+
+			id, err := users.ParseToken(ctx, access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != user.ID {
+				t.Fatalf("expected the token for user %d, got %d", user.ID, id)
+			}
+		})
+	}
+}
+
+func TestOIDC_UnknownProvider(t *testing.T) {
+	o := NewOIDC(&Users{}, nil, nil)
+
+	if _, err := o.LoginURL(context.Background(), "nope"); !errors.Is(err, domain.ErrProviderNotFound) {
+		t.Fatalf("expected %v, got %v", domain.ErrProviderNotFound, err)
+	}
+}
+
+// newDiscoveryServer answers the discovery of a provider once ready says so, and fails it while down says so.
+func newDiscoveryServer(ready <-chan struct{}, down *atomic.Bool, requests *atomic.Int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(nil)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		<-ready
+		writeJSON(w, map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	server.Start()
+
+	return server
+}
+
+func TestOIDC_discovery(t *testing.T) {
+	var (
+		slowDown, flakyDown         atomic.Bool
+		slowRequests, flakyRequests atomic.Int32
+	)
+	ready := make(chan struct{})
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			close(ready)
+		}
+	}
+	defer release()
+
+	slow := newDiscoveryServer(ready, &slowDown, &slowRequests)
+	defer slow.Close()
+
+	up := make(chan struct{})
+	close(up)
+	flakyDown.Store(true)
+	flaky := newDiscoveryServer(up, &flakyDown, &flakyRequests)
+	defer flaky.Close()
+
+	o := NewOIDC(&Users{}, nil, []OIDCProvider{
+		{Name: "slow", Issuer: slow.URL, ClientID: "client"},
+		{Name: "flaky", Issuer: flaky.URL, ClientID: "client"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := o.LoginURL(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	slowLogin := make(chan error, 1)
+	go func() {
+		_, err := o.LoginURL(context.Background(), "slow")
+		slowLogin <- err
+	}()
+
+	// the other provider doesn't wait for the slow one
+	flakyLogin := make(chan error, 1)
+	go func() {
+		_, err := o.LoginURL(context.Background(), "flaky")
+		flakyLogin <- err
+	}()
+
+	select {
+	case err := <-flakyLogin:
+		if err == nil {
+			t.Fatal("expected the discovery to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the login waited for the discovery of another provider")
+	}
+
+	// the discovery went on after the first login gave up, the second one got it
+	release()
+	if err := <-slowLogin; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.LoginURL(context.Background(), "slow"); err != nil {
+		t.Fatal(err)
+	}
+	if n := slowRequests.Load(); n != 1 {
+		t.Fatalf("expected a single discovery, got %d", n)
+	}
+
+	// a failed discovery isn't kept, the next login tries again
+	flakyDown.Store(false)
+	if _, err := o.LoginURL(context.Background(), "flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if n := flakyRequests.Load(); n != 2 {
+		t.Fatalf("expected two discoveries, got %d", n)
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/sirupsen/logrus"
+)
+
+// OwnedBooks changes the books of a user along with their history, see BookStorage.
+type OwnedBooks interface {
+	GetByOwner(ctx context.Context, ownerID int64) ([]domain.Book, error)
+	DeleteByOwner(ctx context.Context, ownerID int64) ([]int64, error)
+	ReassignOwner(ctx context.Context, ownerID, newOwnerID int64) ([]int64, error)
+}
+
+type Privacy struct {
+	users       UserStorage
+	sessions    SessionRepository
+	identities  IdentityRepository
+	webhooks    WebhookRepository
+	importJobs  ImportJobRepository
+	books       OwnedBooks
+	transactor  Transactor
+	auditClient AuditClient
+
+	booksPolicy  string
+	reassignToID int64
+}
+
+func NewPrivacy(users UserStorage, sessions SessionRepository, identities IdentityRepository, webhooks WebhookRepository, importJobs ImportJobRepository,
+	books OwnedBooks, transactor Transactor, auditClient AuditClient, booksPolicy string, reassignToID int64) *Privacy {
+	return &Privacy{
+		users:        users,
+		sessions:     sessions,
+		identities:   identities,
+		webhooks:     webhooks,
+		importJobs:   importJobs,
+		books:        books,
+		transactor:   transactor,
+		auditClient:  auditClient,
+		booksPolicy:  booksPolicy,
+		reassignToID: reassignToID,
+	}
+}
+
+func (p *Privacy) Export(ctx context.Context, userID int64) (domain.UserExport, error) {
+	export := domain.UserExport{ExportedAt: time.Now()}
+
+	user, err := p.users.GetByID(ctx, userID)
+	if err != nil {
+		return export, err
+	}
+	export.Profile = user
+
+	if export.Sessions, err = p.sessions.ListByUser(ctx, userID); err != nil {
+		return export, err
+	}
+
+	if export.Books, err = p.books.GetByOwner(ctx, userID); err != nil {
+		return export, err
+	}
+
+	if export.Identities, err = p.identities.ListByUser(ctx, userID); err != nil {
+		return export, err
+	}
+
+	if export.Webhooks, err = p.webhooks.List(ctx, userID); err != nil {
+		return export, err
+	}
+
+	if export.ImportJobs, err = p.importJobs.ListByUser(ctx, userID); err != nil {
+		return export, err
+	}
+
+	export.Audit = append(export.Audit, domain.AuditReference{Entity: audit.ENTITY_USER, EntityID: userID})
+	for _, book := range export.Books {
+		export.Audit = append(export.Audit, domain.AuditReference{Entity: audit.ENTITY_BOOK, EntityID: book.ID})
+	}
+
+	return export, nil
+}
+
+// Erase anonymizes the user row instead of deleting it, so references from the audit log
+// stay resolvable. The steps run in a single transaction, a failed erasure leaves the user
+// as it was and can simply be repeated. The audit log hears of them once they are committed.
+func (p *Privacy) Erase(ctx context.Context, userID int64) (domain.ErasureReport, error) {
+	var (
+		report domain.ErasureReport
+		items  []audit.LogItem
+	)
+
+	err := inTransaction(ctx, p.transactor, func(ctx context.Context) error {
+		var err error
+		report, items, err = p.erase(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return domain.ErasureReport{UserID: userID, Policy: p.booksPolicy}, err
+	}
+
+	for _, item := range items {
+		p.sendAudit(ctx, item.Action, item.Entity, item.EntityID)
+	}
+
+	return report, nil
+}
+
+// erase runs the steps of Erase and returns the audit log items of what it changed.
+func (p *Privacy) erase(ctx context.Context, userID int64) (domain.ErasureReport, []audit.LogItem, error) {
+	report := domain.ErasureReport{
+		UserID: userID,
+		Policy: p.booksPolicy,
+	}
+
+	user, err := p.users.GetByID(ctx, userID)
+	if err != nil {
+		return report, nil, err
+	}
+
+	if err := p.sessions.DeleteByUser(ctx, userID); err != nil {
+		return report, nil, err
+	}
+
+	// the emails the providers gave, the webhooks with their secrets and the imports are
+	// personal data too
+	if err := p.identities.DeleteByUser(ctx, userID); err != nil {
+		return report, nil, err
+	}
+	if err := p.webhooks.DeleteByUser(ctx, userID); err != nil {
+		return report, nil, err
+	}
+	if err := p.importJobs.DeleteByUser(ctx, userID); err != nil {
+		return report, nil, err
+	}
+	items := []audit.LogItem{{Action: audit.ACTION_DELETE, Entity: audit.ENTITY_USER, EntityID: userID}}
+
+	switch p.booksPolicy {
+	case domain.ErasureDeleteBooks:
+		if report.DeletedBooks, err = p.books.DeleteByOwner(ctx, userID); err != nil {
+			return report, nil, err
+		}
+		for _, id := range report.DeletedBooks {
+			items = append(items, audit.LogItem{Action: audit.ACTION_DELETE, Entity: audit.ENTITY_BOOK, EntityID: id})
+		}
+	case domain.ErasureReassignBooks, domain.ErasureOrphanBooks:
+		if p.booksPolicy == domain.ErasureReassignBooks {
+			report.ReassignedTo = p.reassignToID
+		}
+
+		if report.ReassignedBooks, err = p.books.ReassignOwner(ctx, userID, report.ReassignedTo); err != nil {
+			return report, nil, err
+		}
+		for _, id := range report.ReassignedBooks {
+			items = append(items, audit.LogItem{Action: audit.ACTION_UPDATE, Entity: audit.ENTITY_BOOK, EntityID: id})
+		}
+	default:
+		return report, nil, fmt.Errorf("unknown erasure policy for books %q", p.booksPolicy)
+	}
+
+	password, err := newRefreshToken()
+	if err != nil {
+		return report, nil, err
+	}
+
+	user = domain.User{
+		ID:           user.ID,
+		Name:         "Deleted user",
+		Email:        fmt.Sprintf("deleted-%d@users.invalid", user.ID),
+		Password:     password,
+		RegisteredAt: user.RegisteredAt,
+		Role:         domain.RoleUser,
+		Disabled:     true,
+	}
+
+	if err := p.users.Update(ctx, user); err != nil {
+		return report, nil, err
+	}
+	items = append(items, audit.LogItem{Action: audit.ACTION_UPDATE, Entity: audit.ENTITY_USER, EntityID: userID})
+
+	return report, items, nil
+}
+
+func (p *Privacy) sendAudit(ctx context.Context, action, entity string, id int64) {
+	if err := p.auditClient.SendLogRequest(ctx, audit.LogItem{
+		Action:    action,
+		Entity:    entity,
+		EntityID:  id,
+		Timestamp: time.Now(),
+	}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": "Privacy.Erase",
+		}).Error("failed to send log request:", err)
+	}
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestPrivacy_Erase(t *testing.T) {
+	testTable := []struct {
+		name             string
+		policy           string
+		wantDeleted      []int64
+		wantReassigned   []int64
+		wantOwner        int64
+		wantBooksLeft    int
+		wantRevision     string
+		wantEventOwner   int64
+		wantAuditActions []string
+	}{
+		{
+			name:             "Delete books",
+			policy:           domain.ErasureDeleteBooks,
+			wantDeleted:      []int64{1, 2},
+			wantBooksLeft:    0,
+			wantRevision:     domain.RevisionDelete,
+			wantEventOwner:   1,
+			wantAuditActions: []string{audit.ACTION_DELETE, audit.ACTION_DELETE, audit.ACTION_DELETE, audit.ACTION_UPDATE},
+		},
+		{
+			name:             "Reassign books",
+			policy:           domain.ErasureReassignBooks,
+			wantReassigned:   []int64{1, 2},
+			wantOwner:        2,
+			wantBooksLeft:    2,
+			wantRevision:     domain.RevisionUpdate,
+			wantEventOwner:   2,
+			wantAuditActions: []string{audit.ACTION_DELETE, audit.ACTION_UPDATE, audit.ACTION_UPDATE, audit.ACTION_UPDATE},
+		},
+		{
+			name:             "Orphan books",
+			policy:           domain.ErasureOrphanBooks,
+			wantReassigned:   []int64{1, 2},
+			wantBooksLeft:    2,
+			wantRevision:     domain.RevisionUpdate,
+			wantAuditActions: []string{audit.ACTION_DELETE, audit.ACTION_UPDATE, audit.ACTION_UPDATE, audit.ACTION_UPDATE},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := memory.NewDB()
+			users, sessions, books, auditLog := memory.NewUserRepository(db), memory.NewTokens(db), memory.NewBookRepository(db), memory.NewAuditLog()
+			revisions := memory.NewBookRevisions(db)
+			storage := NewBooksStorage(books, revisions, memory.NewTransactor(db), DefaultBookRules)
+			ctx := context.Background()
+
+			users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"})
+			users.CreateUser(ctx, domain.User{Name: "Bob", Email: "bob@example.com", Password: "hash"})
+			sessions.Create(ctx, domain.RefreshSession{UserID: 1, Token: "token", ExpiresAt: time.Now().Add(time.Hour)})
+			for _, book := range []domain.Book{{Title: "First", OwnerID: 1}, {Title: "Second", OwnerID: 1}, {Title: "Third", OwnerID: 2}} {
+				books.Create(ctx, &book)
+			}
+
+			report, err := NewPrivacy(users, sessions, memory.NewIdentities(db), memory.NewWebhooks(db), memory.NewImportJobs(db), storage, memory.NewTransactor(db), auditLog, testCase.policy, 2).Erase(ctx, 1)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, report.DeletedBooks, testCase.wantDeleted)
+			assert.Equal(t, report.ReassignedBooks, testCase.wantReassigned)
+
+			user, _ := users.GetByID(ctx, 1)
+			assert.Equal(t, user.Name, "Deleted user")
+			assert.Equal(t, user.Disabled, true)
+
+			left, _ := sessions.ListByUser(ctx, 1)
+			assert.Equal(t, len(left), 0)
+
+			owned, _ := books.List(ctx, domain.BookFilter{}, 0, 10)
+			assert.Equal(t, len(owned), testCase.wantBooksLeft+1)
+			for _, book := range owned[:testCase.wantBooksLeft] {
+				assert.Equal(t, book.OwnerID, testCase.wantOwner)
+			}
+
+			actions := make([]string, 0)
+			for _, item := range auditLog.Items() {
+				actions = append(actions, item.Action)
+			}
+			assert.Equal(t, actions, testCase.wantAuditActions)
+
+			// the history and the event feed tell of the changed books
+			events, _ := revisions.Events(ctx, 0, 10)
+			changed := make([]int64, 0)
+			for _, event := range events {
+				assert.Equal(t, event.Action, testCase.wantRevision)
+				assert.Equal(t, event.Book.OwnerID, testCase.wantEventOwner)
+				changed = append(changed, event.Book.ID)
+			}
+			assert.Equal(t, changed, []int64{1, 2})
+		})
+	}
+}
+
+func TestPrivacy_Erase_failure(t *testing.T) {
+	testTable := []struct {
+		name       string
+		userID     int64
+		policy     string
+		reassignTo int64
+		wantErr    error
+	}{
+		{
+			name:    "Unknown user",
+			userID:  5,
+			policy:  domain.ErasureDeleteBooks,
+			wantErr: domain.ErrUserNotFound,
+		},
+		{
+			name:       "Reassigned to an unknown user",
+			userID:     1,
+			policy:     domain.ErasureReassignBooks,
+			reassignTo: 99,
+			wantErr:    domain.ErrUserNotFound,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := memory.NewDB()
+			users, sessions, books, auditLog := memory.NewUserRepository(db), memory.NewTokens(db), memory.NewBookRepository(db), memory.NewAuditLog()
+			ctx := context.Background()
+
+			users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"})
+			sessions.Create(ctx, domain.RefreshSession{UserID: 1, Token: "token", ExpiresAt: time.Now().Add(time.Hour)})
+			books.Create(ctx, &domain.Book{Title: "First", OwnerID: 1})
+
+			storage := NewBooksStorage(books, memory.NewBookRevisions(db), memory.NewTransactor(db), DefaultBookRules)
+			privacy := NewPrivacy(users, sessions, memory.NewIdentities(db), memory.NewWebhooks(db), memory.NewImportJobs(db), storage, memory.NewTransactor(db), auditLog, testCase.policy, testCase.reassignTo)
+
+			report, err := privacy.Erase(ctx, testCase.userID)
+			assert.Equal(t, err, testCase.wantErr)
+			assert.Equal(t, len(report.ReassignedBooks)+len(report.DeletedBooks), 0)
+
+			// nothing of a failed erasure is kept or reported
+			user, _ := users.GetByID(ctx, 1)
+			assert.Equal(t, user.Name, "Andy")
+			assert.Equal(t, user.Disabled, false)
+
+			left, _ := sessions.ListByUser(ctx, 1)
+			assert.Equal(t, len(left), 1)
+
+			owned, _ := books.GetByOwner(ctx, 1)
+			assert.Equal(t, len(owned), 1)
+			assert.Equal(t, owned[0].Version, int64(1))
+
+			assert.Equal(t, len(auditLog.Items()), 0)
+		})
+	}
+}
+
+func TestPrivacy_Export(t *testing.T) {
+	db := memory.NewDB()
+	users, sessions, books := memory.NewUserRepository(db), memory.NewTokens(db), memory.NewBookRepository(db)
+	ctx := context.Background()
+
+	users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"})
+	users.CreateUser(ctx, domain.User{Name: "Bob", Email: "bob@example.com", Password: "hash"})
+	sessions.Create(ctx, domain.RefreshSession{UserID: 1, Token: "token", ExpiresAt: time.Now().Add(time.Hour)})
+	for _, book := range []domain.Book{{Title: "First", OwnerID: 1}, {Title: "Second", OwnerID: 2}} {
+		books.Create(ctx, &book)
+	}
+
+	storage := NewBooksStorage(books, memory.NewBookRevisions(db), memory.NewTransactor(db), DefaultBookRules)
+	privacy := NewPrivacy(users, sessions, memory.NewIdentities(db), memory.NewWebhooks(db), memory.NewImportJobs(db), storage, memory.NewTransactor(db), memory.NewAuditLog(), domain.ErasureOrphanBooks, 0)
+
+	export, err := privacy.Export(ctx, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, export.Profile.Name, "Andy")
+	assert.Equal(t, len(export.Sessions), 1)
+	assert.Equal(t, len(export.Books), 1)
+	assert.Equal(t, export.Books[0].Title, "First")
+	assert.Equal(t, export.Audit, []domain.AuditReference{
+		{Entity: audit.ENTITY_USER, EntityID: 1},
+		{Entity: audit.ENTITY_BOOK, EntityID: 1},
+	})
+
+	_, err = privacy.Export(ctx, 5)
+	assert.Equal(t, err, domain.ErrUserNotFound)
+}
+
+func TestPrivacy_Erase_personalData(t *testing.T) {
+	db := memory.NewDB()
+	users, sessions, identities, webhooks, importJobs := memory.NewUserRepository(db), memory.NewTokens(db), memory.NewIdentities(db), memory.NewWebhooks(db), memory.NewImportJobs(db)
+	storage := NewBooksStorage(memory.NewBookRepository(db), memory.NewBookRevisions(db), memory.NewTransactor(db), DefaultBookRules)
+	ctx := context.Background()
+
+	users.CreateUser(ctx, domain.User{Name: "Andy", Email: "andy@example.com", Password: "hash"})
+	identities.Create(ctx, domain.UserIdentity{UserID: 1, Provider: "google", Subject: "subject", Email: "andy@gmail.example", CreatedAt: time.Now()})
+	webhooks.Create(ctx, &domain.Webhook{UserID: 1, URL: "https://andy.example/hook", Events: []string{domain.EventBookCreated}, Secret: "0123456789abcdef"})
+	importJobs.Create(ctx, &domain.ImportJob{UserID: 1, Format: "csv", Status: domain.ImportRunning, CreatedAt: time.Now()})
+
+	privacy := NewPrivacy(users, sessions, identities, webhooks, importJobs, storage, memory.NewTransactor(db), memory.NewAuditLog(), domain.ErasureDeleteBooks, 0)
+
+	export, err := privacy.Export(ctx, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(export.Identities), 1)
+	assert.Equal(t, len(export.Webhooks), 1)
+	assert.Equal(t, len(export.ImportJobs), 1)
+
+	_, err = privacy.Erase(ctx, 1)
+	assert.Equal(t, err, nil)
+
+	export, err = privacy.Export(ctx, 1)
+	assert.Equal(t, err, nil)
+
+	data, _ := json.Marshal(export)
+	for _, left := range []string{"andy@example.com", "andy@gmail.example", "andy.example"} {
+		if strings.Contains(string(data), left) {
+			t.Errorf("%s is left after the erasure: %s", left, data)
+		}
+	}
+	assert.Equal(t, len(export.ImportJobs), 0)
+
+	// the provider no longer signs anyone in
+	_, err = identities.Get(ctx, "google", "subject")
+	assert.Equal(t, err, domain.ErrIdentityNotFound)
+}
@@ -0,0 +1,41 @@
+package service
+
+import "context"
+
+// Transactor runs fn in a transaction of the repositories. They take part in it when they
+// are called with the context fn gets, and their changes are only kept when fn succeeds.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type commitHooksKey struct{}
+
+// inTransaction runs fn in a transaction of transactor. The hooks fn hands to afterCommit
+// run with ctx once the outermost transaction is committed, and not at all when it fails.
+func inTransaction(ctx context.Context, transactor Transactor, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(commitHooksKey{}).(*[]func(context.Context)); ok {
+		return transactor.WithinTransaction(ctx, fn)
+	}
+
+	var hooks []func(context.Context)
+	if err := transactor.WithinTransaction(context.WithValue(ctx, commitHooksKey{}, &hooks), fn); err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+
+	return nil
+}
+
+// afterCommit runs hook once the transaction of ctx is committed, or right away outside of
+// one. The hook gets a context without the transaction.
+func afterCommit(ctx context.Context, hook func(ctx context.Context)) {
+	if hooks, ok := ctx.Value(commitHooksKey{}).(*[]func(context.Context)); ok {
+		*hooks = append(*hooks, hook)
+		return
+	}
+
+	hook(ctx)
+}
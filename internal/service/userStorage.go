@@ -2,10 +2,9 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"crypto/rand"
 	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"time"
 
@@ -21,39 +20,95 @@ type AuditClient interface {
 	SendLogRequest(ctx context.Context, req audit.LogItem) error
 }
 
+const (
+	emailTokenTTL = time.Hour * 24
+	resetTokenTTL = time.Hour * 24
+	// eventsTokenTTL is short, unlike headers the query of a feed ends up in logs
+	eventsTokenTTL = time.Minute
+)
+
+// accessClaims are the claims of our access tokens, the OAuth2 fields stay empty for our own sessions.
+type accessClaims struct {
+	jwt.StandardClaims
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
 type Users struct {
 	Repo        UserStorage
 	Hasher      PasswordHasher
 	SessionRepo SessionRepository
 	AuditClient AuditClient
+	Mailer      Mailer
 
-	HmacSecret []byte
-	TokenTtl   time.Duration
+	// Webhooks, when set, is told about the users who sign up
+	Webhooks WebhookPublisher
+
+	HmacSecret       []byte
+	TokenTtl         time.Duration
+	ImpersonationTtl time.Duration
 }
 
 type PasswordHasher interface {
 	Hash(password string) (string, error)
 }
 
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
 type UserStorage interface {
 	CreateUser(ctx context.Context, inp domain.User) error
 	GetByCredential(ctx context.Context, email string, passwords string) (domain.User, error)
+	GetByID(ctx context.Context, id int64) (domain.User, error)
+	GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error)
+	GetByEmail(ctx context.Context, email string) (domain.User, error)
+	GetByEmailToken(ctx context.Context, token string) (domain.User, error)
+	GetByResetToken(ctx context.Context, token string) (domain.User, error)
+	List(ctx context.Context, filter domain.UserFilter) (domain.UserList, error)
+	Update(ctx context.Context, user domain.User) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type IdentityRepository interface {
+	Create(ctx context.Context, identity domain.UserIdentity) error
+	Get(ctx context.Context, provider, subject string) (domain.UserIdentity, error)
+	ListByUser(ctx context.Context, userID int64) ([]domain.UserIdentity, error)
+	DeleteByUser(ctx context.Context, userID int64) error
+}
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *domain.OAuthClient) error
+	GetByClientID(ctx context.Context, clientID string) (domain.OAuthClient, error)
+	List(ctx context.Context) ([]domain.OAuthClient, error)
+	Delete(ctx context.Context, clientID string) error
+}
+
+type AuthorizationCodeRepository interface {
+	Create(ctx context.Context, code domain.AuthorizationCode) error
+	Consume(ctx context.Context, code string) (domain.AuthorizationCode, error)
 }
 
 type SessionRepository interface {
 	Create(ctx context.Context, token domain.RefreshSession) error
 	Get(ctx context.Context, token string) (domain.RefreshSession, error)
+	Find(ctx context.Context, token string) (domain.RefreshSession, error)
+	Consume(ctx context.Context, token string) (domain.RefreshSession, error)
+	ListByUser(ctx context.Context, userID int64) ([]domain.RefreshSession, error)
+	DeleteByUser(ctx context.Context, userID int64) error
 }
 
 // также добавляем новое поле в NewUsers
-func NewUsers(repo UserStorage, hasher PasswordHasher, sessionRepo SessionRepository, auditClient AuditClient, secret []byte, ttl time.Duration) *Users {
+func NewUsers(repo UserStorage, hasher PasswordHasher, sessionRepo SessionRepository, auditClient AuditClient, mailer Mailer, secret []byte, ttl, impersonationTTL time.Duration) *Users {
 	return &Users{
-		Repo:        repo,
-		Hasher:      hasher,
-		HmacSecret:  secret,
-		TokenTtl:    ttl,
-		SessionRepo: sessionRepo,
-		AuditClient: auditClient,
+		Repo:             repo,
+		Hasher:           hasher,
+		HmacSecret:       secret,
+		TokenTtl:         ttl,
+		ImpersonationTtl: impersonationTTL,
+		SessionRepo:      sessionRepo,
+		AuditClient:      auditClient,
+		Mailer:           mailer,
 	}
 
 }
@@ -64,9 +119,11 @@ func (u *Users) SignUp(ctx context.Context, inp domain.SignUpInput) error {
 		return err
 	}
 
+	email := domain.NormalizeEmail(inp.Email)
+
 	user := domain.User{
 		Name:         inp.Name,
-		Email:        inp.Email,
+		Email:        email,
 		Password:     password,
 		RegisteredAt: time.Now(),
 	}
@@ -75,11 +132,16 @@ func (u *Users) SignUp(ctx context.Context, inp domain.SignUpInput) error {
 		return err
 	}
 
-	user, err = u.Repo.GetByCredential(ctx, inp.Email, password)
+	user, err = u.Repo.GetByCredential(ctx, email, password)
 	if err != nil {
 		return err
 	}
 
+	if u.Webhooks != nil {
+		// the email stays private, the subscribers only learn who signed up
+		u.Webhooks.Publish(ctx, domain.EventUserSignedUp, map[string]interface{}{"id": user.ID, "name": user.Name})
+	}
+
 	if err := u.AuditClient.SendLogRequest(ctx, audit.LogItem{
 		Action:    audit.ACTION_REGISTER,
 		Entity:    audit.ENTITY_USER,
@@ -99,11 +161,12 @@ func (u *Users) SignIn(ctx context.Context, inp domain.SignInInput) (string, str
 		return "", "", err
 	}
 
-	getIt, err := u.Repo.GetByCredential(ctx, inp.Email, password)
+	getIt, err := u.Repo.GetByCredential(ctx, domain.NormalizeEmail(inp.Email), password)
 	if err != nil {
-		if errors.Is(sql.ErrNoRows, err) {
-			return "", "", domain.ErrUserNotFound
-		}
+		return "", "", err
+	}
+
+	if err := checkCanSignIn(getIt); err != nil {
 		return "", "", err
 	}
 
@@ -111,53 +174,75 @@ func (u *Users) SignIn(ctx context.Context, inp domain.SignInInput) (string, str
 }
 
 func (u *Users) ParseToken(ctx context.Context, token string) (int64, error) {
-	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
+	access, err := u.ParseAccessToken(ctx, token)
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return u.HmacSecret, nil
-	})
+	return access.UserID, err
+}
+
+// ParseAccessToken also tells the OAuth2 client the token was issued to and its scope.
+func (u *Users) ParseAccessToken(ctx context.Context, token string) (domain.AccessToken, error) {
+	var claims accessClaims
 
+	t, err := jwt.ParseWithClaims(token, &claims, u.keyFunc)
 	if err != nil {
-		return 0, err
+		return domain.AccessToken{}, err
 	}
 
 	if !t.Valid {
-		return 0, errors.New("invalid token")
+		return domain.AccessToken{}, errors.New("invalid token")
 	}
 
-	claims, ok := t.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errors.New("invalid claims")
-	}
-
-	subject := claims["sub"].(string)
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
 	if err != nil {
-		return 0, errors.New("invalid subject")
+		return domain.AccessToken{}, errors.New("invalid subject")
 	}
 
-	id, err := strconv.Atoi(subject)
-	if err != nil {
-		return 0, errors.New("invalid subject")
-	}
+	return domain.AccessToken{UserID: id, ClientID: claims.ClientID, Scope: claims.Scope, Audience: claims.Audience}, nil
+}
+
+// EventsToken issues a token that only opens the feeds of the book events. Browsers can't
+// send headers with EventSource and WebSocket, they pass it in the query or a cookie.
+func (u *Users) EventsToken(ctx context.Context, userID int64) (domain.EventsToken, error) {
+	now := time.Now()
+	token := domain.EventsToken{ExpiresAt: now.Add(eventsTokenTTL)}
+
+	var err error
+	token.Token, err = u.signToken(accessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  domain.AudienceBookEvents,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: token.ExpiresAt.Unix(),
+			Subject:   strconv.FormatInt(userID, 10),
+		},
+	})
 
-	return int64(id), nil
+	return token, err
 }
 
 func (u *Users) generateTokens(ctx context.Context, userID int64) (string, string, error) {
-	notSigned := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: time.Now().Add(u.TokenTtl).Unix(),
-		Subject:   strconv.Itoa(int(userID)),
-	})
+	return u.issueTokens(ctx, strconv.FormatInt(userID, 10), userID, "", "")
+}
 
-	accessToken, err := notSigned.SignedString(u.HmacSecret)
+// issueTokens signs an access token for the subject and opens a refresh session for userID,
+// no session is opened when userID is 0. clientID and scope are only set for OAuth2 clients.
+func (u *Users) issueTokens(ctx context.Context, subject string, userID int64, clientID, scope string) (string, string, error) {
+	accessToken, err := u.signToken(accessClaims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(u.TokenTtl).Unix(),
+			Subject:   subject,
+		},
+		ClientID: clientID,
+		Scope:    scope,
+	})
 	if err != nil {
 		return "", "", err
 	}
 
+	if userID == 0 {
+		return accessToken, "", nil
+	}
+
 	refreshToken, err := newRefreshToken()
 	if err != nil {
 		return "", "", err
@@ -167,6 +252,8 @@ func (u *Users) generateTokens(ctx context.Context, userID int64) (string, strin
 		UserID:    userID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().Add(time.Hour * 24 * 30),
+		ClientID:  clientID,
+		Scope:     scope,
 	}
 
 	err = u.SessionRepo.Create(ctx, session)
@@ -177,13 +264,35 @@ func (u *Users) generateTokens(ctx context.Context, userID int64) (string, strin
 	return accessToken, refreshToken, nil
 }
 
+func (u *Users) keyFunc(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
+	return u.HmacSecret, nil
+}
+
+func (u *Users) signToken(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(u.HmacSecret)
+}
+
+func checkCanSignIn(user domain.User) error {
+	if user.Disabled {
+		return domain.ErrUserDisabled
+	}
+
+	if user.PasswordResetRequired {
+		return domain.ErrPasswordResetNeeded
+	}
+
+	return nil
+}
+
 func newRefreshToken() (string, error) {
 	refresh := make([]byte, 32)
 
-	newSourse := rand.NewSource(time.Now().Unix())
-	r := rand.New(newSourse)
-
-	_, err := r.Read(refresh)
+	_, err := rand.Read(refresh)
 	if err != nil {
 		return "", err
 	}
@@ -201,5 +310,163 @@ func (u *Users) RefreshTokens(ctx context.Context, refreshToken string) (string,
 		return "", "", domain.ErrRefreshTokenExpired
 	}
 
+	user, err := u.Repo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := checkCanSignIn(user); err != nil {
+		return "", "", err
+	}
+
 	return u.generateTokens(ctx, session.UserID)
 }
+
+func (u *Users) GetByID(ctx context.Context, id int64) (domain.User, error) {
+	return u.Repo.GetByID(ctx, id)
+}
+
+// GetByIDs reads several users at once, in no particular order. Unknown IDs are skipped.
+func (u *Users) GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error) {
+	return u.Repo.GetByIDs(ctx, ids)
+}
+
+// Update changes the profile right away, a new email only becomes active after ConfirmEmail.
+func (u *Users) Update(ctx context.Context, id int64, inp domain.UpdateUserInput) (domain.User, error) {
+	user, err := u.Repo.GetByID(ctx, id)
+	if err != nil {
+		return user, err
+	}
+
+	if inp.Name != nil {
+		user.Name = *inp.Name
+	}
+
+	sendConfirmation := false
+	if inp.Email != nil {
+		email := domain.NormalizeEmail(*inp.Email)
+
+		if email == user.Email {
+			user.PendingEmail, user.EmailToken, user.EmailTokenExpiresAt = "", "", time.Time{}
+		} else {
+			token, err := newRefreshToken()
+			if err != nil {
+				return user, err
+			}
+
+			user.PendingEmail = email
+			user.EmailToken = token
+			user.EmailTokenExpiresAt = time.Now().Add(emailTokenTTL)
+			sendConfirmation = true
+		}
+	}
+
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return user, err
+	}
+
+	if sendConfirmation {
+		body := fmt.Sprintf("Use this token to confirm your new email address: %s", user.EmailToken)
+		if err := u.Mailer.Send(ctx, user.PendingEmail, "Confirm your email", body); err != nil {
+			return user, err
+		}
+	}
+
+	u.sendAudit(ctx, "User.Update", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: user.ID,
+	})
+
+	return user, nil
+}
+
+func (u *Users) ConfirmEmail(ctx context.Context, token string) error {
+	user, err := u.Repo.GetByEmailToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return domain.ErrEmailTokenInvalid
+		}
+		return err
+	}
+
+	if user.EmailTokenExpiresAt.Before(time.Now()) {
+		return domain.ErrEmailTokenInvalid
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail, user.EmailToken, user.EmailTokenExpiresAt = "", "", time.Time{}
+
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	u.sendAudit(ctx, "User.ConfirmEmail", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: user.ID,
+	})
+
+	return nil
+}
+
+func (u *Users) ResetPassword(ctx context.Context, inp domain.ResetPasswordInput) error {
+	user, err := u.Repo.GetByResetToken(ctx, inp.Token)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return domain.ErrResetTokenInvalid
+		}
+		return err
+	}
+
+	if user.ResetTokenExpiresAt.Before(time.Now()) {
+		return domain.ErrResetTokenInvalid
+	}
+
+	password, err := u.Hasher.Hash(inp.Password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = password
+	user.PasswordResetRequired, user.ResetToken, user.ResetTokenExpiresAt = false, "", time.Time{}
+
+	if err := u.Repo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	u.sendAudit(ctx, "User.ResetPassword", audit.LogItem{
+		Action:   audit.ACTION_UPDATE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: user.ID,
+	})
+
+	return nil
+}
+
+func (u *Users) Delete(ctx context.Context, id int64) error {
+	if err := u.Repo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	u.sendAudit(ctx, "User.Delete", audit.LogItem{
+		Action:   audit.ACTION_DELETE,
+		Entity:   audit.ENTITY_USER,
+		EntityID: id,
+	})
+
+	return nil
+}
+
+// sendAudit only logs failures, the audit server being down must not break user requests.
+// The audit client takes the user who made the change from ctx, see domain.WithActor.
+func (u *Users) sendAudit(ctx context.Context, method string, item audit.LogItem) {
+	item.Timestamp = time.Now()
+
+	if err := u.AuditClient.SendLogRequest(ctx, item); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method":   method,
+			"actor_id": domain.ActorFromContext(ctx),
+		}).Error("failed to send log request:", err)
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// The headers of a delivery besides its signature.
+	webhookEventHeader    = "X-Webhook-Event"
+	webhookDeliveryHeader = "X-Webhook-Delivery"
+
+	// deliveryBatch is how many deliveries the worker claims at a time.
+	deliveryBatch = 50
+	// deliverySenders is how many deliveries of a batch are sent at once.
+	deliverySenders = 10
+	// leaseMargin is added to the time a batch takes at most, for reading the webhooks and
+	// recording the attempts.
+	leaseMargin = time.Minute
+	// defaultSendTimeout bounds an attempt when the client has no timeout of its own.
+	defaultSendTimeout = 30 * time.Second
+	// deliveriesShown is how many of the last deliveries of a webhook are listed.
+	deliveriesShown = 100
+
+	defaultRetryDelay    = 30 * time.Second
+	maxRetryDelay        = time.Hour
+	defaultMaxAttempts   = 8
+	maxResponseBodyBytes = 64 << 10
+)
+
+// WebhookPublisher is told about the events webhooks may subscribe to.
+type WebhookPublisher interface {
+	Publish(ctx context.Context, event string, data interface{})
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, hook *domain.Webhook) error
+	List(ctx context.Context, userID int64) ([]domain.Webhook, error)
+	Get(ctx context.Context, id int64) (domain.Webhook, error)
+	Delete(ctx context.Context, userID, id int64) error
+	DeleteByUser(ctx context.Context, userID int64) error
+	Subscribed(ctx context.Context, event string) ([]domain.Webhook, error)
+}
+
+type WebhookDeliveryRepository interface {
+	Create(ctx context.Context, delivery *domain.WebhookDelivery) error
+	List(ctx context.Context, webhookID int64, limit int) ([]domain.WebhookDelivery, error)
+	Get(ctx context.Context, id int64) (domain.WebhookDelivery, error)
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, deliveryID int64, attempt domain.WebhookAttempt, status string, nextAttemptAt *time.Time) error
+}
+
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, id int64) (bool, error)
+}
+
+// Webhooks queues a delivery for every webhook subscribed to an event and sends them in
+// the background, retrying a failed delivery with an exponential backoff.
+type Webhooks struct {
+	hooks      WebhookRepository
+	deliveries WebhookDeliveryRepository
+	admins     AdminChecker
+	client     *http.Client
+
+	maxAttempts int
+	// retryDelay is the wait after the first failed attempt, it doubles with every other one
+	retryDelay time.Duration
+
+	wake chan struct{}
+}
+
+func NewWebhooks(hooks WebhookRepository, deliveries WebhookDeliveryRepository, admins AdminChecker, client *http.Client, maxAttempts int) *Webhooks {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Webhooks{
+		hooks:       hooks,
+		deliveries:  deliveries,
+		admins:      admins,
+		client:      client,
+		maxAttempts: maxAttempts,
+		retryDelay:  defaultRetryDelay,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Create registers a webhook of the user. The user events are only for admins.
+func (w *Webhooks) Create(ctx context.Context, userID int64, inp domain.CreateWebhookInput) (domain.Webhook, error) {
+	for _, event := range inp.Events {
+		if event != domain.EventUserSignedUp {
+			continue
+		}
+
+		admin, err := w.admins.IsAdmin(ctx, userID)
+		if err != nil {
+			return domain.Webhook{}, err
+		}
+		if !admin {
+			return domain.Webhook{}, fmt.Errorf("%w: only admins can subscribe to %s", domain.ErrForbidden, event)
+		}
+	}
+
+	hook := domain.Webhook{
+		UserID:    userID,
+		URL:       inp.URL,
+		Events:    inp.Events,
+		Secret:    inp.Secret,
+		CreatedAt: time.Now(),
+	}
+
+	err := w.hooks.Create(ctx, &hook)
+
+	return hook, err
+}
+
+func (w *Webhooks) List(ctx context.Context, userID int64) ([]domain.Webhook, error) {
+	return w.hooks.List(ctx, userID)
+}
+
+func (w *Webhooks) Delete(ctx context.Context, userID, id int64) error {
+	return w.hooks.Delete(ctx, userID, id)
+}
+
+// Deliveries returns the last deliveries of a webhook of the user, the newest first.
+func (w *Webhooks) Deliveries(ctx context.Context, userID, webhookID int64) ([]domain.WebhookDelivery, error) {
+	if _, err := w.userHook(ctx, userID, webhookID); err != nil {
+		return nil, err
+	}
+
+	return w.deliveries.List(ctx, webhookID, deliveriesShown)
+}
+
+// Redeliver queues the payload of a past delivery once more, as a delivery of its own.
+func (w *Webhooks) Redeliver(ctx context.Context, userID, webhookID, deliveryID int64) (domain.WebhookDelivery, error) {
+	if _, err := w.userHook(ctx, userID, webhookID); err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	past, err := w.deliveries.Get(ctx, deliveryID)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+	if past.WebhookID != webhookID {
+		return domain.WebhookDelivery{}, domain.ErrDeliveryNotFound
+	}
+
+	delivery, err := w.queue(ctx, webhookID, past.Event, past.Payload)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	w.notify()
+
+	return delivery, nil
+}
+
+func (w *Webhooks) userHook(ctx context.Context, userID, id int64) (domain.Webhook, error) {
+	hook, err := w.hooks.Get(ctx, id)
+	if err != nil {
+		return hook, err
+	}
+
+	if hook.UserID != userID {
+		return domain.Webhook{}, domain.ErrWebhookNotFound
+	}
+
+	return hook, nil
+}
+
+// Publish queues a delivery of the event for every webhook subscribed to it. A failure is
+// only logged, the change that caused the event has already been made.
+func (w *Webhooks) Publish(ctx context.Context, event string, data interface{}) {
+	if err := w.publish(ctx, event, data); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": "Webhooks.Publish",
+			"event":  event,
+		}).Error("failed to queue the deliveries:", err)
+	}
+}
+
+func (w *Webhooks) publish(ctx context.Context, event string, data interface{}) error {
+	hooks, err := w.hooks.Subscribed(ctx, event)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(domain.WebhookPayload{Event: event, CreatedAt: time.Now(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if _, err := w.queue(ctx, hook.ID, event, payload); err != nil {
+			return err
+		}
+	}
+
+	w.notify()
+
+	return nil
+}
+
+func (w *Webhooks) queue(ctx context.Context, webhookID int64, event string, payload json.RawMessage) (domain.WebhookDelivery, error) {
+	now := time.Now()
+
+	delivery := domain.WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		Status:        domain.DeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		Attempts:      make([]domain.WebhookAttempt, 0),
+	}
+
+	err := w.deliveries.Create(ctx, &delivery)
+
+	return delivery, err
+}
+
+// notify wakes the worker up without waiting for it.
+func (w *Webhooks) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the deliveries that are due until ctx is done. It looks for them every
+// interval, and right away when a delivery is queued by this process.
+func (w *Webhooks) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+func (w *Webhooks) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := w.deliveries.Claim(ctx, time.Now(), w.lease(), deliveryBatch)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"method": "Webhooks.Run",
+			}).Error("failed to claim the deliveries:", err)
+			return
+		}
+
+		// a slow receiver holds up its own deliveries, not the whole batch
+		senders := make(chan struct{}, deliverySenders)
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			senders <- struct{}{}
+			wg.Add(1)
+
+			go func(delivery domain.WebhookDelivery) {
+				defer wg.Done()
+				defer func() { <-senders }()
+
+				w.deliver(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < deliveryBatch {
+			return
+		}
+	}
+}
+
+func (w *Webhooks) deliver(ctx context.Context, delivery domain.WebhookDelivery) {
+	hook, err := w.hooks.Get(ctx, delivery.WebhookID)
+	if err != nil {
+		// a webhook deleted in the meantime takes its deliveries with it
+		logrus.WithFields(logrus.Fields{
+			"method":   "Webhooks.deliver",
+			"delivery": delivery.ID,
+		}).Error("failed to read the webhook:", err)
+		return
+	}
+
+	attempt := w.send(ctx, hook, delivery)
+	attempts := delivery.AttemptCount + 1
+
+	status, next := domain.DeliverySucceeded, (*time.Time)(nil)
+	if attempt.Error != "" {
+		status = domain.DeliveryFailed
+		if attempts < w.maxAttempts {
+			at := time.Now().Add(w.backoff(attempts))
+			status, next = domain.DeliveryPending, &at
+		}
+	}
+
+	if err := w.deliveries.RecordAttempt(ctx, delivery.ID, attempt, status, next); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method":   "Webhooks.deliver",
+			"delivery": delivery.ID,
+		}).Error("failed to record the attempt:", err)
+	}
+}
+
+// lease holds the claimed deliveries back from other workers until the batch is sent for
+// sure, every attempt timing out included.
+func (w *Webhooks) lease() time.Duration {
+	rounds := (deliveryBatch + deliverySenders - 1) / deliverySenders
+
+	return time.Duration(rounds)*w.sendTimeout() + leaseMargin
+}
+
+func (w *Webhooks) sendTimeout() time.Duration {
+	if w.client.Timeout > 0 {
+		return w.client.Timeout
+	}
+
+	return defaultSendTimeout
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	delay := w.retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	return delay
+}
+
+// send makes a single attempt, anything but a 2xx response is a failure.
+func (w *Webhooks) send(ctx context.Context, hook domain.Webhook, delivery domain.WebhookDelivery) domain.WebhookAttempt {
+	started := time.Now()
+	attempt := domain.WebhookAttempt{CreatedAt: started}
+
+	// the lease counts on it
+	ctx, cancel := context.WithTimeout(ctx, w.sendTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	timestamp := started.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crud_service-webhooks")
+	req.Header.Set(webhookEventHeader, delivery.Event)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	attempt.DurationMS = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	// the body isn't kept, reading it lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBodyBytes))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("the receiver answered %s", resp.Status)
+	}
+
+	return attempt
+}
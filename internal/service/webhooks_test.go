@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/webhook"
+	"github.com/magiconair/properties/assert"
+)
+
+type fakeWebhooks struct {
+	WebhookRepository
+
+	hooks []domain.Webhook
+}
+
+func (f *fakeWebhooks) Create(ctx context.Context, hook *domain.Webhook) error {
+	hook.ID = int64(len(f.hooks) + 1)
+	f.hooks = append(f.hooks, *hook)
+
+	return nil
+}
+
+func (f *fakeWebhooks) List(ctx context.Context, userID int64) ([]domain.Webhook, error) {
+	hooks := make([]domain.Webhook, 0)
+	for _, hook := range f.hooks {
+		if hook.UserID == userID {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks, nil
+}
+
+func (f *fakeWebhooks) Get(ctx context.Context, id int64) (domain.Webhook, error) {
+	for _, hook := range f.hooks {
+		if hook.ID == id {
+			return hook, nil
+		}
+	}
+
+	return domain.Webhook{}, domain.ErrWebhookNotFound
+}
+
+func (f *fakeWebhooks) Delete(ctx context.Context, userID, id int64) error {
+	return nil
+}
+
+func (f *fakeWebhooks) Subscribed(ctx context.Context, event string) ([]domain.Webhook, error) {
+	hooks := make([]domain.Webhook, 0)
+	for _, hook := range f.hooks {
+		if contains(hook.Events, event) {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks, nil
+}
+
+type fakeDeliveries struct {
+	mu         sync.Mutex
+	deliveries []domain.WebhookDelivery
+}
+
+func (f *fakeDeliveries) Create(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delivery.ID = int64(len(f.deliveries) + 1)
+	f.deliveries = append(f.deliveries, *delivery)
+
+	return nil
+}
+
+func (f *fakeDeliveries) List(ctx context.Context, webhookID int64, limit int) ([]domain.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (f *fakeDeliveries) Get(ctx context.Context, id int64) (domain.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id < 1 || int(id) > len(f.deliveries) {
+		return domain.WebhookDelivery{}, domain.ErrDeliveryNotFound
+	}
+
+	return f.deliveries[id-1], nil
+}
+
+func (f *fakeDeliveries) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	claimed := make([]domain.WebhookDelivery, 0)
+	for i, delivery := range f.deliveries {
+		if delivery.Status == domain.DeliveryPending && !delivery.NextAttemptAt.After(now) && len(claimed) < limit {
+			leased := now.Add(lease)
+			f.deliveries[i].NextAttemptAt = &leased
+			claimed = append(claimed, f.deliveries[i])
+		}
+	}
+
+	return claimed, nil
+}
+
+func (f *fakeDeliveries) RecordAttempt(ctx context.Context, deliveryID int64, attempt domain.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delivery := &f.deliveries[deliveryID-1]
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.AttemptCount++
+	delivery.Status, delivery.NextAttemptAt = status, nextAttemptAt
+
+	return nil
+}
+
+type fakeAdmins map[int64]bool
+
+func (f fakeAdmins) IsAdmin(ctx context.Context, id int64) (bool, error) {
+	return f[id], nil
+}
+
+// receiver checks the signature of every delivery and answers with the next status.
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	payloads []domain.WebhookPayload
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	err := webhook.Verify("0123456789abcdef", req.Header.Get(webhook.TimestampHeader), req.Header.Get(webhook.SignatureHeader), body, time.Minute)
+	if err != nil {
+		r.t.Errorf("the signature doesn't verify: %v", err)
+	}
+
+	var payload domain.WebhookPayload
+	json.Unmarshal(body, &payload)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.payloads = append(r.payloads, payload)
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+
+	w.WriteHeader(status)
+}
+
+func newTestWebhooks(t *testing.T, statuses []int, maxAttempts int) (*Webhooks, *fakeDeliveries, *receiver) {
+	recv := &receiver{t: t, statuses: statuses}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	deliveries := &fakeDeliveries{}
+	hooks := &fakeWebhooks{hooks: []domain.Webhook{
+		{ID: 1, UserID: 1, URL: server.URL, Events: []string{domain.EventBookCreated}, Secret: "0123456789abcdef"},
+	}}
+
+	w := NewWebhooks(hooks, deliveries, fakeAdmins{}, webhook.NewClient(time.Second, true), maxAttempts)
+	// the retries are due right away
+	w.retryDelay = 0
+
+	return w, deliveries, recv
+}
+
+func TestWebhooks_deliver(t *testing.T) {
+	testTable := []struct {
+		name           string
+		statuses       []int
+		maxAttempts    int
+		runs           int
+		expectedStatus string
+		expectedCodes  []int
+	}{
+		{
+			name:           "OK",
+			runs:           1,
+			expectedStatus: domain.DeliverySucceeded,
+			expectedCodes:  []int{http.StatusNoContent},
+		},
+		{
+			name:           "Retried",
+			statuses:       []int{http.StatusInternalServerError, http.StatusBadGateway},
+			runs:           3,
+			expectedStatus: domain.DeliverySucceeded,
+			expectedCodes:  []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent},
+		},
+		{
+			name:           "Out of attempts",
+			statuses:       []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			maxAttempts:    2,
+			runs:           3,
+			expectedStatus: domain.DeliveryFailed,
+			expectedCodes:  []int{http.StatusInternalServerError, http.StatusInternalServerError},
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			w, deliveries, recv := newTestWebhooks(t, testCase.statuses, testCase.maxAttempts)
+
+			w.Publish(context.Background(), domain.EventBookCreated, domain.Book{ID: 1, Title: "Title"})
+			// nobody subscribed to it
+			w.Publish(context.Background(), domain.EventBookDeleted, domain.Book{ID: 1})
+
+			for i := 0; i < testCase.runs; i++ {
+				w.deliverDue(context.Background())
+			}
+
+			assert.Equal(t, len(deliveries.deliveries), 1)
+
+			delivery := deliveries.deliveries[0]
+			assert.Equal(t, delivery.Status, testCase.expectedStatus)
+
+			codes := make([]int, 0)
+			for _, attempt := range delivery.Attempts {
+				codes = append(codes, attempt.StatusCode)
+			}
+			assert.Equal(t, codes, testCase.expectedCodes)
+			assert.Equal(t, recv.payloads[0].Event, domain.EventBookCreated)
+
+			assert.Equal(t, delivery.NextAttemptAt, (*time.Time)(nil))
+		})
+	}
+}
+
+func TestWebhooks_Redeliver(t *testing.T) {
+	w, deliveries, recv := newTestWebhooks(t, nil, 0)
+
+	w.Publish(context.Background(), domain.EventBookCreated, domain.Book{ID: 1})
+	w.deliverDue(context.Background())
+
+	_, err := w.Redeliver(context.Background(), 2, 1, 1)
+	assert.Equal(t, errors.Is(err, domain.ErrWebhookNotFound), true)
+
+	delivery, err := w.Redeliver(context.Background(), 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.deliverDue(context.Background())
+
+	assert.Equal(t, delivery.ID, int64(2))
+	assert.Equal(t, deliveries.deliveries[1].Status, domain.DeliverySucceeded)
+	assert.Equal(t, len(recv.payloads), 2)
+	assert.Equal(t, recv.payloads[1].CreatedAt.Equal(recv.payloads[0].CreatedAt), true)
+}
+
+func TestWebhooks_Create(t *testing.T) {
+	w := NewWebhooks(&fakeWebhooks{}, &fakeDeliveries{}, fakeAdmins{1: true}, http.DefaultClient, 0)
+
+	inp := domain.CreateWebhookInput{URL: "https://example.com/hook", Events: []string{domain.EventBookCreated, domain.EventUserSignedUp}, Secret: "0123456789abcdef"}
+
+	_, err := w.Create(context.Background(), 2, inp)
+	assert.Equal(t, errors.Is(err, domain.ErrForbidden), true)
+
+	hook, err := w.Create(context.Background(), 1, inp)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hook.ID, int64(1))
+}
+
+func TestWebhooks_backoff(t *testing.T) {
+	w := NewWebhooks(nil, nil, nil, nil, 0)
+
+	assert.Equal(t, w.backoff(1), 30*time.Second)
+	assert.Equal(t, w.backoff(2), time.Minute)
+	assert.Equal(t, w.backoff(4), 4*time.Minute)
+	assert.Equal(t, w.backoff(20), time.Hour)
+}
+
+func TestWebhooks_deliverDue_slowReceiver(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-done:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+
+	deliveries := &fakeDeliveries{}
+	hooks := &fakeWebhooks{hooks: []domain.Webhook{
+		{ID: 1, UserID: 1, URL: server.URL, Events: []string{domain.EventBookCreated}, Secret: "0123456789abcdef"},
+	}}
+
+	timeout := 100 * time.Millisecond
+	w := NewWebhooks(hooks, deliveries, fakeAdmins{}, webhook.NewClient(timeout, true), 1)
+
+	for i := 0; i < 2*deliverySenders; i++ {
+		w.Publish(context.Background(), domain.EventBookCreated, domain.Book{ID: int64(i + 1)})
+	}
+
+	started := time.Now()
+	w.deliverDue(context.Background())
+
+	// one after another they'd take 2*deliverySenders timeouts
+	if elapsed := time.Since(started); elapsed >= time.Duration(deliverySenders)*timeout {
+		t.Errorf("the batch took %v", elapsed)
+	}
+
+	for _, delivery := range deliveries.deliveries {
+		assert.Equal(t, delivery.AttemptCount, 1)
+		assert.Equal(t, delivery.Status, domain.DeliveryFailed)
+	}
+}
+
+func TestWebhooks_lease(t *testing.T) {
+	w := NewWebhooks(nil, nil, nil, &http.Client{Timeout: 10 * time.Second}, 0)
+	assert.Equal(t, w.lease(), 5*10*time.Second+leaseMargin)
+
+	w = NewWebhooks(nil, nil, nil, http.DefaultClient, 0)
+	assert.Equal(t, w.lease(), 5*defaultSendTimeout+leaseMargin)
+}
@@ -0,0 +1,78 @@
+// Package bookpb holds the protobuf messages of books generated from api/proto/book.proto,
+// run make proto after changing it, and their conversions from and to the domain types.
+package bookpb
+
+import (
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func FromBook(book domain.Book) *Book {
+	msg := &Book{
+		Id:          book.ID,
+		Title:       book.Title,
+		Author:      book.Author,
+		PublishDate: timestamppb.New(book.PublishDate),
+		Rating:      int32(book.Rating),
+		Isbn:        book.ISBN,
+		OwnerId:     book.OwnerID,
+		Version:     book.Version,
+	}
+
+	if book.DeletedAt != nil {
+		msg.DeletedAt = timestamppb.New(*book.DeletedAt)
+	}
+
+	return msg
+}
+
+func FromBooks(books []domain.Book) *BookList {
+	list := &BookList{Books: make([]*Book, len(books))}
+	for i, book := range books {
+		list.Books[i] = FromBook(book)
+	}
+
+	return list
+}
+
+// ToBook leaves the publish date zero when the message has none.
+func ToBook(msg *Book) domain.Book {
+	book := domain.Book{
+		ID:      msg.GetId(),
+		Title:   msg.GetTitle(),
+		Author:  msg.GetAuthor(),
+		Rating:  int(msg.GetRating()),
+		ISBN:    msg.GetIsbn(),
+		OwnerID: msg.GetOwnerId(),
+		Version: msg.GetVersion(),
+	}
+
+	if msg.PublishDate != nil {
+		book.PublishDate = msg.PublishDate.AsTime()
+	}
+	if msg.DeletedAt != nil {
+		deletedAt := msg.DeletedAt.AsTime()
+		book.DeletedAt = &deletedAt
+	}
+
+	return book
+}
+
+func ToUpdateBookInput(msg *UpdateBookInput) domain.UpdateBookInput {
+	upd := domain.UpdateBookInput{
+		Title:  msg.Title,
+		Author: msg.Author,
+		ISBN:   msg.Isbn,
+	}
+
+	if msg.PublishDate != nil {
+		publishDate := msg.PublishDate.AsTime()
+		upd.PublishDate = &publishDate
+	}
+	if msg.Rating != nil {
+		rating := int(*msg.Rating)
+		upd.Rating = &rating
+	}
+
+	return upd
+}
@@ -0,0 +1,154 @@
+package graphql
+
+import (
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// findOperation returns the operation of the name, the name can be left out when the
+// document has a single operation.
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+
+	return found
+}
+
+// maxFragmentDepth bounds how deep fragments spread one another, real queries stay far
+// below it.
+const maxFragmentDepth = 10
+
+// queryComplexity counts a point for every field the operation selects. The fields of a
+// connection count once per book of the page, so a query can't ask for large pages of
+// deeply nested fields. Counting stops once the cost exceeds maxComplexity, the cost is
+// maxComplexity + 1 then.
+func queryComplexity(doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}, maxComplexity int) (int, error) {
+	c := complexity{
+		max:       maxComplexity,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		costs:     make(map[string]int),
+		variables: variables,
+		visiting:  make(map[string]bool),
+	}
+
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			c.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	cost := c.selectionSet(op.SelectionSet)
+
+	return cost, c.err
+}
+
+type complexity struct {
+	max       int
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+
+	// costs keeps the cost of the fragments counted so far, a fragment spread many times
+	// is only counted once
+	costs map[string]int
+
+	// fragments that spread themselves are refused by the validation later, they must
+	// not loop here
+	visiting map[string]bool
+	depth    int
+	err      error
+}
+
+func (c *complexity) selectionSet(set *ast.SelectionSet) int {
+	if set == nil {
+		return 0
+	}
+
+	cost := 0
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost++
+			if size := c.pageSize(selection); size > 0 {
+				cost += size * c.selectionSet(selection.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			cost += c.selectionSet(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			cost += c.fragment(selection.Name.Value)
+		}
+
+		// the rest of the selection can only add to a cost that is too high already
+		if cost > c.max || c.err != nil {
+			return c.max + 1
+		}
+	}
+
+	return cost
+}
+
+func (c *complexity) fragment(name string) int {
+	if cost, ok := c.costs[name]; ok {
+		return cost
+	}
+
+	fragment, ok := c.fragments[name]
+	if !ok || c.visiting[name] {
+		return 0
+	}
+
+	if c.depth == maxFragmentDepth {
+		c.err = newError(codeTooComplex, "fragments are spread more than %d deep", maxFragmentDepth)
+		return 0
+	}
+
+	c.visiting[name], c.depth = true, c.depth+1
+	cost := c.selectionSet(fragment.SelectionSet)
+	c.visiting[name], c.depth = false, c.depth-1
+
+	c.costs[name] = cost
+
+	return cost
+}
+
+// pageSize is how many times the selection of a field is resolved.
+func (c *complexity) pageSize(field *ast.Field) int {
+	if field.Name.Value != "books" {
+		return 1
+	}
+
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if first, err := strconv.Atoi(value.Value); err == nil {
+				return clampPageSize(first)
+			}
+		case *ast.Variable:
+			// JSON numbers are decoded as float64
+			if first, ok := c.variables[value.Name.Value].(float64); ok {
+				return clampPageSize(int(first))
+			}
+		}
+	}
+
+	return defaultPageSize
+}
@@ -0,0 +1,80 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/sirupsen/logrus"
+)
+
+// the codes go to the extensions of the errors, the clients can tell the errors apart by them
+const (
+	codeBadRequest     = "BAD_REQUEST"
+	codeTooComplex     = "QUERY_TOO_COMPLEX"
+	codeInvalidInput   = "BAD_USER_INPUT"
+	codeUnauthorized   = "UNAUTHENTICATED"
+	codeForbidden      = "FORBIDDEN"
+	codeNotFound       = "NOT_FOUND"
+	codeConflict       = "CONFLICT"
+	codeVersionChanged = "VERSION_MISMATCH"
+	codeInternal       = "INTERNAL"
+)
+
+// errorCodes matches domain errors like the problem kinds of the REST API do.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{domain.ErrInvalidInput, codeInvalidInput},
+	{domain.ErrUnauthorized, codeUnauthorized},
+	{domain.ErrForbidden, codeForbidden},
+	{domain.ErrUserNotFound, codeNotFound},
+	{domain.ErrBookNotFound, codeNotFound},
+	{domain.ErrDuplicateBook, codeConflict},
+	{domain.ErrBookVersionMismatch, codeVersionChanged},
+}
+
+type codedError struct {
+	message string
+	code    string
+	fields  []domain.FieldError
+}
+
+func newError(code, format string, args ...interface{}) *codedError {
+	return &codedError{message: fmt.Sprintf(format, args...), code: code}
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func (e *codedError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code}
+	if len(e.fields) > 0 {
+		extensions["fields"] = e.fields
+	}
+
+	return extensions
+}
+
+// toError keeps the message of known errors, the others are logged and reported without
+// details.
+func toError(field string, err error) error {
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		return &codedError{message: err.Error(), code: codeInvalidInput, fields: validationErr.Fields}
+	}
+
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return newError(e.code, "%v", err)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"field": field,
+	}).Error(err)
+
+	return newError(codeInternal, "internal error")
+}
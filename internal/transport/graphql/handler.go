@@ -0,0 +1,149 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// defaultMaxComplexity is used when no limit is configured.
+const defaultMaxComplexity = 1000
+
+type BookService interface {
+	Create(ctx context.Context, book *domain.Book) error
+	GetByID(ctx context.Context, id int64) (domain.Book, error)
+	List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error)
+	Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error)
+	Delete(ctx context.Context, id, expectedVersion int64) error
+}
+
+type UserService interface {
+	GetByID(ctx context.Context, id int64) (domain.User, error)
+	GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error)
+}
+
+// Handler serves GraphQL queries over HTTP, as GET with query parameters or as POST with
+// a JSON body. It expects the user to be the actor of the request context already.
+type Handler struct {
+	schema        graphql.Schema
+	users         UserService
+	maxComplexity int
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func NewHandler(books BookService, users UserService, maxComplexity int) (*Handler, error) {
+	schema, err := newSchema(&resolver{books: books})
+	if err != nil {
+		return nil, err
+	}
+
+	if maxComplexity == 0 {
+		maxComplexity = defaultMaxComplexity
+	}
+
+	return &Handler{schema: schema, users: users, maxComplexity: maxComplexity}, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := readRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query)})})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	op := findOperation(doc, req.OperationName)
+	if op == nil {
+		writeError(w, http.StatusBadRequest, newError(codeBadRequest, "the operation is unknown"))
+		return
+	}
+
+	// GET can't change anything
+	if r.Method == http.MethodGet && op.Operation == ast.OperationTypeMutation {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, newError(codeBadRequest, "mutations must be sent with POST"))
+		return
+	}
+
+	// the cost is checked before anything is resolved
+	cost, err := queryComplexity(doc, op, req.Variables, h.maxComplexity)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if cost > h.maxComplexity {
+		writeError(w, http.StatusBadRequest, newError(codeTooComplex, "the query costs more than the limit of %d", h.maxComplexity))
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        withLoaders(r.Context(), h.users),
+	})
+
+	writeResult(w, http.StatusOK, result)
+}
+
+func readRequest(r *http.Request) (request, error) {
+	var req request
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, newError(codeBadRequest, "variables must be a JSON object")
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, newError(codeBadRequest, "the body must be a JSON object with a query")
+		}
+	default:
+		return req, newError(codeBadRequest, "only GET and POST are supported")
+	}
+
+	if req.Query == "" {
+		return req, newError(codeBadRequest, "query is required")
+	}
+
+	return req, nil
+}
+
+func writeResult(w http.ResponseWriter, status int, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeError answers a request that was refused before it ran.
+func writeError(w http.ResponseWriter, status int, err error) {
+	formatted := gqlerrors.FormatError(err)
+	if extended, ok := err.(gqlerrors.ExtendedError); ok {
+		formatted.Extensions = extended.Extensions()
+	}
+
+	writeResult(w, status, &graphql.Result{Errors: []gqlerrors.FormattedError{formatted}})
+}
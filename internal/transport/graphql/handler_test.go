@@ -0,0 +1,309 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+type fakeBooks struct {
+	BookService
+
+	books []domain.Book
+}
+
+func (f *fakeBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	for _, book := range f.books {
+		if book.ID == id {
+			return book, nil
+		}
+	}
+
+	return domain.Book{}, domain.ErrBookNotFound
+}
+
+func (f *fakeBooks) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	page := make([]domain.Book, 0, limit)
+	for _, book := range f.books {
+		if book.ID > afterID && len(page) < limit && (filter.Author == "" || book.Author == filter.Author) {
+			page = append(page, book)
+		}
+	}
+
+	return page, nil
+}
+
+func (f *fakeBooks) Create(ctx context.Context, book *domain.Book) error {
+	book.ID, book.Version = int64(len(f.books)+1), 1
+	f.books = append(f.books, *book)
+
+	return nil
+}
+
+func (f *fakeBooks) Delete(ctx context.Context, id, expectedVersion int64) error {
+	return domain.ErrBookVersionMismatch
+}
+
+type fakeUsers struct {
+	UserService
+
+	mu      sync.Mutex
+	batches [][]int64
+}
+
+func (f *fakeUsers) GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, ids)
+	f.mu.Unlock()
+
+	users := make([]domain.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, domain.User{ID: id, Name: "User", Email: "user@example.com", Role: domain.RoleUser})
+	}
+
+	return users, nil
+}
+
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (r response) code() string {
+	if len(r.Errors) == 0 {
+		return ""
+	}
+
+	code, _ := r.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func do(t *testing.T, handler http.Handler, method, query string, variables map[string]interface{}) (int, response) {
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, "/graphql?query="+url.QueryEscape(query), nil)
+	} else {
+		body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+		req = httptest.NewRequest(method, "/graphql", strings.NewReader(string(body)))
+	}
+	req = req.WithContext(domain.WithActor(req.Context(), 7))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var resp response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, resp
+}
+
+func TestHandler_books(t *testing.T) {
+	books := &fakeBooks{books: []domain.Book{
+		{ID: 1, Title: "First", Author: "A", OwnerID: 7},
+		{ID: 2, Title: "Second", Author: "B", OwnerID: 8},
+		{ID: 3, Title: "Third", Author: "A", OwnerID: 8},
+	}}
+	users := &fakeUsers{}
+
+	handler, err := NewHandler(books, users, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `query($after: String) {
+		books(first: 2, after: $after) {
+			edges { node { title owner { id email } } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`
+
+	status, resp := do(t, handler, http.MethodPost, query, nil)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, len(resp.Errors), 0)
+
+	conn := resp.Data["books"].(map[string]interface{})
+	edges := conn["edges"].([]interface{})
+	assert.Equal(t, len(edges), 2)
+
+	// only the email of the current user is visible
+	first := edges[0].(map[string]interface{})["node"].(map[string]interface{})["owner"].(map[string]interface{})
+	second := edges[1].(map[string]interface{})["node"].(map[string]interface{})["owner"].(map[string]interface{})
+	assert.Equal(t, first["email"], "user@example.com")
+	assert.Equal(t, second["email"], nil)
+
+	// the owners of the page are read at once
+	assert.Equal(t, len(users.batches), 1)
+	assert.Equal(t, len(users.batches[0]), 2)
+
+	pageInfo := conn["pageInfo"].(map[string]interface{})
+	assert.Equal(t, pageInfo["hasNextPage"], true)
+
+	_, resp = do(t, handler, http.MethodPost, query, map[string]interface{}{"after": pageInfo["endCursor"]})
+	conn = resp.Data["books"].(map[string]interface{})
+	assert.Equal(t, len(conn["edges"].([]interface{})), 1)
+	assert.Equal(t, conn["pageInfo"].(map[string]interface{})["hasNextPage"], false)
+
+	_, resp = do(t, handler, http.MethodPost, `{ books(filter: {author: "A"}) { edges { node { title } } } }`, nil)
+	assert.Equal(t, len(resp.Data["books"].(map[string]interface{})["edges"].([]interface{})), 2)
+
+	_, resp = do(t, handler, http.MethodPost, `{ book(id: 4) { title } }`, nil)
+	assert.Equal(t, resp.Data["book"], nil)
+	assert.Equal(t, len(resp.Errors), 0)
+}
+
+func TestHandler_errors(t *testing.T) {
+	handler, err := NewHandler(&fakeBooks{}, &fakeUsers{}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "OK",
+			method:         http.MethodGet,
+			query:          `{ me { name } }`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Syntax error",
+			method:         http.MethodPost,
+			query:          `{ me { name }`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Too complex",
+			method:         http.MethodPost,
+			query:          `{ books(first: 100) { edges { node { title } } } }`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeTooComplex,
+		},
+		{
+			name:           "Too complex through fragments",
+			method:         http.MethodPost,
+			query:          `{ books { ...page } } fragment page on BookConnection { edges { node { id title author } } }`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeTooComplex,
+		},
+		{
+			name:           "Mutation over GET",
+			method:         http.MethodGet,
+			query:          `mutation { deleteBook(id: 1) }`,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedCode:   codeBadRequest,
+		},
+		{
+			name:           "Empty page",
+			method:         http.MethodPost,
+			query:          `{ books(first: 0) { pageInfo { hasNextPage } } }`,
+			expectedStatus: http.StatusOK,
+			expectedCode:   codeInvalidInput,
+		},
+		{
+			name:           "Domain error",
+			method:         http.MethodPost,
+			query:          `mutation { deleteBook(id: 1, expectedVersion: 2) }`,
+			expectedStatus: http.StatusOK,
+			expectedCode:   codeVersionChanged,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			status, resp := do(t, handler, testCase.method, testCase.query, nil)
+
+			assert.Equal(t, status, testCase.expectedStatus)
+			assert.Equal(t, resp.code(), testCase.expectedCode)
+		})
+	}
+}
+
+func TestHandler_fragmentChain(t *testing.T) {
+	handler, err := NewHandler(&fakeBooks{}, &fakeUsers{}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		name           string
+		fragments      int
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "OK",
+			fragments:      3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Too complex",
+			fragments:      8,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeTooComplex,
+		},
+		{
+			// counted spread by spread, it would take 2^28 steps
+			name:           "Too deep",
+			fragments:      28,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeTooComplex,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			// each fragment spreads the next one twice
+			var query strings.Builder
+			query.WriteString("{ ...F0 }")
+			for i := 0; i < testCase.fragments; i++ {
+				fmt.Fprintf(&query, " fragment F%d on Query { ...F%d ...F%d }", i, i+1, i+1)
+			}
+			fmt.Fprintf(&query, " fragment F%d on Query { me { name } }", testCase.fragments)
+
+			status, resp := do(t, handler, http.MethodPost, query.String(), nil)
+
+			assert.Equal(t, status, testCase.expectedStatus)
+			assert.Equal(t, resp.code(), testCase.expectedCode)
+		})
+	}
+}
+
+func TestHandler_createBook(t *testing.T) {
+	books := &fakeBooks{}
+
+	handler, err := NewHandler(books, &fakeUsers{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `mutation($input: CreateBookInput!) { createBook(input: $input) { id title owner { id } } }`
+	input := map[string]interface{}{"title": "Title", "author": "Author", "rating": 4, "publishDate": "2020-01-02T00:00:00Z"}
+
+	_, resp := do(t, handler, http.MethodPost, query, map[string]interface{}{"input": input})
+	assert.Equal(t, len(resp.Errors), 0)
+
+	// the book belongs to the current user
+	book := resp.Data["createBook"].(map[string]interface{})
+	assert.Equal(t, book["id"], "1")
+	assert.Equal(t, book["owner"].(map[string]interface{})["id"], "7")
+	assert.Equal(t, books.books[0].PublishDate.Year(), 2020)
+}
@@ -0,0 +1,61 @@
+package graphql
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/graph-gophers/dataloader/v7"
+)
+
+// loaderWait is how long the loader collects keys before it reads them. The resolvers of
+// a list all ask for their keys before any of them waits, so it can be short.
+const loaderWait = time.Millisecond
+
+type loadersKey struct{}
+
+// loaders batch the reads of a single request, a page of books reads its owners at once.
+type loaders struct {
+	users *dataloader.Loader[int64, domain.User]
+}
+
+func withLoaders(ctx context.Context, users UserService) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		users: dataloader.NewBatchedLoader(loadUsers(users), dataloader.WithWait[int64, domain.User](loaderWait)),
+	})
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func loadUsers(users UserService) dataloader.BatchFunc[int64, domain.User] {
+	return func(ctx context.Context, ids []int64) []*dataloader.Result[domain.User] {
+		results := make([]*dataloader.Result[domain.User], len(ids))
+
+		found, err := users.GetByIDs(ctx, ids)
+		if err != nil {
+			for i := range results {
+				results[i] = &dataloader.Result[domain.User]{Error: err}
+			}
+			return results
+		}
+
+		byID := make(map[int64]domain.User, len(found))
+		for _, user := range found {
+			byID[user.ID] = user
+		}
+
+		// the results must be in the order of the IDs
+		for i, id := range ids {
+			user, ok := byID[id]
+			if !ok {
+				results[i] = &dataloader.Result[domain.User]{Error: domain.ErrUserNotFound}
+				continue
+			}
+			results[i] = &dataloader.Result[domain.User]{Data: user}
+		}
+
+		return results
+	}
+}
@@ -0,0 +1,416 @@
+package graphql
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// the connection types follow the Relay cursor connections specification
+type bookEdge struct {
+	Cursor string
+	Node   domain.Book
+}
+
+type pageInfo struct {
+	HasNextPage bool
+	EndCursor   *string
+}
+
+type bookConnection struct {
+	Edges    []bookEdge
+	PageInfo pageInfo
+}
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Only the email of the current user is visible.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user := p.Source.(domain.User)
+				if user.ID != domain.ActorFromContext(p.Context) {
+					return nil, nil
+				}
+				return user.Email, nil
+			},
+		},
+		"registeredAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		"role":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var bookType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Book",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"title":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"author":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"publishDate": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		"rating":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"isbn": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if isbn := p.Source.(domain.Book).ISBN; isbn != "" {
+					return isbn, nil
+				}
+				return nil, nil
+			},
+		},
+		"version": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"owner": &graphql.Field{
+			Type:    userType,
+			Resolve: resolveOwner,
+		},
+	},
+})
+
+var bookConnectionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "BookConnection",
+	Fields: graphql.Fields{
+		"edges": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+			Name: "BookEdge",
+			Fields: graphql.Fields{
+				"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"node":   &graphql.Field{Type: graphql.NewNonNull(bookType)},
+			},
+		}))))},
+		"pageInfo": &graphql.Field{Type: graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+			Name: "PageInfo",
+			Fields: graphql.Fields{
+				"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+				"endCursor":   &graphql.Field{Type: graphql.String},
+			},
+		}))},
+	},
+})
+
+var bookFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "BookFilter",
+	Description: "Title and author match a part of the field regardless of case.",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"author":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ownerId":   &graphql.InputObjectFieldConfig{Type: graphql.ID},
+		"minRating": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"maxRating": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+	},
+})
+
+var createBookInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "CreateBookInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":       &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"author":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"publishDate": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+		"rating":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+		"isbn":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var updateBookInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "UpdateBookInput",
+	Description: "Only the fields that are set change, an empty isbn clears it.",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"author":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"publishDate": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+		"rating":      &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"isbn":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+func newSchema(r *resolver) (graphql.Schema, error) {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:    graphql.NewNonNull(userType),
+				Resolve: r.me,
+			},
+			"book": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.getBook,
+			},
+			"books": &graphql.Field{
+				Type: graphql.NewNonNull(bookConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+					"filter": &graphql.ArgumentConfig{Type: bookFilterType},
+				},
+				Resolve: r.listBooks,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createBookInputType)},
+				},
+				Resolve: r.createBook,
+			},
+			"updateBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+					"input":           &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateBookInputType)},
+				},
+				Resolve: r.updateBook,
+			},
+			"deleteBook": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"expectedVersion": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.deleteBook,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+type resolver struct {
+	books BookService
+}
+
+func (r *resolver) me(p graphql.ResolveParams) (interface{}, error) {
+	user, err := loadersFromContext(p.Context).users.Load(p.Context, domain.ActorFromContext(p.Context))()
+	if err != nil {
+		return nil, toError("me", err)
+	}
+
+	return user, nil
+}
+
+// resolveOwner hands back a thunk, so that the owners of a whole page are read at once.
+func resolveOwner(p graphql.ResolveParams) (interface{}, error) {
+	ownerID := p.Source.(domain.Book).OwnerID
+	if ownerID == 0 {
+		return nil, nil
+	}
+
+	thunk := loadersFromContext(p.Context).users.Load(p.Context, ownerID)
+
+	return func() (interface{}, error) {
+		owner, err := thunk()
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, toError("Book.owner", err)
+		}
+
+		return owner, nil
+	}, nil
+}
+
+func (r *resolver) getBook(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	book, err := r.books.GetByID(p.Context, id)
+	if errors.Is(err, domain.ErrBookNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, toError("book", err)
+	}
+
+	return book, nil
+}
+
+func (r *resolver) listBooks(p graphql.ResolveParams) (interface{}, error) {
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > maxPageSize {
+		return nil, newError(codeInvalidInput, "first must be between 1 and %d", maxPageSize)
+	}
+
+	var afterID int64
+	if after, ok := p.Args["after"].(string); ok {
+		id, err := parseCursor(after)
+		if err != nil {
+			return nil, newError(codeInvalidInput, "after is not a cursor of this connection")
+		}
+		afterID = id
+	}
+
+	filter, err := bookFilter(p.Args["filter"])
+	if err != nil {
+		return nil, err
+	}
+
+	// one more book tells whether there is a next page
+	books, err := r.books.List(p.Context, filter, afterID, first+1)
+	if err != nil {
+		return nil, toError("books", err)
+	}
+
+	conn := bookConnection{Edges: make([]bookEdge, 0, first)}
+	if len(books) > first {
+		books = books[:first]
+		conn.PageInfo.HasNextPage = true
+	}
+
+	for _, book := range books {
+		conn.Edges = append(conn.Edges, bookEdge{Cursor: cursor(book.ID), Node: book})
+	}
+
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+
+	return conn, nil
+}
+
+func (r *resolver) createBook(p graphql.ResolveParams) (interface{}, error) {
+	input := p.Args["input"].(map[string]interface{})
+
+	// books belong to whoever created them
+	book := domain.Book{OwnerID: domain.ActorFromContext(p.Context)}
+	book.Title, _ = input["title"].(string)
+	book.Author, _ = input["author"].(string)
+	book.Rating, _ = input["rating"].(int)
+	book.ISBN, _ = input["isbn"].(string)
+	book.PublishDate, _ = input["publishDate"].(time.Time)
+
+	if err := r.books.Create(p.Context, &book); err != nil {
+		return nil, toError("createBook", err)
+	}
+
+	return book, nil
+}
+
+func (r *resolver) updateBook(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	input := p.Args["input"].(map[string]interface{})
+
+	var upd domain.UpdateBookInput
+	if title, ok := input["title"].(string); ok {
+		upd.Title = &title
+	}
+	if author, ok := input["author"].(string); ok {
+		upd.Author = &author
+	}
+	if publishDate, ok := input["publishDate"].(time.Time); ok {
+		upd.PublishDate = &publishDate
+	}
+	if rating, ok := input["rating"].(int); ok {
+		upd.Rating = &rating
+	}
+	if isbn, ok := input["isbn"].(string); ok {
+		upd.ISBN = &isbn
+	}
+
+	expectedVersion, _ := p.Args["expectedVersion"].(int)
+
+	book, err := r.books.Update(p.Context, id, int64(expectedVersion), upd)
+	if err != nil {
+		return nil, toError("updateBook", err)
+	}
+
+	return book, nil
+}
+
+func (r *resolver) deleteBook(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	expectedVersion, _ := p.Args["expectedVersion"].(int)
+
+	if err := r.books.Delete(p.Context, id, int64(expectedVersion)); err != nil {
+		return nil, toError("deleteBook", err)
+	}
+
+	return true, nil
+}
+
+func bookFilter(arg interface{}) (domain.BookFilter, error) {
+	var filter domain.BookFilter
+
+	input, ok := arg.(map[string]interface{})
+	if !ok {
+		return filter, nil
+	}
+
+	filter.Title, _ = input["title"].(string)
+	filter.Author, _ = input["author"].(string)
+	filter.MinRating, _ = input["minRating"].(int)
+	filter.MaxRating, _ = input["maxRating"].(int)
+
+	if ownerID, ok := input["ownerId"]; ok && ownerID != nil {
+		id, err := parseID(ownerID)
+		if err != nil {
+			return filter, err
+		}
+		filter.OwnerID = id
+	}
+
+	return filter, nil
+}
+
+func parseID(arg interface{}) (int64, error) {
+	s, _ := arg.(string)
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		return 0, newError(codeInvalidInput, "%q is not an ID", s)
+	}
+
+	return id, nil
+}
+
+// cursors are opaque to the clients, they hold the ID of the book of the edge
+func cursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func parseCursor(cursor string) (int64, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(id), 10, 64)
+}
+
+func clampPageSize(first int) int {
+	switch {
+	case first < 1:
+		return 1
+	case first > maxPageSize:
+		return maxPageSize
+	default:
+		return first
+	}
+}
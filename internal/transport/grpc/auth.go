@@ -0,0 +1,173 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/authpb"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// publicServicePrefix marks the methods that are called without a token.
+var publicServicePrefix = "/" + authpb.AuthService_ServiceDesc.ServiceName + "/"
+
+// readMethods only need the read scope of the books, the other book methods change them.
+var readMethods = map[string]bool{
+	bookpb.BookService_Get_FullMethodName:   true,
+	bookpb.BookService_List_FullMethodName:  true,
+	bookpb.BookService_Watch_FullMethodName: true,
+}
+
+// inputs are checked against the same binding tags the REST API checks
+var validate = func() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding")
+	return v
+}()
+
+// authenticator checks the access token of the calls like the auth middleware of the REST
+// API does, the user is the actor of the context then.
+type authenticator struct {
+	users UserService
+}
+
+func (a *authenticator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, publicServicePrefix) {
+		return ctx, nil
+	}
+
+	token, err := tokenFromMetadata(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	access, err := a.users.ParseAccessToken(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	id := access.UserID
+
+	if access.Audience != "" {
+		return nil, status.Error(codes.Unauthenticated, "the token isn't meant for this API")
+	}
+
+	if !access.Allows(domain.ScopeBooks, !readMethods[method]) {
+		return nil, status.Errorf(codes.PermissionDenied, "the token isn't granted the %s scope", domain.ScopeBooks)
+	}
+
+	// like the REST API, the access tokens of a disabled user stop working right away
+	user, err := a.users.GetByID(ctx, id)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, toStatus(method, err)
+	}
+
+	if user.Disabled {
+		return nil, toStatus(method, domain.ErrUserDisabled)
+	}
+
+	return domain.WithActor(ctx, id), nil
+}
+
+func tokenFromMetadata(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", errors.New("authorization metadata is missing")
+	}
+
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("authorization metadata must be \"Bearer <token>\"")
+	}
+
+	return token, nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+type authServer struct {
+	authpb.UnimplementedAuthServiceServer
+
+	users UserService
+}
+
+func (s *authServer) SignUp(ctx context.Context, req *authpb.SignUpRequest) (*emptypb.Empty, error) {
+	inp := domain.SignUpInput{Name: req.GetName(), Email: req.GetEmail(), Password: req.GetPassword()}
+	if err := validate.Struct(inp); err != nil {
+		return nil, invalidArgument("%v", err)
+	}
+
+	if err := s.users.SignUp(ctx, inp); err != nil {
+		return nil, toStatus("AuthService.SignUp", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *authServer) SignIn(ctx context.Context, req *authpb.SignInRequest) (*authpb.Tokens, error) {
+	inp := domain.SignInInput{Email: req.GetEmail(), Password: req.GetPassword()}
+	if err := validate.Struct(inp); err != nil {
+		return nil, invalidArgument("%v", err)
+	}
+
+	accessToken, refreshToken, err := s.users.SignIn(ctx, inp)
+	if err != nil {
+		// an unknown email must look the same as a wrong password
+		if errors.Is(err, domain.ErrUserNotFound) {
+			err = domain.ErrInvalidCredentials
+		}
+		return nil, toStatus("AuthService.SignIn", err)
+	}
+
+	return &authpb.Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (s *authServer) Refresh(ctx context.Context, req *authpb.RefreshRequest) (*authpb.Tokens, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, invalidArgument("refresh_token is required")
+	}
+
+	accessToken, refreshToken, err := s.users.RefreshTokens(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, toStatus("AuthService.Refresh", err)
+	}
+
+	return &authpb.Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
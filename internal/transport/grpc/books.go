@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+type bookServer struct {
+	bookpb.UnimplementedBookServiceServer
+
+	books BookService
+}
+
+func (s *bookServer) Create(ctx context.Context, req *bookpb.CreateBookRequest) (*bookpb.Book, error) {
+	if req.GetBook() == nil {
+		return nil, invalidArgument("book is required")
+	}
+
+	book := bookpb.ToBook(req.GetBook())
+	// books belong to whoever created them, the owner can't be set by the client
+	book.ID, book.Version, book.DeletedAt = 0, 0, nil
+	book.OwnerID = domain.ActorFromContext(ctx)
+
+	if err := s.books.Create(ctx, &book); err != nil {
+		return nil, toStatus("BookService.Create", err)
+	}
+
+	return bookpb.FromBook(book), nil
+}
+
+func (s *bookServer) Get(ctx context.Context, req *bookpb.GetBookRequest) (*bookpb.Book, error) {
+	book, err := s.books.GetByID(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus("BookService.Get", err)
+	}
+
+	return bookpb.FromBook(book), nil
+}
+
+func (s *bookServer) List(ctx context.Context, req *bookpb.ListBooksRequest) (*bookpb.ListBooksResponse, error) {
+	pageSize := int(req.GetPageSize())
+	switch {
+	case pageSize < 0:
+		return nil, invalidArgument("page_size can't be negative")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	afterID, err := parsePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, invalidArgument("page_token is invalid")
+	}
+
+	// one more book tells whether there is a next page
+	books, err := s.books.List(ctx, domain.BookFilter{}, afterID, pageSize+1)
+	if err != nil {
+		return nil, toStatus("BookService.List", err)
+	}
+
+	resp := &bookpb.ListBooksResponse{}
+	if len(books) > pageSize {
+		books = books[:pageSize]
+		resp.NextPageToken = pageToken(books[pageSize-1].ID)
+	}
+	resp.Books = bookpb.FromBooks(books).GetBooks()
+
+	return resp, nil
+}
+
+func (s *bookServer) Update(ctx context.Context, req *bookpb.UpdateBookRequest) (*bookpb.Book, error) {
+	if req.GetBook() == nil {
+		return nil, invalidArgument("book is required")
+	}
+
+	book, err := s.books.Update(ctx, req.GetId(), req.GetExpectedVersion(), bookpb.ToUpdateBookInput(req.GetBook()))
+	if err != nil {
+		return nil, toStatus("BookService.Update", err)
+	}
+
+	return bookpb.FromBook(book), nil
+}
+
+func (s *bookServer) Delete(ctx context.Context, req *bookpb.DeleteBookRequest) (*emptypb.Empty, error) {
+	if err := s.books.Delete(ctx, req.GetId(), req.GetExpectedVersion()); err != nil {
+		return nil, toStatus("BookService.Delete", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *bookServer) Watch(req *bookpb.WatchBooksRequest, stream bookpb.BookService_WatchServer) error {
+	ctx := stream.Context()
+	events := s.books.Watch(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case event, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return status.FromContextError(ctx.Err()).Err()
+				}
+				return status.Error(codes.ResourceExhausted, "the watcher fell behind, changes may have been missed")
+			}
+
+			if err := stream.Send(&bookpb.BookEvent{Action: event.Action, Book: bookpb.FromBook(event.Book)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// page tokens are opaque to the clients, they hold the last ID of the previous page
+func pageToken(lastID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
+
+func parsePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(id), 10, 64)
+}
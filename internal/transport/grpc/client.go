@@ -3,12 +3,18 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	audit "github.com/andy-ahmedov/audit_log_server/pkg/domain"
+	"github.com/andy-ahmedov/crud_service/internal/domain"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// actorMetadataKey carries the user who made the change, LogRequest has no field for it.
+const actorMetadataKey = "actor-id"
+
 type Client struct {
 	conn        *grpc.ClientConn
 	auditClient audit.AuditServiceClient
@@ -46,6 +52,10 @@ func (c *Client) SendLogRequest(ctx context.Context, req audit.LogItem) error {
 		return err
 	}
 
+	if actor := domain.ActorFromContext(ctx); actor != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, actorMetadataKey, strconv.FormatInt(actor, 10))
+	}
+
 	_, err = c.auditClient.Log(ctx, &audit.LogRequest{
 		Action:    action,
 		Entity:    entity,
@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes matches domain errors like the problem kinds of the REST API do.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{domain.ErrInvalidInput, codes.InvalidArgument},
+	{domain.ErrUnauthorized, codes.Unauthenticated},
+	{domain.ErrInvalidCredentials, codes.Unauthenticated},
+	{domain.ErrRefreshTokenNotFound, codes.Unauthenticated},
+	{domain.ErrRefreshTokenExpired, codes.Unauthenticated},
+	{domain.ErrForbidden, codes.PermissionDenied},
+	{domain.ErrUserDisabled, codes.PermissionDenied},
+	{domain.ErrPasswordResetNeeded, codes.PermissionDenied},
+	{domain.ErrUserNotFound, codes.NotFound},
+	{domain.ErrBookNotFound, codes.NotFound},
+	{domain.ErrUserAlreadyExists, codes.AlreadyExists},
+	{domain.ErrDuplicateBook, codes.AlreadyExists},
+	{domain.ErrBookVersionMismatch, codes.Aborted},
+	{domain.ErrRateLimited, codes.ResourceExhausted},
+}
+
+// toStatus keeps the message of known errors, the others are logged and reported as
+// INTERNAL without details.
+func toStatus(method string, err error) error {
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		fields := make([]string, len(validationErr.Fields))
+		for i, field := range validationErr.Fields {
+			fields[i] = field.Field + ": " + field.Message
+		}
+
+		return status.Error(codes.InvalidArgument, strings.Join(fields, "; "))
+	}
+
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, err.Error())
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"method": method,
+	}).Error(err)
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+func invalidArgument(format string, args ...interface{}) error {
+	return status.Errorf(codes.InvalidArgument, format, args...)
+}
@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/transport/authpb"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// NewGateway serves the HTTP rules of the protos by calling the gRPC server on the port,
+// the calls go through the same interceptors as the gRPC clients' calls. The connection
+// is closed when the context is done.
+func NewGateway(ctx context.Context, port int) (http.Handler, error) {
+	conn, err := grpc.DialContext(ctx, fmt.Sprintf("localhost:%d", port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	return newGateway(ctx, conn)
+}
+
+func newGateway(ctx context.Context, conn *grpc.ClientConn) (http.Handler, error) {
+	// the fields keep the snake_case names of the REST API, the Authorization header is
+	// passed on as the authorization metadata
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.HTTPBodyMarshaler{
+			Marshaler: &runtime.JSONPb{
+				MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+				UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+			},
+		}),
+	)
+
+	if err := bookpb.RegisterBookServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+
+	if err := authpb.RegisterAuthServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
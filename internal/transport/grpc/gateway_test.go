@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestGateway(t *testing.T) {
+	conn := newTestConn(t, &fakeBooks{books: []domain.Book{{ID: 1, Title: "Title"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	gateway, err := newGateway(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Get",
+			method:       http.MethodGet,
+			target:       "/v1/books/1",
+			token:        "valid",
+			expectedCode: http.StatusOK,
+			expectedBody: `"title":"Title"`,
+		},
+		{
+			name:         "Unknown book",
+			method:       http.MethodGet,
+			target:       "/v1/books/2",
+			token:        "valid",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "No token",
+			method:       http.MethodGet,
+			target:       "/v1/books/1",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Create",
+			method:       http.MethodPost,
+			target:       "/v1/books",
+			body:         `{"title":"New"}`,
+			token:        "valid",
+			expectedCode: http.StatusOK,
+			expectedBody: `"owner_id":"7"`,
+		},
+		{
+			name:         "Bad page token",
+			method:       http.MethodGet,
+			target:       "/v1/books?page_token=oops",
+			token:        "valid",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Sign in without a token",
+			method:       http.MethodPost,
+			target:       "/v1/auth/sign-in",
+			body:         `{"email":"user@example.com","password":"password"}`,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(testCase.method, testCase.target, strings.NewReader(testCase.body))
+			if testCase.token != "" {
+				req.Header.Set("Authorization", "Bearer "+testCase.token)
+			}
+
+			w := httptest.NewRecorder()
+			gateway.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedCode)
+			assert.Equal(t, strings.Contains(w.Body.String(), testCase.expectedBody), true, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RateLimit limits the calls of the AuthService per client address. With the store and
+// limit of the /auth routes of the REST API both APIs share the budget of a client.
+type RateLimit struct {
+	Store ratelimit.Store
+	Limit ratelimit.Limit
+}
+
+type rateLimiter struct {
+	RateLimit
+}
+
+func (r *rateLimiter) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if r.Store == nil || !r.Limit.Enabled() || !strings.HasPrefix(info.FullMethod, publicServicePrefix) {
+		return handler(ctx, req)
+	}
+
+	// the keys are the ones of the REST API, see clientIPKey there
+	res, err := r.Store.Take(ctx, "auth:ip:"+clientIP(ctx), r.Limit)
+	if err != nil {
+		// the limiter must not take the API down with it
+		logrus.WithFields(logrus.Fields{
+			"method": info.FullMethod,
+		}).Error("rate limit storage error:", err)
+		return handler(ctx, req)
+	}
+
+	if !res.Allowed {
+		retryAfter := strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds())))
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+		return nil, toStatus(info.FullMethod, domain.ErrRateLimited)
+	}
+
+	return handler(ctx, req)
+}
+
+// clientIP trusts the X-Forwarded-For the gateway adds to the calls it makes over loopback,
+// like gin does for the REST API.
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 {
+			first, _, _ := strings.Cut(forwarded[0], ",")
+			if first = strings.TrimSpace(first); first != "" {
+				return first
+			}
+		}
+	}
+
+	return host
+}
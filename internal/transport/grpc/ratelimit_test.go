@@ -0,0 +1,42 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/authpb"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/magiconair/properties/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_rateLimit(t *testing.T) {
+	books := &fakeBooks{books: []domain.Book{{ID: 1, Title: "Title"}}}
+	conn := dialTestServer(t, NewServer(books, &fakeUsers{}, RateLimit{
+		Store: ratelimit.NewMemoryStore(),
+		Limit: ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 1},
+	}))
+	auth := authpb.NewAuthServiceClient(conn)
+
+	signIn := func(header *metadata.MD) error {
+		_, err := auth.SignIn(context.Background(), &authpb.SignInRequest{Email: "user@example.com", Password: "password"}, grpc.Header(header))
+		return err
+	}
+
+	var header metadata.MD
+	assert.Equal(t, status.Code(signIn(&header)), codes.Unauthenticated)
+	assert.Equal(t, status.Code(signIn(&header)), codes.ResourceExhausted)
+	assert.Equal(t, header.Get("retry-after"), []string{"3600"})
+
+	// only the auth service is limited
+	for i := 0; i < 3; i++ {
+		_, err := bookpb.NewBookServiceClient(conn).Get(withToken("valid"), &bookpb.GetBookRequest{Id: 1})
+		assert.Equal(t, status.Code(err), codes.OK)
+	}
+}
@@ -0,0 +1,64 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/authpb"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"google.golang.org/grpc"
+)
+
+type BookService interface {
+	Create(ctx context.Context, book *domain.Book) error
+	GetByID(ctx context.Context, id int64) (domain.Book, error)
+	List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error)
+	Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error)
+	Delete(ctx context.Context, id, expectedVersion int64) error
+	Watch(ctx context.Context) <-chan domain.BookEvent
+}
+
+type UserService interface {
+	SignUp(ctx context.Context, inp domain.SignUpInput) error
+	SignIn(ctx context.Context, inp domain.SignInInput) (string, string, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (string, string, error)
+	ParseAccessToken(ctx context.Context, token string) (domain.AccessToken, error)
+	GetByID(ctx context.Context, id int64) (domain.User, error)
+}
+
+// Server serves the books and auth APIs over gRPC, next to the REST API.
+type Server struct {
+	srv *grpc.Server
+}
+
+// NewServer limits the AuthService calls by rateLimit, a nil store leaves them unlimited.
+func NewServer(books BookService, users UserService, rateLimit RateLimit) *Server {
+	auth := &authenticator{users: users}
+	limiter := &rateLimiter{RateLimit: rateLimit}
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(limiter.unary, auth.unary),
+		grpc.ChainStreamInterceptor(auth.stream),
+	)
+
+	bookpb.RegisterBookServiceServer(srv, &bookServer{books: books})
+	authpb.RegisterAuthServiceServer(srv, &authServer{users: users})
+
+	return &Server{srv: srv}
+}
+
+func (s *Server) ListenAndServe(port int) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+
+	return s.srv.Serve(lis)
+}
+
+// GracefulStop waits for the calls in progress, watchers included.
+func (s *Server) GracefulStop() {
+	s.srv.GracefulStop()
+}
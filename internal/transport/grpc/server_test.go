@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/authpb"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/magiconair/properties/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeBooks struct {
+	BookService
+
+	books  []domain.Book
+	events chan domain.BookEvent
+}
+
+func (f *fakeBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	for _, book := range f.books {
+		if book.ID == id {
+			return book, nil
+		}
+	}
+
+	return domain.Book{}, domain.ErrBookNotFound
+}
+
+func (f *fakeBooks) List(ctx context.Context, filter domain.BookFilter, afterID int64, limit int) ([]domain.Book, error) {
+	page := make([]domain.Book, 0, limit)
+	for _, book := range f.books {
+		if book.ID > afterID && len(page) < limit {
+			page = append(page, book)
+		}
+	}
+
+	return page, nil
+}
+
+func (f *fakeBooks) Create(ctx context.Context, book *domain.Book) error {
+	book.ID, book.Version = int64(len(f.books)+1), 1
+	f.books = append(f.books, *book)
+
+	return nil
+}
+
+func (f *fakeBooks) Watch(ctx context.Context) <-chan domain.BookEvent {
+	return f.events
+}
+
+type fakeUsers struct {
+	UserService
+}
+
+func (f *fakeUsers) ParseAccessToken(ctx context.Context, token string) (domain.AccessToken, error) {
+	switch token {
+	case "valid":
+		return domain.AccessToken{UserID: 7}, nil
+	case "disabled":
+		return domain.AccessToken{UserID: 8}, nil
+	case "read-only":
+		return domain.AccessToken{UserID: 7, ClientID: "client", Scope: domain.ScopeBooks + ":read"}, nil
+	}
+
+	return domain.AccessToken{}, errors.New("token is invalid")
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, id int64) (domain.User, error) {
+	return domain.User{ID: id, Disabled: id == 8}, nil
+}
+
+func (f *fakeUsers) SignIn(ctx context.Context, inp domain.SignInInput) (string, string, error) {
+	return "", "", domain.ErrUserNotFound
+}
+
+func newTestConn(t *testing.T, books *fakeBooks) *grpc.ClientConn {
+	return dialTestServer(t, NewServer(books, &fakeUsers{}, RateLimit{}))
+}
+
+func dialTestServer(t *testing.T, srv *Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+
+	go srv.srv.Serve(lis)
+	t.Cleanup(srv.GracefulStop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServer_auth(t *testing.T) {
+	conn := newTestConn(t, &fakeBooks{books: []domain.Book{{ID: 1, Title: "Title"}}})
+	books := bookpb.NewBookServiceClient(conn)
+
+	testTable := []struct {
+		name         string
+		ctx          context.Context
+		id           int64
+		expectedCode codes.Code
+	}{
+		{name: "No token", ctx: context.Background(), id: 1, expectedCode: codes.Unauthenticated},
+		{name: "Invalid token", ctx: withToken("expired"), id: 1, expectedCode: codes.Unauthenticated},
+		{name: "Valid token", ctx: withToken("valid"), id: 1, expectedCode: codes.OK},
+		{name: "Disabled user", ctx: withToken("disabled"), id: 1, expectedCode: codes.PermissionDenied},
+		{name: "Read-only token", ctx: withToken("read-only"), id: 1, expectedCode: codes.OK},
+		{name: "Unknown book", ctx: withToken("valid"), id: 2, expectedCode: codes.NotFound},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := books.Get(testCase.ctx, &bookpb.GetBookRequest{Id: testCase.id})
+			assert.Equal(t, status.Code(err), testCase.expectedCode)
+		})
+	}
+
+	// the books:read scope doesn't let the client change the books
+	_, err := books.Create(withToken("read-only"), &bookpb.CreateBookRequest{Book: &bookpb.Book{Title: "Title"}})
+	assert.Equal(t, status.Code(err), codes.PermissionDenied)
+
+	// signing in needs no token, and an unknown email looks like a wrong password
+	_, err = authpb.NewAuthServiceClient(conn).SignIn(context.Background(), &authpb.SignInRequest{Email: "user@example.com", Password: "password"})
+	assert.Equal(t, status.Code(err), codes.Unauthenticated)
+	assert.Equal(t, status.Convert(err).Message(), domain.ErrInvalidCredentials.Error())
+}
+
+func TestServer_bookService(t *testing.T) {
+	fake := &fakeBooks{events: make(chan domain.BookEvent, 1)}
+	conn := newTestConn(t, fake)
+	books := bookpb.NewBookServiceClient(conn)
+	ctx := withToken("valid")
+
+	for _, title := range []string{"First", "Second", "Third"} {
+		book, err := books.Create(ctx, &bookpb.CreateBookRequest{Book: &bookpb.Book{Title: title, OwnerId: 99}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the owner is the user of the token
+		assert.Equal(t, book.GetOwnerId(), int64(7))
+	}
+
+	titles := make([]string, 0)
+	req := &bookpb.ListBooksRequest{PageSize: 2}
+	for pages := 0; ; pages++ {
+		resp, err := books.List(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, book := range resp.GetBooks() {
+			titles = append(titles, book.GetTitle())
+		}
+
+		if resp.GetNextPageToken() == "" {
+			assert.Equal(t, pages, 1)
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	assert.Equal(t, titles, []string{"First", "Second", "Third"})
+
+	_, err := books.List(ctx, &bookpb.ListBooksRequest{PageToken: "not a token"})
+	assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+	stream, err := books.Watch(ctx, &bookpb.WatchBooksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.events <- domain.BookEvent{Action: domain.RevisionUpdate, Book: domain.Book{ID: 2, Title: "Changed"}}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, event.GetAction(), domain.RevisionUpdate)
+	assert.Equal(t, event.GetBook().GetTitle(), "Changed")
+
+	// a watcher that fell behind is told so
+	close(fake.events)
+	_, err = stream.Recv()
+	assert.Equal(t, status.Code(err), codes.ResourceExhausted)
+}
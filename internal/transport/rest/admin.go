@@ -0,0 +1,246 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary ListUsers
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Listing users with pagination and search by name or email.
+// @ID admin-list-users
+// @Produce json
+// @Param search query string false "Part of the name or email"
+// @Param limit query int false "Page size, 20 by default"
+// @Param offset query int false "Number of users to skip"
+// @Success 200 {object} domain.UserList "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users [get]
+func (h *Handler) listUsers(c *gin.Context) {
+	var filter domain.UserFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	users, err := h.userService.List(c.Request.Context(), filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// @Summary GetUser
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Getting a user by ID.
+// @ID admin-get-user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} domain.User "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id} [get]
+func (h *Handler) getUser(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	user, err := h.userService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary GetUserSessions
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Listing active sessions of a user.
+// @ID admin-get-user-sessions
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} domain.RefreshSession "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/sessions [get]
+func (h *Handler) getUserSessions(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	sessions, err := h.userService.Sessions(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// @Summary DisableUser
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Disabling an account and ending all of its sessions.
+// @ID admin-disable-user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} domain.User "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/disable [post]
+func (h *Handler) disableUser(c *gin.Context) {
+	h.setUserDisabled(true, c)
+}
+
+// @Summary EnableUser
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Enabling a previously disabled account.
+// @ID admin-enable-user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} domain.User "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/enable [post]
+func (h *Handler) enableUser(c *gin.Context) {
+	h.setUserDisabled(false, c)
+}
+
+func (h *Handler) setUserDisabled(disabled bool, c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	user, err := h.userService.SetDisabled(c.Request.Context(), id, disabled)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary ForcePasswordReset
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Ending all sessions of a user and mailing a token the user has to set a new password with.
+// @ID admin-force-password-reset
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {string} gin.H "The password reset has been requested."
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/reset-password [post]
+func (h *Handler) forcePasswordReset(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if err := h.userService.ForcePasswordReset(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// @Summary ChangeUserRole
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Changing the role of a user.
+// @ID admin-change-user-role
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param input body domain.ChangeRoleInput true "New role"
+// @Success 200 {object} domain.User "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/role [put]
+func (h *Handler) changeUserRole(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	var inp domain.ChangeRoleInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	user, err := h.userService.ChangeRole(c.Request.Context(), id, inp.Role)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary ImpersonateUser
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Issuing a short-lived access token to act as the user for support purposes. The action is recorded in the audit log.
+// @ID admin-impersonate-user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} domain.Impersonation "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/impersonate [post]
+func (h *Handler) impersonateUser(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	adminID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	imp, err := h.userService.Impersonate(c.Request.Context(), adminID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, imp)
+}
@@ -0,0 +1,196 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/service"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestRest_adminMiddleware(t *testing.T) {
+	type mockBehavior func(s *mock_service.MockUserStorage)
+
+	testTable := []struct {
+		name               string
+		mockBehavior       mockBehavior
+		expectedStatusCode int
+	}{
+		{
+			name: "OK",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1, Role: domain.RoleAdmin}, nil)
+			},
+			expectedStatusCode: 200,
+		},
+		{
+			name: "Not an admin",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1, Role: domain.RoleUser}, nil)
+			},
+			expectedStatusCode: 403,
+		},
+		{
+			name: "Disabled admin",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1, Role: domain.RoleAdmin, Disabled: true}, nil)
+			},
+			expectedStatusCode: 403,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			repo := mock_service.NewMockUserStorage(c)
+			testCase.mockBehavior(repo)
+
+			handler := NewHandler(nil, &service.Users{Repo: repo}, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.GET("/admin", func(c *gin.Context) {
+				ctx := context.WithValue(c.Request.Context(), ctxUserID, int64(1))
+				c.Request = c.Request.WithContext(ctx)
+			}, handler.adminMiddleware, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/admin", nil)
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+		})
+	}
+}
+
+func TestRest_authMiddleware(t *testing.T) {
+	type mockBehavior func(s *mock_service.MockUserStorage)
+
+	testTable := []struct {
+		name               string
+		mockBehavior       mockBehavior
+		expectedStatusCode int
+	}{
+		{
+			name: "OK",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1}, nil)
+			},
+			expectedStatusCode: 200,
+		},
+		{
+			name: "Disabled user",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1, Disabled: true}, nil)
+			},
+			expectedStatusCode: 403,
+		},
+		{
+			name: "Deleted user",
+			mockBehavior: func(s *mock_service.MockUserStorage) {
+				s.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{}, domain.ErrUserNotFound)
+			},
+			expectedStatusCode: 401,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			repo := mock_service.NewMockUserStorage(c)
+			testCase.mockBehavior(repo)
+
+			users := &service.Users{Repo: repo, HmacSecret: []byte("secret")}
+			handler := NewHandler(nil, users, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.GET("/me", handler.authMiddleware, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestToken(t, users, 1))
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+		})
+	}
+}
+
+func TestRest_scopeMiddleware(t *testing.T) {
+	testTable := []struct {
+		name               string
+		access             domain.AccessToken
+		method             string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Own session",
+			access:             domain.AccessToken{UserID: 1},
+			method:             http.MethodPost,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "Granted scope",
+			access:             domain.AccessToken{UserID: 1, ClientID: "app", Scope: "profile books"},
+			method:             http.MethodPost,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "Read scope to read",
+			access:             domain.AccessToken{UserID: 1, ClientID: "app", Scope: "books:read"},
+			method:             http.MethodGet,
+			expectedStatusCode: 200,
+		},
+		{
+			name:               "Read scope to write",
+			access:             domain.AccessToken{UserID: 1, ClientID: "app", Scope: "books:read"},
+			method:             http.MethodPost,
+			expectedStatusCode: 403,
+		},
+		{
+			name:               "Another scope",
+			access:             domain.AccessToken{UserID: 1, ClientID: "app", Scope: "profile"},
+			method:             http.MethodGet,
+			expectedStatusCode: 403,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := NewHandler(nil, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.Handle(testCase.method, "/books", func(c *gin.Context) {
+				ctx := context.WithValue(c.Request.Context(), ctxAccessToken, testCase.access)
+				c.Request = c.Request.WithContext(ctx)
+			}, handler.scopeMiddleware(domain.ScopeBooks), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(testCase.method, "/books", nil)
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+		})
+	}
+}
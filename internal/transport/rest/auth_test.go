@@ -15,7 +15,7 @@ import (
 )
 
 func TestRest_signUp(t *testing.T) {
-	type mockBehavior func(s *mock_service.MockUserStorage, user domain.User)
+	type mockBehavior func(s *mock_service.MockUserStorage, h *mock_service.MockPasswordHasher, a *mock_service.MockAuditClient, user domain.User)
 
 	testTable := []struct {
 		name               string
@@ -26,17 +26,40 @@ func TestRest_signUp(t *testing.T) {
 	}{
 		{
 			name:      "OK",
-			inputBody: `{"name":"Test", "email":"test@gmail.com", "password":"qwerty"}`,
+			inputBody: `{"name":"Test", "email":"Test@Gmail.com", "password":"qwerty"}`,
 			inputUser: domain.User{
+				ID:       1,
 				Name:     "Test",
 				Email:    "test@gmail.com",
-				Password: "qwerty",
+				Password: "hashed",
 			},
-			mockBehavior: func(s *mock_service.MockUserStorage, user domain.User) {
-				s.EXPECT().CreateUser(context.Background(), user).Return(nil)
+			mockBehavior: func(s *mock_service.MockUserStorage, h *mock_service.MockPasswordHasher, a *mock_service.MockAuditClient, user domain.User) {
+				h.EXPECT().Hash("qwerty").Return(user.Password, nil)
+				s.EXPECT().CreateUser(context.Background(), gomock.Any()).Return(nil)
+				s.EXPECT().GetByCredential(context.Background(), user.Email, user.Password).Return(user, nil)
+				a.EXPECT().SendLogRequest(context.Background(), gomock.Any()).Return(nil)
 			},
 			expectedStatusCode: 200,
 		},
+		{
+			name:      "Invalid email",
+			inputBody: `{"name":"Test", "email":"test", "password":"qwerty"}`,
+			mockBehavior: func(s *mock_service.MockUserStorage, h *mock_service.MockPasswordHasher, a *mock_service.MockAuditClient, user domain.User) {
+			},
+			expectedStatusCode: 400,
+		},
+		{
+			name:      "Email already taken",
+			inputBody: `{"name":"Test", "email":"test@gmail.com", "password":"qwerty"}`,
+			inputUser: domain.User{
+				Password: "hashed",
+			},
+			mockBehavior: func(s *mock_service.MockUserStorage, h *mock_service.MockPasswordHasher, a *mock_service.MockAuditClient, user domain.User) {
+				h.EXPECT().Hash("qwerty").Return(user.Password, nil)
+				s.EXPECT().CreateUser(context.Background(), gomock.Any()).Return(domain.ErrUserAlreadyExists)
+			},
+			expectedStatusCode: 409,
+		},
 	}
 
 	for _, testCase := range testTable {
@@ -45,13 +68,16 @@ func TestRest_signUp(t *testing.T) {
 			defer c.Finish()
 
 			auth := mock_service.NewMockUserStorage(c)
-			testCase.mockBehavior(auth, testCase.inputUser)
+			hasher := mock_service.NewMockPasswordHasher(c)
+			audit := mock_service.NewMockAuditClient(c)
+			testCase.mockBehavior(auth, hasher, audit, testCase.inputUser)
 
-			services := &service.Users{Repo: auth}
+			services := &service.Users{Repo: auth, Hasher: hasher, AuditClient: audit}
 
-			handler := NewHandler(nil, services)
+			handler := NewHandler(nil, services, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
 
 			r := gin.New()
+			r.Use(problemMiddleware)
 			r.POST("/sign-up", handler.signUp)
 
 			w := httptest.NewRecorder()
@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+type batchResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	Status int          `json:"status"`
+	Book   *domain.Book `json:"book,omitempty"`
+	Error  *problem     `json:"error,omitempty"`
+}
+
+// @Summary batchBooks
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Creating, replacing and deleting up to 1000 books at once. In the atomic mode (default) either all operations are written or none of them, in the best_effort mode every operation that succeeds is written. The result of each operation has the status it would have as a single request, 207 is returned when any of them failed.
+// @ID batch-books
+// @Accept json
+// @Produce json
+// @Param input body domain.BookBatch true "Operations"
+// @Success 200 {array} batchResult "OK"
+// @Success 207 {array} batchResult "Multi-Status"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/batch [post]
+func (h *Handler) batchBooks(c *gin.Context) {
+	var batch domain.BookBatch
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	// the books belong to whoever created them, like with single requests
+	ownerID, _ := getUserIDFromContext(c)
+	for _, op := range batch.Operations {
+		if op.Op == domain.BatchCreate {
+			op.Book.OwnerID = ownerID
+		}
+	}
+
+	results, err := h.booksService.Batch(c.Request.Context(), batch)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	status := http.StatusOK
+	response := make([]batchResult, len(results))
+
+	for i, result := range results {
+		response[i] = batchResult{Index: result.Index, Op: result.Op, Status: http.StatusOK, Book: result.Book}
+
+		if result.Err != nil {
+			p := newProblem(result.Err)
+			response[i].Status, response[i].Error, response[i].Book = p.Status, &p, nil
+			status = http.StatusMultiStatus
+			continue
+		}
+
+		if result.Op == domain.BatchCreate {
+			response[i].Status = http.StatusCreated
+		}
+	}
+
+	c.JSON(status, response)
+}
@@ -1,165 +1,444 @@
 package rest
 
 import (
-	"context"
-	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/andy-ahmedov/crud_service/internal/domain"
 	"github.com/gin-gonic/gin"
 )
 
+const acceptPatch = "application/merge-patch+json, application/json-patch+json"
+
 // @Summary CreateBook
 // @Security ApiKeyAuth
 // @Tags books
-// @Description Adding a book to the database.
+// @Description Adding a book to the database. A retry sent with the same Idempotency-Key is answered with the first response instead of adding the book again, like every POST, PUT, PATCH and DELETE. Deprecated in favour of POST /v1/books, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
 // @ID add-book
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
 // @Param input body domain.Book true "Book information"
+// @Param Idempotency-Key header string false "A unique key of the request, kept for 24h by default"
 // @Success 200 {string} gin.H "The data has been successfully written."
-// @Failure 400 {object} errResponse "Bad Request"
-// @Failure 500 {object} errResponse "Internal Server Error"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 409 {object} problem "A request with the key is still being handled"
+// @Failure 422 {object} problem "Unprocessable Entity, or the key was used for another request"
+// @Failure 500 {object} problem "Internal Server Error"
 // @Router /books [post]
 func (h Handler) createBook(c *gin.Context) {
 	var book domain.Book
 
-	if err := c.ShouldBindJSON(&book); err != nil {
-		logError("createBook", "writing data to a structure", err)
-		c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
+	if err := bindBook(c, &book); err != nil {
+		c.Error(err)
 		return
 	}
 
-	err := h.booksService.Create(context.TODO(), &book)
+	// books belong to whoever created them, the owner can't be set by the client
+	book.OwnerID, _ = getUserIDFromContext(c)
+
+	err := h.booksService.Create(c.Request.Context(), &book)
 	if err != nil {
-		logError("createBook", "service error", err)
-		c.JSON(http.StatusInternalServerError, errResponse{Message: err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"id": book.ID})
+	// there is no message for the ID alone, protobuf clients get the whole book
+	if acceptsProtobuf(c) {
+		respond(c, http.StatusOK, book)
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{"id": book.ID})
 }
 
 // @Summary getAllBooks
 // @Security ApiKeyAuth
 // @Tags books
-// @Description Getting all books.
+// @Description Getting all books. Deprecated in favour of GET /v1/books, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
 // @ID get-all-books
-// @Produce json
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Param If-None-Match header string false "ETag of a previous response"
 // @Success 200 {array} domain.Book "Books have been successfully received."
-// @Failure 500 {object} errResponse "Internal Server Error"
+// @Success 304 "Not Modified"
+// @Failure 500 {object} problem "Internal Server Error"
 // @Router /books [get]
 func (h *Handler) getAllBooks(c *gin.Context) {
 	books, err := h.booksService.GetAll(c)
 	if err != nil {
-		logError("getAllBooks", "reading data from a database", err)
-		c.JSON(http.StatusInternalServerError, errResponse{Message: err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, books)
+	etag := booksETag(books)
+	c.Header("ETag", etag)
+
+	if noneMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	respond(c, http.StatusOK, books)
 }
 
 // @Summary GetBookByID
 // @Security ApiKeyAuth
 // @Tags id
-// @Description Retrieves a book by ID. If the book is not found, returns an error.
+// @Description Retrieves a book by ID. If the book is not found, returns an error. The version of the book is sent as its ETag. With as_of the book is read as it was at that time. Deprecated in favour of GET /v1/books/{id}, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
 // @ID get-book-by-id
 // @Accept  json
-// @Produce  json
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
 // @Param id path int true "Book ID"
+// @Param as_of query string false "RFC 3339 timestamp"
+// @Param If-None-Match header string false "ETag of a previous response"
 // @Success 200 {object} domain.Book "OK"
-// @Failure 400 {object} errResponse "Bad Request"
-// @Failure 500 {object} errResponse "Internal Server Error"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
 // @Router /books/{id} [get]
 func (h *Handler) getBook(c *gin.Context) {
 	id, err := getIDFromRequest(c.Param("id"))
 	if err != nil {
-		logError("getBook", "reading id from request", err)
-		c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if asOf := c.Query("as_of"); asOf != "" {
+		h.getBookAsOf(id, asOf, c)
+		return
+	}
+
+	book, err := h.booksService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	book, err := h.booksService.GetByID(context.TODO(), id)
+	etag := bookETag(book.Version)
+	c.Header("ETag", etag)
+
+	if noneMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	respond(c, http.StatusOK, book)
+}
+
+func (h *Handler) getBookAsOf(id int64, asOf string, c *gin.Context) {
+	at, err := time.Parse(time.RFC3339, asOf)
 	if err != nil {
-		if errors.Is(err, domain.ErrBookNotFound) {
-			logError("getBook", "there is no book with the given identifier", err)
-			c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
-			return
-		}
-		logError("getBook", "reading data from a database", err)
-		c.JSON(http.StatusInternalServerError, errResponse{Message: err.Error()})
+		c.Error(invalidInput(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, book)
+	book, err := h.booksService.GetAsOf(c.Request.Context(), id, at)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// a past version can't be used for If-Match, so it gets no ETag
+	respond(c, http.StatusOK, book)
 }
 
 // @Summary deleteBook
 // @Security ApiKeyAuth
 // @Tags id
-// @Description Deleting a book by ID.
+// @Description Moving a book to the trash by ID, it can be restored until the trash is purged. With If-Match the book is only deleted if it hasn't been changed since. Deprecated in favour of DELETE /v1/books/{id}, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
 // @ID delete-book
 // @Accept json
 // @Produce json
 // @Param id path int true "Book ID"
+// @Param If-Match header string false "ETag of the book as it was read"
 // @Success 200 {string} string "The data has been successfully written."
-// @Failure 400 {object} errResponse "Bad Request"
-// @Failure 500 {object} errResponse "Internal Server Error"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 412 {object} problem "Precondition Failed"
+// @Failure 500 {object} problem "Internal Server Error"
 // @Router /books/{id} [delete]
 func (h *Handler) deleteBook(c *gin.Context) {
 	id, err := getIDFromRequest(c.Param("id"))
 	if err != nil {
-		logError("deleteBook", "reading id from request", err)
-		c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
+		c.Error(invalidInput(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.Error(domain.ErrBookVersionMismatch)
 		return
 	}
 
-	err = h.booksService.Delete(context.TODO(), id)
+	err = h.booksService.Delete(c.Request.Context(), id, version)
 	if err != nil {
-		logError("deleteBook", "deleting data from the database", err)
-		c.JSON(http.StatusInternalServerError, errResponse{Message: err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.String(http.StatusOK, "The row with the given ID was successfully deleted.\n")
+	c.String(http.StatusOK, "The row with the given ID was successfully moved to the trash.\n")
 }
 
 // @Summary updateBook
 // @Security ApiKeyAuth
 // @Tags id
-// @Description Updating book data by ID.
+// @Description Replacing book data by ID, all fields are required. With If-Match the book is only updated if it hasn't been changed since. Deprecated in favour of PATCH /v1/books/{id}, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
 // @ID update-book
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
 // @Param id path int true "Book ID"
+// @Param If-Match header string false "ETag of the book as it was read"
 // @Param updateBook body domain.UpdateBookInput true "Book update information"
-// @Success 200 {string} string "ok"
-// @Failure 400 {object} errResponse "Bad Request"
-// @Failure 500 {object} errResponse "Internal Server Error"
+// @Success 200 {object} domain.Book "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 412 {object} problem "Precondition Failed"
+// @Failure 422 {object} problem "Unprocessable Entity"
+// @Failure 500 {object} problem "Internal Server Error"
 // @Router /books/{id} [put]
 func (h *Handler) updateBook(c *gin.Context) {
 	var updBook domain.UpdateBookInput
 
-	if err := c.ShouldBindJSON(&updBook); err != nil {
-		logError("updateBook", "writing data to a structure", err)
-		c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
+	if err := bindUpdateBookInput(c, &updBook); err != nil {
+		c.Error(err)
+		return
+	}
+
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.Error(domain.ErrBookVersionMismatch)
+		return
+	}
+
+	book, err := h.booksService.Update(c.Request.Context(), id, version, updBook)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("ETag", bookETag(book.Version))
+	respond(c, http.StatusOK, book)
+}
+
+// @Summary patchBook
+// @Security ApiKeyAuth
+// @Tags id
+// @Description Partially updating a book with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), picked by the Content-Type. With If-Match the patch is only applied if the book hasn't been changed since. Deprecated in favour of PATCH /v1/books/{id}, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
+// @ID patch-book
+// @Accept application/merge-patch+json,application/json-patch+json
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Param id path int true "Book ID"
+// @Param If-Match header string false "ETag of the book as it was read"
+// @Param patch body object true "Patch document"
+// @Success 200 {object} domain.Book "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 412 {object} problem "Precondition Failed"
+// @Failure 415 {object} problem "Unsupported Media Type"
+// @Failure 422 {object} problem "Unprocessable Entity"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/{id} [patch]
+func (h *Handler) patchBook(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	patch := domain.BookPatch{}
+
+	switch c.ContentType() {
+	case "application/merge-patch+json", "application/json":
+		patch.Format = domain.MergePatch
+	case "application/json-patch+json":
+		patch.Format = domain.JSONPatch
+	default:
+		c.Header("Accept-Patch", acceptPatch)
+		c.Error(fmt.Errorf("%w: use one of %s", domain.ErrUnsupportedMediaType, acceptPatch))
+		return
+	}
+
+	if patch.Body, err = io.ReadAll(c.Request.Body); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.Error(domain.ErrBookVersionMismatch)
 		return
 	}
 
+	book, err := h.booksService.Patch(c.Request.Context(), id, version, patch)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("ETag", bookETag(book.Version))
+	respond(c, http.StatusOK, book)
+}
+
+// @Summary getTrash
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Getting the deleted books that can still be restored, the latest deleted first.
+// @ID get-trash
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Success 200 {array} domain.Book "OK"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/trash [get]
+func (h *Handler) getTrash(c *gin.Context) {
+	books, err := h.booksService.Trash(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	respond(c, http.StatusOK, books)
+}
+
+// @Summary restoreBook
+// @Security ApiKeyAuth
+// @Tags id
+// @Description Taking a book out of the trash.
+// @ID restore-book
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Param id path int true "Book ID"
+// @Success 200 {object} domain.Book "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/{id}/restore [post]
+func (h *Handler) restoreBook(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	book, err := h.booksService.Restore(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("ETag", bookETag(book.Version))
+	respond(c, http.StatusOK, book)
+}
+
+// @Summary PurgeBook
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Deleting a book for good, whether it is in the trash or not.
+// @ID admin-purge-book
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {string} gin.H "The book has been purged."
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/books/{id} [delete]
+func (h *Handler) purgeBook(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if err := h.booksService.Purge(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// @Summary getBookHistory
+// @Security ApiKeyAuth
+// @Tags id
+// @Description Getting the revisions of a book, the oldest first, with the fields each of them changed and the user who made it.
+// @ID get-book-history
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {array} domain.BookHistoryEntry "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/{id}/history [get]
+func (h *Handler) getBookHistory(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	history, err := h.booksService.History(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// @Summary revertBook
+// @Security ApiKeyAuth
+// @Tags id
+// @Description Writing the fields of an earlier revision as a new version of the book. With If-Match the book is only reverted if it hasn't been changed since.
+// @ID revert-book
+// @Produce json,xml,application/x-yaml,application/msgpack,application/x-protobuf
+// @Param id path int true "Book ID"
+// @Param revision path int true "Revision to revert to"
+// @Param If-Match header string false "ETag of the book as it was read"
+// @Success 200 {object} domain.Book "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 412 {object} problem "Precondition Failed"
+// @Failure 422 {object} problem "Unprocessable Entity"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/{id}/revert/{revision} [post]
+func (h *Handler) revertBook(c *gin.Context) {
 	id, err := getIDFromRequest(c.Param("id"))
 	if err != nil {
-		logError("updateBook", "reading id from request", err)
-		c.JSON(http.StatusBadRequest, errResponse{Message: err.Error()})
+		c.Error(invalidInput(err))
+		return
+	}
+
+	revision, err := getIDFromRequest(c.Param("revision"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.Error(domain.ErrBookVersionMismatch)
 		return
 	}
 
-	err = h.booksService.Update(context.TODO(), id, updBook)
+	book, err := h.booksService.Revert(c.Request.Context(), id, revision, version)
 	if err != nil {
-		logError("updateBook", "service error", err)
-		c.JSON(http.StatusInternalServerError, errResponse{Message: err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.String(http.StatusOK, "The book with the given ID has been successfully updated.\n")
+	c.Header("ETag", bookETag(book.Version))
+	respond(c, http.StatusOK, book)
 }
@@ -0,0 +1,318 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/repository/memory"
+	"github.com/andy-ahmedov/crud_service/internal/service"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/magiconair/properties/assert"
+)
+
+// fakeBooks keeps a single book, writes succeed only for its current version or version 0.
+type fakeBooks struct {
+	BooksRepository
+
+	book   domain.Book
+	filter domain.BookFilter
+}
+
+func (f *fakeBooks) GetByID(ctx context.Context, id int64) (domain.Book, error) {
+	if id != f.book.ID {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	return f.book, nil
+}
+
+func (f *fakeBooks) Update(ctx context.Context, id, expectedVersion int64, upd domain.UpdateBookInput) (domain.Book, error) {
+	if id != f.book.ID {
+		return domain.Book{}, domain.ErrBookNotFound
+	}
+
+	if expectedVersion != 0 && expectedVersion != f.book.Version {
+		return domain.Book{}, domain.ErrBookVersionMismatch
+	}
+
+	f.book.Title = *upd.Title
+	f.book.Version++
+
+	return f.book, nil
+}
+
+func TestRest_bookConditionalRequests(t *testing.T) {
+	const fullBook = `{"title":"New title","author":"Author","publish_date":"2020-01-02T00:00:00Z","rating":5}`
+
+	testTable := []struct {
+		name               string
+		method             string
+		body               string
+		headers            map[string]string
+		expectedStatusCode int
+		expectedETag       string
+	}{
+		{
+			name:               "Read",
+			method:             "GET",
+			expectedStatusCode: 200,
+			expectedETag:       `"3"`,
+		},
+		{
+			name:               "Read not modified",
+			method:             "GET",
+			headers:            map[string]string{"If-None-Match": `"2", "3"`},
+			expectedStatusCode: 304,
+			expectedETag:       `"3"`,
+		},
+		{
+			name:               "Read modified",
+			method:             "GET",
+			headers:            map[string]string{"If-None-Match": `W/"2"`},
+			expectedStatusCode: 200,
+			expectedETag:       `"3"`,
+		},
+		{
+			name:               "Update current version",
+			method:             "PUT",
+			body:               fullBook,
+			headers:            map[string]string{"If-Match": `"3"`},
+			expectedStatusCode: 200,
+			expectedETag:       `"4"`,
+		},
+		{
+			name:               "Update stale version",
+			method:             "PUT",
+			body:               fullBook,
+			headers:            map[string]string{"If-Match": `"2"`},
+			expectedStatusCode: 412,
+		},
+		{
+			name:               "Update with a weak tag",
+			method:             "PUT",
+			body:               fullBook,
+			headers:            map[string]string{"If-Match": `W/"3"`},
+			expectedStatusCode: 412,
+		},
+		{
+			name:               "Partial update with PUT",
+			method:             "PUT",
+			body:               `{"title":"New title"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Patch with an unknown format",
+			method:             "PATCH",
+			body:               `title=New`,
+			headers:            map[string]string{"Content-Type": "text/plain"},
+			expectedStatusCode: 415,
+		},
+		{
+			name:               "Update without a precondition",
+			method:             "PUT",
+			body:               fullBook,
+			expectedStatusCode: 200,
+			expectedETag:       `"4"`,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books := &fakeBooks{book: domain.Book{ID: 1, Title: "Title", Version: 3}}
+
+			handler := NewHandler(books, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.GET("/books/:id", handler.getBook)
+			r.PUT("/books/:id", handler.updateBook)
+			r.PATCH("/books/:id", handler.patchBook)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(testCase.method, "/books/1", bytes.NewBufferString(testCase.body))
+			for k, v := range testCase.headers {
+				req.Header.Set(k, v)
+			}
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get("ETag"), testCase.expectedETag)
+		})
+	}
+}
+
+func (f *fakeBooks) Export(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error {
+	if f.book.ID == 0 {
+		return errors.New("connection refused")
+	}
+
+	f.filter = filter
+
+	return fn(f.book)
+}
+
+func TestRest_exportBooks(t *testing.T) {
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name               string
+		query              string
+		acceptEncoding     string
+		book               domain.Book
+		expectedStatusCode int
+		expectedEncoding   string
+		expectedBody       string
+		expectedFilter     domain.BookFilter
+	}{
+		{
+			name:               "CSV",
+			book:               domain.Book{ID: 1, Title: "Title", Author: "Author", PublishDate: published, Rating: 5, Version: 2},
+			expectedStatusCode: 200,
+			expectedBody:       "id,title,author,publish_date,rating,isbn,owner_id,version\n1,Title,Author,2020-01-02T00:00:00Z,5,,,2\n",
+		},
+		{
+			name:               "Gzipped NDJSON",
+			query:              "?format=ndjson",
+			acceptEncoding:     "deflate, gzip;q=0.5",
+			book:               domain.Book{ID: 1, Title: "Title", Author: "Author", PublishDate: published, Rating: 5, ISBN: "978-3-16-148410-0", OwnerID: 7, Version: 2},
+			expectedStatusCode: 200,
+			expectedEncoding:   "gzip",
+			expectedBody:       `{"author":"Author","id":1,"isbn":"978-3-16-148410-0","owner_id":7,"publish_date":"2020-01-02T00:00:00Z","rating":5,"title":"Title","version":2}` + "\n",
+		},
+		{
+			name:               "Refused gzip",
+			acceptEncoding:     "gzip;q=0",
+			book:               domain.Book{ID: 1, Title: "Title", Author: "Author", PublishDate: published, Rating: 5, Version: 2},
+			expectedStatusCode: 200,
+			expectedBody:       "id,title,author,publish_date,rating,isbn,owner_id,version\n1,Title,Author,2020-01-02T00:00:00Z,5,,,2\n",
+		},
+		{
+			name:               "Filtered",
+			query:              "?title=go&author=pike&owner_id=7&min_rating=3&max_rating=5",
+			book:               domain.Book{ID: 1, Title: "Title", Author: "Author", PublishDate: published, Rating: 5, Version: 2},
+			expectedStatusCode: 200,
+			expectedBody:       "id,title,author,publish_date,rating,isbn,owner_id,version\n1,Title,Author,2020-01-02T00:00:00Z,5,,,2\n",
+			expectedFilter:     domain.BookFilter{Title: "go", Author: "pike", OwnerID: 7, MinRating: 3, MaxRating: 5},
+		},
+		{
+			name:               "Unsupported format",
+			query:              "?format=xml",
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Invalid rating",
+			query:              "?min_rating=high",
+			book:               domain.Book{ID: 1},
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Failing query",
+			expectedStatusCode: 500,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books := &fakeBooks{book: testCase.book}
+			handler := NewHandler(books, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.GET("/books/export", handler.exportBooks)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/books/export"+testCase.query, nil)
+			req.Header.Set("Accept-Encoding", testCase.acceptEncoding)
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get("Content-Encoding"), testCase.expectedEncoding)
+			assert.Equal(t, books.filter, testCase.expectedFilter)
+
+			if testCase.expectedBody == "" {
+				return
+			}
+
+			var body io.Reader = w.Body
+			if testCase.expectedEncoding == "gzip" {
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			}
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(got), testCase.expectedBody)
+		})
+	}
+}
+
+func TestRest_bookHistoryActor(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	repo := mock_service.NewMockUserStorage(c)
+	repo.EXPECT().GetByID(gomock.Any(), int64(7)).Return(domain.User{ID: 7}, nil).AnyTimes()
+	users := &service.Users{Repo: repo, HmacSecret: []byte("secret")}
+
+	db := memory.NewDB()
+	books := service.NewBooksStorage(memory.NewBookRepository(db), memory.NewBookRevisions(db), memory.NewTransactor(db), service.DefaultBookRules)
+
+	r := NewHandler(books, users, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil).InitGinRouter()
+	token := signTestToken(t, users, 7)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := serve(http.MethodPost, "/books", `{"title":"Title","author":"Author","publish_date":"2020-01-02T00:00:00Z","rating":3}`)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	path := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	w = serve(http.MethodDelete, path, "")
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = serve(http.MethodGet, path+"/history", "")
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	var history []domain.BookHistoryEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(history), 2)
+	for _, entry := range history {
+		assert.Equal(t, entry.ActorID, int64(7))
+	}
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+)
+
+// bookETag is a strong validator, a version of a book always has the same representation.
+func bookETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// booksETag changes whenever a book of the list is added, removed or changed.
+func booksETag(books []domain.Book) string {
+	h := sha256.New()
+	for _, book := range books {
+		fmt.Fprintf(h, "%d:%d,", book.ID, book.Version)
+	}
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
+
+// ifMatchVersion returns the version expected by an If-Match header, 0 when the header is
+// missing or "*". ok is false when the header doesn't name a single version of ours, weak
+// tags never match here, see RFC 9110 section 13.1.1.
+func ifMatchVersion(header string) (version int64, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// noneMatch tells whether an If-None-Match header names etag using the weak comparison
+// of RFC 9110 section 13.1.2.
+func noneMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,240 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// heartbeatInterval keeps idle feeds from being closed by proxies.
+	heartbeatInterval = 15 * time.Second
+
+	// eventWriteTimeout drops a WebSocket client that doesn't read its events.
+	eventWriteTimeout = 10 * time.Second
+
+	// the query parameter and cookie the feeds take the tokens of /books/events/token from
+	eventsTokenParam  = "access_token"
+	eventsTokenCookie = "book_events_token"
+)
+
+// the origin is checked by the default of the upgrader, the feed is for the same site
+var upgrader = websocket.Upgrader{}
+
+// @Summary createBookEventsToken
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Issuing a token that opens the feeds of /books/events for a minute. EventSource and WebSocket can't send the Authorization header, the token goes in the access_token query parameter or the HttpOnly cookie set along. The feeds opened stay open after it expires.
+// @ID book-events-token
+// @Produce json
+// @Success 200 {object} domain.EventsToken "OK"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/events/token [post]
+func (h *Handler) createBookEventsToken(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	token, err := h.userService.EventsToken(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(eventsTokenCookie, token.Token, ceilSeconds(time.Until(token.ExpiresAt)), "/books/events", "", c.Request.TLS != nil, true)
+
+	c.JSON(http.StatusOK, token)
+}
+
+// @Summary streamBookEvents
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Pushing the changes to books as Server-Sent Events as they happen. The id of an event resumes the feed after it, through the Last-Event-ID header that EventSource sends when it reconnects. The feed ends when the client falls behind, reconnecting catches up. Deprecated in favour of GET /v1/books:watch, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
+// @ID book-events
+// @Produce text/event-stream
+// @Param author query string false "Only the books of this author"
+// @Param book_id query []int false "Only these books" collectionFormat(multi)
+// @Param Last-Event-ID header int false "The id of the last event received"
+// @Param last_event_id query int false "Last-Event-ID for clients that can't set it"
+// @Success 200 {object} domain.BookEvent
+// @Param access_token query string false "A token of /books/events/token, for clients that can't set the auth header"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/events [get]
+func (h *Handler) streamBookEvents(c *gin.Context) {
+	filter, lastEventID, err := bookEventsQuery(c)
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	events, err := h.booksService.Events(c.Request.Context(), lastEventID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// nginx would otherwise hold the events back
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.Matches(event) {
+				continue
+			}
+
+			if err := writeServerSentEvent(c.Writer, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		c.Writer.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event domain.BookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if event.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Action, data)
+
+	return err
+}
+
+// @Summary bookEventsWebSocket
+// @Security ApiKeyAuth
+// @Tags books
+// @Description The feed of /books/events over a WebSocket, each message is a JSON event. last_event_id resumes the feed after an event. The socket is closed when the client falls behind, reconnecting catches up. Deprecated in favour of GET /v1/books:watch, this route answers with Deprecation and Link headers while the gRPC gateway is served.
+// @Deprecated
+// @ID book-events-websocket
+// @Param author query string false "Only the books of this author"
+// @Param book_id query []int false "Only these books" collectionFormat(multi)
+// @Param last_event_id query int false "The id of the last event received"
+// @Success 101 {object} domain.BookEvent
+// @Param access_token query string false "A token of /books/events/token, for clients that can't set the auth header"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/events/ws [get]
+func (h *Handler) bookEventsWebSocket(c *gin.Context) {
+	filter, lastEventID, err := bookEventsQuery(c)
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	events, err := h.booksService.Events(c.Request.Context(), lastEventID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// the upgrader answers a failed handshake itself
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// the client only sends control messages, reading them notices when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind, resume from the last event"),
+					time.Now().Add(eventWriteTimeout))
+				return
+			}
+			if !filter.Matches(event) {
+				continue
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func bookEventsQuery(c *gin.Context) (domain.BookEventFilter, int64, error) {
+	filter := domain.BookEventFilter{Author: c.Query("author")}
+
+	for _, param := range c.QueryArray("book_id") {
+		id, err := getIDFromRequest(param)
+		if err != nil {
+			return filter, 0, fmt.Errorf("book_id: %v", err)
+		}
+		filter.BookIDs = append(filter.BookIDs, id)
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID == "" {
+		return filter, 0, nil
+	}
+
+	id, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil || id < 0 {
+		return filter, 0, fmt.Errorf("the last event ID %q is invalid", lastEventID)
+	}
+
+	return filter, id, nil
+}
@@ -0,0 +1,213 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/service"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/magiconair/properties/assert"
+)
+
+func (f *fakeBooks) Events(ctx context.Context, lastEventID int64) (<-chan domain.BookEvent, error) {
+	events := make(chan domain.BookEvent, 3)
+
+	// the events after lastEventID, the feed ends after them
+	for _, event := range []domain.BookEvent{
+		{ID: 1, Action: domain.RevisionCreate, Book: domain.Book{ID: 1, Author: "Author"}},
+		{ID: 2, Action: domain.RevisionCreate, Book: domain.Book{ID: 2, Author: "Other"}},
+		{ID: 3, Action: domain.RevisionUpdate, Book: domain.Book{ID: 1, Author: "Author"}},
+	} {
+		if event.ID > lastEventID {
+			events <- event
+		}
+	}
+	close(events)
+
+	return events, nil
+}
+
+func newEventsRouter() *gin.Engine {
+	handler := NewHandler(&fakeBooks{}, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+	r := gin.New()
+	r.Use(problemMiddleware)
+	r.GET("/books/events", handler.streamBookEvents)
+	r.GET("/books/events/ws", handler.bookEventsWebSocket)
+
+	return r
+}
+
+func TestRest_streamBookEvents(t *testing.T) {
+	testTable := []struct {
+		name         string
+		target       string
+		lastEventID  string
+		expectedCode int
+		expectedIDs  []string
+	}{
+		{
+			name:         "All",
+			target:       "/books/events",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"1", "2", "3"},
+		},
+		{
+			name:         "Resumed",
+			target:       "/books/events",
+			lastEventID:  "1",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"2", "3"},
+		},
+		{
+			name:         "By author",
+			target:       "/books/events?author=author",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"1", "3"},
+		},
+		{
+			name:         "By book",
+			target:       "/books/events?book_id=2&last_event_id=1",
+			expectedCode: http.StatusOK,
+			expectedIDs:  []string{"2"},
+		},
+		{
+			name:         "Invalid last event ID",
+			target:       "/books/events",
+			lastEventID:  "last",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testCase.target, nil)
+			if testCase.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", testCase.lastEventID)
+			}
+
+			w := httptest.NewRecorder()
+			newEventsRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedCode)
+			if w.Code != http.StatusOK {
+				return
+			}
+			assert.Equal(t, w.Header().Get("Content-Type"), "text/event-stream")
+
+			ids := make([]string, 0)
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				if id, ok := strings.CutPrefix(line, "id: "); ok {
+					ids = append(ids, id)
+				}
+			}
+			assert.Equal(t, ids, testCase.expectedIDs)
+		})
+	}
+}
+
+func TestRest_bookEventsWebSocket(t *testing.T) {
+	srv := httptest.NewServer(newEventsRouter())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/books/events/ws?author=Author&last_event_id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var event domain.BookEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, event.ID, int64(3))
+	assert.Equal(t, event.Action, domain.RevisionUpdate)
+
+	// the feed of the fake ends there, the client is told to resume later
+	_, _, err = conn.ReadMessage()
+	assert.Equal(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), true)
+}
+
+func TestRest_eventsAuthMiddleware(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	repo := mock_service.NewMockUserStorage(c)
+	repo.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1}, nil).AnyTimes()
+
+	users := &service.Users{Repo: repo, HmacSecret: []byte("secret")}
+	handler := NewHandler(&fakeBooks{}, users, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+	r := handler.InitGinRouter()
+
+	accessToken := signTestToken(t, users, 1)
+	eventsToken, err := users.EventsToken(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		name         string
+		target       string
+		header       string
+		cookie       string
+		expectedCode int
+	}{
+		{
+			name:         "Access token in the header",
+			target:       "/books/events",
+			header:       accessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Events token in the query",
+			target:       "/books/events?access_token=" + eventsToken.Token,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Events token in the cookie",
+			target:       "/books/events",
+			cookie:       eventsToken.Token,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Access token in the query",
+			target:       "/books/events?access_token=" + accessToken,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Events token for another route",
+			target:       "/books/trash",
+			header:       eventsToken.Token,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "No token",
+			target:       "/books/events",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testCase.target, nil)
+			if testCase.header != "" {
+				req.Header.Set("Authorization", "Bearer "+testCase.header)
+			}
+			if testCase.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: eventsTokenCookie, Value: testCase.cookie})
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedCode)
+		})
+	}
+}
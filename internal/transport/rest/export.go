@@ -0,0 +1,191 @@
+package rest
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/tabular"
+	"github.com/gin-gonic/gin"
+)
+
+var exportColumns = []tabular.Column{
+	{Name: "id", Kind: tabular.Int},
+	{Name: "title", Kind: tabular.String},
+	{Name: "author", Kind: tabular.String},
+	{Name: "publish_date", Kind: tabular.Time},
+	{Name: "rating", Kind: tabular.Int},
+	{Name: "isbn", Kind: tabular.String},
+	{Name: "owner_id", Kind: tabular.Int},
+	{Name: "version", Kind: tabular.Int},
+}
+
+// @Summary exportBooks
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Streaming the books of the listing as a file, ordered by ID. The filters match like those of the book list. The response is compressed when the client accepts gzip.
+// @ID export-books
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce application/vnd.apache.parquet
+// @Param format query string false "csv (default), ndjson, xlsx or parquet"
+// @Param title query string false "Part of the title"
+// @Param author query string false "Part of the author"
+// @Param owner_id query int false "Owner ID"
+// @Param min_rating query int false "Lowest rating"
+// @Param max_rating query int false "Highest rating"
+// @Param Accept-Encoding header string false "gzip to compress the response"
+// @Success 200 {file} file "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/export [get]
+func (h *Handler) exportBooks(c *gin.Context) {
+	format := c.DefaultQuery("format", tabular.CSV)
+	switch format {
+	case tabular.CSV, tabular.NDJSON, tabular.XLSX, tabular.Parquet:
+	default:
+		c.Error(invalidInput(fmt.Errorf("unsupported export format %q", format)))
+		return
+	}
+
+	filter, err := bookFilterQuery(c)
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	export := &bookExport{c: c, format: format}
+
+	// the response is only started with the first book, so that a failing query is still
+	// reported as a problem
+	err = h.booksService.Export(c.Request.Context(), filter, export.write)
+	if err == nil && export.w == nil {
+		err = export.start()
+	}
+	if err != nil {
+		if export.w == nil {
+			c.Error(err)
+			return
+		}
+		// the headers are already sent, all we can do is to log it
+		logError("exportBooks", "streaming books", err)
+		return
+	}
+
+	if err := export.close(); err != nil {
+		logError("exportBooks", "finishing the file", err)
+	}
+}
+
+func bookFilterQuery(c *gin.Context) (domain.BookFilter, error) {
+	filter := domain.BookFilter{Title: c.Query("title"), Author: c.Query("author")}
+
+	if ownerID := c.Query("owner_id"); ownerID != "" {
+		id, err := getIDFromRequest(ownerID)
+		if err != nil {
+			return filter, fmt.Errorf("owner_id: %v", err)
+		}
+		filter.OwnerID = id
+	}
+
+	for param, rating := range map[string]*int{"min_rating": &filter.MinRating, "max_rating": &filter.MaxRating} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %v", param, err)
+		}
+		*rating = n
+	}
+
+	return filter, nil
+}
+
+type bookExport struct {
+	c      *gin.Context
+	format string
+	gz     *gzip.Writer
+	w      tabular.Writer
+}
+
+func (e *bookExport) start() error {
+	e.c.Header("Content-Type", tabular.ContentType(e.format))
+	e.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "books."+e.format))
+	e.c.Header("Vary", "Accept-Encoding")
+
+	var out io.Writer = e.c.Writer
+	if acceptsGzip(e.c.GetHeader("Accept-Encoding")) {
+		e.c.Header("Content-Encoding", "gzip")
+		e.gz = gzip.NewWriter(e.c.Writer)
+		out = e.gz
+	}
+
+	e.c.Status(http.StatusOK)
+	e.c.Writer.WriteHeaderNow()
+
+	w, err := tabular.NewWriter(e.format, out, exportColumns)
+	if err != nil {
+		return err
+	}
+	e.w = w
+
+	return nil
+}
+
+func (e *bookExport) write(book domain.Book) error {
+	if e.w == nil {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	// missing ISBNs and owners are exported as missing values rather than "" and 0
+	var isbn, ownerID interface{}
+	if book.ISBN != "" {
+		isbn = book.ISBN
+	}
+	if book.OwnerID != 0 {
+		ownerID = book.OwnerID
+	}
+
+	return e.w.Write([]interface{}{
+		book.ID, book.Title, book.Author, book.PublishDate, int64(book.Rating), isbn, ownerID, book.Version,
+	})
+}
+
+func (e *bookExport) close() error {
+	if err := e.w.Close(); err != nil {
+		return err
+	}
+
+	if e.gz != nil {
+		return e.gz.Close()
+	}
+
+	return nil
+}
+
+// acceptsGzip ignores gzip when the client gave it a quality of 0.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+const gatewayBooksPrefix = "/v1/books"
+
+// ServeGateway serves the /v1 API generated from the protos by gateway, it must be called
+// before InitGinRouter. The routes of /books the gateway replaces are deprecated then.
+func (h *Handler) ServeGateway(gateway http.Handler) {
+	h.gateway = gateway
+}
+
+// initGateway puts the books of /v1 behind the middlewares of /books. Its paths, such as
+// /v1/books:watch, don't fit the gin router, so they are told apart here. The auth calls
+// are limited by the gRPC server, with the budget of /auth.
+func (h Handler) initGateway(router *gin.Engine) {
+	books := func(middleware gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if !strings.HasPrefix(c.Request.URL.Path, gatewayBooksPrefix) {
+				c.Next()
+				return
+			}
+
+			middleware(c)
+		}
+	}
+
+	router.Any("/v1/*path",
+		books(h.authMiddleware),
+		books(h.scopeMiddleware(domain.ScopeBooks)),
+		books(h.rateLimitMiddleware("books", h.rateLimits.Books, userKey)),
+		books(h.idempotencyMiddleware),
+		gin.WrapH(h.gateway),
+	)
+}
+
+// deprecated marks the routes replaced by the /v1 API, see the Deprecation header draft
+// and RFC 8288. {id} in successor is the ID of the route.
+func (h Handler) deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.gateway != nil {
+			c.Header("Deprecation", "true")
+			c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", strings.ReplaceAll(successor, "{id}", c.Param("id"))))
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/service"
+	mock_service "github.com/andy-ahmedov/crud_service/internal/service/mocks"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestRest_gateway(t *testing.T) {
+	testTable := []struct {
+		name               string
+		method             string
+		path               string
+		signedIn           bool
+		expectedStatusCode int
+		expectedCalls      int
+	}{
+		{
+			name:               "Auth without a token",
+			method:             http.MethodPost,
+			path:               "/v1/auth/sign-in",
+			expectedStatusCode: 200,
+			expectedCalls:      2,
+		},
+		{
+			name:               "Books without a token",
+			method:             http.MethodGet,
+			path:               "/v1/books",
+			expectedStatusCode: 401,
+		},
+		{
+			name:               "Watch without a token",
+			method:             http.MethodGet,
+			path:               "/v1/books:watch",
+			expectedStatusCode: 401,
+		},
+		{
+			name:               "Books over the limit",
+			method:             http.MethodGet,
+			path:               "/v1/books/1",
+			signedIn:           true,
+			expectedStatusCode: 429,
+			expectedCalls:      1,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			repo := mock_service.NewMockUserStorage(c)
+			repo.EXPECT().GetByID(gomock.Any(), int64(1)).Return(domain.User{ID: 1}, nil).AnyTimes()
+
+			users := &service.Users{Repo: repo, HmacSecret: []byte("secret")}
+			rateLimits := RateLimits{Store: ratelimit.NewMemoryStore(), Books: ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 1}}
+			handler := NewHandler(nil, users, nil, nil, nil, nil, nil, rateLimits, Idempotency{}, nil)
+
+			calls := 0
+			handler.ServeGateway(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+			}))
+			r := handler.InitGinRouter()
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(testCase.method, testCase.path, nil)
+				if testCase.signedIn {
+					req.Header.Set("Authorization", "Bearer "+signTestToken(t, users, 1))
+				}
+
+				w = httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+			}
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, calls, testCase.expectedCalls)
+		})
+	}
+}
+
+func TestRest_deprecated(t *testing.T) {
+	testTable := []struct {
+		name         string
+		gateway      http.Handler
+		expectedLink string
+	}{
+		{
+			name:         "Gateway served",
+			gateway:      http.NotFoundHandler(),
+			expectedLink: `</v1/books/5>; rel="successor-version"`,
+		},
+		{
+			name: "No gateway",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := NewHandler(nil, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+			handler.ServeGateway(testCase.gateway)
+
+			r := gin.New()
+			r.GET("/books/:id", handler.deprecated(gatewayBooksPrefix+"/{id}"), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books/5", nil))
+
+			assert.Equal(t, w.Header().Get("Link"), testCase.expectedLink)
+			assert.Equal(t, w.Header().Get("Deprecation") == "true", testCase.gateway != nil)
+		})
+	}
+}
+
+func signTestToken(t *testing.T, users *service.Users, id int64) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Subject:   strconv.FormatInt(id, 10),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}).SignedString(users.HmacSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
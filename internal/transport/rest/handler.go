@@ -3,10 +3,14 @@ package rest
 import (
 	"context"
 	"errors"
+	"io"
+	"net/http"
 	"strconv"
+	"time"
 
 	_ "github.com/andy-ahmedov/crud_service/docs"
 	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -16,61 +20,245 @@ type BooksRepository interface {
 	Create(ctx context.Context, book *domain.Book) error
 	GetByID(ctx context.Context, id int64) (domain.Book, error)
 	GetAll(ctx context.Context) ([]domain.Book, error)
-	Delete(ctx context.Context, id int64) error
-	Update(ctx context.Context, id int64, updBook domain.UpdateBookInput) error
+	Export(ctx context.Context, filter domain.BookFilter, fn func(domain.Book) error) error
+	Delete(ctx context.Context, id, expectedVersion int64) error
+	Update(ctx context.Context, id, expectedVersion int64, updBook domain.UpdateBookInput) (domain.Book, error)
+	Patch(ctx context.Context, id, expectedVersion int64, patch domain.BookPatch) (domain.Book, error)
+	Trash(ctx context.Context) ([]domain.Book, error)
+	Restore(ctx context.Context, id int64) (domain.Book, error)
+	Purge(ctx context.Context, id int64) error
+	History(ctx context.Context, id int64) ([]domain.BookHistoryEntry, error)
+	GetAsOf(ctx context.Context, id int64, at time.Time) (domain.Book, error)
+	Revert(ctx context.Context, id, revision, expectedVersion int64) (domain.Book, error)
+	Batch(ctx context.Context, batch domain.BookBatch) ([]domain.BookOperationResult, error)
+	Events(ctx context.Context, lastEventID int64) (<-chan domain.BookEvent, error)
 }
 
 type UserRepository interface {
 	SignUp(ctx context.Context, inp domain.SignUpInput) error
 	SignIn(ctx context.Context, inp domain.SignInInput) (string, string, error)
-	ParseToken(ctx context.Context, token string) (int64, error)
+	ParseAccessToken(ctx context.Context, token string) (domain.AccessToken, error)
+	EventsToken(ctx context.Context, userID int64) (domain.EventsToken, error)
 	RefreshTokens(ctx context.Context, refreshToken string) (string, string, error)
+	GetByID(ctx context.Context, id int64) (domain.User, error)
+	Update(ctx context.Context, id int64, inp domain.UpdateUserInput) (domain.User, error)
+	ConfirmEmail(ctx context.Context, token string) error
+	ResetPassword(ctx context.Context, inp domain.ResetPasswordInput) error
+	Delete(ctx context.Context, id int64) error
+
+	IsAdmin(ctx context.Context, id int64) (bool, error)
+	List(ctx context.Context, filter domain.UserFilter) (domain.UserList, error)
+	Sessions(ctx context.Context, id int64) ([]domain.RefreshSession, error)
+	SetDisabled(ctx context.Context, id int64, disabled bool) (domain.User, error)
+	ForcePasswordReset(ctx context.Context, id int64) error
+	ChangeRole(ctx context.Context, id int64, role string) (domain.User, error)
+	Impersonate(ctx context.Context, adminID, userID int64) (domain.Impersonation, error)
+}
+
+type PrivacyService interface {
+	Export(ctx context.Context, userID int64) (domain.UserExport, error)
+	Erase(ctx context.Context, userID int64) (domain.ErasureReport, error)
+}
+
+type OIDCService interface {
+	LoginURL(ctx context.Context, provider string) (domain.OIDCLogin, error)
+	SignIn(ctx context.Context, provider, code string, login domain.OIDCLogin) (string, string, error)
+}
+
+type OAuthService interface {
+	RegisterClient(ctx context.Context, inp domain.RegisterClientInput) (domain.RegisteredClient, error)
+	ListClients(ctx context.Context) ([]domain.OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	Authorize(ctx context.Context, userID int64, req domain.AuthorizeRequest) (string, error)
+	Token(ctx context.Context, creds domain.ClientCredentials, req domain.TokenRequest) (domain.TokenResponse, error)
+	Introspect(ctx context.Context, creds domain.ClientCredentials, token string) (domain.Introspection, error)
+	Revoke(ctx context.Context, creds domain.ClientCredentials, token string) error
+}
+
+type ImportService interface {
+	Import(ctx context.Context, userID int64, opts domain.ImportOptions, src io.ReadCloser) (domain.ImportJob, error)
+	Job(ctx context.Context, userID, id int64) (domain.ImportJob, error)
+}
+
+type WebhookService interface {
+	Create(ctx context.Context, userID int64, inp domain.CreateWebhookInput) (domain.Webhook, error)
+	List(ctx context.Context, userID int64) ([]domain.Webhook, error)
+	Delete(ctx context.Context, userID, id int64) error
+	Deliveries(ctx context.Context, userID, webhookID int64) ([]domain.WebhookDelivery, error)
+	Redeliver(ctx context.Context, userID, webhookID, deliveryID int64) (domain.WebhookDelivery, error)
 }
 
-type errResponse struct {
-	Message string
+type RateLimits struct {
+	Store ratelimit.Store
+	Auth  ratelimit.Limit
+	Books ratelimit.Limit
+	Users ratelimit.Limit
 }
 
 type Handler struct {
-	booksService BooksRepository
-	userService  UserRepository
+	booksService   BooksRepository
+	userService    UserRepository
+	privacyService PrivacyService
+	oidcService    OIDCService
+	oauthService   OAuthService
+	importService  ImportService
+	webhookService WebhookService
+	rateLimits     RateLimits
+	idempotency    Idempotency
+	graphQL        http.Handler
+	gateway        http.Handler
 }
 
-func NewHandler(books BooksRepository, users UserRepository) *Handler {
+// NewHandler serves graphQL at /graphql to the signed-in users, nil leaves it out.
+func NewHandler(books BooksRepository, users UserRepository, privacy PrivacyService, oidc OIDCService, oauth OAuthService, imports ImportService, webhooks WebhookService, rateLimits RateLimits, idempotency Idempotency, graphQL http.Handler) *Handler {
 	return &Handler{
-		booksService: books,
-		userService:  users,
+		booksService:   books,
+		userService:    users,
+		privacyService: privacy,
+		oidcService:    oidc,
+		oauthService:   oauth,
+		importService:  imports,
+		webhookService: webhooks,
+		rateLimits:     rateLimits,
+		idempotency:    idempotency,
+		graphQL:        graphQL,
 	}
 }
 
 func (h Handler) InitGinRouter() *gin.Engine {
 	router := gin.Default()
 
-	router.Use(loggingMiddleware)
+	router.Use(requestIDMiddleware, loggingMiddleware, problemMiddleware)
 
 	auth := router.Group("/auth")
+	auth.Use(h.rateLimitMiddleware("auth", h.rateLimits.Auth, clientIPKey))
 	{
 		auth.POST("/sign-up", h.signUp)
 		auth.POST("/sign-in", h.signIn)
 		auth.POST("/refresh", h.refresh)
+		auth.POST("/confirm-email", h.confirmEmail)
+		auth.POST("/reset-password", h.resetPassword)
+
+		oidc := auth.Group("/oidc/:provider")
+		{
+			oidc.GET("/login", h.oidcLogin)
+			oidc.GET("/callback", h.oidcCallback)
+		}
+	}
+
+	oauth := router.Group("/oauth")
+	oauth.Use(h.rateLimitMiddleware("oauth", h.rateLimits.Auth, clientIPKey))
+	{
+		oauth.GET("/authorize", h.authMiddleware, h.oauthAuthorize)
+		oauth.POST("/token", h.oauthToken)
+		oauth.POST("/introspect", h.oauthIntrospect)
+		oauth.POST("/revoke", h.oauthRevoke)
+	}
+
+	users := router.Group("/users")
+	users.Use(h.authMiddleware, h.scopeMiddleware(domain.ScopeProfile), h.rateLimitMiddleware("users", h.rateLimits.Users, userKey), h.idempotencyMiddleware)
+	{
+		me := users.Group("/me")
+		{
+			me.GET("", h.getMe)
+			me.PATCH("", h.updateMe)
+			me.DELETE("", h.deleteMe)
+			me.GET("/export", h.exportMe)
+			me.POST("/erase", h.eraseMe)
+		}
 	}
 
 	books := router.Group("/books")
-	books.Use(h.authMiddleware)
+	books.Use(h.authMiddleware, h.scopeMiddleware(domain.ScopeBooks), h.rateLimitMiddleware("books", h.rateLimits.Books, userKey), h.idempotencyMiddleware)
 	{
-		books.POST("", h.createBook)
-		books.GET("", h.getAllBooks)
+		books.POST("", h.deprecated(gatewayBooksPrefix), h.createBook)
+		books.GET("", h.deprecated(gatewayBooksPrefix), h.getAllBooks)
+		books.GET("/trash", h.getTrash)
+		books.GET("/export", h.exportBooks)
+		books.POST("/events/token", h.createBookEventsToken)
+		books.POST("/batch", h.batchBooks)
+		books.POST("/import", h.importBooks)
+		books.GET("/import/:job", h.getImportJob)
 
 		id := books.Group("/:id")
 		{
-			id.GET("", h.getBook)
-			id.DELETE("", h.deleteBook)
-			id.PUT("", h.updateBook)
+			id.GET("", h.deprecated(gatewayBooksPrefix+"/{id}"), h.getBook)
+			id.DELETE("", h.deprecated(gatewayBooksPrefix+"/{id}"), h.deleteBook)
+			id.PUT("", h.deprecated(gatewayBooksPrefix+"/{id}"), h.updateBook)
+			id.PATCH("", h.deprecated(gatewayBooksPrefix+"/{id}"), h.patchBook)
+			id.POST("/restore", h.restoreBook)
+			id.GET("/history", h.getBookHistory)
+			id.POST("/revert/:revision", h.revertBook)
 		}
 	}
 
+	// the feeds also take the tokens of /books/events/token, EventSource and WebSocket can't
+	// send the Authorization header
+	events := router.Group("/books/events")
+	events.Use(h.eventsAuthMiddleware, h.scopeMiddleware(domain.ScopeBooks), h.rateLimitMiddleware("books", h.rateLimits.Books, userKey))
+	{
+		events.GET("", h.deprecated(gatewayBooksPrefix+":watch"), h.streamBookEvents)
+		events.GET("/ws", h.deprecated(gatewayBooksPrefix+":watch"), h.bookEventsWebSocket)
+	}
+
+	webhooks := router.Group("/webhooks")
+	webhooks.Use(h.authMiddleware, h.scopeMiddleware(domain.ScopeWebhooks), h.rateLimitMiddleware("webhooks", h.rateLimits.Users, userKey), h.idempotencyMiddleware)
+	{
+		webhooks.POST("", h.createWebhook)
+		webhooks.GET("", h.listWebhooks)
+
+		id := webhooks.Group("/:id")
+		{
+			id.DELETE("", h.deleteWebhook)
+			id.GET("/deliveries", h.listWebhookDeliveries)
+			id.POST("/deliveries/:delivery/redeliver", h.redeliverWebhook)
+		}
+	}
+
+	if h.graphQL != nil {
+		graphQL := router.Group("/graphql")
+		graphQL.Use(h.authMiddleware, h.scopeMiddleware(domain.ScopeBooks), h.rateLimitMiddleware("graphql", h.rateLimits.Books, userKey))
+		{
+			graphQL.GET("", gin.WrapH(h.graphQL))
+			graphQL.POST("", gin.WrapH(h.graphQL))
+		}
+	}
+
+	admin := router.Group("/admin")
+	admin.Use(h.authMiddleware, h.scopeMiddleware(domain.ScopeAdmin), h.adminMiddleware, h.rateLimitMiddleware("admin", h.rateLimits.Users, userKey), h.idempotencyMiddleware)
+	{
+		adminUsers := admin.Group("/users")
+		{
+			adminUsers.GET("", h.listUsers)
+
+			id := adminUsers.Group("/:id")
+			{
+				id.GET("", h.getUser)
+				id.GET("/sessions", h.getUserSessions)
+				id.POST("/disable", h.disableUser)
+				id.POST("/enable", h.enableUser)
+				id.POST("/reset-password", h.forcePasswordReset)
+				id.PUT("/role", h.changeUserRole)
+				id.POST("/impersonate", h.impersonateUser)
+				id.POST("/erase", h.eraseUser)
+			}
+		}
+
+		admin.DELETE("/books/:id", h.purgeBook)
+
+		clients := admin.Group("/oauth/clients")
+		{
+			clients.POST("", h.registerOAuthClient)
+			clients.GET("", h.listOAuthClients)
+			clients.DELETE("/:client_id", h.deleteOAuthClient)
+		}
+	}
+
+	if h.gateway != nil {
+		h.initGateway(router)
+	}
+
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	router.Run()
 
 	return router
 }
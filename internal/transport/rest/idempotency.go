@@ -0,0 +1,189 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/idempotency"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	defaultIdempotencyTTL     = 24 * time.Hour
+	maxBufferedIdempotentBody = 1 << 20
+
+	// idempotencyLock keeps a key taken while its request is handled, a request that
+	// never finishes gives it up after that
+	idempotencyLock = 5 * time.Minute
+)
+
+var idempotencyKeyPattern = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+// replayedHeaders are the headers of a response set by the handlers, the rest belong to
+// the request they answered.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+type Idempotency struct {
+	Store idempotency.Store
+	// TTL is how long a response is replayed, 24h by default
+	TTL time.Duration
+}
+
+// idempotencyMiddleware answers a repeated POST, PUT, PATCH or DELETE with an
+// Idempotency-Key header with the response to the first one. Reusing a key for another
+// request is refused. Only the responses of the requests that succeeded are kept, a
+// request that failed can be retried with the same key. It must run after authMiddleware,
+// the keys of different users never clash.
+func (h *Handler) idempotencyMiddleware(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if h.idempotency.Store == nil || key == "" || !isMutation(c.Request.Method) {
+		c.Next()
+		return
+	}
+
+	if !idempotencyKeyPattern.MatchString(key) {
+		abortWithError(c, invalidInput(fmt.Errorf("the %s header must be 1 to 255 printable characters", idempotencyKeyHeader)))
+		return
+	}
+
+	fingerprint, err := fingerprintRequest(c.Request)
+	if err != nil {
+		abortWithError(c, invalidInput(err))
+		return
+	}
+
+	// the body put back may be a spooled file, the server only closes the one it read
+	defer c.Request.Body.Close()
+
+	ctx := c.Request.Context()
+	key = userKey(c) + ":" + key
+
+	// unlike the rate limiter, a request isn't let through without the store: it could be made twice
+	rec, reserved, err := h.idempotency.Store.Reserve(ctx, key, fingerprint, idempotencyLock)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if !reserved {
+		switch {
+		case rec.Fingerprint != fingerprint:
+			abortWithError(c, domain.ErrIdempotencyKeyReused)
+		case !rec.Done:
+			abortWithError(c, domain.ErrIdempotencyKeyInUse)
+		default:
+			replay(c, rec)
+		}
+		return
+	}
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	c.Next()
+
+	// the request is handled by now, a client gone in the meantime keeps it from being recorded
+	ctx = context.WithoutCancel(ctx)
+
+	status := w.Status()
+	if len(c.Errors) > 0 || status >= http.StatusInternalServerError {
+		if err := h.idempotency.Store.Release(ctx, key); err != nil {
+			logError("idempotencyMiddleware", "releasing the key", err)
+		}
+		return
+	}
+
+	rec = idempotency.Record{Fingerprint: fingerprint, Done: true, Status: status, Header: make(http.Header), Body: w.body.Bytes()}
+	for _, name := range replayedHeaders {
+		if value := w.Header().Get(name); value != "" {
+			rec.Header.Set(name, value)
+		}
+	}
+
+	ttl := h.idempotency.TTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	if err := h.idempotency.Store.Save(ctx, key, rec, ttl); err != nil {
+		logError("idempotencyMiddleware", "saving the response", err)
+	}
+}
+
+func replay(c *gin.Context, rec idempotency.Record) {
+	for name, values := range rec.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header(idempotentReplayedHeader, "true")
+
+	c.Status(rec.Status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(rec.Body)
+	c.Abort()
+}
+
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// fingerprintRequest hashes the method, the URL and the body of the request and puts the
+// body back. Bodies too large to be kept in memory, such as imports, are spooled to a file.
+func fingerprintRequest(req *http.Request) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.RequestURI())
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, io.TeeReader(req.Body, h), maxBufferedIdempotentBody+1)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if n <= maxBufferedIdempotentBody {
+		req.Body.Close()
+		req.Body = io.NopCloser(&buf)
+	} else {
+		// past the largest body accepted the handler refuses it anyway
+		rest := io.LimitReader(req.Body, maxImportSize+1-n)
+		body, err := spool(io.MultiReader(&buf, io.TeeReader(rest, h)))
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = body
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// recordingWriter keeps a copy of the body written to the client.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,256 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestHandler_idempotencyMiddleware(t *testing.T) {
+	handler := NewHandler(nil, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{Store: idempotency.NewMemoryStore()}, nil)
+
+	created, failures := 0, 1
+
+	r := gin.New()
+	r.Use(problemMiddleware, func(c *gin.Context) {
+		userID, _ := strconv.ParseInt(c.GetHeader("X-User"), 10, 64)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxUserID, userID))
+	}, handler.idempotencyMiddleware)
+	r.POST("/books", func(c *gin.Context) {
+		var book domain.Book
+		if err := c.ShouldBindJSON(&book); err != nil {
+			c.Error(invalidInput(err))
+			return
+		}
+
+		if book.Title == "Flaky" && failures > 0 {
+			failures--
+			c.Error(domain.ErrRateLimited)
+			return
+		}
+
+		created++
+		book.ID = int64(created)
+
+		c.Header("Location", "/books/"+strconv.Itoa(created))
+		c.JSON(http.StatusCreated, book)
+	})
+
+	testTable := []struct {
+		name               string
+		key                string
+		user               string
+		body               string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedReplayed   string
+		expectedCreated    int
+	}{
+		{
+			name:               "First request",
+			key:                "key-1",
+			body:               `{"title":"Title"}`,
+			expectedStatusCode: 201,
+			expectedLocation:   "/books/1",
+			expectedCreated:    1,
+		},
+		{
+			name:               "Retry",
+			key:                "key-1",
+			body:               `{"title":"Title"}`,
+			expectedStatusCode: 201,
+			expectedLocation:   "/books/1",
+			expectedReplayed:   "true",
+			expectedCreated:    1,
+		},
+		{
+			name:               "Reused key",
+			key:                "key-1",
+			body:               `{"title":"Another"}`,
+			expectedStatusCode: 422,
+			expectedCreated:    1,
+		},
+		{
+			name:               "Key of another user",
+			key:                "key-1",
+			user:               "2",
+			body:               `{"title":"Another"}`,
+			expectedStatusCode: 201,
+			expectedLocation:   "/books/2",
+			expectedCreated:    2,
+		},
+		{
+			name:               "No key",
+			body:               `{"title":"Title"}`,
+			expectedStatusCode: 201,
+			expectedLocation:   "/books/3",
+			expectedCreated:    3,
+		},
+		{
+			name:               "Invalid key",
+			key:                "key with spaces",
+			body:               `{"title":"Title"}`,
+			expectedStatusCode: 400,
+			expectedCreated:    3,
+		},
+		{
+			name:               "Failed request",
+			key:                "key-2",
+			body:               `{"title":"Flaky"}`,
+			expectedStatusCode: 429,
+			expectedCreated:    3,
+		},
+		{
+			name:               "Failed request retried",
+			key:                "key-2",
+			body:               `{"title":"Flaky"}`,
+			expectedStatusCode: 201,
+			expectedLocation:   "/books/4",
+			expectedCreated:    4,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			user := testCase.user
+			if user == "" {
+				user = "1"
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewBufferString(testCase.body))
+			req.Header.Set("X-User", user)
+			if testCase.key != "" {
+				req.Header.Set(idempotencyKeyHeader, testCase.key)
+			}
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get("Location"), testCase.expectedLocation)
+			assert.Equal(t, w.Header().Get(idempotentReplayedHeader), testCase.expectedReplayed)
+			assert.Equal(t, created, testCase.expectedCreated)
+		})
+	}
+}
+
+func TestHandler_idempotencyMiddleware_inProgress(t *testing.T) {
+	store := idempotency.NewMemoryStore()
+	handler := NewHandler(nil, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{Store: store}, nil)
+
+	r := gin.New()
+	r.Use(problemMiddleware, func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxUserID, int64(1)))
+	}, handler.idempotencyMiddleware)
+	r.DELETE("/books/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+	req.Header.Set(idempotencyKeyHeader, "key")
+
+	fingerprint, err := fingerprintRequest(req.Clone(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another replica is still handling the first request
+	store.Reserve(context.Background(), "user:1:key", fingerprint, idempotencyLock)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, http.StatusConflict)
+}
+
+// cancelAwareStore refuses to work with a cancelled context, as a database would.
+type cancelAwareStore struct {
+	*idempotency.MemoryStore
+}
+
+func (s cancelAwareStore) Save(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.MemoryStore.Save(ctx, key, rec, ttl)
+}
+
+func (s cancelAwareStore) Release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.MemoryStore.Release(ctx, key)
+}
+
+func TestHandler_idempotencyMiddleware_clientGone(t *testing.T) {
+	testTable := []struct {
+		name               string
+		fail               bool
+		expectedStatusCode int
+		expectedReplayed   string
+		expectedCalls      int
+	}{
+		{
+			name:               "Saved",
+			expectedStatusCode: http.StatusNoContent,
+			expectedReplayed:   "true",
+			expectedCalls:      1,
+		},
+		{
+			name:               "Released",
+			fail:               true,
+			expectedStatusCode: http.StatusNoContent,
+			expectedCalls:      2,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := NewHandler(nil, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{Store: cancelAwareStore{idempotency.NewMemoryStore()}}, nil)
+
+			calls := 0
+			var cancel context.CancelFunc
+
+			r := gin.New()
+			r.Use(problemMiddleware, func(c *gin.Context) {
+				ctx := context.WithValue(c.Request.Context(), ctxUserID, int64(1))
+				ctx, cancel = context.WithCancel(ctx)
+				c.Request = c.Request.WithContext(ctx)
+			}, handler.idempotencyMiddleware)
+			r.DELETE("/books/:id", func(c *gin.Context) {
+				calls++
+				// the client hangs up while the request is handled
+				cancel()
+
+				if testCase.fail && calls == 1 {
+					c.Error(domain.ErrRateLimited)
+					return
+				}
+				c.Status(http.StatusNoContent)
+			})
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+				req.Header.Set(idempotencyKeyHeader, "key")
+
+				w = httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+			}
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get(idempotentReplayedHeader), testCase.expectedReplayed)
+			assert.Equal(t, calls, testCase.expectedCalls)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/tabular"
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportSize = 64 << 20
+
+// @Summary importBooks
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Importing books from a CSV, JSON Lines or Excel file. Rows matching a book by ISBN, or by title and author without an ISBN, are skipped, update the book or fail. A dry run only validates the rows and returns the finished job, other imports run in the background and their job can be polled.
+// @ID import-books
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV, NDJSON or XLSX file with a header row"
+// @Param format formData string false "csv, ndjson or xlsx, taken from the file name by default"
+// @Param mapping formData string false "JSON object mapping book fields to columns, e.g. {\"title\":\"Name\"}"
+// @Param dry_run formData bool false "Validate without writing"
+// @Param on_duplicate formData string false "skip, update or fail (default)"
+// @Success 200 {object} domain.ImportJob "Finished dry run"
+// @Success 202 {object} domain.ImportJob "Accepted"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/import [post]
+func (h *Handler) importBooks(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var opts domain.ImportOptions
+	if err := c.ShouldBind(&opts); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if mapping := c.PostForm("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &opts.Mapping); err != nil {
+			c.Error(invalidInput(fmt.Errorf("reading the mapping: %w", err)))
+			return
+		}
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+	defer file.Close()
+
+	if opts.Format == "" {
+		opts.Format = tabular.FormatFromFilename(header.Filename)
+		if opts.Format == "" {
+			c.Error(invalidInput(errors.New("the format can't be told from the file name, set it explicitly")))
+			return
+		}
+	}
+
+	src, err := spool(file)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	job, err := h.importService.Import(c.Request.Context(), userID, opts, src)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if job.DryRun {
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/books/import/%d", job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// @Summary getImportJob
+// @Security ApiKeyAuth
+// @Tags books
+// @Description Polling the progress of an import, only the user who started it can see it.
+// @ID get-import-job
+// @Produce json
+// @Param job path int true "Import job ID"
+// @Success 200 {object} domain.ImportJob "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /books/import/{job} [get]
+func (h *Handler) getImportJob(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	id, err := getIDFromRequest(c.Param("job"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	job, err := h.importService.Job(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// spooledFile is removed once it is closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (f spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+
+	return err
+}
+
+// spool copies the upload, the files of a multipart form are removed when the request
+// is over while a background import still reads them.
+func spool(src io.Reader) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "book-import-*")
+	if err != nil {
+		return nil, err
+	}
+
+	file := spooledFile{tmp}
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -3,9 +3,15 @@ package rest
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/pkg/ratelimit"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,13 +20,16 @@ type CtxValue int
 
 const (
 	ctxUserID CtxValue = iota
+	ctxRequestID
+	ctxAccessToken
 )
 
 func loggingMiddleware(c *gin.Context) {
 	// log.Printf("%s: [%s] - %s ", time.Now().Format(time.RFC3339), r.Method, r.RequestURI)
 	log.WithFields(log.Fields{
-		"request": c.Request.Method,
-		"uri":     c.Request.RequestURI,
+		"request":    c.Request.Method,
+		"uri":        c.Request.RequestURI,
+		"request_id": c.GetString(requestIDHeader),
 	}).Info()
 	c.Next()
 }
@@ -28,22 +37,120 @@ func loggingMiddleware(c *gin.Context) {
 func (h *Handler) authMiddleware(c *gin.Context) {
 	token, err := getTokenFromRequest(c.Request)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		abortWithError(c, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err))
 		return
 	}
 
-	id, err := h.userService.ParseToken(c.Request.Context(), token)
+	h.authenticate(c, token, "")
+}
+
+// eventsAuthMiddleware also takes the tokens of Users.EventsToken, from the access_token
+// query parameter (RFC 6750 section 2.3) or the cookie set along with them.
+func (h *Handler) eventsAuthMiddleware(c *gin.Context) {
+	if token, err := getTokenFromRequest(c.Request); err == nil {
+		h.authenticate(c, token, "")
+		return
+	}
+
+	token := c.Query(eventsTokenParam)
+	if token == "" {
+		token, _ = c.Cookie(eventsTokenCookie)
+	}
+
+	if token == "" {
+		abortWithError(c, fmt.Errorf("%w: no token in the auth header, the %s parameter or the cookie", domain.ErrUnauthorized, eventsTokenParam))
+		return
+	}
+
+	h.authenticate(c, token, domain.AudienceBookEvents)
+}
+
+// authenticate only accepts the tokens issued for audience, the access tokens have none.
+func (h *Handler) authenticate(c *gin.Context, token, audience string) {
+	access, err := h.userService.ParseAccessToken(c.Request.Context(), token)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		abortWithError(c, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err))
+		return
+	}
+
+	if access.Audience != audience {
+		abortWithError(c, fmt.Errorf("%w: the token isn't meant for this route", domain.ErrUnauthorized))
+		return
+	}
+	id := access.UserID
+
+	// disabling a user ends its sessions, its access tokens are only stopped here
+	user, err := h.userService.GetByID(c.Request.Context(), id)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		abortWithError(c, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err))
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if user.Disabled {
+		abortWithError(c, domain.ErrUserDisabled)
 		return
 	}
 
 	ctx := context.WithValue(c.Request.Context(), ctxUserID, id)
+	ctx = context.WithValue(ctx, ctxAccessToken, access)
+	ctx = domain.WithActor(ctx, id)
 	c.Request = c.Request.WithContext(ctx)
 
 	c.Next()
 }
 
+// scopeMiddleware must run after authMiddleware. The tokens of OAuth2 clients need the
+// scope, or its read-only form for the safe methods.
+func (h *Handler) scopeMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, _ := c.Request.Context().Value(ctxAccessToken).(domain.AccessToken)
+
+		write := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+		if !access.Allows(scope, write) {
+			abortWithError(c, fmt.Errorf("%w: the token isn't granted the %s scope", domain.ErrForbidden, scope))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// adminMiddleware must run after authMiddleware.
+func (h *Handler) adminMiddleware(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	isAdmin, err := h.userService.IsAdmin(c.Request.Context(), id)
+	if err != nil {
+		logError("adminMiddleware", "reading user role", err)
+		abortWithError(c, domain.ErrForbidden)
+		return
+	}
+
+	if !isAdmin {
+		abortWithError(c, domain.ErrForbidden)
+		return
+	}
+
+	c.Next()
+}
+
+func getUserIDFromContext(c *gin.Context) (int64, error) {
+	id, ok := c.Request.Context().Value(ctxUserID).(int64)
+	if !ok {
+		return 0, domain.ErrUnauthorized
+	}
+
+	return id, nil
+}
+
 func getTokenFromRequest(r *http.Request) (string, error) {
 	token := r.Header.Get("Authorization")
 	if token == "" {
@@ -51,7 +158,8 @@ func getTokenFromRequest(r *http.Request) (string, error) {
 	}
 
 	sub := strings.Split(token, " ")
-	if len(sub) != 2 || sub[0] != "Beaver" {
+	// Bearer is what OAuth2 clients send, see RFC 6750
+	if len(sub) != 2 || (sub[0] != "Beaver" && sub[0] != "Bearer") {
 		return "", errors.New("invalid auth header")
 	}
 
@@ -61,3 +169,50 @@ func getTokenFromRequest(r *http.Request) (string, error) {
 
 	return sub[1], nil
 }
+
+func (h *Handler) rateLimitMiddleware(group string, limit ratelimit.Limit, key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.rateLimits.Store == nil || !limit.Enabled() {
+			c.Next()
+			return
+		}
+
+		res, err := h.rateLimits.Store.Take(c.Request.Context(), fmt.Sprintf("%s:%s", group, key(c)), limit)
+		if err != nil {
+			// the limiter must not take the API down with it
+			logError("rateLimitMiddleware", "rate limit storage error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			abortWithError(c, domain.ErrRateLimited)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func clientIPKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// userKey must run after authMiddleware, anonymous requests fall back to the client IP.
+func userKey(c *gin.Context) string {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		return clientIPKey(c)
+	}
+
+	return "user:" + strconv.FormatInt(id, 10)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,155 @@
+package rest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"google.golang.org/protobuf/proto"
+)
+
+// mimeYAML2 is registered with IANA, gin only knows the older application/x-yaml.
+const mimeYAML2 = "application/yaml"
+
+// bookMediaTypes are the formats books are read and written in, JSON first as the default.
+var bookMediaTypes = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML,
+	mimeYAML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+	binding.MIMEPROTOBUF,
+}
+
+// bookListXML gives a list of books the root element XML documents need.
+type bookListXML struct {
+	XMLName xml.Name      `xml:"books"`
+	Books   []domain.Book `xml:"book"`
+}
+
+// respond writes obj in the format the Accept header asks for. Only books and lists of
+// books can be sent as protobuf. Like RFC 7231 allows, the Accept header is ignored rather
+// than failing a request whose change has already been made when it asks for nothing
+// that can be produced, the response is JSON then.
+func respond(c *gin.Context, status int, obj interface{}) {
+	switch c.NegotiateFormat(bookMediaTypes...) {
+	case binding.MIMEXML, binding.MIMEXML2:
+		if books, ok := obj.([]domain.Book); ok {
+			obj = bookListXML{Books: books}
+		}
+		c.XML(status, obj)
+	case binding.MIMEYAML, mimeYAML2:
+		c.YAML(status, obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Render(status, render.MsgPack{Data: obj})
+	case binding.MIMEPROTOBUF:
+		if msg, ok := protoMessage(obj); ok {
+			c.ProtoBuf(status, msg)
+			return
+		}
+		c.JSON(status, obj)
+	default:
+		c.JSON(status, obj)
+	}
+}
+
+// acceptsProtobuf tells whether respond picks protobuf.
+func acceptsProtobuf(c *gin.Context) bool {
+	return c.NegotiateFormat(bookMediaTypes...) == binding.MIMEPROTOBUF
+}
+
+func protoMessage(obj interface{}) (proto.Message, bool) {
+	switch obj := obj.(type) {
+	case domain.Book:
+		return bookpb.FromBook(obj), true
+	case []domain.Book:
+		return bookpb.FromBooks(obj), true
+	default:
+		return nil, false
+	}
+}
+
+// bindBook decodes the body by its Content-Type, a missing one is taken for JSON. Errors
+// are reported like bindBody does.
+func bindBook(c *gin.Context, book *domain.Book) error {
+	if c.ContentType() != binding.MIMEPROTOBUF {
+		return bindBody(c, book)
+	}
+
+	var msg bookpb.Book
+	if err := readProto(c, &msg); err != nil {
+		return err
+	}
+	*book = bookpb.ToBook(&msg)
+
+	return validateBody(book)
+}
+
+func bindUpdateBookInput(c *gin.Context, upd *domain.UpdateBookInput) error {
+	if c.ContentType() != binding.MIMEPROTOBUF {
+		return bindBody(c, upd)
+	}
+
+	var msg bookpb.UpdateBookInput
+	if err := readProto(c, &msg); err != nil {
+		return err
+	}
+	*upd = bookpb.ToUpdateBookInput(&msg)
+
+	return validateBody(upd)
+}
+
+// bindBody reports a body that can't be read as invalid input and an unknown Content-Type
+// as domain.ErrUnsupportedMediaType.
+func bindBody(c *gin.Context, obj interface{}) error {
+	var b binding.Binding
+
+	switch c.ContentType() {
+	case "", binding.MIMEJSON:
+		b = binding.JSON
+	case binding.MIMEXML, binding.MIMEXML2:
+		b = binding.XML
+	case binding.MIMEYAML, mimeYAML2:
+		b = binding.YAML
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		b = binding.MsgPack
+	default:
+		return fmt.Errorf("%w: use one of %s", domain.ErrUnsupportedMediaType, strings.Join(bookMediaTypes, ", "))
+	}
+
+	if err := c.ShouldBindWith(obj, b); err != nil {
+		return invalidInput(err)
+	}
+
+	return nil
+}
+
+// validateBody checks the binding tags like gin does for the other formats.
+func validateBody(obj interface{}) error {
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		return invalidInput(err)
+	}
+
+	return nil
+}
+
+func readProto(c *gin.Context, msg proto.Message) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return invalidInput(err)
+	}
+
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return invalidInput(err)
+	}
+
+	return nil
+}
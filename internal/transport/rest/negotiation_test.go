@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/andy-ahmedov/crud_service/internal/transport/bookpb"
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRest_bookNegotiation(t *testing.T) {
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	update, err := proto.Marshal(&bookpb.UpdateBookInput{
+		Title:       proto.String("Proto title"),
+		Author:      proto.String("Author"),
+		PublishDate: timestamppb.New(published),
+		Rating:      proto.Int32(5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		name                string
+		method              string
+		body                []byte
+		contentType         string
+		accept              string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedTitle       string
+	}{
+		{
+			name:                "Default",
+			method:              "GET",
+			expectedStatusCode:  200,
+			expectedContentType: "application/json; charset=utf-8",
+			expectedTitle:       "Title",
+		},
+		{
+			name:                "XML",
+			method:              "GET",
+			accept:              "application/xml",
+			expectedStatusCode:  200,
+			expectedContentType: "application/xml; charset=utf-8",
+			expectedTitle:       "Title",
+		},
+		{
+			name:                "YAML",
+			method:              "GET",
+			accept:              "application/yaml",
+			expectedStatusCode:  200,
+			expectedContentType: "application/x-yaml; charset=utf-8",
+			expectedTitle:       "Title",
+		},
+		{
+			name:                "MessagePack",
+			method:              "GET",
+			accept:              "application/msgpack",
+			expectedStatusCode:  200,
+			expectedContentType: "application/msgpack; charset=utf-8",
+			expectedTitle:       "Title",
+		},
+		{
+			name:                "Unknown type",
+			method:              "GET",
+			accept:              "text/html",
+			expectedStatusCode:  200,
+			expectedContentType: "application/json; charset=utf-8",
+			expectedTitle:       "Title",
+		},
+		{
+			name:                "Protobuf update",
+			method:              "PUT",
+			body:                update,
+			contentType:         "application/x-protobuf",
+			accept:              "application/x-protobuf",
+			expectedStatusCode:  200,
+			expectedContentType: "application/x-protobuf",
+			expectedTitle:       "Proto title",
+		},
+		{
+			name:                "XML update",
+			method:              "PUT",
+			body:                []byte(`<book><title>XML title</title><author>Author</author><publish_date>2020-01-02T00:00:00Z</publish_date><rating>5</rating></book>`),
+			contentType:         "application/xml",
+			expectedStatusCode:  200,
+			expectedContentType: "application/json; charset=utf-8",
+			expectedTitle:       "XML title",
+		},
+		{
+			name:                "Incomplete protobuf update",
+			method:              "PUT",
+			body:                []byte{},
+			contentType:         "application/x-protobuf",
+			expectedStatusCode:  400,
+			expectedContentType: problemContentType,
+		},
+		{
+			name:                "Unsupported content type",
+			method:              "PUT",
+			body:                []byte("title=Title"),
+			contentType:         "text/plain",
+			expectedStatusCode:  415,
+			expectedContentType: problemContentType,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			books := &fakeBooks{book: domain.Book{ID: 1, Title: "Title", Author: "Author", PublishDate: published, Version: 3}}
+
+			handler := NewHandler(books, nil, nil, nil, nil, nil, nil, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware)
+			r.GET("/books/:id", handler.getBook)
+			r.PUT("/books/:id", handler.updateBook)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(testCase.method, "/books/1", bytes.NewReader(testCase.body))
+			req.Header.Set("Content-Type", testCase.contentType)
+			req.Header.Set("Accept", testCase.accept)
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get("Content-Type"), testCase.expectedContentType)
+
+			if testCase.expectedTitle == "" {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(testCase.expectedContentType, "application/x-protobuf"):
+				var msg bookpb.Book
+				if err := proto.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, msg.GetTitle(), testCase.expectedTitle)
+				assert.Equal(t, msg.GetPublishDate().AsTime(), published)
+			case strings.HasPrefix(testCase.expectedContentType, "application/msgpack"):
+				var book map[string]interface{}
+				if err := codec.NewDecoderBytes(w.Body.Bytes(), &codec.MsgpackHandle{}).Decode(&book); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, string(book["title"].([]byte)), testCase.expectedTitle)
+			default:
+				assert.Equal(t, strings.Contains(w.Body.String(), testCase.expectedTitle), true)
+			}
+		})
+	}
+}
+
+func TestRespond_bookListXML(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/books", nil)
+	c.Request.Header.Set("Accept", "text/xml")
+
+	respond(c, 200, []domain.Book{{ID: 1, Title: "Title"}, {ID: 2, Title: "Other"}})
+
+	assert.Equal(t, strings.HasPrefix(w.Body.String(), "<books><book><id>1</id><title>Title</title>"), true)
+	assert.Equal(t, strings.HasSuffix(w.Body.String(), "</book></books>"), true)
+}
@@ -0,0 +1,266 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary OAuthAuthorize
+// @Security ApiKeyAuth
+// @Tags oauth
+// @Description Authorization endpoint of RFC 6749 for our apps. The signed in user is redirected back to the client with an authorization code, PKCE is required for public clients.
+// @ID oauth-authorize
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "One of the registered redirect URIs"
+// @Param scope query string false "Space separated scopes, all allowed scopes by default"
+// @Param state query string false "Opaque value sent back to the client"
+// @Param code_challenge query string false "PKCE code challenge"
+// @Param code_challenge_method query string false "S256 or plain"
+// @Success 302 {string} string "Redirect to the client"
+// @Failure 400 {object} domain.OAuthError "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /oauth/authorize [get]
+func (h *Handler) oauthAuthorize(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req domain.AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		logError("oauthAuthorize", "reading query parameters", err)
+		c.JSON(http.StatusBadRequest, domain.NewOAuthError(domain.OAuthInvalidRequest, err.Error()))
+		return
+	}
+
+	redirect, err := h.oauthService.Authorize(c.Request.Context(), userID, req)
+	if err != nil {
+		var oauthErr *domain.OAuthError
+		if errors.As(err, &oauthErr) && oauthErr.RedirectURI != "" {
+			redirectOAuthError(oauthErr, c)
+			return
+		}
+
+		handleOAuthError("oauthAuthorize", err, c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// @Summary OAuthToken
+// @Tags oauth
+// @Description Token endpoint of RFC 6749 supporting the authorization_code, refresh_token and client_credentials grants. Clients authenticate with HTTP Basic or the client_id and client_secret form fields.
+// @ID oauth-token
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI used for the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Space separated scopes"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Success 200 {object} domain.TokenResponse "OK"
+// @Failure 400 {object} domain.OAuthError "Bad Request"
+// @Failure 401 {object} domain.OAuthError "Unauthorized"
+// @Failure 500 {object} domain.OAuthError "Internal Server Error"
+// @Router /oauth/token [post]
+func (h *Handler) oauthToken(c *gin.Context) {
+	var req domain.TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		logError("oauthToken", "reading form", err)
+		c.JSON(http.StatusBadRequest, domain.NewOAuthError(domain.OAuthInvalidRequest, err.Error()))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	resp, err := h.oauthService.Token(c.Request.Context(), clientCredentials(c), req)
+	if err != nil {
+		handleOAuthError("oauthToken", err, c)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// @Summary OAuthIntrospect
+// @Tags oauth
+// @Description Token introspection of RFC 7662 for authenticated clients. Unknown, expired and revoked tokens are reported as inactive.
+// @ID oauth-introspect
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access or refresh token"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Success 200 {object} domain.Introspection "OK"
+// @Failure 401 {object} domain.OAuthError "Unauthorized"
+// @Failure 500 {object} domain.OAuthError "Internal Server Error"
+// @Router /oauth/introspect [post]
+func (h *Handler) oauthIntrospect(c *gin.Context) {
+	introspection, err := h.oauthService.Introspect(c.Request.Context(), clientCredentials(c), c.PostForm("token"))
+	if err != nil {
+		handleOAuthError("oauthIntrospect", err, c)
+		return
+	}
+
+	c.JSON(http.StatusOK, introspection)
+}
+
+// @Summary OAuthRevoke
+// @Tags oauth
+// @Description Token revocation of RFC 7009. Refresh tokens of the client are revoked right away, access tokens expire on their own.
+// @ID oauth-revoke
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Refresh token"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} domain.OAuthError "Bad Request"
+// @Failure 401 {object} domain.OAuthError "Unauthorized"
+// @Failure 500 {object} domain.OAuthError "Internal Server Error"
+// @Router /oauth/revoke [post]
+func (h *Handler) oauthRevoke(c *gin.Context) {
+	if err := h.oauthService.Revoke(c.Request.Context(), clientCredentials(c), c.PostForm("token")); err != nil {
+		handleOAuthError("oauthRevoke", err, c)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// @Summary RegisterOAuthClient
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Registering an app that signs users in through this service. The client secret is only returned here.
+// @ID admin-register-oauth-client
+// @Accept json
+// @Produce json
+// @Param input body domain.RegisterClientInput true "Client metadata"
+// @Success 201 {object} domain.RegisteredClient "Created"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/oauth/clients [post]
+func (h *Handler) registerOAuthClient(c *gin.Context) {
+	var inp domain.RegisterClientInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	client, err := h.oauthService.RegisterClient(c.Request.Context(), inp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, client)
+}
+
+// @Summary ListOAuthClients
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Listing the registered OAuth clients.
+// @ID admin-list-oauth-clients
+// @Produce json
+// @Success 200 {array} domain.OAuthClient "OK"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/oauth/clients [get]
+func (h *Handler) listOAuthClients(c *gin.Context) {
+	clients, err := h.oauthService.ListClients(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+// @Summary DeleteOAuthClient
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Deleting an OAuth client, its refresh tokens stop working right away.
+// @ID admin-delete-oauth-client
+// @Produce json
+// @Param client_id path string true "Client ID"
+// @Success 200 {string} string "OK"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/oauth/clients/{client_id} [delete]
+func (h *Handler) deleteOAuthClient(c *gin.Context) {
+	if err := h.oauthService.DeleteClient(c.Request.Context(), c.Param("client_id")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// clientCredentials prefers HTTP Basic, the form fields are allowed by RFC 6749 section 2.3.1.
+func clientCredentials(c *gin.Context) domain.ClientCredentials {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		// the credentials are form encoded before they are put in the header
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+
+		return domain.ClientCredentials{ClientID: id, ClientSecret: secret}
+	}
+
+	return domain.ClientCredentials{ClientID: c.PostForm("client_id"), ClientSecret: c.PostForm("client_secret")}
+}
+
+func redirectOAuthError(oauthErr *domain.OAuthError, c *gin.Context) {
+	u, err := url.Parse(oauthErr.RedirectURI)
+	if err != nil {
+		handleOAuthError("oauthAuthorize", err, c)
+		return
+	}
+
+	query := u.Query()
+	query.Set("error", oauthErr.Code)
+	query.Set("error_description", oauthErr.Description)
+	if oauthErr.State != "" {
+		query.Set("state", oauthErr.State)
+	}
+	u.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// handleOAuthError answers the protocol endpoints, whose errors are defined by RFC 6749
+// rather than being problems.
+func handleOAuthError(handler string, err error, c *gin.Context) {
+	var oauthErr *domain.OAuthError
+
+	switch {
+	case errors.As(err, &oauthErr) && oauthErr.Code == domain.OAuthInvalidClient:
+		logError(handler, "client authentication failed", err)
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, oauthErr)
+	case errors.As(err, &oauthErr):
+		logError(handler, "invalid oauth request", err)
+		c.JSON(http.StatusBadRequest, oauthErr)
+	default:
+		// like the problems, unknown errors are only logged
+		logError(handler, "service error", err)
+		c.JSON(http.StatusInternalServerError, domain.NewOAuthError(domain.OAuthServerError, "The server failed to handle the request"))
+	}
+}
@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcCookie       = "oidc-login"
+	oidcCookieMaxAge = 600
+)
+
+// @Summary OIDCLogin
+// @Tags auth
+// @Description Redirecting to an external OpenID Connect provider to sign in.
+// @ID oidc-login
+// @Param provider path string true "Provider name from the configuration"
+// @Success 302 {string} string "Redirect to the provider"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /auth/oidc/{provider}/login [get]
+func (h *Handler) oidcLogin(c *gin.Context) {
+	login, err := h.oidcService.LoginURL(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// the provider sends the state back, the nonce and the PKCE verifier never leave the browser cookie
+	value := strings.Join([]string{login.State, login.Nonce, login.Verifier}, ".")
+	c.SetCookie(oidcCookie, value, oidcCookieMaxAge, "/auth/oidc", "localhost", false, true)
+
+	c.Redirect(http.StatusFound, login.URL)
+}
+
+// @Summary OIDCCallback
+// @Tags auth
+// @Description Finishing the sign in with an external OpenID Connect provider. The identity is linked to an account, which is created on the first login.
+// @ID oidc-callback
+// @Produce json
+// @Param provider path string true "Provider name from the configuration"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State issued by the login endpoint"
+// @Success 200 {string} gin.H "The JWT token was successfully generated."
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 409 {object} problem "Conflict"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *Handler) oidcCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.Error(fmt.Errorf("%w: the provider refused the login: %s", domain.ErrOIDCLoginFailed, providerErr))
+		return
+	}
+
+	cookie, err := c.Cookie(oidcCookie)
+	if err != nil {
+		c.Error(invalidInput(errors.New("the login has expired, please start again")))
+		return
+	}
+	c.SetCookie(oidcCookie, "", -1, "/auth/oidc", "localhost", false, true)
+
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 3 || parts[0] != c.Query("state") {
+		c.Error(invalidInput(errors.New("invalid state")))
+		return
+	}
+
+	login := domain.OIDCLogin{State: parts[0], Nonce: parts[1], Verifier: parts[2]}
+
+	accessToken, refreshToken, err := h.oidcService.SignIn(c.Request.Context(), c.Param("provider"), c.Query("code"), login)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.SetCookie("refresh-token", refreshToken, 0, "/auth", "localhost", false, true)
+	c.JSON(http.StatusOK, gin.H{"token": accessToken})
+}
@@ -0,0 +1,150 @@
+package rest
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary ExportMe
+// @Security ApiKeyAuth
+// @Tags users
+// @Description Exporting all data kept about the current user: profile, sessions, owned books and audit log references.
+// @ID export-me
+// @Produce json
+// @Produce application/zip
+// @Param format query string false "json (default) or zip"
+// @Success 200 {object} domain.UserExport "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /users/me/export [get]
+func (h *Handler) exportMe(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.Error(invalidInput(fmt.Errorf("unsupported export format %q", format)))
+		return
+	}
+
+	export, err := h.privacyService.Export(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	filename := fmt.Sprintf("user-%d-export.%s", id, format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "application/zip")
+
+	if err := writeExportZip(c.Writer, export); err != nil {
+		// the headers are already sent, all we can do is to log it
+		logError("exportMe", "writing zip archive", err)
+	}
+}
+
+// @Summary EraseMe
+// @Security ApiKeyAuth
+// @Tags users
+// @Description Erasing the personal data of the current user. The account is anonymized and disabled, owned books are handled according to the configured policy.
+// @ID erase-me
+// @Produce json
+// @Success 200 {object} domain.ErasureReport "OK"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /users/me/erase [post]
+func (h *Handler) eraseMe(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	report, err := h.privacyService.Erase(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.SetCookie("refresh-token", "", -1, "/auth", "localhost", false, true)
+	c.JSON(http.StatusOK, report)
+}
+
+// @Summary EraseUser
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Erasing the personal data of a user on their request.
+// @ID admin-erase-user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} domain.ErasureReport "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /admin/users/{id}/erase [post]
+func (h *Handler) eraseUser(c *gin.Context) {
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	report, err := h.privacyService.Erase(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func writeExportZip(w http.ResponseWriter, export domain.UserExport) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"books.json", export.Books},
+		{"audit.json", export.Audit},
+	}
+
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
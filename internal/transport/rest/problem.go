@@ -0,0 +1,187 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	problemContentType = "application/problem+json"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// problem is the error body of the API, see RFC 7807. Code is stable and meant for
+// clients, Detail is meant for humans.
+type problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	Code      string              `json:"code"`
+	RequestID string              `json:"request_id,omitempty"`
+	Errors    []domain.FieldError `json:"errors,omitempty"`
+}
+
+type problemKind struct {
+	err    error
+	status int
+	code   string
+	// opaque problems only show the message of err, not what it wraps
+	opaque bool
+}
+
+var problemKinds = []problemKind{
+	{err: domain.ErrInvalidInput, status: http.StatusBadRequest, code: "invalid_input"},
+	{err: domain.ErrMalformedPatch, status: http.StatusBadRequest, code: "malformed_patch"},
+	{err: domain.ErrEmailTokenInvalid, status: http.StatusBadRequest, code: "email_token_invalid"},
+	{err: domain.ErrResetTokenInvalid, status: http.StatusBadRequest, code: "reset_token_invalid"},
+	{err: domain.ErrUnauthorized, status: http.StatusUnauthorized, code: "unauthorized"},
+	{err: domain.ErrInvalidCredentials, status: http.StatusUnauthorized, code: "invalid_credentials"},
+	{err: domain.ErrRefreshTokenNotFound, status: http.StatusUnauthorized, code: "refresh_token_not_found"},
+	{err: domain.ErrRefreshTokenExpired, status: http.StatusUnauthorized, code: "refresh_token_expired"},
+	{err: domain.ErrOIDCLoginFailed, status: http.StatusUnauthorized, code: "oidc_login_failed", opaque: true},
+	{err: domain.ErrForbidden, status: http.StatusForbidden, code: "forbidden"},
+	{err: domain.ErrUserDisabled, status: http.StatusForbidden, code: "user_disabled"},
+	{err: domain.ErrPasswordResetNeeded, status: http.StatusForbidden, code: "password_reset_required"},
+	{err: domain.ErrUserNotFound, status: http.StatusNotFound, code: "user_not_found"},
+	{err: domain.ErrBookNotFound, status: http.StatusNotFound, code: "book_not_found"},
+	{err: domain.ErrRevisionNotFound, status: http.StatusNotFound, code: "revision_not_found"},
+	{err: domain.ErrImportJobNotFound, status: http.StatusNotFound, code: "import_job_not_found"},
+	{err: domain.ErrIdentityNotFound, status: http.StatusNotFound, code: "identity_not_found"},
+	{err: domain.ErrProviderNotFound, status: http.StatusNotFound, code: "provider_not_found"},
+	{err: domain.ErrOAuthClientNotFound, status: http.StatusNotFound, code: "oauth_client_not_found"},
+	{err: domain.ErrWebhookNotFound, status: http.StatusNotFound, code: "webhook_not_found"},
+	{err: domain.ErrDeliveryNotFound, status: http.StatusNotFound, code: "delivery_not_found"},
+	{err: domain.ErrUserAlreadyExists, status: http.StatusConflict, code: "user_already_exists"},
+	{err: domain.ErrDuplicateBook, status: http.StatusConflict, code: "duplicate_book"},
+	{err: domain.ErrIdempotencyKeyInUse, status: http.StatusConflict, code: "idempotency_key_in_use"},
+	{err: domain.ErrBookVersionMismatch, status: http.StatusPreconditionFailed, code: "version_mismatch"},
+	{err: domain.ErrUnsupportedMediaType, status: http.StatusUnsupportedMediaType, code: "unsupported_media_type"},
+	{err: domain.ErrInvalidPatch, status: http.StatusUnprocessableEntity, code: "invalid_patch"},
+	{err: domain.ErrIdempotencyKeyReused, status: http.StatusUnprocessableEntity, code: "idempotency_key_reused"},
+	{err: domain.ErrBatchAborted, status: http.StatusFailedDependency, code: "batch_aborted"},
+	{err: domain.ErrRateLimited, status: http.StatusTooManyRequests, code: "rate_limited"},
+}
+
+func newProblem(err error) problem {
+	var (
+		validationErr *domain.ValidationError
+		oauthErr      *domain.OAuthError
+	)
+
+	switch {
+	case errors.As(err, &validationErr):
+		return problemWithStatus(http.StatusUnprocessableEntity, "validation_failed", err.Error(), validationErr.Fields)
+	case errors.As(err, &oauthErr):
+		return problemWithStatus(http.StatusBadRequest, oauthErr.Code, oauthErr.Description, nil)
+	}
+
+	for _, kind := range problemKinds {
+		if !errors.Is(err, kind.err) {
+			continue
+		}
+
+		detail := err.Error()
+		if kind.opaque {
+			detail = kind.err.Error()
+		}
+
+		return problemWithStatus(kind.status, kind.code, detail, nil)
+	}
+
+	// unknown errors may tell too much about the internals, they are only logged
+	return problemWithStatus(http.StatusInternalServerError, "internal_error", "The server failed to handle the request", nil)
+}
+
+func problemWithStatus(status int, code, detail string, fields []domain.FieldError) problem {
+	return problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fields,
+	}
+}
+
+// problemMiddleware writes the last error reported with c.Error as a problem, unless
+// the handler has already responded. It must run before the handlers and the other
+// middlewares that report errors.
+func problemMiddleware(c *gin.Context) {
+	c.Next()
+
+	if len(c.Errors) == 0 {
+		return
+	}
+
+	err := c.Errors.Last().Err
+	p := newProblem(err)
+	p.Instance = c.Request.URL.Path
+	p.RequestID = c.GetString(requestIDHeader)
+
+	entry := log.WithFields(log.Fields{
+		"handler":    c.HandlerName(),
+		"request_id": p.RequestID,
+		"status":     p.Status,
+		"code":       p.Code,
+	})
+	if p.Status >= http.StatusInternalServerError {
+		entry.Error(err)
+	} else {
+		entry.Warn(err)
+	}
+
+	if c.Writer.Written() {
+		return
+	}
+
+	c.Header("Content-Type", problemContentType)
+	c.JSON(p.Status, p)
+}
+
+// requestIDMiddleware keeps the request ID sent by a proxy or makes a new one, it is
+// sent back in the X-Request-ID header and in problems.
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+
+	c.Set(requestIDHeader, id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxRequestID, id))
+	c.Header(requestIDHeader, id)
+
+	c.Next()
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// invalidInput marks an error of reading the request, its message is shown to the client.
+func invalidInput(err error) error {
+	return fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+}
+
+// abortWithError stops the chain of a middleware, problemMiddleware writes the response.
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
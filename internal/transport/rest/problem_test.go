@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestRest_problem(t *testing.T) {
+	testTable := []struct {
+		name               string
+		err                error
+		requestID          string
+		expectedStatusCode int
+		expectedCode       string
+		expectedDetail     string
+		expectedRequestID  string
+	}{
+		{
+			name:               "Not found",
+			err:                fmt.Errorf("reading book 1: %w", domain.ErrBookNotFound),
+			requestID:          "req-1",
+			expectedStatusCode: 404,
+			expectedCode:       "book_not_found",
+			expectedDetail:     "reading book 1: Book not found",
+			expectedRequestID:  "req-1",
+		},
+		{
+			name: "Validation",
+			err: &domain.ValidationError{Fields: []domain.FieldError{
+				{Field: "title", Message: "must not be blank"},
+			}},
+			requestID:          "req-2",
+			expectedStatusCode: 422,
+			expectedCode:       "validation_failed",
+			expectedRequestID:  "req-2",
+		},
+		{
+			name:               "Internal errors are hidden",
+			err:                errors.New("connection refused"),
+			requestID:          "req-3",
+			expectedStatusCode: 500,
+			expectedCode:       "internal_error",
+			expectedDetail:     "The server failed to handle the request",
+			expectedRequestID:  "req-3",
+		},
+		{
+			name:               "Opaque errors only show the kind",
+			err:                fmt.Errorf("%w: id token expired", domain.ErrOIDCLoginFailed),
+			requestID:          "req-4",
+			expectedStatusCode: 401,
+			expectedCode:       "oidc_login_failed",
+			expectedDetail:     domain.ErrOIDCLoginFailed.Error(),
+			expectedRequestID:  "req-4",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(requestIDMiddleware, problemMiddleware)
+			r.GET("/test", func(c *gin.Context) {
+				c.Error(testCase.err)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set(requestIDHeader, testCase.requestID)
+
+			r.ServeHTTP(w, req)
+
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			assert.Equal(t, w.Header().Get("Content-Type"), problemContentType)
+			assert.Equal(t, w.Header().Get(requestIDHeader), testCase.expectedRequestID)
+			assert.Equal(t, p.Status, testCase.expectedStatusCode)
+			assert.Equal(t, p.Code, testCase.expectedCode)
+			assert.Equal(t, p.Instance, "/test")
+			assert.Equal(t, p.RequestID, testCase.expectedRequestID)
+			if testCase.expectedDetail != "" {
+				assert.Equal(t, p.Detail, testCase.expectedDetail)
+			}
+		})
+	}
+}
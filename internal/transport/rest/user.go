@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary GetMe
+// @Security ApiKeyAuth
+// @Tags users
+// @Description Getting the profile of the current user.
+// @ID get-me
+// @Produce json
+// @Success 200 {object} domain.User "OK"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /users/me [get]
+func (h *Handler) getMe(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, err := h.userService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary UpdateMe
+// @Security ApiKeyAuth
+// @Tags users
+// @Description Updating the profile of the current user. A new email has to be confirmed before it is applied.
+// @ID update-me
+// @Accept json
+// @Produce json
+// @Param input body domain.UpdateUserInput true "Profile update information"
+// @Success 200 {object} domain.User "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /users/me [patch]
+func (h *Handler) updateMe(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var inp domain.UpdateUserInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	user, err := h.userService.Update(c.Request.Context(), id, inp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary DeleteMe
+// @Security ApiKeyAuth
+// @Tags users
+// @Description Deleting the account of the current user together with its sessions.
+// @ID delete-me
+// @Produce json
+// @Success 200 {string} gin.H "The account has been successfully deleted."
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /users/me [delete]
+func (h *Handler) deleteMe(c *gin.Context) {
+	id, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := h.userService.Delete(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.SetCookie("refresh-token", "", -1, "/auth", "localhost", false, true)
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// @Summary ConfirmEmail
+// @Tags auth
+// @Description Confirming a new email address with the token sent to it.
+// @ID confirm-email
+// @Accept json
+// @Produce json
+// @Param input body domain.ConfirmEmailInput true "Confirmation token"
+// @Success 200 {string} gin.H "The email has been successfully confirmed."
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 409 {object} problem "Conflict"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /auth/confirm-email [post]
+func (h *Handler) confirmEmail(c *gin.Context) {
+	var inp domain.ConfirmEmailInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if err := h.userService.ConfirmEmail(c.Request.Context(), inp.Token); err != nil {
+		if errors.Is(err, domain.ErrEmailTokenInvalid) {
+			c.Error(invalidInput(err))
+			return
+		}
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// @Summary ResetPassword
+// @Tags auth
+// @Description Setting a new password with the token mailed after a reset was requested.
+// @ID reset-password
+// @Accept json
+// @Produce json
+// @Param input body domain.ResetPasswordInput true "Reset token and new password"
+// @Success 200 {string} gin.H "The password has been successfully changed."
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /auth/reset-password [post]
+func (h *Handler) resetPassword(c *gin.Context) {
+	var inp domain.ResetPasswordInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if err := h.userService.ResetPassword(c.Request.Context(), inp); err != nil {
+		if errors.Is(err, domain.ErrResetTokenInvalid) {
+			c.Error(invalidInput(err))
+			return
+		}
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
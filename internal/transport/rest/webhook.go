@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary createWebhook
+// @Security ApiKeyAuth
+// @Tags webhooks
+// @Description Registering a URL to receive the events as JSON POST requests. Every request is signed with the secret: X-Webhook-Signature is "sha256=" and the hex HMAC-SHA256 of the X-Webhook-Timestamp header, a dot and the body. Failed deliveries are retried with an exponential backoff. Only admins can subscribe to user.signed_up.
+// @ID create-webhook
+// @Accept json
+// @Produce json
+// @Param input body domain.CreateWebhookInput true "Webhook"
+// @Success 201 {object} domain.Webhook "Created"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 403 {object} problem "Forbidden"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /webhooks [post]
+func (h *Handler) createWebhook(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var inp domain.CreateWebhookInput
+	if err := c.ShouldBindJSON(&inp); err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	hook, err := h.webhookService.Create(c.Request.Context(), userID, inp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/webhooks/%d", hook.ID))
+	c.JSON(http.StatusCreated, hook)
+}
+
+// @Summary listWebhooks
+// @Security ApiKeyAuth
+// @Tags webhooks
+// @Description Listing the webhooks of the current user.
+// @ID list-webhooks
+// @Produce json
+// @Success 200 {array} domain.Webhook "OK"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /webhooks [get]
+func (h *Handler) listWebhooks(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	hooks, err := h.webhookService.List(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// @Summary deleteWebhook
+// @Security ApiKeyAuth
+// @Tags webhooks
+// @Description Deleting a webhook of the current user along with its deliveries.
+// @ID delete-webhook
+// @Param id path int true "Webhook ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /webhooks/{id} [delete]
+func (h *Handler) deleteWebhook(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	if err := h.webhookService.Delete(c.Request.Context(), userID, id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary listWebhookDeliveries
+// @Security ApiKeyAuth
+// @Tags webhooks
+// @Description Listing the last deliveries of a webhook with every attempt made, the newest first.
+// @ID list-webhook-deliveries
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {array} domain.WebhookDelivery "OK"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /webhooks/{id}/deliveries [get]
+func (h *Handler) listWebhookDeliveries(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	deliveries, err := h.webhookService.Deliveries(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// @Summary redeliverWebhook
+// @Security ApiKeyAuth
+// @Tags webhooks
+// @Description Sending the payload of a past delivery once more, as a new delivery.
+// @ID redeliver-webhook
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param delivery path int true "Delivery ID"
+// @Success 202 {object} domain.WebhookDelivery "Accepted"
+// @Failure 400 {object} problem "Bad Request"
+// @Failure 401 {object} problem "Unauthorized"
+// @Failure 404 {object} problem "Not Found"
+// @Failure 500 {object} problem "Internal Server Error"
+// @Router /webhooks/{id}/deliveries/{delivery}/redeliver [post]
+func (h *Handler) redeliverWebhook(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	id, err := getIDFromRequest(c.Param("id"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	deliveryID, err := getIDFromRequest(c.Param("delivery"))
+	if err != nil {
+		c.Error(invalidInput(err))
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(c.Request.Context(), userID, id, deliveryID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
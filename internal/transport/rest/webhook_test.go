@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-ahmedov/crud_service/internal/domain"
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+)
+
+type fakeWebhookService struct {
+	WebhookService
+
+	created domain.CreateWebhookInput
+}
+
+func (f *fakeWebhookService) Create(ctx context.Context, userID int64, inp domain.CreateWebhookInput) (domain.Webhook, error) {
+	for _, event := range inp.Events {
+		if event == domain.EventUserSignedUp {
+			return domain.Webhook{}, domain.ErrForbidden
+		}
+	}
+
+	f.created = inp
+
+	return domain.Webhook{ID: 1, UserID: userID, URL: inp.URL, Events: inp.Events, Secret: inp.Secret}, nil
+}
+
+func (f *fakeWebhookService) Redeliver(ctx context.Context, userID, webhookID, deliveryID int64) (domain.WebhookDelivery, error) {
+	if webhookID != 1 || deliveryID != 1 {
+		return domain.WebhookDelivery{}, domain.ErrDeliveryNotFound
+	}
+
+	return domain.WebhookDelivery{ID: 2, WebhookID: webhookID, Status: domain.DeliveryPending}, nil
+}
+
+func TestHandler_webhooks(t *testing.T) {
+	testTable := []struct {
+		name                 string
+		method               string
+		path                 string
+		body                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		{
+			name:                 "OK",
+			method:               http.MethodPost,
+			path:                 "/webhooks",
+			body:                 `{"url":"https://example.com/hook","events":["book.created"],"secret":"0123456789abcdef"}`,
+			expectedStatusCode:   201,
+			expectedResponseBody: `{"id":1,"user_id":1,"url":"https://example.com/hook","events":["book.created"],"created_at":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			name:               "Unknown event",
+			method:             http.MethodPost,
+			path:               "/webhooks",
+			body:               `{"url":"https://example.com/hook","events":["book.read"],"secret":"0123456789abcdef"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Not a URL",
+			method:             http.MethodPost,
+			path:               "/webhooks",
+			body:               `{"url":"ftp://example.com","events":["book.created"],"secret":"0123456789abcdef"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Short secret",
+			method:             http.MethodPost,
+			path:               "/webhooks",
+			body:               `{"url":"https://example.com/hook","events":["book.created"],"secret":"secret"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "Admin event",
+			method:             http.MethodPost,
+			path:               "/webhooks",
+			body:               `{"url":"https://example.com/hook","events":["user.signed_up"],"secret":"0123456789abcdef"}`,
+			expectedStatusCode: 403,
+		},
+		{
+			name:               "Redeliver",
+			method:             http.MethodPost,
+			path:               "/webhooks/1/deliveries/1/redeliver",
+			expectedStatusCode: 202,
+		},
+		{
+			name:               "Redeliver unknown delivery",
+			method:             http.MethodPost,
+			path:               "/webhooks/1/deliveries/5/redeliver",
+			expectedStatusCode: 404,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := NewHandler(nil, nil, nil, nil, nil, nil, &fakeWebhookService{}, RateLimits{}, Idempotency{}, nil)
+
+			r := gin.New()
+			r.Use(problemMiddleware, func(c *gin.Context) {
+				c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxUserID, int64(1)))
+			})
+			r.POST("/webhooks", handler.createWebhook)
+			r.POST("/webhooks/:id/deliveries/:delivery/redeliver", handler.redeliverWebhook)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(testCase.method, testCase.path, bytes.NewBufferString(testCase.body))
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, testCase.expectedStatusCode)
+			if testCase.expectedResponseBody != "" {
+				assert.Equal(t, w.Body.String(), testCase.expectedResponseBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+// Package cache keeps values for a while, in the memory of the process or in a store
+// speaking the Redis protocol that replicas share.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+type Store interface {
+	// Get reports whether the key is kept, an expired key isn't.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
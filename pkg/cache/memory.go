@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps up to size values, the least recently used one goes first.
+type MemoryStore struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// recent holds the entries, the most recently used first
+	recent *list.List
+
+	now func() time.Time
+}
+
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	if !s.now().Before(entry.expiresAt) {
+		s.remove(elem)
+		return nil, false, nil
+	}
+
+	s.recent.MoveToFront(elem)
+
+	return entry.value, true, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &memoryEntry{key: key, value: value, expiresAt: s.now().Add(ttl)}
+
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry
+		s.recent.MoveToFront(elem)
+		return nil
+	}
+
+	s.entries[key] = s.recent.PushFront(entry)
+
+	for s.recent.Len() > s.size {
+		s.remove(s.recent.Back())
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := s.entries[key]; ok {
+			s.remove(elem)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]*list.Element)
+	s.recent.Init()
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	s.recent.Remove(elem)
+	delete(s.entries, elem.Value.(*memoryEntry).key)
+}
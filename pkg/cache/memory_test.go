@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore(2)
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	store.Set(ctx, "b", []byte("2"), time.Hour)
+
+	// reading a makes b the least recently used
+	if value, ok, _ := store.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("expected a to be kept, got %q", value)
+	}
+
+	store.Set(ctx, "c", []byte("3"), time.Hour)
+
+	if _, ok, _ := store.Get(ctx, "b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, ok, _ := store.Get(ctx, "c"); !ok {
+		t.Fatal("expected c to be kept")
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Fatal("expected a to expire")
+	}
+
+	store.Delete(ctx, "c")
+
+	if _, ok, _ := store.Get(ctx, "c"); ok {
+		t.Fatal("expected c to be deleted")
+	}
+}
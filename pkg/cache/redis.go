@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the values in Redis or anything speaking its protocol, such as
+// Valkey or KeyDB. The keys are prefixed, so that the store can be shared.
+type RedisStore struct {
+	client redis.Cmdable
+	prefix string
+}
+
+func NewRedisStore(client redis.Cmdable, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+
+	return s.client.Del(ctx, prefixed...).Err()
+}
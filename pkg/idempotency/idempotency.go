@@ -0,0 +1,31 @@
+// Package idempotency keeps the responses of requests made with an idempotency key, so
+// that a retried request is answered with the first response instead of being made again.
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Record is what is kept of the request that took a key.
+type Record struct {
+	// Fingerprint tells the requests made with the same key apart
+	Fingerprint string
+	// Done is false while the request is still being handled, the response is kept once it is
+	Done   bool
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type Store interface {
+	// Reserve takes the key for a request with the fingerprint until ttl has passed.
+	// When the key is already taken reserved is false and the record of the request
+	// that took it is returned.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (rec Record, reserved bool, err error)
+	// Save keeps the response of the request that reserved the key until ttl has passed.
+	Save(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release gives the key up, the next request made with it is handled again.
+	Release(ctx context.Context, key string) error
+}
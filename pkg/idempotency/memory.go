@@ -0,0 +1,76 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = 1024
+
+type memoryEntry struct {
+	rec       Record
+	expiresAt time.Time
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	calls   int
+
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	s.calls++
+	if s.calls%sweepInterval == 0 {
+		s.sweep(now)
+	}
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		return entry.rec, false, nil
+	}
+
+	rec := Record{Fingerprint: fingerprint}
+	s.entries[key] = &memoryEntry{rec: rec, expiresAt: now.Add(ttl)}
+
+	return rec, true, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &memoryEntry{rec: rec, expiresAt: s.now().Add(ttl)}
+
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
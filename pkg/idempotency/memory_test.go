@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Reserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	if _, reserved, _ := store.Reserve(ctx, "key", "first", time.Minute); !reserved {
+		t.Fatal("expected a new key to be reserved")
+	}
+
+	rec, reserved, _ := store.Reserve(ctx, "key", "second", time.Minute)
+	if reserved || rec.Fingerprint != "first" || rec.Done {
+		t.Fatalf("expected the request in progress, got %+v", rec)
+	}
+
+	if err := store.Save(ctx, "key", Record{Fingerprint: "first", Done: true, Status: 201, Body: []byte("{}")}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Minute)
+
+	rec, reserved, _ = store.Reserve(ctx, "key", "first", time.Minute)
+	if reserved || !rec.Done || rec.Status != 201 {
+		t.Fatalf("expected the saved response, got %+v", rec)
+	}
+
+	now = now.Add(time.Hour)
+
+	if _, reserved, _ := store.Reserve(ctx, "key", "third", time.Minute); !reserved {
+		t.Fatal("expected an expired key to be reserved again")
+	}
+
+	store.Release(ctx, "key")
+
+	if _, reserved, _ := store.Reserve(ctx, "key", "fourth", time.Minute); !reserved {
+		t.Fatal("expected a released key to be reserved again")
+	}
+}
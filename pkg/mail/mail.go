@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
+	return &SMTPSender{
+		addr: fmt.Sprintf("%s:%d", host, port),
+		auth: smtp.PlainAuth("", username, password, host),
+		from: from,
+	}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg))
+}
+
+// ConsoleSender only prints messages to stdout, it is meant for local development.
+type ConsoleSender struct{}
+
+func NewConsoleSender() *ConsoleSender {
+	return &ConsoleSender{}
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, to, subject, body string) error {
+	fmt.Printf("MAIL to %s: %s\n%s\n", to, subject, body)
+
+	return nil
+}
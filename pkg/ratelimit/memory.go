@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = 1024
+
+type memoryEntry struct {
+	bucket Bucket
+	limit  Limit
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	calls   int
+
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	s.calls++
+	if s.calls%sweepInterval == 0 {
+		s.sweep(now)
+	}
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &memoryEntry{bucket: NewBucket(limit, now)}
+		s.entries[key] = entry
+	}
+	entry.limit = limit
+
+	return entry.bucket.Take(limit, now), nil
+}
+
+// sweep drops buckets that have refilled completely, they are indistinguishable from new ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if entry.bucket.Full(entry.limit, now) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Bucket is the token bucket state shared by every Store implementation.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// RefillTime is how long an empty bucket takes to refill completely, a bucket left alone
+// for as long is as good as a new one.
+func (l Limit) RefillTime() time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+
+	return secondsToDuration(l.capacity() / l.rate())
+}
+
+func NewBucket(limit Limit, now time.Time) Bucket {
+	return Bucket{
+		Tokens:    limit.capacity(),
+		UpdatedAt: now,
+	}
+}
+
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	capacity := limit.capacity()
+	rate := limit.rate()
+
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+	b.UpdatedAt = now
+
+	res := Result{
+		Limit: int(capacity),
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - b.Tokens) / rate)
+	}
+
+	res.Remaining = int(math.Floor(b.Tokens))
+	res.Reset = secondsToDuration((capacity - b.Tokens) / rate)
+
+	return res
+}
+
+// Full reports whether the bucket would be refilled completely at the given time,
+// in which case the stored state carries no information and can be dropped.
+func (b Bucket) Full(limit Limit, now time.Time) bool {
+	return b.Tokens+now.Sub(b.UpdatedAt).Seconds()*limit.rate() >= limit.capacity()
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
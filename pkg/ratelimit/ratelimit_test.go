@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Requests: 2, Period: time.Second}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := store.Take(ctx, "key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("request %d: expected to be allowed", i)
+		}
+		if res.Remaining != 1-i {
+			t.Fatalf("request %d: expected %d remaining, got %d", i, 1-i, res.Remaining)
+		}
+	}
+
+	res, _ := store.Take(ctx, "key", limit)
+	if res.Allowed {
+		t.Fatal("expected the third request to be rejected")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("expected retry after 500ms, got %v", res.RetryAfter)
+	}
+
+	res, _ = store.Take(ctx, "other", limit)
+	if !res.Allowed {
+		t.Fatal("expected buckets to be independent")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+
+	res, _ = store.Take(ctx, "key", limit)
+	if !res.Allowed {
+		t.Fatal("expected a token to be refilled")
+	}
+}
+
+func TestLimit_RefillTime(t *testing.T) {
+	for _, tc := range []struct {
+		limit Limit
+		want  time.Duration
+	}{
+		{Limit{Requests: 10, Period: time.Minute}, time.Minute},
+		{Limit{Requests: 10, Period: time.Minute, Burst: 20}, 2 * time.Minute},
+		{Limit{}, 0},
+	} {
+		if got := tc.limit.RefillTime(); got != tc.want {
+			t.Fatalf("%+v: expected %v, got %v", tc.limit, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package tabular reads records of CSV, JSON Lines and Excel files and writes them in
+// these formats and Parquet.
+package tabular
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+	XLSX   = "xlsx"
+	// Parquet files can only be written.
+	Parquet = "parquet"
+)
+
+var ErrUnknownFormat = errors.New("unknown file format")
+
+// RowError is returned for a record that can't be parsed, the next ones can still be read.
+type RowError struct {
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Reader returns the records of a file one by one as maps from the column name to the
+// value, io.EOF follows the last record. Reading can go on after a *RowError, other
+// errors end the file.
+type Reader interface {
+	Read() (map[string]string, error)
+}
+
+// NewReader expects the header in the first row of CSV files and of the first sheet of
+// Excel workbooks. Excel workbooks are read into memory.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case CSV:
+		return newCSVReader(r)
+	case NDJSON:
+		return newNDJSONReader(r), nil
+	case XLSX:
+		return newXLSXReader(r)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// FormatFromFilename returns an empty string for unknown extensions.
+func FormatFromFilename(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return CSV
+	case ".ndjson", ".jsonl":
+		return NDJSON
+	case ".xlsx":
+		return XLSX
+	default:
+		return ""
+	}
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+
+	// a byte order mark is left by some spreadsheet programs
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	return &csvReader{r: cr, header: header}, nil
+}
+
+func (c *csvReader) Read() (map[string]string, error) {
+	row, err := c.r.Read()
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &RowError{Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record(c.header, row), nil
+}
+
+type ndjsonReader struct {
+	s *bufio.Scanner
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &ndjsonReader{s: s}
+}
+
+func (n *ndjsonReader) Read() (map[string]string, error) {
+	for n.s.Scan() {
+		line := strings.TrimSpace(n.s.Text())
+		if line == "" {
+			continue
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+
+		var values map[string]interface{}
+		if err := decoder.Decode(&values); err != nil {
+			return nil, &RowError{Err: err}
+		}
+
+		rec := make(map[string]string, len(values))
+		for key, value := range values {
+			switch v := value.(type) {
+			case nil:
+				rec[key] = ""
+			case string:
+				rec[key] = v
+			default:
+				rec[key] = fmt.Sprint(v)
+			}
+		}
+
+		return rec, nil
+	}
+
+	if err := n.s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+type xlsxReader struct {
+	file   *excelize.File
+	rows   *excelize.Rows
+	header []string
+}
+
+func newXLSXReader(r io.Reader) (*xlsxReader, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := file.Rows(file.GetSheetName(0))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	x := &xlsxReader{file: file, rows: rows}
+
+	header, err := x.next()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+	x.header = header
+
+	return x, nil
+}
+
+func (x *xlsxReader) Read() (map[string]string, error) {
+	row, err := x.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return record(x.header, row), nil
+}
+
+func (x *xlsxReader) next() ([]string, error) {
+	if !x.rows.Next() {
+		err := x.rows.Error()
+		x.rows.Close()
+		x.file.Close()
+
+		if err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return x.rows.Columns()
+}
+
+func record(header, row []string) map[string]string {
+	rec := make(map[string]string, len(header))
+
+	for i, name := range header {
+		if i < len(row) {
+			rec[name] = row[i]
+		} else {
+			rec[name] = ""
+		}
+	}
+
+	return rec
+}
@@ -0,0 +1,123 @@
+package tabular
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestNewReader(t *testing.T) {
+	workbook := excelize.NewFile()
+	workbook.SetSheetRow("Sheet1", "A1", &[]string{"title", "rating"})
+	workbook.SetSheetRow("Sheet1", "A2", &[]interface{}{"Title", 5})
+	workbook.SetSheetRow("Sheet1", "A3", &[]string{"Other"})
+
+	var xlsx bytes.Buffer
+	if err := workbook.Write(&xlsx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]string{
+		{"title": "Title", "rating": "5"},
+		{"title": "Other", "rating": ""},
+	}
+
+	testTable := []struct {
+		name   string
+		format string
+		input  io.Reader
+	}{
+		{
+			name:   "CSV",
+			format: CSV,
+			input:  strings.NewReader("\ufefftitle,rating\nTitle,5\nOther\n"),
+		},
+		{
+			name:   "NDJSON",
+			format: NDJSON,
+			input:  strings.NewReader("{\"title\":\"Title\",\"rating\":5}\n\n{\"title\":\"Other\",\"rating\":null}\n"),
+		},
+		{
+			name:   "XLSX",
+			format: XLSX,
+			input:  &xlsx,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			reader, err := NewReader(testCase.format, testCase.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, wantRec := range want {
+				rec, err := reader.Read()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				assert.Equal(t, rec, wantRec)
+			}
+
+			_, err = reader.Read()
+			assert.Equal(t, err, io.EOF)
+		})
+	}
+}
+
+func TestReader_RowError(t *testing.T) {
+	testTable := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{
+			name:   "CSV",
+			format: CSV,
+			input:  "title,rating\nTitle,5\nBro\"ken,1\nOther,\n",
+		},
+		{
+			name:   "NDJSON",
+			format: NDJSON,
+			input:  "{\"title\":\"Title\",\"rating\":5}\n{\"title\":\n{\"title\":\"Other\",\"rating\":null}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			reader, err := NewReader(testCase.format, strings.NewReader(testCase.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := reader.Read(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rowErr *RowError
+			_, err = reader.Read()
+			assert.Equal(t, errors.As(err, &rowErr), true)
+
+			rec, err := reader.Read()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, rec, map[string]string{"title": "Other", "rating": ""})
+
+			_, err = reader.Read()
+			assert.Equal(t, err, io.EOF)
+		})
+	}
+}
+
+func TestNewReader_UnknownFormat(t *testing.T) {
+	_, err := NewReader("xml", strings.NewReader(""))
+	assert.Equal(t, errors.Is(err, ErrUnknownFormat), true)
+	assert.Equal(t, FormatFromFilename("books.JSONL"), NDJSON)
+	assert.Equal(t, FormatFromFilename("books.txt"), "")
+}
@@ -0,0 +1,274 @@
+package tabular
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// parquetRowGroupSize bounds the rows a Parquet writer keeps in memory.
+const parquetRowGroupSize = 10000
+
+// Kinds of the values of a column.
+const (
+	String = iota
+	Int
+	Time
+)
+
+type Column struct {
+	Name string
+	Kind int
+}
+
+// Writer writes one record per call with a value for every column: a string, an int64 or
+// a time.Time depending on the kind of the column, or nil for a missing value. Close
+// flushes what is buffered without closing the underlying writer.
+type Writer interface {
+	Write(values []interface{}) error
+	Close() error
+}
+
+// NewWriter writes a header row to CSV files and Excel workbooks.
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, columns)
+	case NDJSON:
+		return newNDJSONWriter(w, columns), nil
+	case XLSX:
+		return newXLSXWriter(w, columns)
+	case Parquet:
+		return newParquetWriter(w, columns), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// ContentType returns the media type of files in the format.
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case NDJSON:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &csvWriter{w: cw, record: make([]string, len(columns))}, nil
+}
+
+func (c *csvWriter) Write(values []interface{}) error {
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			c.record[i] = ""
+		case string:
+			c.record[i] = v
+		case int64:
+			c.record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			c.record[i] = v.Format(time.RFC3339)
+		default:
+			c.record[i] = fmt.Sprint(v)
+		}
+	}
+
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+	columns []Column
+}
+
+func newNDJSONWriter(w io.Writer, columns []Column) *ndjsonWriter {
+	bw := bufio.NewWriter(w)
+
+	return &ndjsonWriter{w: bw, encoder: json.NewEncoder(bw), columns: columns}
+}
+
+func (n *ndjsonWriter) Write(values []interface{}) error {
+	rec := make(map[string]interface{}, len(n.columns))
+	for i, column := range n.columns {
+		rec[column.Name] = values[i]
+	}
+
+	return n.encoder.Encode(rec)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+// xlsxWriter streams the rows to temporary files, the workbook is only put together and
+// written once it is closed.
+type xlsxWriter struct {
+	w         io.Writer
+	file      *excelize.File
+	stream    *excelize.StreamWriter
+	row       int
+	dateStyle int
+}
+
+func newXLSXWriter(w io.Writer, columns []Column) (*xlsxWriter, error) {
+	file := excelize.NewFile()
+
+	stream, err := file.NewStreamWriter(file.GetSheetName(0))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// 22 is the built-in "m/d/yy h:mm" format, without it times show as plain numbers
+	dateStyle, err := file.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	x := &xlsxWriter{w: w, file: file, stream: stream, dateStyle: dateStyle}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+
+	if err := x.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *xlsxWriter) Write(values []interface{}) error {
+	x.row++
+
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		if t, ok := value.(time.Time); ok {
+			row[i] = excelize.Cell{StyleID: x.dateStyle, Value: t}
+		} else {
+			row[i] = value
+		}
+	}
+
+	return x.stream.SetRow(cell, row)
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.file.Close()
+
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+
+	return x.file.Write(x.w)
+}
+
+type parquetWriter struct {
+	w       *parquet.Writer
+	columns []parquet.LeafColumn
+}
+
+// newParquetWriter stores every column as optional, times as milliseconds since the epoch.
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	group := make(parquet.Group, len(columns))
+	for _, column := range columns {
+		switch column.Kind {
+		case Int:
+			group[column.Name] = parquet.Optional(parquet.Int(64))
+		case Time:
+			group[column.Name] = parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+		default:
+			group[column.Name] = parquet.Optional(parquet.String())
+		}
+	}
+
+	schema := parquet.NewSchema("record", group)
+
+	// the columns of a group are sorted by name, the values are matched to them here
+	leaves := make([]parquet.LeafColumn, len(columns))
+	for i, column := range columns {
+		leaves[i], _ = schema.Lookup(column.Name)
+	}
+
+	return &parquetWriter{
+		w:       parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		columns: leaves,
+	}
+}
+
+func (p *parquetWriter) Write(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+
+	for i, value := range values {
+		var v parquet.Value
+		switch value := value.(type) {
+		case nil:
+			row[p.columns[i].ColumnIndex] = parquet.NullValue().Level(0, 0, p.columns[i].ColumnIndex)
+			continue
+		case string:
+			v = parquet.ByteArrayValue([]byte(value))
+		case int64:
+			v = parquet.Int64Value(value)
+		case time.Time:
+			v = parquet.Int64Value(value.UnixMilli())
+		default:
+			return fmt.Errorf("unsupported value %T", value)
+		}
+
+		row[p.columns[i].ColumnIndex] = v.Level(0, 1, p.columns[i].ColumnIndex)
+	}
+
+	_, err := p.w.WriteRows([]parquet.Row{row})
+
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
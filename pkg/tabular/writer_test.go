@@ -0,0 +1,81 @@
+package tabular
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/parquet-go/parquet-go"
+)
+
+var testColumns = []Column{
+	{Name: "title", Kind: String},
+	{Name: "rating", Kind: Int},
+	{Name: "published", Kind: Time},
+}
+
+var testValues = [][]interface{}{
+	{"Title", int64(5), time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+	{"Other", nil, nil},
+}
+
+func writeAll(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(format, &buf, testColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, values := range testValues {
+		if err := w.Write(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestNewWriter(t *testing.T) {
+	assert.Equal(t, writeAll(t, CSV).String(), "title,rating,published\nTitle,5,2020-01-02T00:00:00Z\nOther,,\n")
+
+	assert.Equal(t, writeAll(t, NDJSON).String(),
+		`{"published":"2020-01-02T00:00:00Z","rating":5,"title":"Title"}`+"\n"+`{"published":null,"rating":null,"title":"Other"}`+"\n")
+
+	// a written workbook reads back like any other
+	reader, err := NewReader(XLSX, writeAll(t, XLSX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rec["title"], "Title")
+	assert.Equal(t, rec["rating"], "5")
+}
+
+func TestNewWriter_Parquet(t *testing.T) {
+	buf := writeAll(t, Parquet)
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, file.NumRows(), int64(2))
+
+	rows := make([]parquet.Row, 2)
+	n, _ := file.RowGroups()[0].Rows().ReadRows(rows)
+	assert.Equal(t, n, 2)
+
+	// the columns are sorted by name: published, rating, title
+	assert.Equal(t, rows[0][0].Int64(), time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC).UnixMilli())
+	assert.Equal(t, rows[0][1].Int64(), int64(5))
+	assert.Equal(t, rows[0][2].String(), "Title")
+	assert.Equal(t, rows[1][1].IsNull(), true)
+}
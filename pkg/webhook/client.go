@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("webhooks can't be sent to private addresses")
+
+// NewClient returns a client that gives up on a receiver after timeout. Unless
+// allowPrivate is set it refuses to connect to loopback, private and link-local
+// addresses, so that webhooks can't reach the internal network. The address is checked
+// when connecting, after the name is resolved, and redirects are not followed.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would connect on our behalf, to an address that isn't checked
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package webhook signs the webhook requests and sends them to public addresses only.
+//
+// A request carries the unix time it was signed at in the Timestamp header and the
+// signature in the Signature header: "sha256=" and the hex HMAC-SHA256 of the timestamp,
+// a dot and the body, keyed with the secret of the webhook. Receivers check it with Verify.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrInvalidSignature = errors.New("the signature doesn't match")
+	ErrExpiredSignature = errors.New("the signature is too old")
+)
+
+// Sign returns the value of the Signature header.
+func Sign(secret string, timestamp int64, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, timestamp, body))
+}
+
+// Verify checks the headers of a request, the timestamp must be within tolerance of now so
+// that a captured request can't be replayed later.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return ErrExpiredSignature
+	}
+
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal(sum, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func mac(secret string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	h.Write([]byte("."))
+	h.Write(body)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"book.created"}`)
+	now := time.Now().Unix()
+	signature := Sign("secret", now, body)
+
+	testTable := []struct {
+		name        string
+		secret      string
+		timestamp   int64
+		signature   string
+		body        []byte
+		expectedErr error
+	}{
+		{name: "OK", secret: "secret", timestamp: now, signature: signature, body: body},
+		{name: "Wrong secret", secret: "other", timestamp: now, signature: signature, body: body, expectedErr: ErrInvalidSignature},
+		{name: "Changed body", secret: "secret", timestamp: now, signature: signature, body: []byte(`{}`), expectedErr: ErrInvalidSignature},
+		{name: "Other timestamp", secret: "secret", timestamp: now - 1, signature: signature, body: body, expectedErr: ErrInvalidSignature},
+		{name: "Missing prefix", secret: "secret", timestamp: now, signature: signature[len(signaturePrefix):], body: body, expectedErr: ErrInvalidSignature},
+		{name: "Replayed", secret: "secret", timestamp: now - 600, signature: Sign("secret", now-600, body), body: body, expectedErr: ErrExpiredSignature},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := Verify(testCase.secret, strconv.FormatInt(testCase.timestamp, 10), testCase.signature, testCase.body, 5*time.Minute)
+			assert.Equal(t, err, testCase.expectedErr)
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := NewClient(time.Second, false).Get(srv.URL)
+	assert.Equal(t, errors.Is(err, ErrPrivateAddress), true)
+
+	resp, err := NewClient(time.Second, true).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+}
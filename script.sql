@@ -3,7 +3,11 @@ CREATE Table Books (
 	title VARCHAR(255) NOT NULL, 
 	author VARCHAR(255) NOT NULL,
 	publish_date TIMESTAMP not null default now(),
-	rating INT NOT NULL
+	rating INT NOT NULL,
+	isbn VARCHAR(17),
+	owner_id BIGINT,
+	version BIGINT NOT NULL DEFAULT 1,
+	deleted_at TIMESTAMPTZ
 );
 
 CREATE Table Users (
@@ -11,12 +15,160 @@ CREATE Table Users (
 	name VARCHAR(255) NOT NULL, 
 	email VARCHAR(255) NOT NULL,
 	password VARCHAR(255) NOT NULL,
-	registered_at TIMESTAMP not null
+	registered_at TIMESTAMPTZ not null,
+	role VARCHAR(16) NOT NULL DEFAULT 'user',
+	disabled BOOLEAN NOT NULL DEFAULT false,
+	pending_email VARCHAR(255),
+	email_token VARCHAR(255) UNIQUE,
+	email_token_expires_at TIMESTAMPTZ,
+	password_reset_required BOOLEAN NOT NULL DEFAULT false,
+	reset_token VARCHAR(255) UNIQUE,
+	reset_token_expires_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX users_email_key ON Users (LOWER(email));
+
+ALTER TABLE Books ADD FOREIGN KEY (owner_id) REFERENCES Users (id) ON DELETE SET NULL;
+CREATE INDEX books_owner_id_idx ON Books (owner_id);
+CREATE INDEX books_deleted_at_idx ON Books (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE Table book_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	book_id BIGINT REFERENCES Books (id) ON DELETE CASCADE NOT NULL,
+	revision BIGINT NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor_id BIGINT REFERENCES Users (id) ON DELETE SET NULL,
+	snapshot JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (book_id, revision)
+);
+
+CREATE Table import_jobs (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT REFERENCES Users (id) ON DELETE CASCADE NOT NULL,
+	format VARCHAR(16) NOT NULL,
+	dry_run BOOLEAN NOT NULL,
+	on_duplicate VARCHAR(16) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	processed INT NOT NULL DEFAULT 0,
+	created INT NOT NULL DEFAULT 0,
+	updated INT NOT NULL DEFAULT 0,
+	skipped INT NOT NULL DEFAULT 0,
+	failed INT NOT NULL DEFAULT 0,
+	errors JSONB NOT NULL DEFAULT '[]',
+	error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at TIMESTAMPTZ
 );
 
 CREATE Table refresh_tokens (
 	id serial NOT NULL UNIQUE,
 	user_id int REFERENCES Users (id) on delete CASCADE NOT NULL,
 	token VARCHAR(255) NOT NULL UNIQUE,
-	expires_at TIMESTAMP NOT NULL
-);
\ No newline at end of file
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE Table user_identities (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT REFERENCES Users (id) on delete CASCADE NOT NULL,
+	provider VARCHAR(64) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (provider, subject)
+);
+
+CREATE Table rate_limits (
+	key VARCHAR(255) PRIMARY KEY,
+	tokens DOUBLE PRECISION NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX rate_limits_updated_at_idx ON rate_limits (updated_at);
+
+-- the key is scoped to the user who sent it, see idempotencyMiddleware
+CREATE Table idempotency_keys (
+	key VARCHAR(512) PRIMARY KEY,
+	fingerprint CHAR(64) NOT NULL,
+	done BOOLEAN NOT NULL DEFAULT false,
+	status_code INT NOT NULL DEFAULT 0,
+	headers JSONB,
+	body BYTEA,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+CREATE Table oauth_clients (
+	id BIGSERIAL PRIMARY KEY,
+	client_id VARCHAR(64) NOT NULL UNIQUE,
+	secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+	name VARCHAR(255) NOT NULL,
+	redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+	grant_types TEXT[] NOT NULL DEFAULT '{}',
+	scopes TEXT[] NOT NULL DEFAULT '{}',
+	public BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE Table oauth_codes (
+	code VARCHAR(255) PRIMARY KEY,
+	client_id VARCHAR(64) REFERENCES oauth_clients (client_id) on delete CASCADE NOT NULL,
+	user_id BIGINT REFERENCES Users (id) on delete CASCADE NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	scope VARCHAR(255) NOT NULL,
+	code_challenge VARCHAR(255) NOT NULL,
+	code_challenge_method VARCHAR(16) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN client_id VARCHAR(64) REFERENCES oauth_clients (client_id) on delete CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT '';
+
+-- every replica listens for the new revisions, they are the events of the book feed
+CREATE FUNCTION notify_book_revision() RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('book_events', NEW.id::TEXT);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_revisions_notify AFTER INSERT ON book_revisions
+	FOR EACH ROW EXECUTE FUNCTION notify_book_revision();
+
+CREATE Table webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT REFERENCES Users (id) ON DELETE CASCADE NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT[] NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE Table webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT REFERENCES webhooks (id) ON DELETE CASCADE NOT NULL,
+	event VARCHAR(32) NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE Table webhook_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	delivery_id BIGINT REFERENCES webhook_deliveries (id) ON DELETE CASCADE NOT NULL,
+	status_code INT,
+	error TEXT,
+	duration_ms BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
\ No newline at end of file